@@ -0,0 +1,33 @@
+// Command lambda serves the API from AWS Lambda behind API Gateway. It shares the router,
+// handlers and repositories with the long-running server; deploy it with
+// BACKGROUND_JOBS_ENABLED=false and have a scheduled EventBridge rule call
+// POST /api/internal/run-expiry-sweep in place of the background jobs.
+package main
+
+import (
+	"log"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/server"
+)
+
+func main() {
+	// Load the application configuration from the function's environment variables
+	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Connect to DynamoDB and create the repositories and services once per cold start
+	deps, err := server.NewDeps(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	// Keep the revoked tokens fresh while the function is warm
+	server.StartBackgroundJobs(deps)
+
+	// Translate API Gateway proxy events into requests to the router
+	algnhsa.ListenAndServe(server.NewRouter(deps), nil)
+}
@@ -1,37 +1,526 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// defaultJWTSecret is the development JWT secret, which must not be used in production
+const defaultJWTSecret = "dev-reserve-secret-key"
+
+// tableNameChars are the characters DynamoDB allows in table names
+const tableNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-."
+
+// maxJWTExpirationHours is the longest a JWT may stay valid for
+const maxJWTExpirationHours = 30 * 24
+
+// minInternalAPISecretLength is the shortest shared secret accepted for the internal endpoints
+const minInternalAPISecretLength = 32
+
+// JWTKeySet holds the keys JWTs are signed with while rotating the secret. Tokens are signed
+// with the Current key, named in their kid header, and verified with whichever key their kid names.
+type JWTKeySet struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
 // Config holds all the configuration for the application
 type Config struct {
 	// Server configuration
 	Port string
+	// ProductionMode enforces stricter security settings, see Validate
+	ProductionMode bool
+	// Origins allowed to make cross-origin requests
+	CORSAllowedOrigins []string
+	// Proxies, as CIDRs or single IPs, whose X-Forwarded-For header is believed when working
+	// out a client's IP address; see TrustedProxyNetworks
+	TrustedProxies []string
+
+	// TLS, with a certificate from Let's Encrypt for TLSDomain unless a certificate file is given
+	TLSEnabled  bool
+	TLSDomain   string
+	TLSCacheDir string
+	TLSCertFile string
+	TLSKeyFile  string
 
 	// AWS configuration
-	AWSRegion    string
-	DynamoDBEndpoint string
+	AWSRegion           string
+	DynamoDBEndpoint    string
+	TablePrefix         string
+	DynamoDBBillingMode string
+	// Retries of throttled or failed DynamoDB requests, with exponential backoff from the base delay
+	DynamoDBMaxRetries     int
+	DynamoDBRetryBaseDelay time.Duration
+	// How long to wait at startup for DynamoDB to accept connections; only waited for with a
+	// local endpoint or DBWaitForReady, so a misconfigured AWS setup still fails fast
+	DBStartupTimeout time.Duration
+	DBWaitForReady   bool
 
 	// Security
 	JWTSecret string
 	JWTExpirationHours int
+	// Clock skew tolerated when checking a token's exp, nbf and iat
+	JWTLeeway time.Duration
+	// How often each replica reloads the revoked tokens, so a token revoked through another
+	// replica may keep working here for up to this long
+	TokenRevocationRefreshInterval time.Duration
+	// Signing keys by kid; if empty, tokens are signed with JWTSecret and have no kid
+	JWTKeys    JWTKeySet
+	jwtKeysErr error
+	// Once there is a keyset, tokens without a kid are rejected, unless this is set and they
+	// are verified with JWTSecret before it, while moving from JWTSecret to the keyset
+	JWTSecretValidUntil    time.Time
+	jwtSecretValidUntilErr error
+
+	// Whether anyone can register; if false, registering requires an invite code
+	AllowSelfRegistration bool
+
+	// Admin created at startup if no admin exists yet (skipped if the username is empty),
+	// or an existing user promoted to admin instead
+	BootstrapAdminUsername        string
+	BootstrapAdminPassword        string
+	BootstrapAdminPromoteUsername string
+
+	// Per-user rate limit for authenticated routes (0 disables rate limiting), stricter
+	// limits for expensive routes keyed by "METHOD /path/template", and users exempt from both
+	RateLimitPerMinute   int
+	RateLimitRoutes      map[string]int
+	RateLimitExemptUsers []string
+
+	// Reservation expiry sweep
+	ExpiryCheckInterval time.Duration
+	ExpiryCheckJitter   time.Duration
+
+	// Whether this process runs the expiry sweep, scheduled activation and health checks
+	// itself. Serverless deployments turn it off and call the internal sweep endpoint on a
+	// schedule instead.
+	BackgroundJobsEnabled bool
+	// Shared secret callers of the internal endpoints send in the X-Internal-Secret header;
+	// the endpoints are disabled if it is empty
+	InternalAPISecret string
+
+	// Maximum total time an auto-renewing reservation may keep renewing for
+	AutoRenewMaxDuration time.Duration
+
+	// Reservation duration bounds for environments that don't set their own
+	MinReservationMins int
+	MaxReservationMins int
+
+	// Environment health checks
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	HealthCheckWorkers  int
+	// Whether environments whose last health check failed can't be reserved
+	BlockUnhealthyReservations bool
+
+	// Maximum number of environments whose current reservation is looked up at once
+	ReservationLookupWorkers int
+
+	// Values allowed for a reservation's purpose
+	ReservationPurposes []string
+
+	// Hosts a reservation's Jira link may point at; any host is allowed if empty
+	JiraAllowedHosts []string
+	// Jira site a reservation's Jira link must be an issue of, e.g. https://acme.atlassian.net;
+	// links aren't checked against it if empty
+	JiraBaseURL string
+
+	// Whether a reservation awaiting approval holds its environment so nobody else can reserve it
+	ApprovalHoldsEnvironment bool
+
+	// Whether to leave the holder's username out when a reservation fails because the
+	// environment is already reserved
+	HideReservationHolder bool
+
+	// How long a user can hold an environment while deciding whether to reserve it
+	HoldTTL time.Duration
+
+	// How often scheduled reservations whose start time has passed are activated
+	ScheduleCheckInterval time.Duration
+
+	// How long GET /api/stats reuses what it read from DynamoDB (0 reads it on every request)
+	DashboardCacheTTL time.Duration
+
+	// Whether to correct environment statuses that disagree with the reservations at startup
+	ReconcileOnStartup bool
+
+	// JSON or YAML file of environments to create at startup, and whether existing ones are
+	// left alone ("create") or have their description, tags and limits updated ("sync")
+	EnvironmentsSeedFile string
+	SeedMode             string
+
+	// Number of workers delivering webhook events
+	WebhookWorkers int
+
+	// Password reset
+	PasswordResetTokenTTL time.Duration
+
+	// URL of the web app, used for links in emails; links are left out if it is empty
+	BaseURL string
+
+	// SMTP configuration (emails are logged instead of sent if SMTPHost is empty)
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
 }
 
 // LoadConfig loads the configuration from environment variables
 func LoadConfig() Config {
-	return Config{
+	cfg := Config{
 		// Server configuration
-		Port: getEnv("PORT", "8080"),
+		Port:               getEnv("PORT", "8080"),
+		ProductionMode:     getEnvBool("PRODUCTION_MODE", false),
+		CORSAllowedOrigins: getEnvList("CORS_ALLOWED_ORIGINS", []string{"*"}),
+		TrustedProxies:     getEnvList("TRUSTED_PROXIES", nil),
+
+		// TLS
+		TLSEnabled:  getEnvBool("TLS_ENABLED", false),
+		TLSDomain:   getEnv("TLS_DOMAIN", ""),
+		TLSCacheDir: getEnv("TLS_CACHE_DIR", "certs"),
+		TLSCertFile: getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:  getEnv("TLS_KEY_FILE", ""),
 
 		// AWS configuration
-		AWSRegion:    getEnv("AWS_REGION", "us-east-1"),
+		AWSRegion:        getEnv("AWS_REGION", "us-east-1"),
 		DynamoDBEndpoint: getEnv("DYNAMODB_ENDPOINT", ""),
+		// DYNAMODB_TABLE_PREFIX is the variable's older name, still read for existing deployments
+		TablePrefix:            getEnv("TABLE_PREFIX", getEnv("DYNAMODB_TABLE_PREFIX", "")),
+		DynamoDBBillingMode:    getEnv("DYNAMODB_BILLING_MODE", "PROVISIONED"),
+		DynamoDBMaxRetries:     getEnvInt("DYNAMODB_MAX_RETRIES", 5),
+		DynamoDBRetryBaseDelay: getEnvDuration("DYNAMODB_RETRY_BASE_DELAY", 50*time.Millisecond),
+		DBStartupTimeout:       getEnvDuration("DB_STARTUP_TIMEOUT", 60*time.Second),
+		DBWaitForReady:         getEnvBool("DB_WAIT_FOR_READY", false),
 
 		// Security
-		JWTSecret: getEnv("JWT_SECRET", "dev-reserve-secret-key"),
+		JWTSecret: getEnv("JWT_SECRET", defaultJWTSecret),
 		JWTExpirationHours: 24,
+		JWTLeeway: getEnvDuration("JWT_LEEWAY", 30*time.Second),
+		TokenRevocationRefreshInterval: getEnvDuration("TOKEN_REVOCATION_REFRESH_INTERVAL", 10*time.Second),
+
+		// Registration
+		AllowSelfRegistration: getEnvBool("ALLOW_SELF_REGISTRATION", true),
+
+		// Bootstrap admin; ADMIN_USERNAME and ADMIN_PASSWORD are accepted as shorter names
+		BootstrapAdminUsername:        getEnv("BOOTSTRAP_ADMIN_USERNAME", getEnv("ADMIN_USERNAME", "")),
+		BootstrapAdminPassword:        getEnv("BOOTSTRAP_ADMIN_PASSWORD", getEnv("ADMIN_PASSWORD", "")),
+		BootstrapAdminPromoteUsername: getEnv("ADMIN_PROMOTE_USERNAME", ""),
+
+		// Per-user rate limit
+		RateLimitPerMinute: getEnvInt("RATE_LIMIT_PER_MINUTE", 120),
+		RateLimitRoutes: getEnvIntMap("RATE_LIMIT_ROUTES", map[string]int{
+			"GET /api/reservations/search":     30,
+			"GET /api/environments/{id}/stats": 30,
+		}),
+		RateLimitExemptUsers: getEnvList("RATE_LIMIT_EXEMPT_USERS", nil),
+
+		// Reservation expiry sweep
+		ExpiryCheckInterval: getEnvDuration("EXPIRY_CHECK_INTERVAL", 1*time.Minute),
+		ExpiryCheckJitter:   getEnvDuration("EXPIRY_CHECK_JITTER", 10*time.Second),
+
+		// Background jobs and the internal endpoint replacing them
+		BackgroundJobsEnabled: getEnvBool("BACKGROUND_JOBS_ENABLED", true),
+		InternalAPISecret:     getEnv("INTERNAL_API_SECRET", ""),
+
+		// Auto-renewing reservations
+		AutoRenewMaxDuration: getEnvDuration("AUTO_RENEW_MAX_DURATION", 7*24*time.Hour),
+
+		// Reservation duration bounds
+		MinReservationMins: getEnvInt("MIN_RESERVATION_MINS", 10),
+		MaxReservationMins: getEnvInt("MAX_RESERVATION_MINS", 4320), // 3 days
+
+		// Environment health checks
+		HealthCheckInterval:        getEnvDuration("HEALTH_CHECK_INTERVAL", 1*time.Minute),
+		HealthCheckTimeout:         getEnvDuration("HEALTH_CHECK_TIMEOUT", 5*time.Second),
+		HealthCheckWorkers:         getEnvInt("HEALTH_CHECK_WORKERS", 4),
+		BlockUnhealthyReservations: getEnvBool("BLOCK_UNHEALTHY_RESERVATIONS", false),
+
+		// Current reservation lookups
+		ReservationLookupWorkers: getEnvInt("RESERVATION_LOOKUP_WORKERS", 8),
+
+		// Reservation purposes
+		ReservationPurposes: getEnvList("RESERVATION_PURPOSES", []string{"FEATURE", "BUGFIX", "RELEASE", "PERF", "OTHER"}),
+
+		// Jira links
+		JiraAllowedHosts: getEnvList("JIRA_ALLOWED_HOSTS", nil),
+		JiraBaseURL:      getEnv("JIRA_BASE_URL", ""),
+
+		// Reservation approval
+		ApprovalHoldsEnvironment: getEnvBool("APPROVAL_HOLDS_ENVIRONMENT", false),
+
+		// Reservation conflicts
+		HideReservationHolder: getEnvBool("HIDE_RESERVATION_HOLDER", false),
+
+		// Environment holds
+		HoldTTL: getEnvDuration("HOLD_TTL", 60*time.Second),
+
+		// Scheduled reservations
+		ScheduleCheckInterval: getEnvDuration("SCHEDULE_CHECK_INTERVAL", 30*time.Second),
+
+		// Dashboard stats
+		DashboardCacheTTL: getEnvDuration("DASHBOARD_CACHE_TTL", 30*time.Second),
+
+		// Maintenance
+		ReconcileOnStartup: getEnvBool("RECONCILE_ON_STARTUP", false),
+
+		// Environment seeding
+		EnvironmentsSeedFile: getEnv("ENVIRONMENTS_SEED_FILE", ""),
+		SeedMode:             getEnv("SEED_MODE", "create"),
+
+		// Webhooks
+		WebhookWorkers: getEnvInt("WEBHOOK_WORKERS", 4),
+
+		// Password reset
+		PasswordResetTokenTTL: getEnvDuration("PASSWORD_RESET_TOKEN_TTL", 1*time.Hour),
+
+		// Web app URL for links in emails
+		BaseURL: getEnv("BASE_URL", ""),
+
+		// SMTP configuration
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "dev-reserve@localhost"),
+	}
+	cfg.JWTKeys, cfg.jwtKeysErr = getEnvJWTKeySet("JWT_KEYS")
+	cfg.JWTSecretValidUntil, cfg.jwtSecretValidUntilErr = getEnvTime("JWT_SECRET_VALID_UNTIL")
+	return cfg
+}
+
+// Validate checks the configuration, returning an error describing every problem found
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.JWTSecret == "" {
+		problems = append(problems, "JWT_SECRET must not be empty")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES must be a list of CIDRs or IP addresses: %v", err))
+		}
+	}
+	if c.JWTLeeway < 0 {
+		problems = append(problems, "JWT_LEEWAY must not be negative")
+	}
+	if c.TokenRevocationRefreshInterval <= 0 {
+		problems = append(problems, "TOKEN_REVOCATION_REFRESH_INTERVAL must be positive")
+	}
+	if c.jwtKeysErr != nil {
+		problems = append(problems, fmt.Sprintf(`JWT_KEYS must be a JSON object like {"current": "k2", "keys": {"k1": "...", "k2": "..."}}: %v`, c.jwtKeysErr))
+	} else if len(c.JWTKeys.Keys) > 0 {
+		if _, ok := c.JWTKeys.Keys[c.JWTKeys.Current]; !ok {
+			problems = append(problems, fmt.Sprintf("JWT_KEYS current key %q must be one of its keys", c.JWTKeys.Current))
+		}
+		for kid, secret := range c.JWTKeys.Keys {
+			if kid == "" || secret == "" {
+				problems = append(problems, "JWT_KEYS key IDs and secrets must not be empty")
+				break
+			}
+		}
+	}
+	// Tokens without a kid were issued before the keyset, so they expire within the JWT
+	// expiration time of the switch and a longer window would only widen the exposure
+	if c.jwtSecretValidUntilErr != nil {
+		problems = append(problems, fmt.Sprintf("JWT_SECRET_VALID_UNTIL must be an RFC 3339 time: %v", c.jwtSecretValidUntilErr))
+	} else if !c.JWTSecretValidUntil.IsZero() && c.JWTSecretValidUntil.After(time.Now().Add(time.Duration(c.JWTExpirationHours)*time.Hour)) {
+		problems = append(problems, "JWT_SECRET_VALID_UNTIL must be at most JWT_EXPIRATION_HOURS from now")
+	}
+	if c.AWSRegion == "" {
+		problems = append(problems, "AWS_REGION must not be empty")
+	}
+	if c.DynamoDBBillingMode != "PROVISIONED" && c.DynamoDBBillingMode != "PAY_PER_REQUEST" {
+		problems = append(problems, fmt.Sprintf("DYNAMODB_BILLING_MODE must be PROVISIONED or PAY_PER_REQUEST, got %q", c.DynamoDBBillingMode))
+	}
+	if c.DynamoDBMaxRetries < 0 {
+		problems = append(problems, "DYNAMODB_MAX_RETRIES must not be negative")
+	}
+	if c.DynamoDBRetryBaseDelay <= 0 {
+		problems = append(problems, "DYNAMODB_RETRY_BASE_DELAY must be positive")
+	}
+	if c.DBStartupTimeout <= 0 {
+		problems = append(problems, "DB_STARTUP_TIMEOUT must be positive")
+	}
+	if strings.TrimLeft(c.TablePrefix, tableNameChars) != "" {
+		problems = append(problems, fmt.Sprintf("TABLE_PREFIX may only contain letters, digits, '_', '-' and '.', got %q", c.TablePrefix))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be a port number between 1 and 65535, got %q", c.Port))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSEnabled && c.TLSCertFile == "" && c.TLSDomain == "" {
+		problems = append(problems, "TLS_ENABLED requires TLS_DOMAIN, or TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.JiraBaseURL != "" {
+		if u, err := url.Parse(c.JiraBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("JIRA_BASE_URL must be an absolute http or https URL, got %q", c.JiraBaseURL))
+		}
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("BASE_URL must be an absolute http or https URL, got %q", c.BaseURL))
+		}
+	}
+	if c.InternalAPISecret != "" && len(c.InternalAPISecret) < minInternalAPISecretLength {
+		problems = append(problems, fmt.Sprintf("INTERNAL_API_SECRET must be at least %d characters", minInternalAPISecretLength))
+	}
+	if c.SeedMode != "create" && c.SeedMode != "sync" {
+		problems = append(problems, fmt.Sprintf("SEED_MODE must be create or sync, got %q", c.SeedMode))
 	}
+	if c.HoldTTL <= 0 {
+		problems = append(problems, "HOLD_TTL must be positive")
+	}
+	if c.ScheduleCheckInterval <= 0 {
+		problems = append(problems, "SCHEDULE_CHECK_INTERVAL must be positive")
+	}
+	if c.ExpiryCheckInterval <= 0 {
+		problems = append(problems, "EXPIRY_CHECK_INTERVAL must be positive")
+	}
+	if c.ExpiryCheckJitter < 0 {
+		problems = append(problems, "EXPIRY_CHECK_JITTER must not be negative")
+	}
+	if c.DashboardCacheTTL < 0 {
+		problems = append(problems, "DASHBOARD_CACHE_TTL must not be negative")
+	}
+	if c.HealthCheckInterval <= 0 || c.HealthCheckTimeout <= 0 {
+		problems = append(problems, "HEALTH_CHECK_INTERVAL and HEALTH_CHECK_TIMEOUT must be positive")
+	}
+	if c.HealthCheckWorkers < 1 {
+		problems = append(problems, "HEALTH_CHECK_WORKERS must be at least 1")
+	}
+	if c.ReservationLookupWorkers < 1 {
+		problems = append(problems, "RESERVATION_LOOKUP_WORKERS must be at least 1")
+	}
+	if c.MinReservationMins < 1 || c.MaxReservationMins < c.MinReservationMins {
+		problems = append(problems, fmt.Sprintf("MIN_RESERVATION_MINS must be at least 1 and at most MAX_RESERVATION_MINS, got %d and %d", c.MinReservationMins, c.MaxReservationMins))
+	}
+	if c.RateLimitPerMinute < 0 {
+		problems = append(problems, "RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	for route, limit := range c.RateLimitRoutes {
+		if limit < 1 {
+			problems = append(problems, fmt.Sprintf("RATE_LIMIT_ROUTES limit for %s must be at least 1, got %d", route, limit))
+		}
+	}
+	if c.JWTExpirationHours < 1 || c.JWTExpirationHours > maxJWTExpirationHours {
+		problems = append(problems, fmt.Sprintf("JWT expiration must be between 1 and %d hours, got %d", maxJWTExpirationHours, c.JWTExpirationHours))
+	}
+
+	// Production mode refuses insecure or development-only settings
+	if c.ProductionMode {
+		if c.JWTSecret == defaultJWTSecret {
+			problems = append(problems, "JWT_SECRET must be changed from the default in production mode")
+		}
+		for _, origin := range c.CORSAllowedOrigins {
+			if origin == "*" {
+				problems = append(problems, "CORS_ALLOWED_ORIGINS must not include * in production mode")
+				break
+			}
+		}
+		if c.DynamoDBEndpoint != "" {
+			problems = append(problems, "DYNAMODB_ENDPOINT must be empty in production mode, it is only for local DynamoDB")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Snapshot is the part of the configuration that is safe to show to admins. Secrets such
+// as the JWT secret, SMTP password and bootstrap admin password are left out entirely.
+type Snapshot struct {
+	Port                            string   `json:"port"`
+	ProductionMode                  bool     `json:"productionMode"`
+	CORSAllowedOrigins              []string `json:"corsAllowedOrigins"`
+	TrustedProxies                  []string `json:"trustedProxies"`
+	TLSEnabled                      bool     `json:"tlsEnabled"`
+	AWSRegion                       string   `json:"awsRegion"`
+	DynamoDBEndpoint                string   `json:"dynamoDbEndpoint"`
+	TablePrefix                     string   `json:"tablePrefix"`
+	DynamoDBBillingMode             string   `json:"dynamoDbBillingMode"`
+	JWTExpirationHours              int      `json:"jwtExpirationHours"`
+	AllowSelfRegistration           bool     `json:"allowSelfRegistration"`
+	RateLimitPerMinute              int      `json:"rateLimitPerMinute"`
+	ReservationCheckIntervalSeconds int      `json:"reservationCheckIntervalSeconds"`
+	BackgroundJobsEnabled           bool     `json:"backgroundJobsEnabled"`
+	MinReservationMins              int      `json:"minReservationMins"`
+	MaxReservationMins              int      `json:"maxReservationMins"`
+	SMTPConfigured                  bool     `json:"smtpConfigured"`
+}
+
+// Snapshot returns the configuration without its secrets
+func (c Config) Snapshot() Snapshot {
+	return Snapshot{
+		Port:                            c.Port,
+		ProductionMode:                  c.ProductionMode,
+		CORSAllowedOrigins:              c.CORSAllowedOrigins,
+		TrustedProxies:                  c.TrustedProxies,
+		TLSEnabled:                      c.TLSEnabled,
+		AWSRegion:                       c.AWSRegion,
+		DynamoDBEndpoint:                c.DynamoDBEndpoint,
+		TablePrefix:                     c.TablePrefix,
+		DynamoDBBillingMode:             c.DynamoDBBillingMode,
+		JWTExpirationHours:              c.JWTExpirationHours,
+		AllowSelfRegistration:           c.SelfRegistrationEnabled(),
+		RateLimitPerMinute:              c.RateLimitPerMinute,
+		ReservationCheckIntervalSeconds: int(c.ExpiryCheckInterval.Seconds()),
+		BackgroundJobsEnabled:           c.BackgroundJobsEnabled,
+		MinReservationMins:              c.MinReservationMins,
+		MaxReservationMins:              c.MaxReservationMins,
+		SMTPConfigured:                  c.SMTPHost != "",
+	}
+}
+
+// TrustedProxyNetworks returns the trusted proxies as networks, a single IP address becoming
+// a network of just that address. Entries that can't be parsed are left out; Validate
+// reports them.
+func (c Config) TrustedProxyNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, proxy := range c.TrustedProxies {
+		if network, err := parseTrustedProxy(proxy); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// parseTrustedProxy parses a trusted proxy given as a CIDR or a single IP address
+func parseTrustedProxy(proxy string) (*net.IPNet, error) {
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", proxy)
+	}
+	return network, nil
+}
+
+// SelfRegistrationEnabled reports whether users can register without an invite code.
+// It is always disabled in production mode.
+func (c Config) SelfRegistrationEnabled() bool {
+	return c.AllowSelfRegistration && !c.ProductionMode
 }
 
 // getEnv retrieves an environment variable or returns a default value if not found
@@ -42,3 +531,116 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt retrieves an environment variable as an integer
+// or returns a default value if it is not set or cannot be parsed
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer %q for %s, using default %d", value, key, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getEnvBool retrieves an environment variable as a boolean ("true", "1", ...)
+// or returns a default value if it is not set or cannot be parsed
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean %q for %s, using default %t", value, key, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// getEnvList retrieves an environment variable as a comma-separated list
+// or returns a default value if it is not set or has no items
+func getEnvList(key string, defaultValue []string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return defaultValue
+	}
+	return items
+}
+
+// getEnvIntMap retrieves an environment variable as comma-separated key=integer pairs
+// (e.g. "GET /api/a=10,GET /api/b=20") or returns a default value if it is not set.
+// Pairs that can't be parsed are logged and skipped.
+func getEnvIntMap(key string, defaultValue map[string]int) map[string]int {
+	value := os.Getenv(key)
+	if strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+	result := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			log.Printf("Invalid pair %q for %s, expected key=integer", pair, key)
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			log.Printf("Invalid integer in %q for %s", pair, key)
+			continue
+		}
+		result[strings.TrimSpace(pair[:i])] = number
+	}
+	return result
+}
+
+// getEnvJWTKeySet retrieves an environment variable as a JSON JWT keyset, returning an empty
+// keyset if it is not set. Unlike the other helpers it doesn't fall back to a default when
+// the value can't be parsed, since the error should stop the server from starting.
+func getEnvJWTKeySet(key string) (JWTKeySet, error) {
+	var keySet JWTKeySet
+	value := os.Getenv(key)
+	if strings.TrimSpace(value) == "" {
+		return keySet, nil
+	}
+	if err := json.Unmarshal([]byte(value), &keySet); err != nil {
+		return JWTKeySet{}, err
+	}
+	return keySet, nil
+}
+
+// getEnvTime retrieves an environment variable as an RFC 3339 time, returning the zero time
+// if it is not set. Like getEnvJWTKeySet it returns the parse error rather than a default.
+func getEnvTime(key string) (time.Time, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// getEnvDuration retrieves an environment variable as a duration (e.g. "90s", "5m")
+// or returns a default value if it is not set or cannot be parsed
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using default %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
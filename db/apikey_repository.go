@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix starts every API key, which has the form dr_<id>_<secret>
+const apiKeyPrefix = "dr_"
+
+// APIKeyRepository handles operations on the ApiKeys table
+type APIKeyRepository struct {
+	db       *DynamoDBClient
+	userRepo *UserRepository
+}
+
+// NewAPIKeyRepository creates a new APIKeyRepository
+func NewAPIKeyRepository(db *DynamoDBClient, userRepo *UserRepository) *APIKeyRepository {
+	return &APIKeyRepository{
+		db:       db,
+		userRepo: userRepo,
+	}
+}
+
+// CreateAPIKey generates and stores a new API key. It returns the stored key along with
+// the plaintext key, which can't be recovered later.
+func (r *APIKeyRepository) CreateAPIKey(key models.APIKey) (*models.APIKey, string, error) {
+	// Generate the secret and store only its hash
+	secret, err := utils.GenerateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key.ID = uuid.New().String()
+	key.KeyHash = utils.HashToken(secret)
+	key.CreatedAt = utcNow()
+	key.Revoked = false
+
+	// Convert the key to a DynamoDB item
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal API key: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.APIKeys),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return &key, apiKeyPrefix + key.ID + "_" + secret, nil
+}
+
+// GetAPIKey gets an API key by ID, returning ErrNotFound if it doesn't exist
+func (r *APIKeyRepository) GetAPIKey(id string) (*models.APIKey, error) {
+	// Get the item from DynamoDB
+	result, err := r.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.db.Tables.APIKeys),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	// Check if the item exists
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	// Unmarshal the item into an APIKey struct
+	var key models.APIKey
+	err = attributevalue.UnmarshalMap(result.Item, &key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API key: %w", err)
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys gets all API keys, including revoked ones
+func (r *APIKeyRepository) ListAPIKeys() ([]models.APIKey, error) {
+	// Scan the table
+	result, err := r.db.Client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName: aws.String(r.db.Tables.APIKeys),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan API keys: %w", err)
+	}
+
+	// Unmarshal the items into APIKey structs
+	keys := []models.APIKey{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key so it can no longer be used
+func (r *APIKeyRepository) RevokeAPIKey(id string, username string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.APIKeys),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #revoked = :revoked, #revokedAt = :revokedAt, #revokedBy = :revokedBy"),
+		ExpressionAttributeNames: map[string]string{
+			"#revoked":   "revoked",
+			"#revokedAt": "revokedAt",
+			"#revokedBy": "revokedBy",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":revoked":   &types.AttributeValueMemberBOOL{Value: true},
+			":revokedAt": &types.AttributeValueMemberS{Value: formatTime(utcNow())},
+			":revokedBy": &types.AttributeValueMemberS{Value: username},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate resolves a plaintext API key to the key and the user that owns it. It
+// returns ErrNotFound if the key is malformed, unknown, revoked or its owner no longer exists.
+func (r *APIKeyRepository) Authenticate(plaintext string) (*models.APIKey, *models.User, error) {
+	// Split the key into its ID and secret
+	rest, ok := strings.CutPrefix(plaintext, apiKeyPrefix)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return nil, nil, ErrNotFound
+	}
+
+	// Look up the key and check the secret
+	key, err := r.GetAPIKey(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key.Revoked || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(utils.HashToken(secret))) != 1 {
+		return nil, nil, ErrNotFound
+	}
+
+	// Get the owning user so their current role applies
+	user, err := r.userRepo.GetUser(key.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, user, nil
+}
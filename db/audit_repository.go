@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/devreserve/server/models"
+)
+
+// AuditRepository handles operations on the AuditLog table
+type AuditRepository struct {
+	db *DynamoDBClient
+}
+
+// NewAuditRepository creates a new AuditRepository
+func NewAuditRepository(db *DynamoDBClient) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// RecordEvent records an action performed by a user in the audit log
+func (r *AuditRepository) RecordEvent(entry models.AuditLogEntry) error {
+	// Set the timestamp
+	entry.Timestamp = time.Now().UTC()
+
+	// Convert the entry to a DynamoDB item
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit log entry: %w", err)
+	}
+
+	// Create the input for the PutItem operation
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.AuditLog),
+		Item:      item,
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to record audit log entry: %w", err)
+	}
+
+	return nil
+}
+
+// ListRecentEventsByActor gets the most recent audit log entries for a user, newest first
+func (r *AuditRepository) ListRecentEventsByActor(actor string, limit int) ([]models.AuditLogEntry, error) {
+	// Create a key condition for the actor's entries
+	keyCond := expression.Key("actor").Equal(expression.Value(actor))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.AuditLog),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	}
+
+	// Query the table
+	result, err := r.db.Client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	// Unmarshal the items into AuditLogEntry structs
+	var entries []models.AuditLogEntry
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit log entries: %w", err)
+	}
+
+	return entries, nil
+}
@@ -1,84 +1,220 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/devreserve/server/config"
 )
 
 // DynamoDBClient represents a client for interacting with DynamoDB
 type DynamoDBClient struct {
-	Client *dynamodb.DynamoDB
+	Client *dynamodb.Client
 	Config config.Config
+	Tables TableNames
 }
 
-// DynamoDB table names
+// DynamoDB table base names; use the client's Tables to get the prefixed names
+// actually used in DynamoDB
 const (
 	UsersTableName        = "DevReserve_Users"
 	EnvironmentsTableName = "DevReserve_Environments"
 	ReservationsTableName = "DevReserve_Reservations"
+	LocksTableName        = "DevReserve_Locks"
+	AuditLogTableName     = "DevReserve_AuditLog"
+	APIKeysTableName      = "DevReserve_ApiKeys"
+
+	WebhooksTableName          = "DevReserve_Webhooks"
+	WebhookDeliveriesTableName = "DevReserve_WebhookDeliveries"
+	InvitesTableName           = "DevReserve_Invites"
+	EnvironmentStatsTableName  = "DevReserve_EnvironmentStats"
+	QueueTableName             = "DevReserve_Queue"
+	RevokedTokensTableName     = "DevReserve_RevokedTokens"
 )
 
+// maxRetryDelay caps the exponential backoff between retries of a DynamoDB request
+const maxRetryDelay = 5 * time.Second
+
+// tableActiveTimeout is how long to wait for a newly created table to become active
+const tableActiveTimeout = 2 * time.Minute
+
+// TableNames holds the names of the DynamoDB tables actually used, which are the
+// base names with the configured prefix, so several deployments can share one AWS account
+type TableNames struct {
+	Users             string
+	Environments      string
+	Reservations      string
+	Locks             string
+	AuditLog          string
+	APIKeys           string
+	Webhooks          string
+	WebhookDeliveries string
+	Invites           string
+	EnvironmentStats  string
+	Queue             string
+	RevokedTokens     string
+}
+
+// NewTableNames resolves the table names for a prefix, e.g. "staging_"
+func NewTableNames(prefix string) TableNames {
+	return TableNames{
+		Users:             prefix + UsersTableName,
+		Environments:      prefix + EnvironmentsTableName,
+		Reservations:      prefix + ReservationsTableName,
+		Locks:             prefix + LocksTableName,
+		AuditLog:          prefix + AuditLogTableName,
+		APIKeys:           prefix + APIKeysTableName,
+		Webhooks:          prefix + WebhooksTableName,
+		WebhookDeliveries: prefix + WebhookDeliveriesTableName,
+		Invites:           prefix + InvitesTableName,
+		EnvironmentStats:  prefix + EnvironmentStatsTableName,
+		Queue:             prefix + QueueTableName,
+		RevokedTokens:     prefix + RevokedTokensTableName,
+	}
+}
+
 // NewDynamoDBClient creates a new DynamoDB client
 func NewDynamoDBClient(cfg config.Config) (*DynamoDBClient, error) {
-	// Configure AWS session
-	awsConfig := &aws.Config{
-		Region: aws.String(cfg.AWSRegion),
+	// Configure the AWS SDK. Throttled and transient failures are retried with exponential
+	// backoff; requests that are still throttled after the last retry fail with an error
+	// IsThrottled recognises.
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(cfg.AWSRegion),
+		awsconfig.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = cfg.DynamoDBMaxRetries + 1
+				o.MaxBackoff = maxRetryDelay
+				o.Backoff = exponentialBackoff(cfg.DynamoDBRetryBaseDelay)
+			})
+		}),
 	}
 
 	// If a local endpoint is configured (for local development), use it
 	if cfg.DynamoDBEndpoint != "" {
-		awsConfig.Endpoint = aws.String(cfg.DynamoDBEndpoint)
 		// For local development, we can use dummy credentials
-		awsConfig.Credentials = credentials.NewStaticCredentials("dummy", "dummy", "")
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("dummy", "dummy", ""),
+		))
 	} else {
 		// Check for explicit AWS credentials from environment variables
 		accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
 		secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
 		if accessKey != "" && secretKey != "" {
-			awsConfig.Credentials = credentials.NewStaticCredentials(
-				accessKey,
-				secretKey,
-				"", // token can be empty for regular access keys
-			)
+			opts = append(opts, awsconfig.WithCredentialsProvider(
+				credentials.NewStaticCredentialsProvider(
+					accessKey,
+					secretKey,
+					"", // token can be empty for regular access keys
+				),
+			))
 		}
 	}
 
-	// Create a new AWS session
-	sess, err := session.NewSession(awsConfig)
+	// Load the AWS configuration
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 	}
 
 	// Create a new DynamoDB client
-	dbClient := dynamodb.New(sess)
+	dbClient := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	})
 
 	return &DynamoDBClient{
 		Client: dbClient,
 		Config: cfg,
+		Tables: NewTableNames(cfg.TablePrefix),
 	}, nil
 }
 
+// exponentialBackoff waits base before the first retry of a request and doubles the wait
+// for each further retry, with jitter so that throttled requests don't all retry together.
+// The retryer caps the result at maxRetryDelay.
+func exponentialBackoff(base time.Duration) retry.BackoffDelayer {
+	return retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+		if base <= 0 {
+			return 0, nil
+		}
+		delay := base << (attempt - 1)
+		if delay <= 0 || delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), nil
+	})
+}
+
 // CreateTablesIfNotExist ensures that all required DynamoDB tables exist
-func (db *DynamoDBClient) CreateTablesIfNotExist() error {
+func (db *DynamoDBClient) CreateTablesIfNotExist(ctx context.Context) error {
 	// Create Users table if it doesn't exist
-	if err := db.createUsersTable(); err != nil {
+	if err := db.createUsersTable(ctx); err != nil {
 		return err
 	}
 
 	// Create Environments table if it doesn't exist
-	if err := db.createEnvironmentsTable(); err != nil {
+	if err := db.createEnvironmentsTable(ctx); err != nil {
 		return err
 	}
 
 	// Create Reservations table if it doesn't exist
-	if err := db.createReservationsTable(); err != nil {
+	if err := db.createReservationsTable(ctx); err != nil {
+		return err
+	}
+
+	// Create Locks table if it doesn't exist
+	if err := db.createLocksTable(ctx); err != nil {
+		return err
+	}
+
+	// Create AuditLog table if it doesn't exist
+	if err := db.createAuditLogTable(ctx); err != nil {
+		return err
+	}
+
+	// Create ApiKeys table if it doesn't exist
+	if err := db.createAPIKeysTable(ctx); err != nil {
+		return err
+	}
+
+	// Create Webhooks table if it doesn't exist
+	if err := db.createWebhooksTable(ctx); err != nil {
+		return err
+	}
+
+	// Create WebhookDeliveries table if it doesn't exist
+	if err := db.createWebhookDeliveriesTable(ctx); err != nil {
+		return err
+	}
+
+	// Create Invites table if it doesn't exist
+	if err := db.createInvitesTable(ctx); err != nil {
+		return err
+	}
+
+	// Create EnvironmentStats table if it doesn't exist
+	if err := db.createEnvironmentStatsTable(ctx); err != nil {
+		return err
+	}
+
+	// Create Queue table if it doesn't exist
+	if err := db.createQueueTable(ctx); err != nil {
+		return err
+	}
+
+	// Create RevokedTokens table if it doesn't exist
+	if err := db.createRevokedTokensTable(ctx); err != nil {
 		return err
 	}
 
@@ -87,8 +223,8 @@ func (db *DynamoDBClient) CreateTablesIfNotExist() error {
 }
 
 // createUsersTable creates the Users table if it doesn't exist
-func (db *DynamoDBClient) createUsersTable() error {
-	exists, err := db.tableExists(UsersTableName)
+func (db *DynamoDBClient) createUsersTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Users)
 	if err != nil {
 		return err
 	}
@@ -97,37 +233,41 @@ func (db *DynamoDBClient) createUsersTable() error {
 	}
 
 	input := &dynamodb.CreateTableInput{
-		TableName: aws.String(UsersTableName),
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+		TableName: aws.String(db.Tables.Users),
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String("username"),
-				AttributeType: aws.String("S"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		KeySchema: []*dynamodb.KeySchemaElement{
+		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String("username"),
-				KeyType:       aws.String("HASH"),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		ProvisionedThroughput: &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(5),
 			WriteCapacityUnits: aws.Int64(5),
 		},
 	}
 
-	_, err = db.Client.CreateTable(input)
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to create Users table: %w", err)
 	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
 
 	log.Println("Created Users table")
 	return nil
 }
 
 // createEnvironmentsTable creates the Environments table if it doesn't exist
-func (db *DynamoDBClient) createEnvironmentsTable() error {
-	exists, err := db.tableExists(EnvironmentsTableName)
+func (db *DynamoDBClient) createEnvironmentsTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Environments)
 	if err != nil {
 		return err
 	}
@@ -136,108 +276,748 @@ func (db *DynamoDBClient) createEnvironmentsTable() error {
 	}
 
 	input := &dynamodb.CreateTableInput{
-		TableName: aws.String(EnvironmentsTableName),
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+		TableName: aws.String(db.Tables.Environments),
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String("id"),
-				AttributeType: aws.String("S"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		KeySchema: []*dynamodb.KeySchemaElement{
+		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String("id"),
-				KeyType:       aws.String("HASH"),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		ProvisionedThroughput: &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(5),
 			WriteCapacityUnits: aws.Int64(5),
 		},
 	}
 
-	_, err = db.Client.CreateTable(input)
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to create Environments table: %w", err)
 	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
 
 	log.Println("Created Environments table")
 	return nil
 }
 
 // createReservationsTable creates the Reservations table if it doesn't exist
-func (db *DynamoDBClient) createReservationsTable() error {
-	exists, err := db.tableExists(ReservationsTableName)
+func (db *DynamoDBClient) createReservationsTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Reservations)
 	if err != nil {
 		return err
 	}
 	if exists {
+		db.ensureEndTimeStatusIndex(ctx)
 		return nil
 	}
 
 	input := &dynamodb.CreateTableInput{
-		TableName: aws.String(ReservationsTableName),
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+		TableName: aws.String(db.Tables.Reservations),
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String("id"),
-				AttributeType: aws.String("S"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 			{
 				AttributeName: aws.String("environmentId"),
-				AttributeType: aws.String("S"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("username"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("startTime"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("status"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("endTime"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		KeySchema: []*dynamodb.KeySchemaElement{
+		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String("id"),
-				KeyType:       aws.String("HASH"),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
-		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
 			{
 				IndexName: aws.String("EnvironmentIndex"),
-				KeySchema: []*dynamodb.KeySchemaElement{
+				KeySchema: []types.KeySchemaElement{
 					{
 						AttributeName: aws.String("environmentId"),
-						KeyType:       aws.String("HASH"),
+						KeyType:       types.KeyTypeHash,
 					},
 				},
-				Projection: &dynamodb.Projection{
-					ProjectionType: aws.String("ALL"),
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
 				},
-				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ProvisionedThroughput: &types.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			{
+				IndexName: aws.String("UsernameIndex"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("username"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("startTime"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+				ProvisionedThroughput: &types.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			{
+				IndexName: aws.String("EndTimeStatusIndex"),
+				KeySchema: endTimeStatusIndexKeySchema(),
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+				ProvisionedThroughput: &types.ProvisionedThroughput{
 					ReadCapacityUnits:  aws.Int64(5),
 					WriteCapacityUnits: aws.Int64(5),
 				},
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		ProvisionedThroughput: &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(5),
 			WriteCapacityUnits: aws.Int64(5),
 		},
 	}
 
-	_, err = db.Client.CreateTable(input)
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to create Reservations table: %w", err)
 	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
 
 	log.Println("Created Reservations table")
 	return nil
 }
 
-// tableExists checks if a table exists in DynamoDB
-func (db *DynamoDBClient) tableExists(tableName string) (bool, error) {
-	input := &dynamodb.ListTablesInput{}
-	result, err := db.Client.ListTables(input)
+// endTimeStatusIndexKeySchema is the key of EndTimeStatusIndex, which lets the expiry sweep
+// query reservations of a status by end time instead of scanning the table
+func endTimeStatusIndexKeySchema() []types.KeySchemaElement {
+	return []types.KeySchemaElement{
+		{
+			AttributeName: aws.String("status"),
+			KeyType:       types.KeyTypeHash,
+		},
+		{
+			AttributeName: aws.String("endTime"),
+			KeyType:       types.KeyTypeRange,
+		},
+	}
+}
+
+// ensureEndTimeStatusIndex adds EndTimeStatusIndex to a Reservations table created before
+// the index existed. DynamoDB builds the index in the background, and the expiry sweep
+// scans the table until it is ready. Failures are only logged for the same reason, and
+// because another replica starting at the same time may already be adding the index.
+func (db *DynamoDBClient) ensureEndTimeStatusIndex(ctx context.Context) {
+	result, err := db.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(db.Tables.Reservations),
+	})
 	if err != nil {
-		return false, fmt.Errorf("failed to list tables: %w", err)
+		log.Printf("Error describing Reservations table, not checking for EndTimeStatusIndex: %v", err)
+		return
+	}
+	for _, index := range result.Table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == "EndTimeStatusIndex" {
+			return
+		}
 	}
 
-	for _, name := range result.TableNames {
-		if *name == tableName {
-			return true, nil
+	create := &types.CreateGlobalSecondaryIndexAction{
+		IndexName: aws.String("EndTimeStatusIndex"),
+		KeySchema: endTimeStatusIndexKeySchema(),
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+	// On-demand tables reject provisioned throughput for their indexes
+	if summary := result.Table.BillingModeSummary; summary == nil || summary.BillingMode != types.BillingModePayPerRequest {
+		create.ProvisionedThroughput = &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
 		}
 	}
 
-	return false, nil
+	_, err = db.Client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(db.Tables.Reservations),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("status"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("endTime"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{Create: create},
+		},
+	})
+	if err != nil {
+		log.Printf("Error adding EndTimeStatusIndex to Reservations table: %v", err)
+		return
+	}
+	log.Println("Adding EndTimeStatusIndex to Reservations table, which DynamoDB builds in the background")
+}
+
+// createLocksTable creates the Locks table if it doesn't exist
+func (db *DynamoDBClient) createLocksTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Locks)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.Locks),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("name"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("name"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create Locks table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created Locks table")
+	return nil
+}
+
+// createAuditLogTable creates the AuditLog table if it doesn't exist
+func (db *DynamoDBClient) createAuditLogTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.AuditLog)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.AuditLog),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("actor"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("actor"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create AuditLog table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created AuditLog table")
+	return nil
+}
+
+// createAPIKeysTable creates the ApiKeys table if it doesn't exist
+func (db *DynamoDBClient) createAPIKeysTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.APIKeys)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.APIKeys),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create ApiKeys table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created ApiKeys table")
+	return nil
+}
+
+// createInvitesTable creates the Invites table if it doesn't exist
+func (db *DynamoDBClient) createInvitesTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Invites)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.Invites),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("codeHash"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("codeHash"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create Invites table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created Invites table")
+	return nil
+}
+
+// createRevokedTokensTable creates the RevokedTokens table if it doesn't exist, with a TTL
+// on the ttl attribute so tokens are deleted once they have expired
+func (db *DynamoDBClient) createRevokedTokensTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.RevokedTokens)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.RevokedTokens),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("jti"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("jti"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create RevokedTokens table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	// Expired tokens are also skipped when read, so the table still works if this fails
+	_, err = db.Client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: input.TableName,
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("ttl"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		log.Printf("Error enabling TTL on the RevokedTokens table: %v", err)
+	}
+
+	log.Println("Created RevokedTokens table")
+	return nil
+}
+
+// createEnvironmentStatsTable creates the EnvironmentStats table if it doesn't exist
+func (db *DynamoDBClient) createEnvironmentStatsTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.EnvironmentStats)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.EnvironmentStats),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("environmentId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("environmentId"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create EnvironmentStats table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created EnvironmentStats table")
+	return nil
+}
+
+// createQueueTable creates the Queue table if it doesn't exist
+func (db *DynamoDBClient) createQueueTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Queue)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.Queue),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("environmentId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("position"),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("environmentId"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("position"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create Queue table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created Queue table")
+	return nil
+}
+
+// createWebhooksTable creates the Webhooks table if it doesn't exist
+func (db *DynamoDBClient) createWebhooksTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.Webhooks)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.Webhooks),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create Webhooks table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created Webhooks table")
+	return nil
+}
+
+// createWebhookDeliveriesTable creates the WebhookDeliveries table if it doesn't exist
+func (db *DynamoDBClient) createWebhookDeliveriesTable(ctx context.Context) error {
+	exists, err := db.tableExists(ctx, db.Tables.WebhookDeliveries)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(db.Tables.WebhookDeliveries),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("webhookId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("webhookId"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	}
+
+	db.applyBillingMode(input)
+	_, err = db.Client.CreateTable(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create WebhookDeliveries table: %w", err)
+	}
+	if err := db.WaitForTableActive(*input.TableName, tableActiveTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Created WebhookDeliveries table")
+	return nil
+}
+
+// CreateTablesWhenReady waits until DynamoDB answers a ListTables call and then creates the
+// tables, retrying with exponential backoff and logging each failure, for when the server
+// starts alongside a DynamoDB container that isn't accepting connections yet. Table creation
+// is retried too, since a container that just started accepting connections may still fail
+// requests for a moment. Both share one deadline of timeout.
+func (db *DynamoDBClient) CreateTablesWhenReady(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := retryWithBackoff(ctx, "DynamoDB not ready", db.ping); err != nil {
+		return err
+	}
+	return retryWithBackoff(ctx, "Failed to create DynamoDB tables", db.CreateTablesIfNotExist)
+}
+
+// ping makes the cheapest request DynamoDB answers, to check that it is reachable
+func (db *DynamoDBClient) ping(ctx context.Context) error {
+	_, err := db.Client.ListTables(ctx, &dynamodb.ListTablesInput{
+		Limit: aws.Int32(1),
+	})
+	return err
+}
+
+// retryWithBackoff calls fn until it succeeds or ctx is done, logging each failure with
+// what failed and doubling the wait between attempts from 500ms up to maxRetryDelay
+func retryWithBackoff(ctx context.Context, what string, fn func(ctx context.Context) error) error {
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("%s (attempt %d), retrying in %s: %v", what, attempt, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// WaitForTableActive polls the table's status every 500ms until it is ACTIVE, so
+// that requests made straight after creating it don't fail
+func (db *DynamoDBClient) WaitForTableActive(tableName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		result, err := db.Client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe table %s: %w", tableName, err)
+		}
+		if result.Table.TableStatus == types.TableStatusActive {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for table %s to become active", tableName)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// applyBillingMode switches a table definition to on-demand capacity if the
+// configuration asks for it. Provisioned throughput is dropped from the table and
+// its indexes, since DynamoDB rejects it for PAY_PER_REQUEST tables.
+func (db *DynamoDBClient) applyBillingMode(input *dynamodb.CreateTableInput) {
+	if db.Config.DynamoDBBillingMode != string(types.BillingModePayPerRequest) {
+		return
+	}
+
+	input.BillingMode = types.BillingModePayPerRequest
+	input.ProvisionedThroughput = nil
+	for i := range input.GlobalSecondaryIndexes {
+		input.GlobalSecondaryIndexes[i].ProvisionedThroughput = nil
+	}
+}
+
+// tableExists checks if a table exists in DynamoDB, following ListTables pagination
+// for accounts with more than 100 tables
+func (db *DynamoDBClient) tableExists(ctx context.Context, tableName string) (bool, error) {
+	input := &dynamodb.ListTablesInput{}
+	for {
+		result, err := db.Client.ListTables(ctx, input)
+		if err != nil {
+			return false, fmt.Errorf("failed to list tables: %w", err)
+		}
+
+		for _, name := range result.TableNames {
+			if name == tableName {
+				return true, nil
+			}
+		}
+
+		// Stop once the last page has been read
+		if result.LastEvaluatedTableName == nil {
+			return false, nil
+		}
+		input.ExclusiveStartTableName = result.LastEvaluatedTableName
+	}
 }
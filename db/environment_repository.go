@@ -1,12 +1,18 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/devreserve/server/models"
 	"github.com/google/uuid"
 )
@@ -29,24 +35,24 @@ func (r *EnvironmentRepository) CreateEnvironment(env models.Environment, userna
 	env.CreatedBy = username
 
 	// Set the timestamps
-	now := time.Now()
+	now := utcNow()
 	env.CreatedAt = now
 	env.LastUpdated = now
 
 	// Convert the environment to a DynamoDB item
-	item, err := dynamodbattribute.MarshalMap(env)
+	item, err := attributevalue.MarshalMap(env)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal environment: %w", err)
 	}
 
 	// Create the input for the PutItem operation
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(EnvironmentsTableName),
+		TableName: aws.String(r.db.Tables.Environments),
 		Item:      item,
 	}
 
 	// Put the item in DynamoDB
-	_, err = r.db.Client.PutItem(input)
+	_, err = r.db.Client.PutItem(context.TODO(), input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create environment: %w", err)
 	}
@@ -54,32 +60,172 @@ func (r *EnvironmentRepository) CreateEnvironment(env models.Environment, userna
 	return &env, nil
 }
 
-// GetEnvironment gets an environment by ID
+// BatchCreateEnvironments creates several environments at once using BatchWriteItem
+func (r *EnvironmentRepository) BatchCreateEnvironments(envs []models.Environment, username string) ([]models.Environment, error) {
+	// BatchWriteItem accepts at most 25 items per request
+	const batchSize = 25
+
+	now := utcNow()
+	created := make([]models.Environment, 0, len(envs))
+	for start := 0; start < len(envs); start += batchSize {
+		end := start + batchSize
+		if end > len(envs) {
+			end = len(envs)
+		}
+
+		// Prepare the write requests for this batch
+		writeRequests := make([]types.WriteRequest, 0, end-start)
+		batch := make([]models.Environment, 0, end-start)
+		for _, env := range envs[start:end] {
+			env.ID = uuid.New().String()
+			env.Status = models.StatusFree
+			env.CreatedBy = username
+			env.CreatedAt = now
+			env.LastUpdated = now
+
+			item, err := attributevalue.MarshalMap(env)
+			if err != nil {
+				return created, fmt.Errorf("failed to marshal environment: %w", err)
+			}
+			writeRequests = append(writeRequests, types.WriteRequest{
+				PutRequest: &types.PutRequest{Item: item},
+			})
+			batch = append(batch, env)
+		}
+
+		// Write the batch, retrying any unprocessed items
+		requestItems := map[string][]types.WriteRequest{
+			r.db.Tables.Environments: writeRequests,
+		}
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt == 5 {
+				return created, fmt.Errorf("failed to create environments: unprocessed items remain after retries")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*100) * time.Millisecond)
+			}
+
+			result, err := r.db.Client.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return created, fmt.Errorf("failed to create environments: %w", err)
+			}
+			requestItems = result.UnprocessedItems
+		}
+
+		created = append(created, batch...)
+	}
+
+	return created, nil
+}
+
+// GetEnvironment gets an environment by ID using an eventually consistent read
 func (r *EnvironmentRepository) GetEnvironment(id string) (*models.Environment, error) {
+	return r.getEnvironment(id, false)
+}
+
+// GetEnvironmentConsistent gets an environment by ID using a strongly consistent read, so a
+// status change made just before is always visible. Strongly consistent reads consume twice
+// the read capacity of eventually consistent ones (1 RCU instead of 0.5 for items up to 4 KB),
+// so only use this where a stale status would mislead the user, such as availability checks.
+func (r *EnvironmentRepository) GetEnvironmentConsistent(id string) (*models.Environment, error) {
+	return r.getEnvironment(id, true)
+}
+
+// maxBatchGetKeys is the most keys DynamoDB's BatchGetItem accepts in one request
+const maxBatchGetKeys = 100
+
+// BatchGetEnvironments gets the environments with the given IDs, returning the ones
+// that exist and the IDs of the ones that don't
+func (r *EnvironmentRepository) BatchGetEnvironments(ids []string) ([]models.Environment, []string, error) {
+	if len(ids) > maxBatchGetKeys {
+		return nil, nil, fmt.Errorf("cannot get more than %d environments at once", maxBatchGetKeys)
+	}
+
+	// Build the keys, skipping duplicates which BatchGetItem rejects
+	seen := make(map[string]bool, len(ids))
+	var keys []map[string]types.AttributeValue
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		})
+	}
+
+	// Get the items, retrying any keys DynamoDB didn't process because of throttling
+	var items []map[string]types.AttributeValue
+	requestItems := map[string]types.KeysAndAttributes{
+		r.db.Tables.Environments: {Keys: keys},
+	}
+	backoff := 50 * time.Millisecond
+	for len(keys) > 0 {
+		result, err := r.db.Client.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{
+			RequestItems: requestItems,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to batch get environments: %w", err)
+		}
+		items = append(items, result.Responses[r.db.Tables.Environments]...)
+
+		if len(result.UnprocessedKeys) == 0 {
+			break
+		}
+		requestItems = result.UnprocessedKeys
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	// Unmarshal the items into Environment structs
+	environments := []models.Environment{}
+	err := attributevalue.UnmarshalListOfMaps(items, &environments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal environments: %w", err)
+	}
+
+	// Work out which IDs weren't found
+	missing := []string{}
+	for _, env := range environments {
+		delete(seen, env.ID)
+	}
+	for _, id := range ids {
+		if seen[id] {
+			missing = append(missing, id)
+			delete(seen, id)
+		}
+	}
+
+	return environments, missing, nil
+}
+
+// getEnvironment gets an environment by ID, returning ErrNotFound if it doesn't exist
+func (r *EnvironmentRepository) getEnvironment(id string, consistentRead bool) (*models.Environment, error) {
 	// Create the input for the GetItem operation
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String(EnvironmentsTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
-			},
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
+		ConsistentRead: aws.Bool(consistentRead),
 	}
 
 	// Get the item from DynamoDB
-	result, err := r.db.Client.GetItem(input)
+	result, err := r.db.Client.GetItem(context.TODO(), input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get environment: %w", err)
 	}
 
 	// Check if the item exists
 	if result.Item == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	// Unmarshal the item into an Environment struct
 	var env models.Environment
-	err = dynamodbattribute.UnmarshalMap(result.Item, &env)
+	err = attributevalue.UnmarshalMap(result.Item, &env)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal environment: %w", err)
 	}
@@ -87,22 +233,149 @@ func (r *EnvironmentRepository) GetEnvironment(id string) (*models.Environment,
 	return &env, nil
 }
 
-// ListEnvironments gets all environments
-func (r *EnvironmentRepository) ListEnvironments() ([]models.Environment, error) {
+// ListEnvironments gets all environments, excluding archived ones unless includeArchived is set
+func (r *EnvironmentRepository) ListEnvironments(includeArchived bool) ([]models.Environment, error) {
 	// Create the input for the Scan operation
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(EnvironmentsTableName),
+		TableName: aws.String(r.db.Tables.Environments),
+	}
+
+	// Filter out archived environments
+	if !includeArchived {
+		filt := expression.Name("archived").AttributeNotExists().Or(
+			expression.Name("archived").Equal(expression.Value(false)),
+		)
+		expr, err := expression.NewBuilder().WithFilter(filt).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build expression: %w", err)
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
 	}
 
-	// Scan the table
-	result, err := r.db.Client.Scan(input)
+	// Scan the whole table, a page at a time
+	items, err := r.db.scanAll(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list environments: %w", err)
 	}
 
 	// Unmarshal the items into Environment structs
 	var environments []models.Environment
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &environments)
+	err = attributevalue.UnmarshalListOfMaps(items, &environments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environments: %w", err)
+	}
+
+	return environments, nil
+}
+
+// GetEnvironmentByName gets the environment with the given name, ignoring case, archived or
+// not, returning ErrNotFound if there is none. Names aren't indexed, so it scans the table.
+func (r *EnvironmentRepository) GetEnvironmentByName(name string) (*models.Environment, error) {
+	// Scan the table until an environment with the name turns up
+	paginator := dynamodb.NewScanPaginator(r.db.Client, &dynamodb.ScanInput{
+		TableName: aws.String(r.db.Tables.Environments),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to find environment by name: %w", err)
+		}
+		var environments []models.Environment
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &environments); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal environments: %w", err)
+		}
+		for i := range environments {
+			if strings.EqualFold(environments[i].Name, name) {
+				return &environments[i], nil
+			}
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// ListAvailableEnvironments gets all free, unarchived environments, optionally only those
+// with the given tag and/or in the given pool
+func (r *EnvironmentRepository) ListAvailableEnvironments(tag, pool string) ([]models.Environment, error) {
+	// Create a filter expression for available environments
+	filt := expression.And(
+		expression.Name("status").Equal(expression.Value(models.StatusFree)),
+		expression.Name("archived").AttributeNotExists().Or(
+			expression.Name("archived").Equal(expression.Value(false)),
+		),
+	)
+	if tag != "" {
+		filt = filt.And(expression.Name("tags").Contains(tag))
+	}
+	if pool != "" {
+		filt = filt.And(expression.Name("pool").Equal(expression.Value(pool)))
+	}
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Scan operation
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Environments),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	// Scan the whole table, a page at a time; the filter applies to each page
+	items, err := r.db.scanAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list available environments: %w", err)
+	}
+
+	// Unmarshal the items into Environment structs
+	environments := []models.Environment{}
+	err = attributevalue.UnmarshalListOfMaps(items, &environments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environments: %w", err)
+	}
+
+	return environments, nil
+}
+
+// ListEnvironmentsByGroup gets the unarchived environments in a group
+func (r *EnvironmentRepository) ListEnvironmentsByGroup(groupID string) ([]models.Environment, error) {
+	// Create a filter expression for the group's environments
+	filt := expression.And(
+		expression.Name("groupId").Equal(expression.Value(groupID)),
+		expression.Name("archived").AttributeNotExists().Or(
+			expression.Name("archived").Equal(expression.Value(false)),
+		),
+	)
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Scan operation, reading consistently since the
+	// statuses decide whether the group can be reserved
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Environments),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+	}
+
+	// Scan the table, following pagination since the filter matches few environments
+	items, err := r.db.scanAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments in group: %w", err)
+	}
+
+	// Unmarshal the items into Environment structs
+	environments := []models.Environment{}
+	err = attributevalue.UnmarshalListOfMaps(items, &environments)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal environments: %w", err)
 	}
@@ -112,25 +385,30 @@ func (r *EnvironmentRepository) ListEnvironments() ([]models.Environment, error)
 
 // UpdateEnvironment updates an existing environment
 func (r *EnvironmentRepository) UpdateEnvironment(env models.Environment) error {
-	// Set the last updated timestamp
-	env.LastUpdated = time.Now()
+	// Set the last updated timestamp, storing every timestamp in UTC
+	env.LastUpdated = utcNow()
+	env.CreatedAt = utc(env.CreatedAt)
+	if env.ArchivedAt != nil {
+		archivedAt := utc(*env.ArchivedAt)
+		env.ArchivedAt = &archivedAt
+	}
 
 	// Convert the environment to a DynamoDB item
-	item, err := dynamodbattribute.MarshalMap(env)
+	item, err := attributevalue.MarshalMap(env)
 	if err != nil {
 		return fmt.Errorf("failed to marshal environment: %w", err)
 	}
 
 	// Create the input for the PutItem operation
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(EnvironmentsTableName),
+		TableName: aws.String(r.db.Tables.Environments),
 		Item:      item,
 		// Ensure the environment ID exists
 		ConditionExpression: aws.String("attribute_exists(id)"),
 	}
 
 	// Put the item in DynamoDB
-	_, err = r.db.Client.PutItem(input)
+	_, err = r.db.Client.PutItem(context.TODO(), input)
 	if err != nil {
 		return fmt.Errorf("failed to update environment: %w", err)
 	}
@@ -138,34 +416,98 @@ func (r *EnvironmentRepository) UpdateEnvironment(env models.Environment) error
 	return nil
 }
 
+// UpdateEnvironmentDefinition sets an environment's description, tags and reservation limits
+// from a seed, leaving its status and everything else alone. Empty tags and zero limits are
+// removed. It returns ErrNotFound if the environment doesn't exist.
+func (r *EnvironmentRepository) UpdateEnvironmentDefinition(id string, seed models.EnvironmentSeed) error {
+	// Set the seeded fields that have values and remove the others
+	set := []string{"#description = :description", "#lastUpdated = :lastUpdated"}
+	var remove []string
+	values := map[string]types.AttributeValue{
+		":description": &types.AttributeValueMemberS{Value: seed.Description},
+		":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+	}
+	if len(seed.Tags) > 0 {
+		tags, err := attributevalue.Marshal(seed.Tags)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tags: %w", err)
+		}
+		set = append(set, "#tags = :tags")
+		values[":tags"] = tags
+	} else {
+		remove = append(remove, "#tags")
+	}
+	if seed.MinReservationMins != 0 {
+		set = append(set, "#minReservationMins = :minReservationMins")
+		values[":minReservationMins"] = &types.AttributeValueMemberN{Value: strconv.Itoa(seed.MinReservationMins)}
+	} else {
+		remove = append(remove, "#minReservationMins")
+	}
+	if seed.MaxReservationMins != 0 {
+		set = append(set, "#maxReservationMins = :maxReservationMins")
+		values[":maxReservationMins"] = &types.AttributeValueMemberN{Value: strconv.Itoa(seed.MaxReservationMins)}
+	} else {
+		remove = append(remove, "#maxReservationMins")
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String(update),
+		ExpressionAttributeNames: map[string]string{
+			"#description":        "description",
+			"#tags":               "tags",
+			"#minReservationMins": "minReservationMins",
+			"#maxReservationMins": "maxReservationMins",
+			"#lastUpdated":        "lastUpdated",
+		},
+		ExpressionAttributeValues: values,
+		// Ensure the environment exists
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to update environment definition: %w", err)
+	}
+
+	return nil
+}
+
 // UpdateEnvironmentStatus updates the status of an environment
 func (r *EnvironmentRepository) UpdateEnvironmentStatus(id string, status models.EnvironmentStatus) error {
 	// Create the input for the UpdateItem operation
 	input := &dynamodb.UpdateItemInput{
-		TableName: aws.String(EnvironmentsTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
-			},
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 		UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated"),
-		ExpressionAttributeNames: map[string]*string{
-			"#status":      aws.String("status"),
-			"#lastUpdated": aws.String("lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":      "status",
+			"#lastUpdated": "lastUpdated",
 		},
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":status": {
-				S: aws.String(string(status)),
-			},
-			":lastUpdated": {
-				S: aws.String(time.Now().Format(time.RFC3339)),
-			},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":      &types.AttributeValueMemberS{Value: string(status)},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
 		},
 		ConditionExpression: aws.String("attribute_exists(id)"),
 	}
 
 	// Update the item in DynamoDB
-	_, err := r.db.Client.UpdateItem(input)
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
 	if err != nil {
 		return fmt.Errorf("failed to update environment status: %w", err)
 	}
@@ -173,22 +515,441 @@ func (r *EnvironmentRepository) UpdateEnvironmentStatus(id string, status models
 	return nil
 }
 
-// DeleteEnvironment deletes an environment by ID
-func (r *EnvironmentRepository) DeleteEnvironment(id string) error {
-	// Create the input for the DeleteItem operation
-	input := &dynamodb.DeleteItemInput{
-		TableName: aws.String(EnvironmentsTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
+// FreeEnvironment frees an environment held by the given reservation. It returns
+// ErrEnvironmentChanged if another reservation holds the environment by now, or if it is
+// locked or held, which records no reservation.
+func (r *EnvironmentRepository) FreeEnvironment(id string, reservationID string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #status = :free, #lastUpdated = :lastUpdated REMOVE #currentReservationId"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":               "status",
+			"#lastUpdated":          "lastUpdated",
+			"#currentReservationId": "currentReservationId",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":free":          &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+			":locked":        &types.AttributeValueMemberS{Value: string(models.StatusLocked)},
+			":held":          &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+			":lastUpdated":   &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+			":reservationId": &types.AttributeValueMemberS{Value: reservationID},
+		},
+		ConditionExpression: aws.String("attribute_exists(id) AND #status <> :locked AND #status <> :held AND " + heldByReservationCondition),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrEnvironmentChanged
+		}
+		return fmt.Errorf("failed to free environment: %w", err)
+	}
+
+	return nil
+}
+
+// CorrectEnvironmentStatus sets env's status to status and its holder to reservationID, or
+// removes the holder if reservationID is empty, as long as neither its status nor its last
+// updated time changed since env was read. It returns ErrEnvironmentChanged otherwise.
+func (r *EnvironmentRepository) CorrectEnvironmentStatus(env models.Environment, status models.EnvironmentStatus, reservationID string) error {
+	values := map[string]types.AttributeValue{
+		":status":         &types.AttributeValueMemberS{Value: string(status)},
+		":now":            &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+		":expectedStatus": &types.AttributeValueMemberS{Value: string(env.Status)},
+		":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(env.LastUpdated)},
+		":utc":            &types.AttributeValueMemberS{Value: utcSuffix},
+	}
+	updateExpr := "SET #status = :status, #lastUpdated = :now REMOVE #currentReservationId"
+	if reservationID != "" {
+		updateExpr = "SET #status = :status, #lastUpdated = :now, #currentReservationId = :reservationId"
+		values[":reservationId"] = &types.AttributeValueMemberS{Value: reservationID}
+	}
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: env.ID},
+		},
+		UpdateExpression: aws.String(updateExpr),
+		ExpressionAttributeNames: map[string]string{
+			"#status":               "status",
+			"#lastUpdated":          "lastUpdated",
+			"#currentReservationId": "currentReservationId",
+		},
+		ExpressionAttributeValues: values,
+		// Environments last updated before timestamps were stored in UTC can't be compared exactly
+		ConditionExpression: aws.String("#status = :expectedStatus AND (#lastUpdated = :lastUpdated OR NOT contains(#lastUpdated, :utc))"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrEnvironmentChanged
+		}
+		return fmt.Errorf("failed to correct environment status: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateHealthStatus records the result of probing an environment's health check URL. The result
+// is dropped if the environment's URL changed while it was being probed.
+func (r *EnvironmentRepository) UpdateHealthStatus(id string, healthCheckURL string, status models.HealthStatus, checkedAt time.Time, lastError string) error {
+	updateExpr := "SET #healthStatus = :healthStatus, #lastHealthCheckAt = :lastHealthCheckAt"
+	names := map[string]string{
+		"#healthCheckUrl":    "healthCheckUrl",
+		"#healthStatus":      "healthStatus",
+		"#lastHealthCheckAt": "lastHealthCheckAt",
+		"#lastHealthError":   "lastHealthError",
+	}
+	values := map[string]types.AttributeValue{
+		":healthCheckUrl":    &types.AttributeValueMemberS{Value: healthCheckURL},
+		":healthStatus":      &types.AttributeValueMemberS{Value: string(status)},
+		":lastHealthCheckAt": &types.AttributeValueMemberS{Value: formatTime(checkedAt)},
+	}
+	if lastError != "" {
+		updateExpr += ", #lastHealthError = :lastHealthError"
+		values[":lastHealthError"] = &types.AttributeValueMemberS{Value: lastError}
+	} else {
+		updateExpr += " REMOVE #lastHealthError"
+	}
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(updateExpr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ConditionExpression:       aws.String("#healthCheckUrl = :healthCheckUrl"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return nil
+		}
+		return fmt.Errorf("failed to update environment health: %w", err)
+	}
+
+	return nil
+}
+
+// LockEnvironment locks a free environment so it can't be reserved, recording who locked
+// it and why. It returns ErrEnvironmentUnavailable if the environment isn't free.
+func (r *EnvironmentRepository) LockEnvironment(id string, username string, reason string) error {
+	now := formatTime(time.Now())
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #status = :locked, #lockedReason = :lockedReason, #lockedBy = :lockedBy, #lockedAt = :lockedAt, #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":       "status",
+			"#lockedReason": "lockedReason",
+			"#lockedBy":     "lockedBy",
+			"#lockedAt":     "lockedAt",
+			"#lastUpdated":  "lastUpdated",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":locked":       &types.AttributeValueMemberS{Value: string(models.StatusLocked)},
+			":lockedReason": &types.AttributeValueMemberS{Value: reason},
+			":lockedBy":     &types.AttributeValueMemberS{Value: username},
+			":lockedAt":     &types.AttributeValueMemberS{Value: now},
+			":lastUpdated":  &types.AttributeValueMemberS{Value: now},
+			":free":         &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+		},
+		// Only free environments can be locked
+		ConditionExpression: aws.String("attribute_exists(id) AND #status = :free"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrEnvironmentUnavailable
+		}
+		return fmt.Errorf("failed to lock environment: %w", err)
+	}
+
+	return nil
+}
+
+// UnlockEnvironment frees a locked environment, clearing the lock details. It returns
+// ErrNotLocked if the environment isn't locked.
+func (r *EnvironmentRepository) UnlockEnvironment(id string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #status = :free, #lastUpdated = :lastUpdated REMOVE #lockedReason, #lockedBy, #lockedAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":       "status",
+			"#lockedReason": "lockedReason",
+			"#lockedBy":     "lockedBy",
+			"#lockedAt":     "lockedAt",
+			"#lastUpdated":  "lastUpdated",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":free":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+			":locked":      &types.AttributeValueMemberS{Value: string(models.StatusLocked)},
+		},
+		ConditionExpression: aws.String("attribute_exists(id) AND #status = :locked"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrNotLocked
+		}
+		return fmt.Errorf("failed to unlock environment: %w", err)
+	}
+
+	return nil
+}
+
+// HoldEnvironment holds an environment for username until heldUntil, so nobody else can reserve
+// it while they decide. The environment must be free, already held by username, whose hold is
+// then extended, or held by a hold that has run out. It returns ErrEnvironmentUnavailable otherwise.
+func (r *EnvironmentRepository) HoldEnvironment(id string, username string, heldUntil time.Time) error {
+	now := utcNow()
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #status = :held, #heldBy = :heldBy, #heldUntil = :heldUntil, #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":      "status",
+			"#heldBy":      "heldBy",
+			"#heldUntil":   "heldUntil",
+			"#lastUpdated": "lastUpdated",
+			"#archived":    "archived",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":held":        &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+			":heldBy":      &types.AttributeValueMemberS{Value: username},
+			":heldUntil":   &types.AttributeValueMemberS{Value: formatTime(heldUntil)},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(now)},
+			":now":         &types.AttributeValueMemberS{Value: formatTime(now)},
+			":free":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+			":archived":    &types.AttributeValueMemberBOOL{Value: true},
+		},
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(#archived) OR #archived <> :archived) AND " +
+			"(#status = :free OR (#status = :held AND (#heldBy = :heldBy OR #heldUntil <= :now)))"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrEnvironmentUnavailable
+		}
+		return fmt.Errorf("failed to hold environment: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseHold frees an environment held by username. It returns ErrNotHeld if the
+// environment isn't held by them.
+func (r *EnvironmentRepository) ReleaseHold(id string, username string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #status = :free, #lastUpdated = :lastUpdated REMOVE #heldBy, #heldUntil"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":      "status",
+			"#heldBy":      "heldBy",
+			"#heldUntil":   "heldUntil",
+			"#lastUpdated": "lastUpdated",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":free":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+			":held":        &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+			":heldBy":      &types.AttributeValueMemberS{Value: username},
+		},
+		ConditionExpression: aws.String("attribute_exists(id) AND #status = :held AND #heldBy = :heldBy"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrNotHeld
+		}
+		return fmt.Errorf("failed to release hold: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseExpiredHolds frees environments whose holds have run out without being turned
+// into a reservation. It returns the IDs of the environments it freed.
+func (r *EnvironmentRepository) ReleaseExpiredHolds() ([]string, error) {
+	now := utcNow()
+
+	// Find held environments whose hold has run out
+	filt := expression.And(
+		expression.Name("status").Equal(expression.Value(models.StatusHeld)),
+		expression.Name("heldUntil").LessThanEqual(expression.Value(formatTime(now))),
+	)
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table, following pagination since the filter is applied after each page is read
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Environments),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for expired holds: %w", err)
+	}
+
+	var environments []models.Environment
+	err = attributevalue.UnmarshalListOfMaps(items, &environments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environments: %w", err)
+	}
+
+	var freed []string
+	for _, env := range environments {
+		if env.HeldUntil == nil {
+			continue
+		}
+
+		// Only free the environment if nobody took it or renewed the hold in the meantime
+		_, err := r.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:        aws.String(r.db.Tables.Environments),
+			Key:              map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: env.ID}},
+			UpdateExpression: aws.String("SET #status = :free, #lastUpdated = :lastUpdated REMOVE #heldBy, #heldUntil"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":      "status",
+				"#heldBy":      "heldBy",
+				"#heldUntil":   "heldUntil",
+				"#lastUpdated": "lastUpdated",
 			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":free":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(now)},
+				":held":        &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+				":heldUntil":   &types.AttributeValueMemberS{Value: formatTime(*env.HeldUntil)},
+			},
+			ConditionExpression: aws.String("#status = :held AND #heldUntil = :heldUntil"),
+		})
+		if err != nil {
+			var ccf *types.ConditionalCheckFailedException
+			if errors.As(err, &ccf) {
+				continue
+			}
+			return freed, fmt.Errorf("failed to release expired hold on environment %s: %w", env.ID, err)
+		}
+		freed = append(freed, env.ID)
+	}
+
+	return freed, nil
+}
+
+// ArchiveEnvironment archives a free environment. Environments are archived rather than
+// deleted so their reservation history stays intact.
+func (r *EnvironmentRepository) ArchiveEnvironment(id string, username string) error {
+	now := formatTime(time.Now())
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #archived = :archived, #archivedAt = :archivedAt, #archivedBy = :archivedBy, #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#archived":    "archived",
+			"#archivedAt":  "archivedAt",
+			"#archivedBy":  "archivedBy",
+			"#lastUpdated": "lastUpdated",
+			"#status":      "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":archived":    &types.AttributeValueMemberBOOL{Value: true},
+			":archivedAt":  &types.AttributeValueMemberS{Value: now},
+			":archivedBy":  &types.AttributeValueMemberS{Value: username},
+			":lastUpdated": &types.AttributeValueMemberS{Value: now},
+			":free":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+		},
+		// Environments can only be archived while nobody holds them
+		ConditionExpression: aws.String("attribute_exists(id) AND #status = :free"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to archive environment: %w", err)
+	}
+
+	return nil
+}
+
+// UnarchiveEnvironment makes an archived environment available again
+func (r *EnvironmentRepository) UnarchiveEnvironment(id string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Environments),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #archived = :archived, #lastUpdated = :lastUpdated REMOVE #archivedAt, #archivedBy"),
+		ExpressionAttributeNames: map[string]string{
+			"#archived":    "archived",
+			"#archivedAt":  "archivedAt",
+			"#archivedBy":  "archivedBy",
+			"#lastUpdated": "lastUpdated",
 		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":archived":    &types.AttributeValueMemberBOOL{Value: false},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
 	}
 
-	// Delete the item from DynamoDB
-	_, err := r.db.Client.DeleteItem(input)
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
 	if err != nil {
-		return fmt.Errorf("failed to delete environment: %w", err)
+		return fmt.Errorf("failed to unarchive environment: %w", err)
 	}
 
 	return nil
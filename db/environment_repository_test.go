@@ -0,0 +1,154 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/models"
+)
+
+// newFakeDynamoDB returns a client for a fake DynamoDB endpoint that answers every GetItem
+// request with item, or with no item if item is empty, and sends the decoded requests to got
+func newFakeDynamoDB(t *testing.T, item string, got chan<- map[string]interface{}) *DynamoDBClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); !strings.HasSuffix(target, ".GetItem") {
+			t.Errorf("unexpected request %s", target)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var input map[string]interface{}
+		if err := json.Unmarshal(body, &input); err != nil {
+			t.Errorf("decoding request %s: %v", body, err)
+		}
+		got <- input
+
+		response := `{}`
+		if item != "" {
+			response = `{"Item": ` + item + `}`
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Header().Set("X-Amz-Crc32", strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(response))), 10))
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewDynamoDBClient(config.Config{AWSRegion: "us-east-1", DynamoDBEndpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewDynamoDBClient: %v", err)
+	}
+	return client
+}
+
+func TestGetEnvironmentReadConsistency(t *testing.T) {
+	item := `{"id": {"S": "env-1"}, "name": {"S": "qa-1"}, "status": {"S": "FREE"}}`
+	tests := []struct {
+		name           string
+		get            func(r *EnvironmentRepository, id string) error
+		wantConsistent bool
+	}{
+		{"eventually consistent", func(r *EnvironmentRepository, id string) error {
+			_, err := r.GetEnvironment(id)
+			return err
+		}, false},
+		{"consistent", func(r *EnvironmentRepository, id string) error {
+			_, err := r.GetEnvironmentConsistent(id)
+			return err
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan map[string]interface{}, 1)
+			repo := NewEnvironmentRepository(newFakeDynamoDB(t, item, requests))
+
+			if err := tt.get(repo, "env-1"); err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			input := <-requests
+			consistent, _ := input["ConsistentRead"].(bool)
+			if consistent != tt.wantConsistent {
+				t.Errorf("ConsistentRead = %v, want %v", input["ConsistentRead"], tt.wantConsistent)
+			}
+		})
+	}
+}
+
+func TestGetEnvironmentConsistentNotFound(t *testing.T) {
+	repo := NewEnvironmentRepository(newFakeDynamoDB(t, "", make(chan map[string]interface{}, 1)))
+	if env, err := repo.GetEnvironmentConsistent("env-gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetEnvironmentConsistent = %v, %v; want ErrNotFound", env, err)
+	}
+}
+
+func TestEnvironmentDetailsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		details       map[string]string
+		secretDetails map[string]string
+		// wantStored lists the attributes written; nil maps are left out of the item
+		wantStored []string
+	}{
+		{"both", map[string]string{"url": "https://qa-1.internal", "ssh": "qa-1.internal:22"}, map[string]string{"dbPassword": "hunter2"}, []string{"details", "secretDetails"}},
+		{"public only", map[string]string{"url": "https://qa-1.internal"}, nil, []string{"details"}},
+		{"none", nil, nil, nil},
+		{"cleared", map[string]string{}, map[string]string{}, []string{"details", "secretDetails"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := attributevalue.MarshalMap(models.Environment{ID: "env-1", Details: tt.details, SecretDetails: tt.secretDetails})
+			if err != nil {
+				t.Fatalf("MarshalMap: %v", err)
+			}
+			for _, name := range []string{"details", "secretDetails"} {
+				_, stored := item[name]
+				wantStored := false
+				for _, want := range tt.wantStored {
+					wantStored = wantStored || want == name
+				}
+				if stored != wantStored {
+					t.Errorf("%s stored = %v, want %v", name, stored, wantStored)
+				}
+			}
+
+			var got models.Environment
+			if err := attributevalue.UnmarshalMap(item, &got); err != nil {
+				t.Fatalf("UnmarshalMap: %v", err)
+			}
+			if len(got.Details) != len(tt.details) || (len(tt.details) > 0 && !reflect.DeepEqual(got.Details, tt.details)) {
+				t.Errorf("details = %v, want %v", got.Details, tt.details)
+			}
+			if len(got.SecretDetails) != len(tt.secretDetails) || (len(tt.secretDetails) > 0 && !reflect.DeepEqual(got.SecretDetails, tt.secretDetails)) {
+				t.Errorf("secretDetails = %v, want %v", got.SecretDetails, tt.secretDetails)
+			}
+		})
+	}
+}
+
+func TestGetEnvironmentReadsDetails(t *testing.T) {
+	item := `{"id": {"S": "env-1"}, "name": {"S": "qa-1"}, "status": {"S": "FREE"},
+		"details": {"M": {"url": {"S": "https://qa-1.internal"}}},
+		"secretDetails": {"M": {"dbPassword": {"S": "hunter2"}}}}`
+	repo := NewEnvironmentRepository(newFakeDynamoDB(t, item, make(chan map[string]interface{}, 1)))
+
+	env, err := repo.GetEnvironment("env-1")
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if want := map[string]string{"url": "https://qa-1.internal"}; !reflect.DeepEqual(env.Details, want) {
+		t.Errorf("details = %v, want %v", env.Details, want)
+	}
+	if want := map[string]string{"dbPassword": "hunter2"}; !reflect.DeepEqual(env.SecretDetails, want) {
+		t.Errorf("secretDetails = %v, want %v", env.SecretDetails, want)
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrNotFound is returned when the requested item does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrNotPending is returned when approving a reservation that isn't waiting for approval
+var ErrNotPending = errors.New("reservation is not pending approval")
+
+// ErrEnvironmentUnavailable is returned when an environment can no longer be reserved
+var ErrEnvironmentUnavailable = errors.New("environment is no longer available")
+
+// ErrInviteUsed is returned when registering with an invite code that has already been used
+var ErrInviteUsed = errors.New("invite code has already been used")
+
+// ErrInviteExpired is returned when registering with an invite code that has expired
+var ErrInviteExpired = errors.New("invite code has expired")
+
+// ErrReservationChanged is returned when a reservation was released, expired or transferred
+// by someone else while it was being changed
+var ErrReservationChanged = errors.New("reservation was changed in the meantime")
+
+// ErrEnvironmentChanged is returned when an environment's status was changed by someone else
+// while it was being corrected
+var ErrEnvironmentChanged = errors.New("environment was changed in the meantime")
+
+// ErrNotOwner is returned when a user tries to change a reservation that belongs to someone else
+var ErrNotOwner = errors.New("you can only change your own reservations")
+
+// ErrInvalidPageToken is returned when a pagination token wasn't returned by a previous page
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ErrNotLocked is returned when unlocking an environment that isn't locked
+var ErrNotLocked = errors.New("environment is not locked")
+
+// ErrNotHeld is returned when releasing a hold on an environment the user isn't holding
+var ErrNotHeld = errors.New("environment is not held by this user")
+
+// IsThrottled reports whether err is DynamoDB rejecting a request because of throughput
+// limits, after the client has used up its retries
+func IsThrottled(err error) bool {
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ThrottlingError" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException":
+		return true
+	}
+	return false
+}
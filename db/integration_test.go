@@ -0,0 +1,807 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/models"
+)
+
+// The integration tests run the repositories against a real DynamoDB API, such as DynamoDB
+// Local or LocalStack, given by DYNAMODB_TEST_ENDPOINT:
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_TEST_ENDPOINT=http://localhost:8000 go test -tags integration ./db/
+//
+// Each test creates its own tables under a unique prefix and deletes them afterwards.
+
+// newIntegrationClient creates the tables for a test or benchmark and returns a client for them, skipping
+// the test if no endpoint is configured
+func newIntegrationClient(t testing.TB) *DynamoDBClient {
+	t.Helper()
+	endpoint := os.Getenv("DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_TEST_ENDPOINT is not set")
+	}
+
+	cfg := config.Config{
+		AWSRegion:              "us-east-1",
+		DynamoDBEndpoint:       endpoint,
+		TablePrefix:            fmt.Sprintf("it%d_", time.Now().UnixNano()),
+		DynamoDBMaxRetries:     3,
+		DynamoDBRetryBaseDelay: 50 * time.Millisecond,
+		MinReservationMins:     10,
+		MaxReservationMins:     24 * 60,
+	}
+	client, err := NewDynamoDBClient(cfg)
+	if err != nil {
+		t.Fatalf("NewDynamoDBClient: %v", err)
+	}
+	if err := client.CreateTablesIfNotExist(context.Background()); err != nil {
+		t.Fatalf("CreateTablesIfNotExist: %v", err)
+	}
+	t.Cleanup(func() { deleteTables(t, client) })
+	return client
+}
+
+// deleteTables deletes every table with the client's prefix
+func deleteTables(t testing.TB, client *DynamoDBClient) {
+	paginator := dynamodb.NewListTablesPaginator(client.Client, &dynamodb.ListTablesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Logf("listing tables to delete: %v", err)
+			return
+		}
+		for _, name := range page.TableNames {
+			if !strings.HasPrefix(name, client.Config.TablePrefix) {
+				continue
+			}
+			if _, err := client.Client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{
+				TableName: aws.String(name),
+			}); err != nil {
+				t.Logf("deleting table %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// createTestEnvironment creates a free environment with the given name
+func createTestEnvironment(t testing.TB, envRepo *EnvironmentRepository, name string) *models.Environment {
+	t.Helper()
+	env, err := envRepo.CreateEnvironment(models.Environment{Name: name, Description: "integration test"}, "admin")
+	if err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	return env
+}
+
+// reserveAs reserves env for username from start to end
+func reserveAs(t *testing.T, repo *ReservationRepository, env *models.Environment, username string, start, end time.Time) *models.Reservation {
+	t.Helper()
+	reservation, err := repo.CreateReservation(models.Reservation{
+		EnvironmentID: env.ID,
+		Username:      username,
+		StartTime:     start,
+		EndTime:       end,
+	})
+	if err != nil {
+		t.Fatalf("CreateReservation(%s): %v", username, err)
+	}
+	return reservation
+}
+
+// expectEnvironment checks an environment's status and the reservation holding it
+func expectEnvironment(t *testing.T, envRepo *EnvironmentRepository, id string, status models.EnvironmentStatus, reservationID string) {
+	t.Helper()
+	env, err := envRepo.GetEnvironmentConsistent(id)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if env.Status != status || env.CurrentReservationID != reservationID {
+		t.Errorf("environment is %s held by %q, want %s held by %q", env.Status, env.CurrentReservationID, status, reservationID)
+	}
+}
+
+func TestIntegrationUserRoundTrip(t *testing.T) {
+	client := newIntegrationClient(t)
+	repo := NewUserRepository(client)
+
+	user := models.User{
+		Username: "alice",
+		Password: "hash",
+		Email:    "alice@example.com",
+		Role:     models.RoleUser,
+		Team:     "payments",
+	}
+	if err := repo.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	got, err := repo.GetUser("alice")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Email != user.Email || got.Team != user.Team || got.Role != user.Role {
+		t.Errorf("GetUser = %+v, want the created user", got)
+	}
+
+	byEmail, err := repo.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if byEmail.Username != "alice" {
+		t.Errorf("GetUserByEmail username = %q, want alice", byEmail.Username)
+	}
+
+	version, err := repo.IncrementTokenVersion("alice")
+	if err != nil {
+		t.Fatalf("IncrementTokenVersion: %v", err)
+	}
+	if version != 1 {
+		t.Errorf("IncrementTokenVersion = %d, want 1", version)
+	}
+	versions, err := repo.ListTokenVersions()
+	if err != nil {
+		t.Fatalf("ListTokenVersions: %v", err)
+	}
+	if versions["alice"] != 1 {
+		t.Errorf("ListTokenVersions[alice] = %d, want 1", versions["alice"])
+	}
+
+	if _, err := repo.IncrementTokenVersion("nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("IncrementTokenVersion(nobody) error = %v, want ErrNotFound", err)
+	}
+
+	if err := repo.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := repo.GetUser("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestIntegrationEnvironmentRoundTrip(t *testing.T) {
+	client := newIntegrationClient(t)
+	repo := NewEnvironmentRepository(client)
+
+	env := createTestEnvironment(t, repo, "Staging-1")
+	if env.Status != models.StatusFree {
+		t.Errorf("created environment status = %s, want %s", env.Status, models.StatusFree)
+	}
+
+	byName, err := repo.GetEnvironmentByName("staging-1")
+	if err != nil {
+		t.Fatalf("GetEnvironmentByName: %v", err)
+	}
+	if byName.ID != env.ID {
+		t.Errorf("GetEnvironmentByName ID = %s, want %s", byName.ID, env.ID)
+	}
+	if _, err := repo.GetEnvironmentByName("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetEnvironmentByName(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := repo.UpdateEnvironmentStatus(env.ID, models.StatusLocked); err != nil {
+		t.Fatalf("UpdateEnvironmentStatus: %v", err)
+	}
+	got, err := repo.GetEnvironmentConsistent(env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if got.Status != models.StatusLocked {
+		t.Errorf("status after update = %s, want %s", got.Status, models.StatusLocked)
+	}
+
+	found, missing, err := repo.BatchGetEnvironments([]string{env.ID, "missing", env.ID})
+	if err != nil {
+		t.Fatalf("BatchGetEnvironments: %v", err)
+	}
+	if len(found) != 1 || len(missing) != 1 || missing[0] != "missing" {
+		t.Errorf("BatchGetEnvironments = %d found, missing %v; want 1 found, missing [missing]", len(found), missing)
+	}
+}
+
+func TestIntegrationEnvironmentDetailsRoundTrip(t *testing.T) {
+	client := newIntegrationClient(t)
+	repo := NewEnvironmentRepository(client)
+
+	details := map[string]string{"url": "https://qa-1.internal", "ssh": "qa-1.internal:22"}
+	secretDetails := map[string]string{"dbPassword": "hunter2"}
+	env, err := repo.CreateEnvironment(models.Environment{Name: "Detailed-1", Details: details, SecretDetails: secretDetails}, "admin")
+	if err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	got, err := repo.GetEnvironmentConsistent(env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if !reflect.DeepEqual(got.Details, details) || !reflect.DeepEqual(got.SecretDetails, secretDetails) {
+		t.Errorf("details = %v, secret %v; want %v, secret %v", got.Details, got.SecretDetails, details, secretDetails)
+	}
+
+	// Clearing the secret details leaves the public ones in place
+	got.SecretDetails = map[string]string{}
+	if err := repo.UpdateEnvironment(*got); err != nil {
+		t.Fatalf("UpdateEnvironment: %v", err)
+	}
+	got, err = repo.GetEnvironmentConsistent(env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if !reflect.DeepEqual(got.Details, details) || len(got.SecretDetails) != 0 {
+		t.Errorf("after clearing, details = %v, secret %v; want %v, no secret", got.Details, got.SecretDetails, details)
+	}
+}
+
+func TestIntegrationReserveAndRelease(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	reservation, err := repo.CreateReservation(models.Reservation{
+		EnvironmentID: env.ID,
+		Username:      "alice",
+		StartTime:     now,
+		EndTime:       now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	got, err := envRepo.GetEnvironmentConsistent(env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if got.Status != models.StatusReserved {
+		t.Errorf("environment status after reserving = %s, want %s", got.Status, models.StatusReserved)
+	}
+
+	page, err := repo.ListReservations(models.ReservationFilter{EnvironmentID: env.ID}, time.Now(), 10, "")
+	if err != nil {
+		t.Fatalf("ListReservations: %v", err)
+	}
+	if len(page.Reservations) != 1 || page.Reservations[0].ID != reservation.ID {
+		t.Errorf("ListReservations = %+v, want the created reservation", page.Reservations)
+	}
+
+	if err := repo.ReleaseReservation(reservation.ID, models.RequestMeta{Username: "alice"}, "done", false); err != nil {
+		t.Fatalf("ReleaseReservation: %v", err)
+	}
+	got, err = envRepo.GetEnvironmentConsistent(env.ID)
+	if err != nil {
+		t.Fatalf("GetEnvironmentConsistent: %v", err)
+	}
+	if got.Status != models.StatusFree {
+		t.Errorf("environment status after release = %s, want %s", got.Status, models.StatusFree)
+	}
+}
+
+func TestIntegrationQueuePositions(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewQueueRepository(client, envRepo, NewReservationRepository(client, envRepo), NewUserRepository(client))
+
+	for i, username := range []string{"alice", "bob"} {
+		entry, err := repo.Enqueue(models.QueueEntry{
+			EnvironmentID:         "env-1",
+			Username:              username,
+			RequestedDurationMins: 30,
+			QueuedAt:              time.Now(),
+			ExpiresAt:             time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Enqueue(%s): %v", username, err)
+		}
+		if entry.Position != i+1 {
+			t.Errorf("Enqueue(%s) position = %d, want %d", username, entry.Position, i+1)
+		}
+	}
+
+	queue, err := repo.ListQueue("env-1")
+	if err != nil {
+		t.Fatalf("ListQueue: %v", err)
+	}
+	if len(queue) != 2 || queue[0].Username != "alice" || queue[1].Username != "bob" {
+		t.Errorf("ListQueue = %+v, want alice then bob", queue)
+	}
+}
+
+func TestIntegrationReserveTakenEnvironment(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	first := reserveAs(t, repo, env, "alice", now, now.Add(time.Hour))
+
+	_, err := repo.CreateReservation(models.Reservation{EnvironmentID: env.ID, Username: "bob", StartTime: now, EndTime: now.Add(time.Hour)})
+	if !errors.Is(err, ErrEnvironmentUnavailable) {
+		t.Errorf("CreateReservation of a reserved environment error = %v, want ErrEnvironmentUnavailable", err)
+	}
+	expectEnvironment(t, envRepo, env.ID, models.StatusReserved, first.ID)
+}
+
+func TestIntegrationConcurrentReservations(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	// Everyone may read the environment as free, but the transaction's condition lets one through
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	const attempts = 8
+	var wg sync.WaitGroup
+	results := make([]*models.Reservation, attempts)
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = repo.CreateReservation(models.Reservation{
+				EnvironmentID: env.ID,
+				Username:      fmt.Sprintf("user%d", i),
+				StartTime:     now,
+				EndTime:       now.Add(time.Hour),
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	var winner *models.Reservation
+	for i, err := range errs {
+		switch {
+		case err == nil && winner == nil:
+			winner = results[i]
+		case err == nil:
+			t.Errorf("both %s and %s reserved the environment", winner.Username, results[i].Username)
+		case !errors.Is(err, ErrEnvironmentUnavailable):
+			t.Errorf("CreateReservation(user%d) error = %v, want ErrEnvironmentUnavailable", i, err)
+		}
+	}
+	if winner == nil {
+		t.Fatal("nobody reserved the environment")
+	}
+	expectEnvironment(t, envRepo, env.ID, models.StatusReserved, winner.ID)
+}
+
+func TestIntegrationReleaseConditions(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	reservation := reserveAs(t, repo, env, "alice", now, now.Add(time.Hour))
+
+	if err := repo.ReleaseReservation(reservation.ID, models.RequestMeta{Username: "bob"}, "mine now", false); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("ReleaseReservation by someone else error = %v, want ErrNotOwner", err)
+	}
+	if err := repo.ReleaseReservation(reservation.ID, models.RequestMeta{Username: "root"}, "needed", true); err != nil {
+		t.Fatalf("forced ReleaseReservation: %v", err)
+	}
+	expectEnvironment(t, envRepo, env.ID, models.StatusFree, "")
+
+	// A second release fails instead of overwriting the first one's details
+	if err := repo.ReleaseReservation(reservation.ID, models.RequestMeta{Username: "alice"}, "done", false); !errors.Is(err, ErrReservationChanged) {
+		t.Errorf("second ReleaseReservation error = %v, want ErrReservationChanged", err)
+	}
+	released, err := repo.GetReservation(reservation.ID)
+	if err != nil {
+		t.Fatalf("GetReservation: %v", err)
+	}
+	if released.ReleaseType != models.ReleaseForced || released.ReleasedBy != "root" || released.ReleaseReason != "needed" {
+		t.Errorf("released reservation = %s by %s for %q, want the forced release by root", released.ReleaseType, released.ReleasedBy, released.ReleaseReason)
+	}
+}
+
+func TestIntegrationReleaseAfterExpiry(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	reservation := reserveAs(t, repo, env, "alice", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	expired, err := repo.CheckExpiredReservations()
+	if err != nil {
+		t.Fatalf("CheckExpiredReservations: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != reservation.ID {
+		t.Fatalf("CheckExpiredReservations = %+v, want the ended reservation", expired)
+	}
+	expectEnvironment(t, envRepo, env.ID, models.StatusFree, "")
+
+	// Releasing it late doesn't turn it back into a released reservation
+	if err := repo.ReleaseReservation(reservation.ID, models.RequestMeta{Username: "alice"}, "done", false); !errors.Is(err, ErrReservationChanged) {
+		t.Errorf("ReleaseReservation after expiry error = %v, want ErrReservationChanged", err)
+	}
+	got, err := repo.GetReservation(reservation.ID)
+	if err != nil {
+		t.Fatalf("GetReservation: %v", err)
+	}
+	if got.Status != models.ReservationStatusExpired || got.ReleaseType != models.ReleaseExpired {
+		t.Errorf("reservation is %s (%s), want EXPIRED (%s)", got.Status, got.ReleaseType, models.ReleaseExpired)
+	}
+
+	// A second sweep finds nothing left to expire
+	expired, err = repo.CheckExpiredReservations()
+	if err != nil {
+		t.Fatalf("second CheckExpiredReservations: %v", err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("second CheckExpiredReservations = %+v, want none", expired)
+	}
+}
+
+func TestIntegrationReleaseAfterEnvironmentReReserved(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	now := time.Now()
+	first := reserveAs(t, repo, env, "alice", now, now.Add(time.Hour))
+
+	// The environment was freed from under the first reservation, as the expiry sweep does,
+	// and reserved again before its owner released it
+	if err := envRepo.FreeEnvironment(env.ID, first.ID); err != nil {
+		t.Fatalf("FreeEnvironment: %v", err)
+	}
+	second := reserveAs(t, repo, env, "bob", now, now.Add(time.Hour))
+
+	if err := repo.ReleaseReservation(first.ID, models.RequestMeta{Username: "alice"}, "done", false); !errors.Is(err, ErrReservationChanged) {
+		t.Errorf("ReleaseReservation error = %v, want ErrReservationChanged", err)
+	}
+	expectEnvironment(t, envRepo, env.ID, models.StatusReserved, second.ID)
+
+	// The transaction left the first reservation as it was
+	got, err := repo.GetReservation(first.ID)
+	if err != nil {
+		t.Fatalf("GetReservation: %v", err)
+	}
+	if got.Status != models.ReservationStatusActive || got.ReleaseType != "" {
+		t.Errorf("first reservation is %s (%s), want it still ACTIVE", got.Status, got.ReleaseType)
+	}
+}
+
+func TestIntegrationExpirePendingReservationKeepsLockAndHold(t *testing.T) {
+	tests := []struct {
+		name   string
+		status models.EnvironmentStatus
+		take   func(envRepo *EnvironmentRepository, id string) error
+	}{
+		{"locked", models.StatusLocked, func(envRepo *EnvironmentRepository, id string) error {
+			return envRepo.LockEnvironment(id, "admin", "maintenance")
+		}},
+		{"held", models.StatusHeld, func(envRepo *EnvironmentRepository, id string) error {
+			return envRepo.HoldEnvironment(id, "bob", time.Now().Add(time.Hour))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newIntegrationClient(t)
+			envRepo := NewEnvironmentRepository(client)
+			repo := NewReservationRepository(client, envRepo)
+
+			// A pending reservation doesn't hold its environment, so it can be locked or held
+			// in the meantime, which records no reservation
+			env, err := envRepo.CreateEnvironment(models.Environment{Name: "qa-1", RequiresApproval: true}, "admin")
+			if err != nil {
+				t.Fatalf("CreateEnvironment: %v", err)
+			}
+			now := time.Now()
+			pending := reserveAs(t, repo, env, "alice", now.Add(-2*time.Hour), now.Add(-time.Hour))
+			if err := tt.take(envRepo, env.ID); err != nil {
+				t.Fatalf("taking the environment: %v", err)
+			}
+
+			expired, err := repo.CheckExpiredReservations()
+			if err != nil {
+				t.Fatalf("CheckExpiredReservations: %v", err)
+			}
+			if len(expired) != 1 || expired[0].ID != pending.ID {
+				t.Fatalf("CheckExpiredReservations = %+v, want the pending reservation", expired)
+			}
+			expectEnvironment(t, envRepo, env.ID, tt.status, "")
+		})
+	}
+}
+
+func TestIntegrationListActiveReservationsFilters(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	qa1 := createTestEnvironment(t, envRepo, "qa-1")
+	qa2 := createTestEnvironment(t, envRepo, "qa-2")
+	qa3 := createTestEnvironment(t, envRepo, "qa-3")
+	now := time.Now()
+	soon := reserveAs(t, repo, qa1, "alice", now, now.Add(10*time.Minute))
+	later := reserveAs(t, repo, qa2, "alice", now, now.Add(2*time.Hour))
+	other := reserveAs(t, repo, qa3, "bob", now, now.Add(2*time.Hour))
+	ended := reserveAs(t, repo, createTestEnvironment(t, envRepo, "qa-4"), "alice", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	tests := []struct {
+		name   string
+		filter models.ActiveReservationFilter
+		want   []string
+	}{
+		{"no filter", models.ActiveReservationFilter{}, []string{soon.ID, later.ID, other.ID}},
+		{"environment", models.ActiveReservationFilter{EnvironmentID: qa2.ID}, []string{later.ID}},
+		{"username", models.ActiveReservationFilter{Username: "alice"}, []string{soon.ID, later.ID}},
+		{"expiring soon", models.ActiveReservationFilter{ExpiringWithin: 30 * time.Minute}, []string{soon.ID}},
+		{"username and expiring soon", models.ActiveReservationFilter{Username: "bob", ExpiringWithin: 30 * time.Minute}, []string{}},
+		{"environment and username", models.ActiveReservationFilter{EnvironmentID: qa3.ID, Username: "bob"}, []string{other.ID}},
+		{"ended reservation's environment", models.ActiveReservationFilter{EnvironmentID: ended.EnvironmentID}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservations, err := repo.ListActiveReservations(tt.filter, time.Now())
+			if err != nil {
+				t.Fatalf("ListActiveReservations: %v", err)
+			}
+			if reservations == nil {
+				t.Fatal("ListActiveReservations returned nil, want an empty list")
+			}
+			got := make(map[string]bool, len(reservations))
+			for _, reservation := range reservations {
+				got[reservation.ID] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("ListActiveReservations returned %d reservations, want %v", len(reservations), tt.want)
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Errorf("ListActiveReservations left out %s", id)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrationSearchReservationsAcrossPages(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	// Reservations of PAY-1234 by alice in the past, one by bob, and one of another ticket
+	start := time.Now().Add(-48 * time.Hour)
+	want := make(map[string]bool)
+	for i, r := range []models.Reservation{
+		{Username: "alice", Feature: "Checkout for PAY-1234"},
+		{Username: "alice", GitBranch: "feature/pay-1234"},
+		{Username: "alice", JiraURL: "https://jira.example.com/browse/PAY-1234"},
+		{Username: "bob", Feature: "PAY-1234 load test"},
+		{Username: "alice", Feature: "PAY-9999"},
+	} {
+		env := createTestEnvironment(t, envRepo, fmt.Sprintf("qa-%d", i))
+		r.EnvironmentID = env.ID
+		r.StartTime = start.Add(time.Duration(i) * time.Hour)
+		r.EndTime = r.StartTime.Add(30 * time.Minute)
+		reservation, err := repo.CreateReservation(r)
+		if err != nil {
+			t.Fatalf("CreateReservation: %v", err)
+		}
+		if r.Username == "alice" && r.Feature != "PAY-9999" {
+			want[reservation.ID] = true
+		}
+	}
+
+	// Follow the pages one reservation at a time
+	search := models.ReservationSearch{Query: "pay-1234", Username: "alice", From: &start}
+	got := make(map[string]bool)
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("SearchReservations kept returning next pages")
+		}
+		page, err := repo.SearchReservations(search, 1, token)
+		if err != nil {
+			t.Fatalf("SearchReservations: %v", err)
+		}
+		for _, reservation := range page.Reservations {
+			got[reservation.ID] = true
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	if len(got) != len(want) {
+		t.Errorf("search found %v, want %v", got, want)
+	}
+	for id := range want {
+		if !got[id] {
+			t.Errorf("search left out %s", id)
+		}
+	}
+
+	if _, err := repo.SearchReservations(search, 1, "bogus"); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("SearchReservations with a bogus token error = %v, want ErrInvalidPageToken", err)
+	}
+}
+
+func TestIntegrationReservationAtItsEndTime(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	// Times are stored to the second
+	env := createTestEnvironment(t, envRepo, "qa-1")
+	end := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	reservation := reserveAs(t, repo, env, "alice", end.Add(-2*time.Hour), end)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantActive bool
+	}{
+		{"a second before", end.Add(-time.Second), true},
+		{"at the exact end time", end, false},
+		{"within the sweep interval", end.Add(30 * time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed, err := repo.ListActiveReservations(models.ActiveReservationFilter{EnvironmentID: env.ID}, tt.at)
+			if err != nil {
+				t.Fatalf("ListActiveReservations: %v", err)
+			}
+			current, err := repo.GetActiveReservationByEnvironmentID(env.ID, tt.at)
+			if err != nil {
+				t.Fatalf("GetActiveReservationByEnvironmentID: %v", err)
+			}
+			stored, err := envRepo.GetEnvironmentConsistent(env.ID)
+			if err != nil {
+				t.Fatalf("GetEnvironmentConsistent: %v", err)
+			}
+			withReservation := models.NewEnvironmentWithReservation(*stored, current, tt.at)
+
+			if tt.wantActive {
+				if len(listed) != 1 || listed[0].ID != reservation.ID || current == nil || withReservation.Status != models.StatusReserved {
+					t.Errorf("listed %d, current %v, environment %s; want res listed, current and reserved", len(listed), current, withReservation.Status)
+				}
+				return
+			}
+			if len(listed) != 0 || current != nil || withReservation.Status != models.StatusFree || withReservation.CurrentReservation != nil {
+				t.Errorf("listed %d, current %v, environment %s; want none listed, no current and free", len(listed), current, withReservation.Status)
+			}
+		})
+	}
+}
+
+func TestIntegrationExpiryInNonUTCZone(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	// Ahead of UTC, local timestamps of reservations still running would sort before
+	// the current UTC time, and ones that ended would sort after it
+	saved := time.Local
+	time.Local = time.FixedZone("UTC+14", 14*60*60)
+	t.Cleanup(func() { time.Local = saved })
+
+	now := time.Now()
+	ended := reserveAs(t, repo, createTestEnvironment(t, envRepo, "qa-1"), "alice", now.Add(-2*time.Hour), now.Add(-time.Minute))
+	running := reserveAs(t, repo, createTestEnvironment(t, envRepo, "qa-2"), "bob", now, now.Add(time.Hour))
+
+	expired, err := repo.CheckExpiredReservations()
+	if err != nil {
+		t.Fatalf("CheckExpiredReservations: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != ended.ID {
+		t.Fatalf("expired %d reservations, want only %s", len(expired), ended.ID)
+	}
+	expectEnvironment(t, envRepo, ended.EnvironmentID, models.StatusFree, "")
+	expectEnvironment(t, envRepo, running.EnvironmentID, models.StatusReserved, running.ID)
+}
+
+func TestIntegrationReservationsWrittenInOtherZones(t *testing.T) {
+	client := newIntegrationClient(t)
+	envRepo := NewEnvironmentRepository(client)
+	repo := NewReservationRepository(client, envRepo)
+
+	// Records written before times were stored in UTC, with neither a status nor a UTC
+	// offset, as a laptop in India and a container in California would have written them
+	now := time.Now().UTC().Truncate(time.Second)
+	ist := time.FixedZone("IST", 5*60*60+30*60)
+	pst := time.FixedZone("PST", -8*60*60)
+	tests := []struct {
+		name       string
+		end        time.Time
+		wantActive bool
+	}{
+		{"IST, ending in a minute", now.Add(time.Minute).In(ist), true},
+		{"IST, ended a minute ago", now.Add(-time.Minute).In(ist), false},
+		{"PST, ending in a minute", now.Add(time.Minute).In(pst), true},
+		{"PST, ended a minute ago", now.Add(-time.Minute).In(pst), false},
+		{"UTC, ending in a minute", now.Add(time.Minute), true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := createTestEnvironment(t, envRepo, fmt.Sprintf("qa-%d", i))
+			item, err := attributevalue.MarshalMap(models.Reservation{
+				ID:            fmt.Sprintf("legacy-%d", i),
+				EnvironmentID: env.ID,
+				Username:      "alice",
+				StartTime:     tt.end.Add(-time.Hour),
+				EndTime:       tt.end,
+			})
+			if err != nil {
+				t.Fatalf("MarshalMap: %v", err)
+			}
+			if _, err := client.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+				TableName: aws.String(client.Tables.Reservations),
+				Item:      item,
+			}); err != nil {
+				t.Fatalf("PutItem: %v", err)
+			}
+
+			current, err := repo.GetActiveReservationByEnvironmentID(env.ID, now)
+			if err != nil {
+				t.Fatalf("GetActiveReservationByEnvironmentID: %v", err)
+			}
+			listed, err := repo.ListActiveReservations(models.ActiveReservationFilter{EnvironmentID: env.ID}, now)
+			if err != nil {
+				t.Fatalf("ListActiveReservations: %v", err)
+			}
+			if (current != nil) != tt.wantActive || (len(listed) == 1) != tt.wantActive {
+				t.Errorf("current %v, listed %d; want active %v", current, len(listed), tt.wantActive)
+			}
+		})
+	}
+}
+
+// BenchmarkIntegrationGetEnvironment compares eventually and strongly consistent reads of an
+// environment. Besides the latency, it reports the read capacity units each read consumes
+// when the endpoint returns them.
+func BenchmarkIntegrationGetEnvironment(b *testing.B) {
+	client := newIntegrationClient(b)
+	envRepo := NewEnvironmentRepository(client)
+	env := createTestEnvironment(b, envRepo, "qa-1")
+
+	for _, consistent := range []bool{false, true} {
+		name := "eventually consistent"
+		if consistent {
+			name = "consistent"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := envRepo.getEnvironment(env.ID, consistent); err != nil {
+					b.Fatalf("getEnvironment: %v", err)
+				}
+			}
+			b.StopTimer()
+
+			result, err := client.Client.GetItem(context.Background(), &dynamodb.GetItemInput{
+				TableName:              aws.String(client.Tables.Environments),
+				Key:                    map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: env.ID}},
+				ConsistentRead:         aws.Bool(consistent),
+				ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+			})
+			if err != nil {
+				b.Fatalf("GetItem: %v", err)
+			}
+			if result.ConsumedCapacity != nil && result.ConsumedCapacity.CapacityUnits != nil {
+				b.ReportMetric(*result.ConsumedCapacity.CapacityUnits, "RCU/op")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"time"
+
+	"github.com/devreserve/server/models"
+)
+
+// UserRepositoryInterface is implemented by UserRepository. Handlers depend on it
+// rather than the concrete type so it can be swapped out, e.g. for a mock in tests.
+type UserRepositoryInterface interface {
+	CreateUser(user models.User) error
+	GetUser(username string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByResetTokenHash(tokenHash string) (*models.User, error)
+	HasAdmin() (bool, error)
+	ListUsers() ([]models.UserResponse, error)
+	UpdateUser(user models.User) error
+	DeleteUser(username string) error
+	SetPasswordResetToken(username, tokenHash string, expiresAt time.Time) error
+	ResetPassword(username, tokenHash, hashedPassword string) error
+	IncrementTokenVersion(username string) (int, error)
+	ListTokenVersions() (map[string]int, error)
+}
+
+// EnvironmentRepositoryInterface is implemented by EnvironmentRepository
+type EnvironmentRepositoryInterface interface {
+	CreateEnvironment(env models.Environment, username string) (*models.Environment, error)
+	BatchCreateEnvironments(envs []models.Environment, username string) ([]models.Environment, error)
+	GetEnvironment(id string) (*models.Environment, error)
+	GetEnvironmentConsistent(id string) (*models.Environment, error)
+	BatchGetEnvironments(ids []string) ([]models.Environment, []string, error)
+	GetEnvironmentByName(name string) (*models.Environment, error)
+	ListEnvironments(includeArchived bool) ([]models.Environment, error)
+	ListAvailableEnvironments(tag, pool string) ([]models.Environment, error)
+	ListEnvironmentsByGroup(groupID string) ([]models.Environment, error)
+	UpdateEnvironment(env models.Environment) error
+	UpdateEnvironmentDefinition(id string, seed models.EnvironmentSeed) error
+	UpdateEnvironmentStatus(id string, status models.EnvironmentStatus) error
+	UpdateHealthStatus(id string, healthCheckURL string, status models.HealthStatus, checkedAt time.Time, lastError string) error
+	LockEnvironment(id string, username string, reason string) error
+	UnlockEnvironment(id string) error
+	HoldEnvironment(id string, username string, heldUntil time.Time) error
+	ReleaseHold(id string, username string) error
+	ArchiveEnvironment(id string, username string) error
+	UnarchiveEnvironment(id string) error
+}
+
+// ReservationRepositoryInterface is implemented by ReservationRepository
+type ReservationRepositoryInterface interface {
+	CreateReservation(reservation models.Reservation) (*models.Reservation, error)
+	CreateGroupReservation(envs []models.Environment, template models.Reservation) ([]models.Reservation, error)
+	ListReservationsByGroup(groupReservationID string) ([]models.Reservation, error)
+	ListReservationsBySeries(seriesID string) ([]models.Reservation, error)
+	GetReservation(id string) (*models.Reservation, error)
+	GetActiveReservationByEnvironmentID(environmentID string, now time.Time) (*models.Reservation, error)
+	ListActiveReservations(filter models.ActiveReservationFilter, now time.Time) ([]models.Reservation, error)
+	CountActiveReservationsByEnvironment(now time.Time) (map[string]int, error)
+	ListActiveReservationsByUsername(username string, now time.Time) ([]models.Reservation, error)
+	ListReservationsByEnvironmentID(environmentID string) ([]models.Reservation, error)
+	ListReservations(filter models.ReservationFilter, now time.Time, limit int, pageToken string) (*models.ReservationPage, error)
+	SearchReservations(search models.ReservationSearch, limit int, pageToken string) (*models.ReservationPage, error)
+	ListRecentReservationsByUsername(username string, limit int) ([]models.Reservation, error)
+	ReleaseReservation(id string, meta models.RequestMeta, reason string, force bool) error
+	ListPendingReservations() ([]models.Reservation, error)
+	ApproveReservation(id string, username string) (*models.Reservation, error)
+	UpdateReservation(id string, autoRenew bool, autoRenewUntil *time.Time, purpose models.ReservationPurpose, jiraURL *string) error
+	TransferReservation(id string, fromUsername string, toUsername string) error
+	PreemptReservation(current models.Reservation, reservation models.Reservation, preemptedBy string, reason string) (*models.Reservation, error)
+	ReassignReservation(reservation models.Reservation, toEnvironmentID string) error
+	ReconcileEnvironmentStatuses(dryRun bool) ([]models.EnvironmentStatusFix, error)
+	ScheduleReservation(reservation models.Reservation) (*models.Reservation, error)
+	ListScheduledReservationsByEnvironmentID(environmentID string, now time.Time) ([]models.Reservation, error)
+	CheckReservationRules(env models.Environment, user models.User, start, end time.Time) error
+	ActivateScheduledReservations() ([]models.Reservation, error)
+	RenewAutoRenewingReservations() ([]models.Reservation, error)
+	CheckExpiredReservations() ([]models.Reservation, error)
+}
+
+// StatsRepositoryInterface is implemented by StatsRepository
+type StatsRepositoryInterface interface {
+	RecordContention(environmentID string) error
+	GetEnvironmentStats(environmentID string) (*models.EnvironmentStats, error)
+	RecordReservation(environmentID string, at time.Time) error
+	GetWeeklyReservationCounts(weekStart time.Time) (map[string]int, error)
+}
+
+// AuditRepositoryInterface is implemented by AuditRepository
+type AuditRepositoryInterface interface {
+	RecordEvent(entry models.AuditLogEntry) error
+	ListRecentEventsByActor(actor string, limit int) ([]models.AuditLogEntry, error)
+}
+
+// QueueRepositoryInterface is implemented by QueueRepository
+type QueueRepositoryInterface interface {
+	Enqueue(entry models.QueueEntry) (*models.QueueEntry, error)
+	ListQueue(environmentID string) ([]models.QueueEntry, error)
+	DeleteEntry(environmentID string, position int) error
+	PromoteNext(environmentID string) (*models.Reservation, *models.QueueEntry, error)
+}
+
+// LockRepositoryInterface is implemented by LockRepository
+type LockRepositoryInterface interface {
+	AcquireLock(name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(name, owner string) error
+}
+
+// Make sure the repositories keep satisfying the interfaces
+var (
+	_ UserRepositoryInterface        = (*UserRepository)(nil)
+	_ EnvironmentRepositoryInterface = (*EnvironmentRepository)(nil)
+	_ ReservationRepositoryInterface = (*ReservationRepository)(nil)
+	_ StatsRepositoryInterface       = (*StatsRepository)(nil)
+	_ AuditRepositoryInterface       = (*AuditRepository)(nil)
+	_ QueueRepositoryInterface       = (*QueueRepository)(nil)
+	_ LockRepositoryInterface        = (*LockRepository)(nil)
+)
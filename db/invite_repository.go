@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// InviteRepository handles operations on the Invites table
+type InviteRepository struct {
+	db *DynamoDBClient
+}
+
+// NewInviteRepository creates a new InviteRepository
+func NewInviteRepository(db *DynamoDBClient) *InviteRepository {
+	return &InviteRepository{db: db}
+}
+
+// CreateInvite creates a new invite, returning it along with its plaintext code.
+// Only a hash of the code is stored, so the code can't be recovered later.
+func (r *InviteRepository) CreateInvite(invite models.Invite) (*models.Invite, string, error) {
+	code, err := utils.GenerateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+	invite.CodeHash = utils.HashToken(code)
+	invite.CreatedAt = utcNow()
+	if invite.ExpiresAt != nil {
+		expiresAt := utc(*invite.ExpiresAt)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	// Convert the invite to a DynamoDB item
+	item, err := attributevalue.MarshalMap(invite)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal invite: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Invites),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	return &invite, code, nil
+}
+
+// GetInvite gets an invite by its plaintext code, returning ErrNotFound if there is none
+func (r *InviteRepository) GetInvite(code string) (*models.Invite, error) {
+	result, err := r.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.db.Tables.Invites),
+		Key: map[string]types.AttributeValue{
+			"codeHash": &types.AttributeValueMemberS{Value: utils.HashToken(code)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	// Check if the item exists
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	// Unmarshal the item into an Invite struct
+	var invite models.Invite
+	err = attributevalue.UnmarshalMap(result.Item, &invite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal invite: %w", err)
+	}
+
+	return &invite, nil
+}
+
+// RegisterWithInvite creates the user with the invite's role and marks the invite as
+// used, in one transaction so a code can never register two users. It returns
+// ErrNotFound, ErrInviteUsed or ErrInviteExpired if the code can't be used.
+func (r *InviteRepository) RegisterWithInvite(code string, user models.User) (*models.User, error) {
+	invite, err := r.GetInvite(code)
+	if err != nil {
+		return nil, err
+	}
+	now := utcNow()
+	if invite.Used {
+		return nil, ErrInviteUsed
+	}
+	if invite.ExpiresAt != nil && !invite.ExpiresAt.After(now) {
+		return nil, ErrInviteExpired
+	}
+
+	// Create the user with the role the invite grants
+	user.Role = invite.Role
+	user.CreatedAt = now
+	user.LastUpdated = now
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	_, err = r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: aws.String(r.db.Tables.Users),
+					Item:      item,
+					// Ensure the username doesn't already exist
+					ConditionExpression: aws.String("attribute_not_exists(username)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(r.db.Tables.Invites),
+					Key: map[string]types.AttributeValue{
+						"codeHash": &types.AttributeValueMemberS{Value: invite.CodeHash},
+					},
+					UpdateExpression: aws.String("SET #used = :true, #usedBy = :usedBy, #usedAt = :usedAt"),
+					ExpressionAttributeNames: map[string]string{
+						"#used":   "used",
+						"#usedBy": "usedBy",
+						"#usedAt": "usedAt",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":true":   &types.AttributeValueMemberBOOL{Value: true},
+						":false":  &types.AttributeValueMemberBOOL{Value: false},
+						":usedBy": &types.AttributeValueMemberS{Value: user.Username},
+						":usedAt": &types.AttributeValueMemberS{Value: formatTime(now)},
+					},
+					// Ensure nobody used the invite since it was read
+					ConditionExpression: aws.String("#used = :false"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 &&
+			aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+			return nil, ErrInviteUsed
+		}
+		return nil, fmt.Errorf("failed to register with invite: %w", err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LockRepository handles operations on the Locks table. Locks are simple
+// leases: an item holds the name of the lock, the owner that currently holds
+// it and the time at which the lease expires.
+type LockRepository struct {
+	db *DynamoDBClient
+}
+
+// NewLockRepository creates a new LockRepository
+func NewLockRepository(db *DynamoDBClient) *LockRepository {
+	return &LockRepository{db: db}
+}
+
+// AcquireLock tries to take (or renew) the named lock for the given owner.
+// It returns true if the owner holds the lock for the next ttl, and false if
+// another owner currently holds an unexpired lease.
+func (r *LockRepository) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	// Create the input for the PutItem operation
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Locks),
+		Item: map[string]types.AttributeValue{
+			"name":      &types.AttributeValueMemberS{Value: name},
+			"owner":     &types.AttributeValueMemberS{Value: owner},
+			"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ttl).Unix(), 10)},
+		},
+		// Only take the lock if it is free, expired, or already ours
+		ConditionExpression: aws.String("attribute_not_exists(#name) OR #expiresAt < :now OR #owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#name":      "name",
+			"#owner":     "owner",
+			"#expiresAt": "expiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	}
+
+	// Put the item in DynamoDB
+	_, err := r.db.Client.PutItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReleaseLock gives up the named lock if the owner still holds it, so another owner can take
+// it without waiting for the lease to expire. Releasing a lock held by someone else does nothing.
+func (r *LockRepository) ReleaseLock(name, owner string) error {
+	// Create the input for the DeleteItem operation
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.db.Tables.Locks),
+		Key: map[string]types.AttributeValue{
+			"name": &types.AttributeValueMemberS{Value: name},
+		},
+		// Only delete the lock if it is still ours
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	}
+
+	// Delete the item from DynamoDB
+	_, err := r.db.Client.DeleteItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return nil
+		}
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,680 @@
+// Package mock provides hand-written mocks of the db repository interfaces for use in
+// handler tests. Set the function field for each method a test expects to be called;
+// calling a method whose function is nil panics.
+package mock
+
+import (
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+)
+
+// MockUserRepository is a mock db.UserRepositoryInterface
+type MockUserRepository struct {
+	CreateUserFunc              func(models.User) error
+	GetUserFunc                 func(string) (*models.User, error)
+	GetUserByEmailFunc          func(string) (*models.User, error)
+	GetUserByResetTokenHashFunc func(string) (*models.User, error)
+	HasAdminFunc                func() (bool, error)
+	ListUsersFunc               func() ([]models.UserResponse, error)
+	UpdateUserFunc              func(models.User) error
+	DeleteUserFunc              func(string) error
+	SetPasswordResetTokenFunc   func(string, string, time.Time) error
+	ResetPasswordFunc           func(string, string, string) error
+	IncrementTokenVersionFunc   func(string) (int, error)
+	ListTokenVersionsFunc       func() (map[string]int, error)
+}
+
+// CreateUser calls CreateUserFunc
+func (m *MockUserRepository) CreateUser(user models.User) error {
+	if m.CreateUserFunc == nil {
+		panic("unexpected call to MockUserRepository.CreateUser")
+	}
+	return m.CreateUserFunc(user)
+}
+
+// GetUser calls GetUserFunc
+func (m *MockUserRepository) GetUser(username string) (*models.User, error) {
+	if m.GetUserFunc == nil {
+		panic("unexpected call to MockUserRepository.GetUser")
+	}
+	return m.GetUserFunc(username)
+}
+
+// GetUserByEmail calls GetUserByEmailFunc
+func (m *MockUserRepository) GetUserByEmail(email string) (*models.User, error) {
+	if m.GetUserByEmailFunc == nil {
+		panic("unexpected call to MockUserRepository.GetUserByEmail")
+	}
+	return m.GetUserByEmailFunc(email)
+}
+
+// GetUserByResetTokenHash calls GetUserByResetTokenHashFunc
+func (m *MockUserRepository) GetUserByResetTokenHash(tokenHash string) (*models.User, error) {
+	if m.GetUserByResetTokenHashFunc == nil {
+		panic("unexpected call to MockUserRepository.GetUserByResetTokenHash")
+	}
+	return m.GetUserByResetTokenHashFunc(tokenHash)
+}
+
+// HasAdmin calls HasAdminFunc
+func (m *MockUserRepository) HasAdmin() (bool, error) {
+	if m.HasAdminFunc == nil {
+		panic("unexpected call to MockUserRepository.HasAdmin")
+	}
+	return m.HasAdminFunc()
+}
+
+// ListUsers calls ListUsersFunc
+func (m *MockUserRepository) ListUsers() ([]models.UserResponse, error) {
+	if m.ListUsersFunc == nil {
+		panic("unexpected call to MockUserRepository.ListUsers")
+	}
+	return m.ListUsersFunc()
+}
+
+// UpdateUser calls UpdateUserFunc
+func (m *MockUserRepository) UpdateUser(user models.User) error {
+	if m.UpdateUserFunc == nil {
+		panic("unexpected call to MockUserRepository.UpdateUser")
+	}
+	return m.UpdateUserFunc(user)
+}
+
+// DeleteUser calls DeleteUserFunc
+func (m *MockUserRepository) DeleteUser(username string) error {
+	if m.DeleteUserFunc == nil {
+		panic("unexpected call to MockUserRepository.DeleteUser")
+	}
+	return m.DeleteUserFunc(username)
+}
+
+// SetPasswordResetToken calls SetPasswordResetTokenFunc
+func (m *MockUserRepository) SetPasswordResetToken(username, tokenHash string, expiresAt time.Time) error {
+	if m.SetPasswordResetTokenFunc == nil {
+		panic("unexpected call to MockUserRepository.SetPasswordResetToken")
+	}
+	return m.SetPasswordResetTokenFunc(username, tokenHash, expiresAt)
+}
+
+// ResetPassword calls ResetPasswordFunc
+func (m *MockUserRepository) ResetPassword(username, tokenHash, hashedPassword string) error {
+	if m.ResetPasswordFunc == nil {
+		panic("unexpected call to MockUserRepository.ResetPassword")
+	}
+	return m.ResetPasswordFunc(username, tokenHash, hashedPassword)
+}
+
+// IncrementTokenVersion calls IncrementTokenVersionFunc
+func (m *MockUserRepository) IncrementTokenVersion(username string) (int, error) {
+	if m.IncrementTokenVersionFunc == nil {
+		panic("unexpected call to MockUserRepository.IncrementTokenVersion")
+	}
+	return m.IncrementTokenVersionFunc(username)
+}
+
+// ListTokenVersions calls ListTokenVersionsFunc
+func (m *MockUserRepository) ListTokenVersions() (map[string]int, error) {
+	if m.ListTokenVersionsFunc == nil {
+		panic("unexpected call to MockUserRepository.ListTokenVersions")
+	}
+	return m.ListTokenVersionsFunc()
+}
+
+// MockEnvironmentRepository is a mock db.EnvironmentRepositoryInterface
+type MockEnvironmentRepository struct {
+	CreateEnvironmentFunc           func(models.Environment, string) (*models.Environment, error)
+	BatchCreateEnvironmentsFunc     func([]models.Environment, string) ([]models.Environment, error)
+	GetEnvironmentFunc              func(string) (*models.Environment, error)
+	GetEnvironmentConsistentFunc    func(string) (*models.Environment, error)
+	BatchGetEnvironmentsFunc        func([]string) ([]models.Environment, []string, error)
+	GetEnvironmentByNameFunc        func(string) (*models.Environment, error)
+	ListEnvironmentsFunc            func(bool) ([]models.Environment, error)
+	ListAvailableEnvironmentsFunc   func(string, string) ([]models.Environment, error)
+	ListEnvironmentsByGroupFunc     func(string) ([]models.Environment, error)
+	UpdateEnvironmentFunc           func(models.Environment) error
+	UpdateEnvironmentDefinitionFunc func(string, models.EnvironmentSeed) error
+	UpdateEnvironmentStatusFunc     func(string, models.EnvironmentStatus) error
+	UpdateHealthStatusFunc          func(string, string, models.HealthStatus, time.Time, string) error
+	LockEnvironmentFunc             func(string, string, string) error
+	UnlockEnvironmentFunc           func(string) error
+	HoldEnvironmentFunc             func(string, string, time.Time) error
+	ReleaseHoldFunc                 func(string, string) error
+	ArchiveEnvironmentFunc          func(string, string) error
+	UnarchiveEnvironmentFunc        func(string) error
+}
+
+// CreateEnvironment calls CreateEnvironmentFunc
+func (m *MockEnvironmentRepository) CreateEnvironment(env models.Environment, username string) (*models.Environment, error) {
+	if m.CreateEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.CreateEnvironment")
+	}
+	return m.CreateEnvironmentFunc(env, username)
+}
+
+// BatchCreateEnvironments calls BatchCreateEnvironmentsFunc
+func (m *MockEnvironmentRepository) BatchCreateEnvironments(envs []models.Environment, username string) ([]models.Environment, error) {
+	if m.BatchCreateEnvironmentsFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.BatchCreateEnvironments")
+	}
+	return m.BatchCreateEnvironmentsFunc(envs, username)
+}
+
+// GetEnvironment calls GetEnvironmentFunc
+func (m *MockEnvironmentRepository) GetEnvironment(id string) (*models.Environment, error) {
+	if m.GetEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.GetEnvironment")
+	}
+	return m.GetEnvironmentFunc(id)
+}
+
+// GetEnvironmentConsistent calls GetEnvironmentConsistentFunc
+func (m *MockEnvironmentRepository) GetEnvironmentConsistent(id string) (*models.Environment, error) {
+	if m.GetEnvironmentConsistentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.GetEnvironmentConsistent")
+	}
+	return m.GetEnvironmentConsistentFunc(id)
+}
+
+// BatchGetEnvironments calls BatchGetEnvironmentsFunc
+func (m *MockEnvironmentRepository) BatchGetEnvironments(ids []string) ([]models.Environment, []string, error) {
+	if m.BatchGetEnvironmentsFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.BatchGetEnvironments")
+	}
+	return m.BatchGetEnvironmentsFunc(ids)
+}
+
+// GetEnvironmentByName calls GetEnvironmentByNameFunc
+func (m *MockEnvironmentRepository) GetEnvironmentByName(name string) (*models.Environment, error) {
+	if m.GetEnvironmentByNameFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.GetEnvironmentByName")
+	}
+	return m.GetEnvironmentByNameFunc(name)
+}
+
+// ListEnvironments calls ListEnvironmentsFunc
+func (m *MockEnvironmentRepository) ListEnvironments(includeArchived bool) ([]models.Environment, error) {
+	if m.ListEnvironmentsFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.ListEnvironments")
+	}
+	return m.ListEnvironmentsFunc(includeArchived)
+}
+
+// ListAvailableEnvironments calls ListAvailableEnvironmentsFunc
+func (m *MockEnvironmentRepository) ListAvailableEnvironments(tag, pool string) ([]models.Environment, error) {
+	if m.ListAvailableEnvironmentsFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.ListAvailableEnvironments")
+	}
+	return m.ListAvailableEnvironmentsFunc(tag, pool)
+}
+
+// ListEnvironmentsByGroup calls ListEnvironmentsByGroupFunc
+func (m *MockEnvironmentRepository) ListEnvironmentsByGroup(groupID string) ([]models.Environment, error) {
+	if m.ListEnvironmentsByGroupFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.ListEnvironmentsByGroup")
+	}
+	return m.ListEnvironmentsByGroupFunc(groupID)
+}
+
+// UpdateEnvironment calls UpdateEnvironmentFunc
+func (m *MockEnvironmentRepository) UpdateEnvironment(env models.Environment) error {
+	if m.UpdateEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UpdateEnvironment")
+	}
+	return m.UpdateEnvironmentFunc(env)
+}
+
+// UpdateEnvironmentDefinition calls UpdateEnvironmentDefinitionFunc
+func (m *MockEnvironmentRepository) UpdateEnvironmentDefinition(id string, seed models.EnvironmentSeed) error {
+	if m.UpdateEnvironmentDefinitionFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UpdateEnvironmentDefinition")
+	}
+	return m.UpdateEnvironmentDefinitionFunc(id, seed)
+}
+
+// UpdateEnvironmentStatus calls UpdateEnvironmentStatusFunc
+func (m *MockEnvironmentRepository) UpdateEnvironmentStatus(id string, status models.EnvironmentStatus) error {
+	if m.UpdateEnvironmentStatusFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UpdateEnvironmentStatus")
+	}
+	return m.UpdateEnvironmentStatusFunc(id, status)
+}
+
+// UpdateHealthStatus calls UpdateHealthStatusFunc
+func (m *MockEnvironmentRepository) UpdateHealthStatus(id string, healthCheckURL string, status models.HealthStatus, checkedAt time.Time, lastError string) error {
+	if m.UpdateHealthStatusFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UpdateHealthStatus")
+	}
+	return m.UpdateHealthStatusFunc(id, healthCheckURL, status, checkedAt, lastError)
+}
+
+// LockEnvironment calls LockEnvironmentFunc
+func (m *MockEnvironmentRepository) LockEnvironment(id string, username string, reason string) error {
+	if m.LockEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.LockEnvironment")
+	}
+	return m.LockEnvironmentFunc(id, username, reason)
+}
+
+// UnlockEnvironment calls UnlockEnvironmentFunc
+func (m *MockEnvironmentRepository) UnlockEnvironment(id string) error {
+	if m.UnlockEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UnlockEnvironment")
+	}
+	return m.UnlockEnvironmentFunc(id)
+}
+
+// HoldEnvironment calls HoldEnvironmentFunc
+func (m *MockEnvironmentRepository) HoldEnvironment(id string, username string, heldUntil time.Time) error {
+	if m.HoldEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.HoldEnvironment")
+	}
+	return m.HoldEnvironmentFunc(id, username, heldUntil)
+}
+
+// ReleaseHold calls ReleaseHoldFunc
+func (m *MockEnvironmentRepository) ReleaseHold(id string, username string) error {
+	if m.ReleaseHoldFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.ReleaseHold")
+	}
+	return m.ReleaseHoldFunc(id, username)
+}
+
+// ArchiveEnvironment calls ArchiveEnvironmentFunc
+func (m *MockEnvironmentRepository) ArchiveEnvironment(id string, username string) error {
+	if m.ArchiveEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.ArchiveEnvironment")
+	}
+	return m.ArchiveEnvironmentFunc(id, username)
+}
+
+// UnarchiveEnvironment calls UnarchiveEnvironmentFunc
+func (m *MockEnvironmentRepository) UnarchiveEnvironment(id string) error {
+	if m.UnarchiveEnvironmentFunc == nil {
+		panic("unexpected call to MockEnvironmentRepository.UnarchiveEnvironment")
+	}
+	return m.UnarchiveEnvironmentFunc(id)
+}
+
+// MockReservationRepository is a mock db.ReservationRepositoryInterface
+type MockReservationRepository struct {
+	CreateReservationFunc                        func(models.Reservation) (*models.Reservation, error)
+	CreateGroupReservationFunc                   func([]models.Environment, models.Reservation) ([]models.Reservation, error)
+	ListReservationsByGroupFunc                  func(string) ([]models.Reservation, error)
+	ListReservationsBySeriesFunc                 func(string) ([]models.Reservation, error)
+	GetReservationFunc                           func(string) (*models.Reservation, error)
+	GetActiveReservationByEnvironmentIDFunc      func(string, time.Time) (*models.Reservation, error)
+	ListActiveReservationsFunc                   func(models.ActiveReservationFilter, time.Time) ([]models.Reservation, error)
+	CountActiveReservationsByEnvironmentFunc     func(time.Time) (map[string]int, error)
+	ListActiveReservationsByUsernameFunc         func(string, time.Time) ([]models.Reservation, error)
+	ListReservationsByEnvironmentIDFunc          func(string) ([]models.Reservation, error)
+	ListReservationsFunc                         func(models.ReservationFilter, time.Time, int, string) (*models.ReservationPage, error)
+	SearchReservationsFunc                       func(models.ReservationSearch, int, string) (*models.ReservationPage, error)
+	ListRecentReservationsByUsernameFunc         func(string, int) ([]models.Reservation, error)
+	ReleaseReservationFunc                       func(string, models.RequestMeta, string, bool) error
+	ListPendingReservationsFunc                  func() ([]models.Reservation, error)
+	ApproveReservationFunc                       func(string, string) (*models.Reservation, error)
+	UpdateReservationFunc                        func(string, bool, *time.Time, models.ReservationPurpose, *string) error
+	TransferReservationFunc                      func(string, string, string) error
+	PreemptReservationFunc                       func(models.Reservation, models.Reservation, string, string) (*models.Reservation, error)
+	ReassignReservationFunc                      func(models.Reservation, string) error
+	ReconcileEnvironmentStatusesFunc             func(bool) ([]models.EnvironmentStatusFix, error)
+	ScheduleReservationFunc                      func(models.Reservation) (*models.Reservation, error)
+	ListScheduledReservationsByEnvironmentIDFunc func(string, time.Time) ([]models.Reservation, error)
+	CheckReservationRulesFunc                    func(models.Environment, models.User, time.Time, time.Time) error
+	ActivateScheduledReservationsFunc            func() ([]models.Reservation, error)
+	RenewAutoRenewingReservationsFunc            func() ([]models.Reservation, error)
+	CheckExpiredReservationsFunc                 func() ([]models.Reservation, error)
+}
+
+// CreateReservation calls CreateReservationFunc
+func (m *MockReservationRepository) CreateReservation(reservation models.Reservation) (*models.Reservation, error) {
+	if m.CreateReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.CreateReservation")
+	}
+	return m.CreateReservationFunc(reservation)
+}
+
+// CreateGroupReservation calls CreateGroupReservationFunc
+func (m *MockReservationRepository) CreateGroupReservation(envs []models.Environment, template models.Reservation) ([]models.Reservation, error) {
+	if m.CreateGroupReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.CreateGroupReservation")
+	}
+	return m.CreateGroupReservationFunc(envs, template)
+}
+
+// ListReservationsByGroup calls ListReservationsByGroupFunc
+func (m *MockReservationRepository) ListReservationsByGroup(groupReservationID string) ([]models.Reservation, error) {
+	if m.ListReservationsByGroupFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListReservationsByGroup")
+	}
+	return m.ListReservationsByGroupFunc(groupReservationID)
+}
+
+// ListReservationsBySeries calls ListReservationsBySeriesFunc
+func (m *MockReservationRepository) ListReservationsBySeries(seriesID string) ([]models.Reservation, error) {
+	if m.ListReservationsBySeriesFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListReservationsBySeries")
+	}
+	return m.ListReservationsBySeriesFunc(seriesID)
+}
+
+// GetReservation calls GetReservationFunc
+func (m *MockReservationRepository) GetReservation(id string) (*models.Reservation, error) {
+	if m.GetReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.GetReservation")
+	}
+	return m.GetReservationFunc(id)
+}
+
+// GetActiveReservationByEnvironmentID calls GetActiveReservationByEnvironmentIDFunc
+func (m *MockReservationRepository) GetActiveReservationByEnvironmentID(environmentID string, now time.Time) (*models.Reservation, error) {
+	if m.GetActiveReservationByEnvironmentIDFunc == nil {
+		panic("unexpected call to MockReservationRepository.GetActiveReservationByEnvironmentID")
+	}
+	return m.GetActiveReservationByEnvironmentIDFunc(environmentID, now)
+}
+
+// ListActiveReservations calls ListActiveReservationsFunc
+func (m *MockReservationRepository) ListActiveReservations(filter models.ActiveReservationFilter, now time.Time) ([]models.Reservation, error) {
+	if m.ListActiveReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListActiveReservations")
+	}
+	return m.ListActiveReservationsFunc(filter, now)
+}
+
+// CountActiveReservationsByEnvironment calls CountActiveReservationsByEnvironmentFunc
+func (m *MockReservationRepository) CountActiveReservationsByEnvironment(now time.Time) (map[string]int, error) {
+	if m.CountActiveReservationsByEnvironmentFunc == nil {
+		panic("unexpected call to MockReservationRepository.CountActiveReservationsByEnvironment")
+	}
+	return m.CountActiveReservationsByEnvironmentFunc(now)
+}
+
+// ListActiveReservationsByUsername calls ListActiveReservationsByUsernameFunc
+func (m *MockReservationRepository) ListActiveReservationsByUsername(username string, now time.Time) ([]models.Reservation, error) {
+	if m.ListActiveReservationsByUsernameFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListActiveReservationsByUsername")
+	}
+	return m.ListActiveReservationsByUsernameFunc(username, now)
+}
+
+// ListReservationsByEnvironmentID calls ListReservationsByEnvironmentIDFunc
+func (m *MockReservationRepository) ListReservationsByEnvironmentID(environmentID string) ([]models.Reservation, error) {
+	if m.ListReservationsByEnvironmentIDFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListReservationsByEnvironmentID")
+	}
+	return m.ListReservationsByEnvironmentIDFunc(environmentID)
+}
+
+// ListReservations calls ListReservationsFunc
+func (m *MockReservationRepository) ListReservations(filter models.ReservationFilter, now time.Time, limit int, pageToken string) (*models.ReservationPage, error) {
+	if m.ListReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListReservations")
+	}
+	return m.ListReservationsFunc(filter, now, limit, pageToken)
+}
+
+// SearchReservations calls SearchReservationsFunc
+func (m *MockReservationRepository) SearchReservations(search models.ReservationSearch, limit int, pageToken string) (*models.ReservationPage, error) {
+	if m.SearchReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.SearchReservations")
+	}
+	return m.SearchReservationsFunc(search, limit, pageToken)
+}
+
+// ListRecentReservationsByUsername calls ListRecentReservationsByUsernameFunc
+func (m *MockReservationRepository) ListRecentReservationsByUsername(username string, limit int) ([]models.Reservation, error) {
+	if m.ListRecentReservationsByUsernameFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListRecentReservationsByUsername")
+	}
+	return m.ListRecentReservationsByUsernameFunc(username, limit)
+}
+
+// ReleaseReservation calls ReleaseReservationFunc
+func (m *MockReservationRepository) ReleaseReservation(id string, meta models.RequestMeta, reason string, force bool) error {
+	if m.ReleaseReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.ReleaseReservation")
+	}
+	return m.ReleaseReservationFunc(id, meta, reason, force)
+}
+
+// ListPendingReservations calls ListPendingReservationsFunc
+func (m *MockReservationRepository) ListPendingReservations() ([]models.Reservation, error) {
+	if m.ListPendingReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListPendingReservations")
+	}
+	return m.ListPendingReservationsFunc()
+}
+
+// ApproveReservation calls ApproveReservationFunc
+func (m *MockReservationRepository) ApproveReservation(id string, username string) (*models.Reservation, error) {
+	if m.ApproveReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.ApproveReservation")
+	}
+	return m.ApproveReservationFunc(id, username)
+}
+
+// UpdateReservation calls UpdateReservationFunc
+func (m *MockReservationRepository) UpdateReservation(id string, autoRenew bool, autoRenewUntil *time.Time, purpose models.ReservationPurpose, jiraURL *string) error {
+	if m.UpdateReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.UpdateReservation")
+	}
+	return m.UpdateReservationFunc(id, autoRenew, autoRenewUntil, purpose, jiraURL)
+}
+
+// TransferReservation calls TransferReservationFunc
+func (m *MockReservationRepository) TransferReservation(id string, fromUsername string, toUsername string) error {
+	if m.TransferReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.TransferReservation")
+	}
+	return m.TransferReservationFunc(id, fromUsername, toUsername)
+}
+
+// PreemptReservation calls PreemptReservationFunc
+func (m *MockReservationRepository) PreemptReservation(current models.Reservation, reservation models.Reservation, preemptedBy string, reason string) (*models.Reservation, error) {
+	if m.PreemptReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.PreemptReservation")
+	}
+	return m.PreemptReservationFunc(current, reservation, preemptedBy, reason)
+}
+
+// ReassignReservation calls ReassignReservationFunc
+func (m *MockReservationRepository) ReassignReservation(reservation models.Reservation, toEnvironmentID string) error {
+	if m.ReassignReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.ReassignReservation")
+	}
+	return m.ReassignReservationFunc(reservation, toEnvironmentID)
+}
+
+// ReconcileEnvironmentStatuses calls ReconcileEnvironmentStatusesFunc
+func (m *MockReservationRepository) ReconcileEnvironmentStatuses(dryRun bool) ([]models.EnvironmentStatusFix, error) {
+	if m.ReconcileEnvironmentStatusesFunc == nil {
+		panic("unexpected call to MockReservationRepository.ReconcileEnvironmentStatuses")
+	}
+	return m.ReconcileEnvironmentStatusesFunc(dryRun)
+}
+
+// ScheduleReservation calls ScheduleReservationFunc
+func (m *MockReservationRepository) ScheduleReservation(reservation models.Reservation) (*models.Reservation, error) {
+	if m.ScheduleReservationFunc == nil {
+		panic("unexpected call to MockReservationRepository.ScheduleReservation")
+	}
+	return m.ScheduleReservationFunc(reservation)
+}
+
+// ListScheduledReservationsByEnvironmentID calls ListScheduledReservationsByEnvironmentIDFunc
+func (m *MockReservationRepository) ListScheduledReservationsByEnvironmentID(environmentID string, now time.Time) ([]models.Reservation, error) {
+	if m.ListScheduledReservationsByEnvironmentIDFunc == nil {
+		panic("unexpected call to MockReservationRepository.ListScheduledReservationsByEnvironmentID")
+	}
+	return m.ListScheduledReservationsByEnvironmentIDFunc(environmentID, now)
+}
+
+// CheckReservationRules calls CheckReservationRulesFunc
+func (m *MockReservationRepository) CheckReservationRules(env models.Environment, user models.User, start, end time.Time) error {
+	if m.CheckReservationRulesFunc == nil {
+		panic("unexpected call to MockReservationRepository.CheckReservationRules")
+	}
+	return m.CheckReservationRulesFunc(env, user, start, end)
+}
+
+// ActivateScheduledReservations calls ActivateScheduledReservationsFunc
+func (m *MockReservationRepository) ActivateScheduledReservations() ([]models.Reservation, error) {
+	if m.ActivateScheduledReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.ActivateScheduledReservations")
+	}
+	return m.ActivateScheduledReservationsFunc()
+}
+
+// RenewAutoRenewingReservations calls RenewAutoRenewingReservationsFunc
+func (m *MockReservationRepository) RenewAutoRenewingReservations() ([]models.Reservation, error) {
+	if m.RenewAutoRenewingReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.RenewAutoRenewingReservations")
+	}
+	return m.RenewAutoRenewingReservationsFunc()
+}
+
+// CheckExpiredReservations calls CheckExpiredReservationsFunc
+func (m *MockReservationRepository) CheckExpiredReservations() ([]models.Reservation, error) {
+	if m.CheckExpiredReservationsFunc == nil {
+		panic("unexpected call to MockReservationRepository.CheckExpiredReservations")
+	}
+	return m.CheckExpiredReservationsFunc()
+}
+
+// MockStatsRepository is a mock db.StatsRepositoryInterface
+type MockStatsRepository struct {
+	RecordContentionFunc           func(string) error
+	GetEnvironmentStatsFunc        func(string) (*models.EnvironmentStats, error)
+	RecordReservationFunc          func(string, time.Time) error
+	GetWeeklyReservationCountsFunc func(time.Time) (map[string]int, error)
+}
+
+// RecordContention calls RecordContentionFunc
+func (m *MockStatsRepository) RecordContention(environmentID string) error {
+	if m.RecordContentionFunc == nil {
+		panic("unexpected call to MockStatsRepository.RecordContention")
+	}
+	return m.RecordContentionFunc(environmentID)
+}
+
+// GetEnvironmentStats calls GetEnvironmentStatsFunc
+func (m *MockStatsRepository) GetEnvironmentStats(environmentID string) (*models.EnvironmentStats, error) {
+	if m.GetEnvironmentStatsFunc == nil {
+		panic("unexpected call to MockStatsRepository.GetEnvironmentStats")
+	}
+	return m.GetEnvironmentStatsFunc(environmentID)
+}
+
+// RecordReservation calls RecordReservationFunc
+func (m *MockStatsRepository) RecordReservation(environmentID string, at time.Time) error {
+	if m.RecordReservationFunc == nil {
+		panic("unexpected call to MockStatsRepository.RecordReservation")
+	}
+	return m.RecordReservationFunc(environmentID, at)
+}
+
+// GetWeeklyReservationCounts calls GetWeeklyReservationCountsFunc
+func (m *MockStatsRepository) GetWeeklyReservationCounts(weekStart time.Time) (map[string]int, error) {
+	if m.GetWeeklyReservationCountsFunc == nil {
+		panic("unexpected call to MockStatsRepository.GetWeeklyReservationCounts")
+	}
+	return m.GetWeeklyReservationCountsFunc(weekStart)
+}
+
+// MockAuditRepository is a mock db.AuditRepositoryInterface
+type MockAuditRepository struct {
+	RecordEventFunc             func(models.AuditLogEntry) error
+	ListRecentEventsByActorFunc func(string, int) ([]models.AuditLogEntry, error)
+}
+
+// RecordEvent calls RecordEventFunc
+func (m *MockAuditRepository) RecordEvent(entry models.AuditLogEntry) error {
+	if m.RecordEventFunc == nil {
+		panic("unexpected call to MockAuditRepository.RecordEvent")
+	}
+	return m.RecordEventFunc(entry)
+}
+
+// ListRecentEventsByActor calls ListRecentEventsByActorFunc
+func (m *MockAuditRepository) ListRecentEventsByActor(actor string, limit int) ([]models.AuditLogEntry, error) {
+	if m.ListRecentEventsByActorFunc == nil {
+		panic("unexpected call to MockAuditRepository.ListRecentEventsByActor")
+	}
+	return m.ListRecentEventsByActorFunc(actor, limit)
+}
+
+// MockQueueRepository is a mock db.QueueRepositoryInterface
+type MockQueueRepository struct {
+	EnqueueFunc     func(models.QueueEntry) (*models.QueueEntry, error)
+	ListQueueFunc   func(string) ([]models.QueueEntry, error)
+	DeleteEntryFunc func(string, int) error
+	PromoteNextFunc func(string) (*models.Reservation, *models.QueueEntry, error)
+}
+
+// Enqueue calls EnqueueFunc
+func (m *MockQueueRepository) Enqueue(entry models.QueueEntry) (*models.QueueEntry, error) {
+	if m.EnqueueFunc == nil {
+		panic("unexpected call to MockQueueRepository.Enqueue")
+	}
+	return m.EnqueueFunc(entry)
+}
+
+// ListQueue calls ListQueueFunc
+func (m *MockQueueRepository) ListQueue(environmentID string) ([]models.QueueEntry, error) {
+	if m.ListQueueFunc == nil {
+		panic("unexpected call to MockQueueRepository.ListQueue")
+	}
+	return m.ListQueueFunc(environmentID)
+}
+
+// DeleteEntry calls DeleteEntryFunc
+func (m *MockQueueRepository) DeleteEntry(environmentID string, position int) error {
+	if m.DeleteEntryFunc == nil {
+		panic("unexpected call to MockQueueRepository.DeleteEntry")
+	}
+	return m.DeleteEntryFunc(environmentID, position)
+}
+
+// PromoteNext calls PromoteNextFunc
+func (m *MockQueueRepository) PromoteNext(environmentID string) (*models.Reservation, *models.QueueEntry, error) {
+	if m.PromoteNextFunc == nil {
+		panic("unexpected call to MockQueueRepository.PromoteNext")
+	}
+	return m.PromoteNextFunc(environmentID)
+}
+
+// MockLockRepository is a mock db.LockRepositoryInterface
+type MockLockRepository struct {
+	AcquireLockFunc func(string, string, time.Duration) (bool, error)
+	ReleaseLockFunc func(string, string) error
+}
+
+// AcquireLock calls AcquireLockFunc
+func (m *MockLockRepository) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	if m.AcquireLockFunc == nil {
+		panic("unexpected call to MockLockRepository.AcquireLock")
+	}
+	return m.AcquireLockFunc(name, owner, ttl)
+}
+
+// ReleaseLock calls ReleaseLockFunc
+func (m *MockLockRepository) ReleaseLock(name, owner string) error {
+	if m.ReleaseLockFunc == nil {
+		panic("unexpected call to MockLockRepository.ReleaseLock")
+	}
+	return m.ReleaseLockFunc(name, owner)
+}
+
+// Make sure the mocks keep satisfying the interfaces
+var (
+	_ db.UserRepositoryInterface        = (*MockUserRepository)(nil)
+	_ db.EnvironmentRepositoryInterface = (*MockEnvironmentRepository)(nil)
+	_ db.ReservationRepositoryInterface = (*MockReservationRepository)(nil)
+	_ db.StatsRepositoryInterface       = (*MockStatsRepository)(nil)
+	_ db.AuditRepositoryInterface       = (*MockAuditRepository)(nil)
+	_ db.QueueRepositoryInterface       = (*MockQueueRepository)(nil)
+	_ db.LockRepositoryInterface        = (*MockLockRepository)(nil)
+)
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// encodePageToken turns the key a DynamoDB read stopped at into an opaque token for
+// clients to send back for the next page. All the key attributes are strings. An empty
+// token means there are no more pages.
+func encodePageToken(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	values := make(map[string]string, len(key))
+	for name, value := range key {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("page key attribute %s is not a string", name)
+		}
+		values[name] = s.Value
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken turns a token from encodePageToken back into the key to start reading
+// from, returning nil for an empty token and ErrInvalidPageToken for a malformed one
+func decodePageToken(token string) (map[string]types.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil || len(values) == 0 {
+		return nil, ErrInvalidPageToken
+	}
+	key := make(map[string]types.AttributeValue, len(values))
+	for name, value := range values {
+		key[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	return key, nil
+}
+
+// queryAll runs a query, following pagination, and returns the items of every page
+func (db *DynamoDBClient) queryAll(input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewQueryPaginator(db.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
+
+// scanAll runs a scan, following pagination, and returns the items of every page
+func (db *DynamoDBClient) scanAll(input *dynamodb.ScanInput) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		"id":            &types.AttributeValueMemberS{Value: "res-1"},
+		"environmentId": &types.AttributeValueMemberS{Value: "env-1"},
+	}
+	token, err := encodePageToken(key)
+	if err != nil {
+		t.Fatalf("encodePageToken: %v", err)
+	}
+	if token == "" {
+		t.Fatal("encodePageToken returned an empty token for a non-empty key")
+	}
+
+	decoded, err := decodePageToken(token)
+	if err != nil {
+		t.Fatalf("decodePageToken: %v", err)
+	}
+	if len(decoded) != len(key) {
+		t.Fatalf("decoded %d attributes, want %d", len(decoded), len(key))
+	}
+	for name, value := range key {
+		got, ok := decoded[name].(*types.AttributeValueMemberS)
+		if !ok || got.Value != value.(*types.AttributeValueMemberS).Value {
+			t.Errorf("decoded[%s] = %#v, want %#v", name, decoded[name], value)
+		}
+	}
+}
+
+func TestEncodePageTokenEmptyKey(t *testing.T) {
+	token, err := encodePageToken(nil)
+	if err != nil || token != "" {
+		t.Errorf("encodePageToken(nil) = %q, %v; want an empty token", token, err)
+	}
+}
+
+func TestEncodePageTokenRejectsNonStringKey(t *testing.T) {
+	_, err := encodePageToken(map[string]types.AttributeValue{
+		"position": &types.AttributeValueMemberN{Value: "1"},
+	})
+	if err == nil {
+		t.Error("encodePageToken accepted a number key attribute")
+	}
+}
+
+func TestDecodePageTokenInvalid(t *testing.T) {
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := decodePageToken(token); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("decodePageToken(%q) error = %v, want ErrInvalidPageToken", token, err)
+		}
+	}
+	if key, err := decodePageToken(""); key != nil || err != nil {
+		t.Errorf("decodePageToken(\"\") = %v, %v; want nil, nil", key, err)
+	}
+}
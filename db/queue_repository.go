@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+)
+
+// queueCounterPosition is the position of the item holding an environment's next
+// queue position. Real entries start at position 1.
+const queueCounterPosition = 0
+
+// QueueRepository handles operations on the Queue table, which holds the users
+// waiting for each environment
+type QueueRepository struct {
+	db              *DynamoDBClient
+	envRepo         *EnvironmentRepository
+	reservationRepo *ReservationRepository
+	userRepo        *UserRepository
+}
+
+// NewQueueRepository creates a new QueueRepository
+func NewQueueRepository(db *DynamoDBClient, envRepo *EnvironmentRepository, reservationRepo *ReservationRepository, userRepo *UserRepository) *QueueRepository {
+	return &QueueRepository{
+		db:              db,
+		envRepo:         envRepo,
+		reservationRepo: reservationRepo,
+		userRepo:        userRepo,
+	}
+}
+
+// Enqueue adds an entry to the back of its environment's queue, assigning its position
+func (r *QueueRepository) Enqueue(entry models.QueueEntry) (*models.QueueEntry, error) {
+	position, err := r.nextPosition(entry.EnvironmentID)
+	if err != nil {
+		return nil, err
+	}
+	entry.Position = position
+	entry.QueuedAt = utc(entry.QueuedAt)
+	entry.ExpiresAt = utc(entry.ExpiresAt)
+
+	// Convert the entry to a DynamoDB item
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal queue entry: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Queue),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue entry: %w", err)
+	}
+
+	return &entry, nil
+}
+
+// nextPosition atomically increments and returns an environment's queue counter
+func (r *QueueRepository) nextPosition(environmentID string) (int, error) {
+	result, err := r.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Queue),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: environmentID},
+			"position":      &types.AttributeValueMemberN{Value: strconv.Itoa(queueCounterPosition)},
+		},
+		UpdateExpression: aws.String("ADD #nextPosition :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#nextPosition": "nextPosition",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get next queue position: %w", err)
+	}
+
+	var position int
+	if err := attributevalue.Unmarshal(result.Attributes["nextPosition"], &position); err != nil {
+		return 0, fmt.Errorf("failed to parse queue position: %w", err)
+	}
+	return position, nil
+}
+
+// ListQueue gets the unexpired entries waiting for an environment, first in line first
+func (r *QueueRepository) ListQueue(environmentID string) ([]models.QueueEntry, error) {
+	entries, err := r.listEntries(environmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave out expired entries; they are deleted when they reach the front
+	now := time.Now()
+	queue := []models.QueueEntry{}
+	for _, entry := range entries {
+		if !entry.IsExpired(now) {
+			queue = append(queue, entry)
+		}
+	}
+
+	return queue, nil
+}
+
+// listEntries gets every entry of an environment's queue, including expired ones, in position order
+func (r *QueueRepository) listEntries(environmentID string) ([]models.QueueEntry, error) {
+	// Create a key condition for the environment's entries, skipping the counter
+	keyCond := expression.Key("environmentId").Equal(expression.Value(environmentID)).
+		And(expression.Key("position").GreaterThan(expression.Value(queueCounterPosition)))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Queue),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+	}
+
+	// Query the table, following pagination to get the whole queue
+	items, err := r.db.queryAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query queue: %w", err)
+	}
+
+	// Unmarshal the items into QueueEntry structs
+	var entries []models.QueueEntry
+	err = attributevalue.UnmarshalListOfMaps(items, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal queue entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteEntry removes an entry from its environment's queue
+func (r *QueueRepository) DeleteEntry(environmentID string, position int) error {
+	_, err := r.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.db.Tables.Queue),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: environmentID},
+			"position":      &types.AttributeValueMemberN{Value: strconv.Itoa(position)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete queue entry: %w", err)
+	}
+
+	return nil
+}
+
+// PromoteNext reserves a free environment for the first unexpired entry in its queue,
+// removing the entry. The reservation is checked against the same rules as one made
+// directly: entries of users who can no longer see the environment are deleted along with
+// expired ones at the front, and the queue is left alone while the first user's reservation
+// would be unhealthy, outside the allowed hours or overlap a scheduled reservation. It
+// returns the created reservation and the entry it came from, or nil if the environment
+// isn't free, can't be reserved for the first user yet or nobody is waiting.
+func (r *QueueRepository) PromoteNext(environmentID string) (*models.Reservation, *models.QueueEntry, error) {
+	env, err := r.envRepo.GetEnvironmentConsistent(environmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get environment: %w", err)
+	}
+	if env.Archived || env.Status != models.StatusFree {
+		return nil, nil, nil
+	}
+
+	entries, err := r.listEntries(environmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	for i := range entries {
+		entry := entries[i]
+		if entry.IsExpired(now) {
+			if err := r.DeleteEntry(environmentID, entry.Position); err != nil {
+				log.Printf("Error deleting expired queue entry %d of environment %s: %v", entry.Position, environmentID, err)
+			}
+			continue
+		}
+
+		// Check the reservation the first entry in line would get
+		endTime := now.Add(time.Duration(entry.RequestedDurationMins) * time.Minute)
+		user, err := r.userRepo.GetUser(entry.Username)
+		if err == nil {
+			err = r.reservationRepo.CheckReservationRules(*env, *user, now, endTime)
+		}
+		var broken *models.ReservationRuleError
+		if errors.Is(err, ErrNotFound) || (errors.As(err, &broken) && broken.Rule == models.RuleNotVisible) {
+			log.Printf("Dropping queue entry %d of environment %s: %s can't reserve it", entry.Position, environmentID, entry.Username)
+			if err := r.DeleteEntry(environmentID, entry.Position); err != nil {
+				log.Printf("Error deleting queue entry %d of environment %s: %v", entry.Position, environmentID, err)
+			}
+			continue
+		}
+		if errors.As(err, &broken) {
+			log.Printf("Not promoting queue entry %d of environment %s yet: %s", entry.Position, environmentID, broken.Message)
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check reservation for queued user %s: %w", entry.Username, err)
+		}
+
+		// Reserve the environment for the first entry in line
+		reservation, err := r.reservationRepo.CreateReservation(models.Reservation{
+			EnvironmentID: environmentID,
+			Username:      entry.Username,
+			StartTime:     now,
+			EndTime:       endTime,
+			Feature:       entry.Feature,
+			GitBranch:     entry.GitBranch,
+			JiraURL:       entry.JiraURL,
+			DurationMins:  entry.RequestedDurationMins,
+			Purpose:       entry.Purpose,
+		})
+		if errors.Is(err, ErrEnvironmentUnavailable) {
+			// Someone took the environment in the meantime; the entry keeps its place
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to reserve environment for queued user %s: %w", entry.Username, err)
+		}
+
+		// The reservation exists, so a failure here only leaves a stale entry behind
+		if err := r.DeleteEntry(environmentID, entry.Position); err != nil {
+			log.Printf("Error deleting promoted queue entry %d of environment %s: %v", entry.Position, environmentID, err)
+		}
+		return reservation, &entry, nil
+	}
+
+	return nil, nil, nil
+}
@@ -1,13 +1,20 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
 	"github.com/devreserve/server/models"
 	"github.com/google/uuid"
 )
@@ -16,6 +23,12 @@ import (
 type ReservationRepository struct {
 	db *DynamoDBClient
 	envRepo *EnvironmentRepository
+	// stats counts the reservations made of each environment each week
+	stats *StatsRepository
+
+	// legacyExpired is set once no reservation without a status is left unended, so the
+	// expiry sweep can stop scanning for them
+	legacyExpired atomic.Bool
 }
 
 // NewReservationRepository creates a new ReservationRepository
@@ -23,115 +36,341 @@ func NewReservationRepository(db *DynamoDBClient, envRepo *EnvironmentRepository
 	return &ReservationRepository{
 		db: db,
 		envRepo: envRepo,
+		stats:   NewStatsRepository(db),
 	}
 }
 
-// CreateReservation creates a new reservation in the database
+// CreateReservation creates a new reservation in the database. It returns
+// ErrEnvironmentUnavailable if the environment isn't free, including when it was taken
+// while the reservation was being made.
 func (r *ReservationRepository) CreateReservation(reservation models.Reservation) (*models.Reservation, error) {
 	// Get the environment to check if it's available
-	env, err := r.envRepo.GetEnvironment(reservation.EnvironmentID)
+	env, err := r.envRepo.GetEnvironmentConsistent(reservation.EnvironmentID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get environment: %w", err)
 	}
-	if env == nil {
-		return nil, fmt.Errorf("environment not found")
+	if env.Archived {
+		return nil, fmt.Errorf("environment is archived")
 	}
-	if env.Status != models.StatusFree {
-		return nil, fmt.Errorf("environment is already reserved")
+	if !env.IsReservableBy(reservation.Username, time.Now()) {
+		return nil, ErrEnvironmentUnavailable
 	}
 
 	// Generate a new ID for the reservation
 	reservation.ID = uuid.New().String()
 
+	// Reservations of restricted environments wait for an admin's approval, optionally
+	// holding the environment in the meantime
+	reservation.Status = models.ReservationStatusActive
+	envStatus := models.StatusReserved
+	if env.RequiresApproval {
+		reservation.Status = models.ReservationStatusPending
+		envStatus = models.StatusPendingApproval
+	}
+
 	// Set the timestamps
-	now := time.Now()
+	now := utcNow()
 	reservation.CreatedAt = now
 	reservation.LastUpdated = now
+	reservation.StartTime = utc(reservation.StartTime)
+	reservation.EndTime = utc(reservation.EndTime)
+	if reservation.AutoRenewUntil != nil {
+		autoRenewUntil := utc(*reservation.AutoRenewUntil)
+		reservation.AutoRenewUntil = &autoRenewUntil
+	}
 
 	// Convert the reservation to a DynamoDB item
-	item, err := dynamodbattribute.MarshalMap(reservation)
+	item, err := attributevalue.MarshalMap(reservation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal reservation: %w", err)
 	}
 
 	// Create a transaction to create the reservation and update the environment status
 	// First, prepare the transaction item for creating the reservation
-	putReservation := &dynamodb.TransactWriteItem{
-		Put: &dynamodb.Put{
-			TableName: aws.String(ReservationsTableName),
+	putReservation := types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(r.db.Tables.Reservations),
 			Item:      item,
 		},
 	}
 
 	// Second, prepare the transaction item for updating the environment status
-	updateEnv := &dynamodb.TransactWriteItem{
-		Update: &dynamodb.Update{
-			TableName: aws.String(EnvironmentsTableName),
-			Key: map[string]*dynamodb.AttributeValue{
-				"id": {
-					S: aws.String(reservation.EnvironmentID),
-				},
+	updateEnv := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
 			},
-			UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated"),
-			ExpressionAttributeNames: map[string]*string{
-				"#status":      aws.String("status"),
-				"#lastUpdated": aws.String("lastUpdated"),
+			// Reserving a held environment turns the hold into the reservation
+			UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated, #currentReservationId = :reservationId REMOVE #heldBy, #heldUntil"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#archived":             "archived",
+				"#heldBy":               "heldBy",
+				"#heldUntil":            "heldUntil",
+				"#currentReservationId": "currentReservationId",
 			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":status": {
-					S: aws.String(string(models.StatusReserved)),
-				},
-				":lastUpdated": {
-					S: aws.String(now.Format(time.RFC3339)),
-				},
-				":expectedStatus": {
-					S: aws.String(string(models.StatusFree)),
-				},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":         &types.AttributeValueMemberS{Value: string(envStatus)},
+				":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(now)},
+				":reservationId":  &types.AttributeValueMemberS{Value: reservation.ID},
+				":expectedStatus": &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":held":           &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+				":username":       &types.AttributeValueMemberS{Value: reservation.Username},
+				":archived":       &types.AttributeValueMemberBOOL{Value: true},
 			},
-			ConditionExpression: aws.String("#status = :expectedStatus"),
+			// The environment must be free, held by the user reserving it, or held by a hold that has run out
+			ConditionExpression: aws.String("(#status = :expectedStatus OR (#status = :held AND (#heldBy = :username OR #heldUntil <= :lastUpdated))) AND " +
+				"(attribute_not_exists(#archived) OR #archived <> :archived)"),
 		},
 	}
 
+	// Pending reservations leave the environment alone unless they hold it
+	items := []types.TransactWriteItem{putReservation}
+	if !reservation.IsPending() || r.db.Config.ApprovalHoldsEnvironment {
+		items = append(items, updateEnv)
+	}
+
 	// Execute the transaction
-	_, err = r.db.Client.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
-		TransactItems: []*dynamodb.TransactWriteItem{
-			putReservation,
-			updateEnv,
-		},
+	_, err = r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
 	})
 	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return nil, ErrEnvironmentUnavailable
+		}
 		return nil, fmt.Errorf("failed to create reservation: %w", err)
 	}
+	r.recordReservation(reservation)
+
+	return &reservation, nil
+}
+
+// ScheduleReservation creates a reservation that starts at its future start time. It is
+// SCHEDULED until ActivateScheduledReservations reserves its environment, which it leaves
+// alone until then. Callers check that no other reservation overlaps its time window.
+func (r *ReservationRepository) ScheduleReservation(reservation models.Reservation) (*models.Reservation, error) {
+	// Generate a new ID and set the status and timestamps
+	now := utcNow()
+	reservation.ID = uuid.New().String()
+	reservation.Status = models.ReservationStatusScheduled
+	reservation.CreatedAt = now
+	reservation.LastUpdated = now
+	reservation.StartTime = utc(reservation.StartTime)
+	reservation.EndTime = utc(reservation.EndTime)
+	if reservation.AutoRenewUntil != nil {
+		autoRenewUntil := utc(*reservation.AutoRenewUntil)
+		reservation.AutoRenewUntil = &autoRenewUntil
+	}
+
+	// Convert the reservation to a DynamoDB item
+	item, err := attributevalue.MarshalMap(reservation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal reservation: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Reservations),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule reservation: %w", err)
+	}
+	r.recordReservation(reservation)
 
 	return &reservation, nil
 }
 
-// GetReservation gets a reservation by ID
+// MaxGroupSize is the largest environment group that can be reserved at once. Each member
+// takes two of the 25 items a DynamoDB transaction may contain.
+const MaxGroupSize = 12
+
+// CreateGroupReservation reserves every given environment in a single transaction, creating
+// one reservation per environment from template. The reservations share a new
+// GroupReservationID. If any environment stopped being free or was archived, nothing is
+// reserved and ErrEnvironmentUnavailable is returned.
+func (r *ReservationRepository) CreateGroupReservation(envs []models.Environment, template models.Reservation) ([]models.Reservation, error) {
+	if len(envs) == 0 || len(envs) > MaxGroupSize {
+		return nil, fmt.Errorf("a group reservation needs between 1 and %d environments, got %d", MaxGroupSize, len(envs))
+	}
+
+	now := utcNow()
+	template.GroupReservationID = uuid.New().String()
+	template.Status = models.ReservationStatusActive
+	template.CreatedAt = now
+	template.LastUpdated = now
+	template.StartTime = utc(template.StartTime)
+	template.EndTime = utc(template.EndTime)
+	if template.AutoRenewUntil != nil {
+		autoRenewUntil := utc(*template.AutoRenewUntil)
+		template.AutoRenewUntil = &autoRenewUntil
+	}
+
+	// Create a reservation for each environment and mark the environment reserved,
+	// as long as it is still free
+	reservations := make([]models.Reservation, len(envs))
+	items := make([]types.TransactWriteItem, 0, 2*len(envs))
+	for i, env := range envs {
+		reservation := template
+		reservation.ID = uuid.New().String()
+		reservation.EnvironmentID = env.ID
+		reservations[i] = reservation
+
+		item, err := attributevalue.MarshalMap(reservation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal reservation: %w", err)
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(r.db.Tables.Reservations),
+				Item:      item,
+			},
+		}, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(r.db.Tables.Environments),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: env.ID},
+				},
+				UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated, #currentReservationId = :reservationId"),
+				ExpressionAttributeNames: map[string]string{
+					"#status":               "status",
+					"#lastUpdated":          "lastUpdated",
+					"#archived":             "archived",
+					"#currentReservationId": "currentReservationId",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":status":         &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+					":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(now)},
+					":reservationId":  &types.AttributeValueMemberS{Value: reservation.ID},
+					":expectedStatus": &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+					":archived":       &types.AttributeValueMemberBOOL{Value: true},
+				},
+				ConditionExpression: aws.String("#status = :expectedStatus AND (attribute_not_exists(#archived) OR #archived <> :archived)"),
+			},
+		})
+	}
+
+	// Execute the transaction
+	_, err := r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return nil, ErrEnvironmentUnavailable
+		}
+		return nil, fmt.Errorf("failed to create group reservation: %w", err)
+	}
+	for _, reservation := range reservations {
+		r.recordReservation(reservation)
+	}
+
+	return reservations, nil
+}
+
+// recordReservation counts a new reservation towards its environment's reservations this
+// week. The count only feeds the dashboard, so failures are logged rather than returned.
+func (r *ReservationRepository) recordReservation(reservation models.Reservation) {
+	if err := r.stats.RecordReservation(reservation.EnvironmentID, time.Now()); err != nil {
+		log.Printf("Error counting reservation %s in the weekly stats: %v", reservation.ID, err)
+	}
+}
+
+// ListReservationsByGroup gets the reservations made together under a group reservation ID
+func (r *ReservationRepository) ListReservationsByGroup(groupReservationID string) ([]models.Reservation, error) {
+	// Create a filter expression for the group's reservations
+	filt := expression.Name("groupReservationId").Equal(expression.Value(groupReservationID))
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Scan operation, reading consistently so members released
+	// just before aren't released again
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+	}
+
+	// Scan the table, following pagination since the filter matches few reservations
+	items, err := r.db.scanAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for group reservations: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
+	reservations := []models.Reservation{}
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// ListReservationsBySeries gets the reservations of a recurring reservation series
+func (r *ReservationRepository) ListReservationsBySeries(seriesID string) ([]models.Reservation, error) {
+	// Create a filter expression for the series' reservations
+	filt := expression.Name("seriesId").Equal(expression.Value(seriesID))
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table consistently, following pagination since the filter matches few reservations
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for series reservations: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
+	reservations := []models.Reservation{}
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// GetReservation gets a reservation by ID, returning ErrNotFound if it doesn't exist
 func (r *ReservationRepository) GetReservation(id string) (*models.Reservation, error) {
 	// Create the input for the GetItem operation
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String(ReservationsTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
-			},
+		TableName: aws.String(r.db.Tables.Reservations),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 	}
 
 	// Get the item from DynamoDB
-	result, err := r.db.Client.GetItem(input)
+	result, err := r.db.Client.GetItem(context.TODO(), input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get reservation: %w", err)
 	}
 
 	// Check if the item exists
 	if result.Item == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	// Unmarshal the item into a Reservation struct
 	var reservation models.Reservation
-	err = dynamodbattribute.UnmarshalMap(result.Item, &reservation)
+	err = attributevalue.UnmarshalMap(result.Item, &reservation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal reservation: %w", err)
 	}
@@ -139,189 +378,1676 @@ func (r *ReservationRepository) GetReservation(id string) (*models.Reservation,
 	return &reservation, nil
 }
 
-// GetActiveReservationByEnvironmentID gets the active reservation for an environment
-func (r *ReservationRepository) GetActiveReservationByEnvironmentID(environmentID string) (*models.Reservation, error) {
-	// Create a filter expression for active reservations
-	now := time.Now()
+// GetActiveReservationByEnvironmentID gets the reservation holding an environment at now, if any,
+// using the environment index rather than a table scan
+func (r *ReservationRepository) GetActiveReservationByEnvironmentID(environmentID string, now time.Time) (*models.Reservation, error) {
+	now = utc(now)
+
+	// Create a key condition for the environment's reservations and a filter for active ones
+	keyCond := expression.Key("environmentId").Equal(expression.Value(environmentID))
+	filt := isActive(now)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EnvironmentIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	// Query the index, following pagination since the filter is applied after the
+	// environment's whole reservation history is read
+	items, err := r.db.queryAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active reservations by environment: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	// Return the first (and should be only) active reservation
+	for _, reservation := range reservations {
+		if reservation.IsActiveAt(now) {
+			return &reservation, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ListScheduledReservationsByEnvironmentID gets an environment's scheduled reservations that
+// haven't reached their end time at now, using the environment index rather than a table scan
+func (r *ReservationRepository) ListScheduledReservationsByEnvironmentID(environmentID string, now time.Time) ([]models.Reservation, error) {
+	now = utc(now)
+
+	// Create a key condition for the environment's reservations and a filter for scheduled ones
+	keyCond := expression.Key("environmentId").Equal(expression.Value(environmentID))
 	filt := expression.And(
-		expression.Name("environmentId").Equal(expression.Value(environmentID)),
-		expression.Name("endTime").GreaterThan(expression.Value(now.Format(time.RFC3339))),
+		expression.Name("status").Equal(expression.Value(models.ReservationStatusScheduled)),
+		expression.Name("endTime").GreaterThan(expression.Value(formatTime(now))),
 	)
-	
-	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build expression: %w", err)
 	}
 
-	// Create the input for the Scan operation
-	input := &dynamodb.ScanInput{
-		TableName:                 aws.String(ReservationsTableName),
+	// Query the index, following pagination since the filter is applied after the
+	// environment's whole reservation history is read
+	items, err := r.db.queryAll(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EnvironmentIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
 		FilterExpression:          expr.Filter(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled reservations by environment: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
+	reservations := []models.Reservation{}
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
 	}
 
-	// Scan the table
-	result, err := r.db.Client.Scan(input)
+	return reservations, nil
+}
+
+// CheckReservationRules checks a reservation of env by user from start to end against the
+// rules every reservation starting now follows, looking up env's scheduled reservations. It
+// returns a *models.ReservationRuleError if the reservation breaks one.
+func (r *ReservationRepository) CheckReservationRules(env models.Environment, user models.User, start, end time.Time) error {
+	scheduled, err := r.ListScheduledReservationsByEnvironmentID(env.ID, start)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan for active reservations: %w", err)
+		return fmt.Errorf("failed to get scheduled reservations: %w", err)
 	}
+	return env.CheckReservationRules(user, start, end, scheduled, r.db.Config.BlockUnhealthyReservations)
+}
 
-	// Check if any active reservations were found
-	if len(result.Items) == 0 {
-		return nil, nil
+// ListActiveReservations gets the reservations active at now that match filter. It queries
+// the environment or username index if the filter has one and scans the table otherwise,
+// with DynamoDB applying the rest of the filter.
+func (r *ReservationRepository) ListActiveReservations(filter models.ActiveReservationFilter, now time.Time) ([]models.Reservation, error) {
+	now = utc(now)
+
+	// Build the filter conditions; they are rechecked after reading
+	conds := []expression.ConditionBuilder{isActive(now)}
+	if filter.ExpiringWithin > 0 {
+		conds = append(conds, expression.Or(
+			expression.Name("endTime").LessThanEqual(expression.Value(formatTime(now.Add(filter.ExpiringWithin)))),
+			notUTC("endTime"),
+		))
 	}
 
-	// Unmarshal the item into a Reservation struct
+	// Use an index for the environment or user if there is one to query
+	var keyCond *expression.KeyConditionBuilder
+	indexName := ""
+	switch {
+	case filter.EnvironmentID != "":
+		cond := expression.Key("environmentId").Equal(expression.Value(filter.EnvironmentID))
+		keyCond, indexName = &cond, "EnvironmentIndex"
+		if filter.Username != "" {
+			conds = append(conds, expression.Name("username").Equal(expression.Value(filter.Username)))
+		}
+	case filter.Username != "":
+		cond := expression.Key("username").Equal(expression.Value(filter.Username))
+		keyCond, indexName = &cond, "UsernameIndex"
+	}
+
+	builder := expression.NewBuilder()
+	if keyCond != nil {
+		builder = builder.WithKeyCondition(*keyCond)
+	}
+	if len(conds) == 1 {
+		builder = builder.WithFilter(conds[0])
+	} else {
+		builder = builder.WithFilter(expression.And(conds[0], conds[1], conds[2:]...))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Read every page, since the filter is applied after the items are read
+	var items []map[string]types.AttributeValue
+	if keyCond != nil {
+		items, err = r.db.queryAll(&dynamodb.QueryInput{
+			TableName:                 aws.String(r.db.Tables.Reservations),
+			IndexName:                 aws.String(indexName),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+	} else {
+		items, err = r.db.scanAll(&dynamodb.ScanInput{
+			TableName:                 aws.String(r.db.Tables.Reservations),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active reservations: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
 	var reservations []models.Reservation
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &reservations)
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
 	}
 
-	// Return the first (and should be only) active reservation
-	return &reservations[0], nil
+	// Drop reservations past their end time that haven't been swept yet, and ones with
+	// non-UTC end times that have already ended or end outside the expiry window
+	active := []models.Reservation{}
+	for _, reservation := range reservations {
+		if reservation.IsActiveAt(now) && filter.Matches(reservation, now) {
+			active = append(active, reservation)
+		}
+	}
+
+	return active, nil
 }
 
-// ListActiveReservations gets all currently active reservations
-func (r *ReservationRepository) ListActiveReservations() ([]models.Reservation, error) {
-	// Create a filter expression for active reservations
-	now := time.Now()
-	filt := expression.Name("endTime").GreaterThan(expression.Value(now.Format(time.RFC3339)))
-	
-	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+// CountActiveReservationsByEnvironment counts the reservations that are ACTIVE and haven't
+// reached their end time at now, by environment ID. It reads only the environment IDs off
+// EndTimeStatusIndex, or scans if the index isn't ready yet. Reservations stored without a
+// status aren't counted.
+func (r *ReservationRepository) CountActiveReservationsByEnvironment(now time.Time) (map[string]int, error) {
+	now = utc(now)
+	keyCond := expression.KeyAnd(
+		expression.Key("status").Equal(expression.Value(models.ReservationStatusActive)),
+		expression.Key("endTime").GreaterThan(expression.Value(formatTime(now))),
+	)
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(keyCond).
+		WithProjection(expression.NamesList(expression.Name("environmentId"))).
+		Build()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build expression: %w", err)
 	}
 
-	// Create the input for the Scan operation
-	input := &dynamodb.ScanInput{
-		TableName:                 aws.String(ReservationsTableName),
-		FilterExpression:          expr.Filter(),
+	items, err := r.db.queryAll(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EndTimeStatusIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "ValidationException" || apiErr.ErrorCode() == "ResourceNotFoundException") {
+		log.Printf("EndTimeStatusIndex isn't available, scanning to count active reservations instead: %v", err)
+		reservations, err := r.scanReservations(expression.And(
+			expression.Name("status").Equal(expression.Value(models.ReservationStatusActive)),
+			expression.Name("endTime").GreaterThan(expression.Value(formatTime(now))),
+		))
+		if err != nil {
+			return nil, err
+		}
+		counts := make(map[string]int)
+		for _, reservation := range reservations {
+			counts[reservation.EnvironmentID]++
+		}
+		return counts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to count active reservations: %w", err)
 	}
 
-	// Scan the table
-	result, err := r.db.Client.Scan(input)
+	// Unmarshal the environment IDs and count them
+	var reservations []models.Reservation
+	if err := attributevalue.UnmarshalListOfMaps(items, &reservations); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, reservation := range reservations {
+		counts[reservation.EnvironmentID]++
+	}
+	return counts, nil
+}
+
+// ListActiveReservationsByUsername gets a user's reservations active at now, including ones
+// still waiting for approval and scheduled ones that haven't ended, using the username index
+// rather than a table scan
+func (r *ReservationRepository) ListActiveReservationsByUsername(username string, now time.Time) ([]models.Reservation, error) {
+	now = utc(now)
+
+	// Create a key condition for the user's reservations and a filter for active, pending and scheduled ones
+	keyCond := expression.Key("username").Equal(expression.Value(username))
+	filt := expression.Or(
+		isActive(now),
+		expression.Name("status").Equal(expression.Value(models.ReservationStatusPending)),
+		expression.Name("status").Equal(expression.Value(models.ReservationStatusScheduled)),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan for active reservations: %w", err)
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("UsernameIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
 	}
 
-	// Check if any active reservations were found
-	if len(result.Items) == 0 {
-		return []models.Reservation{}, nil
+	// Query the index, following pagination since the filter is applied after the
+	// user's whole reservation history is read
+	items, err := r.db.queryAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active reservations by username: %w", err)
 	}
 
 	// Unmarshal the items into Reservation structs
 	var reservations []models.Reservation
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &reservations)
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
 	}
 
-	return reservations, nil
+	// Drop reservations past their end time that haven't been swept yet, and ones with
+	// non-UTC end times that have already ended
+	active := []models.Reservation{}
+	for _, reservation := range reservations {
+		if reservation.IsActiveAt(now) || reservation.IsScheduledAt(now) {
+			active = append(active, reservation)
+		}
+	}
+
+	return active, nil
 }
 
-// ReleaseReservation releases a reservation before its end time
-func (r *ReservationRepository) ReleaseReservation(id string, username string) error {
-	// Get the reservation to check if it exists and belongs to the user
-	reservation, err := r.GetReservation(id)
+// ListReservationsByEnvironmentID gets every reservation of an environment, including
+// ended ones, newest first
+func (r *ReservationRepository) ListReservationsByEnvironmentID(environmentID string) ([]models.Reservation, error) {
+	// Create a key condition for the environment's reservations
+	keyCond := expression.Key("environmentId").Equal(expression.Value(environmentID))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
 	if err != nil {
-		return fmt.Errorf("failed to get reservation: %w", err)
-	}
-	if reservation == nil {
-		return fmt.Errorf("reservation not found")
+		return nil, fmt.Errorf("failed to build expression: %w", err)
 	}
-	if reservation.Username != username {
-		return fmt.Errorf("you can only release your own reservations")
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EnvironmentIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
 	}
 
-	// Create a transaction to update the reservation's end time and the environment status
-	// First, prepare the transaction item for updating the reservation
-	updateReservation := &dynamodb.TransactWriteItem{
-		Update: &dynamodb.Update{
-			TableName: aws.String(ReservationsTableName),
-			Key: map[string]*dynamodb.AttributeValue{
-				"id": {
-					S: aws.String(id),
-				},
-			},
-			UpdateExpression: aws.String("SET #endTime = :endTime, #lastUpdated = :lastUpdated"),
-			ExpressionAttributeNames: map[string]*string{
-				"#endTime":     aws.String("endTime"),
-				"#lastUpdated": aws.String("lastUpdated"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":endTime": {
-					S: aws.String(time.Now().Format(time.RFC3339)),
-				},
-				":lastUpdated": {
-					S: aws.String(time.Now().Format(time.RFC3339)),
-				},
-			},
-		},
+	// Query the index, following pagination to get the whole history
+	items, err := r.db.queryAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reservations by environment: %w", err)
 	}
 
-	// Second, prepare the transaction item for updating the environment status
-	updateEnv := &dynamodb.TransactWriteItem{
-		Update: &dynamodb.Update{
-			TableName: aws.String(EnvironmentsTableName),
-			Key: map[string]*dynamodb.AttributeValue{
-				"id": {
-					S: aws.String(reservation.EnvironmentID),
-				},
-			},
-			UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated"),
-			ExpressionAttributeNames: map[string]*string{
-				"#status":      aws.String("status"),
-				"#lastUpdated": aws.String("lastUpdated"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":status": {
-					S: aws.String(string(models.StatusFree)),
-				},
-				":lastUpdated": {
-					S: aws.String(time.Now().Format(time.RFC3339)),
-				},
-			},
-		},
+	// Unmarshal the items into Reservation structs
+	reservations := []models.Reservation{}
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
 	}
 
-	// Execute the transaction
-	_, err = r.db.Client.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
-		TransactItems: []*dynamodb.TransactWriteItem{
-			updateReservation,
-			updateEnv,
-		},
+	// The index has no sort key, so sort by start time here
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].StartTime.After(reservations[j].StartTime)
 	})
+
+	return reservations, nil
+}
+
+// ListReservations gets a page of at most limit reservations matching filter, including
+// ended ones, as seen at now. It queries the environment or username index when the filter
+// names one and scans the table otherwise. Pages can hold fewer than limit reservations,
+// or none, before the last page, since filters are applied after DynamoDB reads them.
+func (r *ReservationRepository) ListReservations(filter models.ReservationFilter, now time.Time, limit int, pageToken string) (*models.ReservationPage, error) {
+	now = utc(now)
+
+	// Build the filter conditions; the status is rechecked after reading
+	var conds []expression.ConditionBuilder
+	switch filter.Status {
+	case models.ReservationListActive:
+		conds = append(conds, isActive(now))
+	case models.ReservationListExpired:
+		conds = append(conds, expression.Or(
+			expression.Name("endTime").LessThanEqual(expression.Value(formatTime(now))),
+			notUTC("endTime"),
+		))
+	}
+
+	reservations, nextToken, err := r.readReservationPage(filter.EnvironmentID, filter.Username, conds, limit, pageToken)
 	if err != nil {
-		return fmt.Errorf("failed to release reservation: %w", err)
+		return nil, err
 	}
 
-	return nil
+	// Recheck the status, for reservations past their end time that haven't been swept yet
+	// and ones with non-UTC end times
+	page := &models.ReservationPage{Reservations: []models.Reservation{}, NextToken: nextToken}
+	for _, reservation := range reservations {
+		switch {
+		case filter.Status == models.ReservationListActive && !reservation.IsActiveAt(now),
+			filter.Status == models.ReservationListExpired && reservation.IsActiveAt(now):
+			continue
+		}
+		page.Reservations = append(page.Reservations, reservation)
+	}
+	return page, nil
 }
 
-// CheckExpiredReservations checks for expired reservations and updates their environments to be free
-func (r *ReservationRepository) CheckExpiredReservations() error {
-	// Get all active reservations
-	activeReservations, err := r.ListActiveReservations()
+// SearchReservations gets a page of at most limit reservations matching search, including
+// ended ones. The username, environment and time range are applied by DynamoDB, but the
+// text query is matched after reading since DynamoDB can only match case-sensitively, so
+// pages can hold fewer than limit reservations, or none, before the last page.
+func (r *ReservationRepository) SearchReservations(search models.ReservationSearch, limit int, pageToken string) (*models.ReservationPage, error) {
+	reservations, nextToken, err := r.readReservationPage(search.EnvironmentID, search.Username, searchConditions(search), limit, pageToken)
 	if err != nil {
-		return fmt.Errorf("failed to list active reservations: %w", err)
+		return nil, err
 	}
 
-	now := time.Now()
-	
-	// Check each reservation to see if it's expired
-	for _, reservation := range activeReservations {
-		if now.After(reservation.EndTime) {
-			// The reservation has expired, update the environment status
-			err := r.envRepo.UpdateEnvironmentStatus(reservation.EnvironmentID, models.StatusFree)
-			if err != nil {
-				return fmt.Errorf("failed to update environment status: %w", err)
-			}
+	page := &models.ReservationPage{Reservations: []models.Reservation{}, NextToken: nextToken}
+	for _, reservation := range reservations {
+		if search.Matches(reservation) {
+			page.Reservations = append(page.Reservations, reservation)
 		}
 	}
+	return page, nil
+}
 
-	return nil
+// searchConditions builds the DynamoDB filter conditions for a search's time range, which
+// selects reservations overlapping it. Non-UTC times always pass and are rechecked by
+// ReservationSearch.Matches. The username and environment are left to readReservationPage.
+func searchConditions(search models.ReservationSearch) []expression.ConditionBuilder {
+	var conds []expression.ConditionBuilder
+	if search.From != nil {
+		conds = append(conds, expression.Or(
+			expression.Name("endTime").GreaterThan(expression.Value(formatTime(*search.From))),
+			notUTC("endTime"),
+		))
+	}
+	if search.To != nil {
+		conds = append(conds, expression.Or(
+			expression.Name("startTime").LessThan(expression.Value(formatTime(*search.To))),
+			notUTC("startTime"),
+		))
+	}
+	return conds
+}
+
+// readReservationPage reads up to limit reservations starting from pageToken, applying conds
+// as a filter. It queries the environment or username index if environmentID or username is
+// set, newest first, and scans the table otherwise. It returns the reservations read and the
+// token for the next page, which is empty on the last one.
+func (r *ReservationRepository) readReservationPage(environmentID, username string, conds []expression.ConditionBuilder, limit int, pageToken string) ([]models.Reservation, string, error) {
+	startKey, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Use an index for the environment or user if there is one to query
+	var keyCond *expression.KeyConditionBuilder
+	indexName := ""
+	switch {
+	case environmentID != "":
+		cond := expression.Key("environmentId").Equal(expression.Value(environmentID))
+		keyCond, indexName = &cond, "EnvironmentIndex"
+		if username != "" {
+			conds = append(conds, expression.Name("username").Equal(expression.Value(username)))
+		}
+	case username != "":
+		cond := expression.Key("username").Equal(expression.Value(username))
+		keyCond, indexName = &cond, "UsernameIndex"
+	}
+
+	builder := expression.NewBuilder()
+	if keyCond != nil {
+		builder = builder.WithKeyCondition(*keyCond)
+	}
+	if len(conds) == 1 {
+		builder = builder.WithFilter(conds[0])
+	} else if len(conds) > 1 {
+		builder = builder.WithFilter(expression.And(conds[0], conds[1], conds[2:]...))
+	}
+	var expr expression.Expression
+	if keyCond != nil || len(conds) > 0 {
+		expr, err = builder.Build()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to build expression: %w", err)
+		}
+	}
+
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	if keyCond != nil {
+		// Query the index, newest first where it is sorted by start time
+		result, err := r.db.Client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:                 aws.String(r.db.Tables.Reservations),
+			IndexName:                 aws.String(indexName),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ScanIndexForward:          aws.Bool(false),
+			Limit:                     aws.Int32(int32(limit)),
+			ExclusiveStartKey:         startKey,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query reservations: %w", err)
+		}
+		items, lastKey = result.Items, result.LastEvaluatedKey
+	} else {
+		// Scan the table, applying the filter if there is one
+		input := &dynamodb.ScanInput{
+			TableName:         aws.String(r.db.Tables.Reservations),
+			Limit:             aws.Int32(int32(limit)),
+			ExclusiveStartKey: startKey,
+		}
+		if len(conds) > 0 {
+			input.FilterExpression = expr.Filter()
+			input.ExpressionAttributeNames = expr.Names()
+			input.ExpressionAttributeValues = expr.Values()
+		}
+		result, err := r.db.Client.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan reservations: %w", err)
+		}
+		items, lastKey = result.Items, result.LastEvaluatedKey
+	}
+
+	// Unmarshal the items into Reservation structs
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	nextToken, err := encodePageToken(lastKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+	return reservations, nextToken, nil
+}
+
+// ListRecentReservationsByUsername gets the most recent reservations made by a user, newest first
+func (r *ReservationRepository) ListRecentReservationsByUsername(username string, limit int) ([]models.Reservation, error) {
+	// Create a key condition for the user's reservations
+	keyCond := expression.Key("username").Equal(expression.Value(username))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Query operation
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("UsernameIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	}
+
+	// Query the index
+	result, err := r.db.Client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reservations by username: %w", err)
+	}
+
+	// Unmarshal the items into Reservation structs
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// heldByReservationCondition checks that the environment is held by the reservation
+// :reservationId. Environments reserved before their holder was recorded have none.
+const heldByReservationCondition = "(attribute_not_exists(#currentReservationId) OR #currentReservationId = :reservationId)"
+
+// ReleaseReservation releases a reservation before its end time, recording who released it,
+// from which IP address and user agent, and why. Unless force is set, only the reservation's
+// owner may release it. The release type is MANUAL for the owner and FORCE_RELEASED for anyone else.
+// It returns ErrReservationChanged if the reservation was released or expired in the meantime, or
+// its environment is held by another reservation by now.
+func (r *ReservationRepository) ReleaseReservation(id string, meta models.RequestMeta, reason string, force bool) error {
+	username := meta.Username
+
+	// Get the reservation to check if it exists and belongs to the user
+	reservation, err := r.GetReservation(id)
+	if err != nil {
+		return fmt.Errorf("failed to get reservation: %w", err)
+	}
+	if !force && reservation.Username != username {
+		return ErrNotOwner
+	}
+	releaseType := models.ReleaseManual
+	if reservation.Username != username {
+		releaseType = models.ReleaseForced
+	}
+
+	// Create a transaction to update the reservation's end time and the environment status
+	// First, prepare the transaction item for updating the reservation
+	updateReservation := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+			// Releasing also turns off auto-renew so the expiry sweep won't renew it
+			UpdateExpression: aws.String("SET #status = :status, #endTime = :endTime, #lastUpdated = :lastUpdated, #autoRenew = :autoRenew, " +
+				"#releaseType = :releaseType, #releaseReason = :releaseReason, #releasedAt = :releasedAt, #releasedBy = :releasedBy, " +
+				"#releasedFromIp = :releasedFromIp, #releasedUserAgent = :releasedUserAgent"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":            "status",
+				"#endTime":           "endTime",
+				"#lastUpdated":       "lastUpdated",
+				"#autoRenew":         "autoRenew",
+				"#releaseType":       "releaseType",
+				"#releaseReason":     "releaseReason",
+				"#releasedAt":        "releasedAt",
+				"#releasedBy":        "releasedBy",
+				"#releasedFromIp":    "releasedFromIp",
+				"#releasedUserAgent": "releasedUserAgent",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":            &types.AttributeValueMemberS{Value: string(models.ReservationStatusReleased)},
+				":endTime":           &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				":lastUpdated":       &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				":autoRenew":         &types.AttributeValueMemberBOOL{Value: false},
+				":releaseType":       &types.AttributeValueMemberS{Value: string(releaseType)},
+				":releaseReason":     &types.AttributeValueMemberS{Value: reason},
+				":releasedAt":        &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				":releasedBy":        &types.AttributeValueMemberS{Value: username},
+				":releasedFromIp":    &types.AttributeValueMemberS{Value: meta.IP},
+				":releasedUserAgent": &types.AttributeValueMemberS{Value: meta.UserAgent},
+				":active":            &types.AttributeValueMemberS{Value: string(models.ReservationStatusActive)},
+				":pending":           &types.AttributeValueMemberS{Value: string(models.ReservationStatusPending)},
+				":scheduled":         &types.AttributeValueMemberS{Value: string(models.ReservationStatusScheduled)},
+			},
+			// Only release it once, and not after the expiry sweep ended it
+			ConditionExpression: aws.String("#status IN (:active, :pending, :scheduled) AND attribute_not_exists(#releaseType)"),
+		},
+	}
+
+	// Second, prepare the transaction item for updating the environment status
+	updateEnv := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated REMOVE #currentReservationId"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":        &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":lastUpdated":   &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				":reservationId": &types.AttributeValueMemberS{Value: id},
+			},
+			// Don't free the environment if another reservation holds it by now
+			ConditionExpression: aws.String(heldByReservationCondition),
+		},
+	}
+
+	// Pending reservations only hold their environment if so configured, and scheduled ones
+	// don't hold it yet
+	items := []types.TransactWriteItem{updateReservation}
+	if (!reservation.IsPending() || r.db.Config.ApprovalHoldsEnvironment) && reservation.Status != models.ReservationStatusScheduled {
+		items = append(items, updateEnv)
+	}
+
+	// Execute the transaction
+	_, err = r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return ErrReservationChanged
+		}
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+
+	return nil
+}
+
+// ListPendingReservations gets all reservations waiting for an admin's approval
+func (r *ReservationRepository) ListPendingReservations() ([]models.Reservation, error) {
+	now := utcNow()
+
+	// Pending reservations whose end time has passed are expired by the sweep
+	filt := expression.Name("releaseType").AttributeNotExists()
+	keyCond := expression.Key("status").Equal(expression.Value(models.ReservationStatusPending))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Query EndTimeStatusIndex, following pagination, or scan if it isn't ready yet
+	var reservations []models.Reservation
+	items, err := r.db.queryAll(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EndTimeStatusIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "ValidationException" || apiErr.ErrorCode() == "ResourceNotFoundException") {
+		log.Printf("EndTimeStatusIndex isn't available, scanning for pending reservations instead: %v", err)
+		reservations, err = r.scanReservations(expression.And(
+			expression.Name("status").Equal(expression.Value(models.ReservationStatusPending)),
+			filt,
+		))
+	} else if err == nil {
+		err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending reservations: %w", err)
+	}
+
+	pending := []models.Reservation{}
+	for _, reservation := range reservations {
+		if reservation.IsActiveAt(now) {
+			pending = append(pending, reservation)
+		}
+	}
+
+	return pending, nil
+}
+
+// ApproveReservation approves a pending reservation and reserves its environment. The
+// reservation's time window starts at approval. It returns ErrNotPending if the reservation
+// isn't waiting for approval and ErrEnvironmentUnavailable if somebody else holds the environment.
+func (r *ReservationRepository) ApproveReservation(id string, username string) (*models.Reservation, error) {
+	// Get the reservation to check that it's pending
+	reservation, err := r.GetReservation(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reservation: %w", err)
+	}
+	now := utcNow()
+	if !reservation.IsPending() || reservation.ReleaseType != "" || !reservation.IsActiveAt(now) {
+		return nil, ErrNotPending
+	}
+
+	// Restart the reservation's time window now that it can be used
+	reservation.Status = models.ReservationStatusActive
+	reservation.ApprovedBy = username
+	reservation.ApprovedAt = &now
+	reservation.EndTime = now.Add(reservation.RenewalPeriod())
+	reservation.StartTime = now
+	reservation.LastUpdated = now
+
+	// First, prepare the transaction item for approving the reservation
+	updateReservation := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+			UpdateExpression: aws.String("SET #status = :active, #approvedBy = :approvedBy, #approvedAt = :now, " +
+				"#startTime = :now, #endTime = :endTime, #lastUpdated = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":      "status",
+				"#approvedBy":  "approvedBy",
+				"#approvedAt":  "approvedAt",
+				"#startTime":   "startTime",
+				"#endTime":     "endTime",
+				"#lastUpdated": "lastUpdated",
+				"#releaseType": "releaseType",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":active":     &types.AttributeValueMemberS{Value: string(models.ReservationStatusActive)},
+				":approvedBy": &types.AttributeValueMemberS{Value: username},
+				":now":        &types.AttributeValueMemberS{Value: formatTime(now)},
+				":endTime":    &types.AttributeValueMemberS{Value: formatTime(reservation.EndTime)},
+				":pending":    &types.AttributeValueMemberS{Value: string(models.ReservationStatusPending)},
+			},
+			ConditionExpression: aws.String("#status = :pending AND attribute_not_exists(#releaseType)"),
+		},
+	}
+
+	// Second, reserve the environment, which is either free or held for this reservation
+	expectedStatus := models.StatusFree
+	if r.db.Config.ApprovalHoldsEnvironment {
+		expectedStatus = models.StatusPendingApproval
+	}
+	updateEnv := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #status = :status, #lastUpdated = :lastUpdated, #currentReservationId = :reservationId"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#archived":             "archived",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":         &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+				":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(now)},
+				":reservationId":  &types.AttributeValueMemberS{Value: reservation.ID},
+				":expectedStatus": &types.AttributeValueMemberS{Value: string(expectedStatus)},
+				":archived":       &types.AttributeValueMemberBOOL{Value: true},
+			},
+			ConditionExpression: aws.String("#status = :expectedStatus AND (attribute_not_exists(#archived) OR #archived <> :archived)"),
+		},
+	}
+
+	// Execute the transaction
+	_, err = r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			updateReservation,
+			updateEnv,
+		},
+	})
+	if err != nil {
+		// Work out which of the two conditions failed
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, ErrNotPending
+			}
+			if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, ErrEnvironmentUnavailable
+			}
+		}
+		return nil, fmt.Errorf("failed to approve reservation: %w", err)
+	}
+
+	return reservation, nil
+}
+
+// isActive matches reservations that hold their environment at now: ACTIVE ones, and ones
+// written before statuses were stored whose end time hasn't passed. Results still have to be
+// checked with IsActiveAt after reading, which drops ACTIVE reservations past their end time
+// that the expiry sweep hasn't reached yet and ones with non-UTC end times.
+func isActive(now time.Time) expression.ConditionBuilder {
+	return expression.Or(
+		expression.Name("status").Equal(expression.Value(models.ReservationStatusActive)),
+		expression.And(
+			withoutStatus(),
+			expression.Or(
+				expression.Name("endTime").GreaterThan(expression.Value(formatTime(now))),
+				notUTC("endTime"),
+			),
+		),
+	)
+}
+
+// withoutStatus matches reservations written before statuses were stored, which have no
+// status or, if they were approved, APPROVED
+func withoutStatus() expression.ConditionBuilder {
+	return expression.Or(
+		expression.Name("status").AttributeNotExists(),
+		expression.Name("status").Equal(expression.Value(models.ReservationStatusApproved)),
+	)
+}
+
+// UpdateReservation changes an active reservation's auto-renew settings and, if
+// purpose isn't empty, its purpose. If jiraURL isn't nil the Jira link is replaced,
+// or removed if it points to an empty string.
+func (r *ReservationRepository) UpdateReservation(id string, autoRenew bool, autoRenewUntil *time.Time, purpose models.ReservationPurpose, jiraURL *string) error {
+	now := utcNow()
+
+	updateExpr := "SET #autoRenew = :autoRenew, #lastUpdated = :lastUpdated"
+	names := map[string]string{
+		"#autoRenew":   "autoRenew",
+		"#lastUpdated": "lastUpdated",
+		"#endTime":     "endTime",
+	}
+	values := map[string]types.AttributeValue{
+		":autoRenew":   &types.AttributeValueMemberBOOL{Value: autoRenew},
+		":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(now)},
+		":now":         &types.AttributeValueMemberS{Value: formatTime(now)},
+		":utc":         &types.AttributeValueMemberS{Value: utcSuffix},
+	}
+	if autoRenewUntil != nil {
+		updateExpr += ", #autoRenewUntil = :autoRenewUntil"
+		names["#autoRenewUntil"] = "autoRenewUntil"
+		values[":autoRenewUntil"] = &types.AttributeValueMemberS{Value: formatTime(*autoRenewUntil)}
+	}
+	if purpose != "" {
+		updateExpr += ", #purpose = :purpose"
+		names["#purpose"] = "purpose"
+		values[":purpose"] = &types.AttributeValueMemberS{Value: string(purpose)}
+	}
+	if jiraURL != nil {
+		names["#jiraUrl"] = "jiraUrl"
+		if *jiraURL != "" {
+			updateExpr += ", #jiraUrl = :jiraUrl"
+			values[":jiraUrl"] = &types.AttributeValueMemberS{Value: *jiraURL}
+		} else {
+			// REMOVE has to follow all of the SET actions
+			updateExpr += " REMOVE #jiraUrl"
+		}
+	}
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		UpdateExpression:          aws.String(updateExpr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		// Only active reservations can be changed
+		ConditionExpression: aws.String("(#endTime > :now OR NOT contains(#endTime, :utc))"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to update reservation: %w", err)
+	}
+
+	return nil
+}
+
+// TransferReservation hands an active reservation from fromUsername over to toUsername. It returns
+// ErrReservationChanged if the reservation is no longer active or no longer held by fromUsername.
+func (r *ReservationRepository) TransferReservation(id string, fromUsername string, toUsername string) error {
+	now := utcNow()
+
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(r.db.Tables.Reservations),
+		Key:              map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		UpdateExpression: aws.String("SET #username = :to, #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#username":    "username",
+			"#lastUpdated": "lastUpdated",
+			"#endTime":     "endTime",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":to":          &types.AttributeValueMemberS{Value: toUsername},
+			":from":        &types.AttributeValueMemberS{Value: fromUsername},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(now)},
+			":now":         &types.AttributeValueMemberS{Value: formatTime(now)},
+			":utc":         &types.AttributeValueMemberS{Value: utcSuffix},
+		},
+		// Only transfer the reservation if it's still active and nobody else moved it first
+		ConditionExpression: aws.String("#username = :from AND (#endTime > :now OR NOT contains(#endTime, :utc))"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrReservationChanged
+		}
+		return fmt.Errorf("failed to transfer reservation: %w", err)
+	}
+
+	return nil
+}
+
+// PreemptReservation ends current, the reservation holding an environment, and creates
+// reservation for the same environment in a single transaction, recording who preempted
+// current and why. The environment stays reserved throughout. It returns
+// ErrReservationChanged if current was released, renewed, expired or transferred in the
+// meantime, or the environment stopped being reserved.
+func (r *ReservationRepository) PreemptReservation(current models.Reservation, reservation models.Reservation, preemptedBy string, reason string) (*models.Reservation, error) {
+	now := utcNow()
+	reservation.ID = uuid.New().String()
+	reservation.EnvironmentID = current.EnvironmentID
+	reservation.Status = models.ReservationStatusActive
+	reservation.CreatedAt = now
+	reservation.LastUpdated = now
+	reservation.StartTime = utc(reservation.StartTime)
+	reservation.EndTime = utc(reservation.EndTime)
+
+	// Convert the reservation to a DynamoDB item
+	item, err := attributevalue.MarshalMap(reservation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal reservation: %w", err)
+	}
+
+	// End the current reservation, as long as nobody changed it since it was read
+	endCurrent := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: current.ID},
+			},
+			UpdateExpression: aws.String("SET #status = :released, #endTime = :now, #lastUpdated = :now, #autoRenew = :autoRenew, " +
+				"#releaseType = :releaseType, #releaseReason = :releaseReason, #releasedAt = :now, #releasedBy = :releasedBy"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":        "status",
+				"#endTime":       "endTime",
+				"#lastUpdated":   "lastUpdated",
+				"#autoRenew":     "autoRenew",
+				"#releaseType":   "releaseType",
+				"#releaseReason": "releaseReason",
+				"#releasedAt":    "releasedAt",
+				"#releasedBy":    "releasedBy",
+				"#username":      "username",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now":           &types.AttributeValueMemberS{Value: formatTime(now)},
+				":released":      &types.AttributeValueMemberS{Value: string(models.ReservationStatusReleased)},
+				":autoRenew":     &types.AttributeValueMemberBOOL{Value: false},
+				":releaseType":   &types.AttributeValueMemberS{Value: string(models.ReleasePreempted)},
+				":releaseReason": &types.AttributeValueMemberS{Value: reason},
+				":releasedBy":    &types.AttributeValueMemberS{Value: preemptedBy},
+				":endTime":       &types.AttributeValueMemberS{Value: formatTime(current.EndTime)},
+				":holder":        &types.AttributeValueMemberS{Value: current.Username},
+			},
+			ConditionExpression: aws.String("#endTime = :endTime AND #username = :holder"),
+		},
+	}
+
+	// Create the new reservation
+	putReservation := types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Item:      item,
+		},
+	}
+
+	// Check the environment is still reserved and touch its last updated time
+	updateEnv := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: current.EnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #lastUpdated = :lastUpdated, #currentReservationId = :reservationId"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(now)},
+				":expectedStatus": &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+				":reservationId":  &types.AttributeValueMemberS{Value: reservation.ID},
+			},
+			ConditionExpression: aws.String("#status = :expectedStatus"),
+		},
+	}
+
+	// Execute the transaction
+	_, err = r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{endCurrent, putReservation, updateEnv},
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return nil, ErrReservationChanged
+		}
+		return nil, fmt.Errorf("failed to preempt reservation: %w", err)
+	}
+	r.recordReservation(reservation)
+
+	return &reservation, nil
+}
+
+// ReassignReservation moves an active reservation to the environment toEnvironmentID in a
+// single transaction, freeing its current environment and reserving the new one. It returns
+// ErrReservationChanged if the reservation was released, renewed, expired or moved in the
+// meantime, its environment stopped being reserved, or the new environment stopped being free.
+func (r *ReservationRepository) ReassignReservation(reservation models.Reservation, toEnvironmentID string) error {
+	now := formatTime(utcNow())
+
+	// Move the reservation, as long as nobody changed it since it was read
+	updateReservation := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.ID},
+			},
+			UpdateExpression: aws.String("SET #environmentId = :to, #lastUpdated = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#environmentId": "environmentId",
+				"#lastUpdated":   "lastUpdated",
+				"#endTime":       "endTime",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":to":      &types.AttributeValueMemberS{Value: toEnvironmentID},
+				":from":    &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
+				":now":     &types.AttributeValueMemberS{Value: now},
+				":endTime": &types.AttributeValueMemberS{Value: formatTime(reservation.EndTime)},
+			},
+			ConditionExpression: aws.String("#environmentId = :from AND #endTime = :endTime"),
+		},
+	}
+
+	// Free the environment it is moving from
+	freeSource := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #status = :free, #lastUpdated = :now REMOVE #currentReservationId"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":free":          &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":reserved":      &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+				":now":           &types.AttributeValueMemberS{Value: now},
+				":reservationId": &types.AttributeValueMemberS{Value: reservation.ID},
+			},
+			ConditionExpression: aws.String("#status = :reserved AND " + heldByReservationCondition),
+		},
+	}
+
+	// Reserve the environment it is moving to
+	reserveTarget := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: toEnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #status = :reserved, #lastUpdated = :now, #currentReservationId = :reservationId"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#archived":             "archived",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":free":          &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":reserved":      &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+				":now":           &types.AttributeValueMemberS{Value: now},
+				":archived":      &types.AttributeValueMemberBOOL{Value: true},
+				":reservationId": &types.AttributeValueMemberS{Value: reservation.ID},
+			},
+			ConditionExpression: aws.String("#status = :free AND (attribute_not_exists(#archived) OR #archived <> :archived)"),
+		},
+	}
+
+	// Execute the transaction
+	_, err := r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{updateReservation, freeSource, reserveTarget},
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return ErrReservationChanged
+		}
+		return fmt.Errorf("failed to reassign reservation: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileEnvironmentStatuses compares every environment's status with the reservations
+// active now and corrects the ones that disagree, such as environments left RESERVED while
+// the expiry sweep was down. Reserved environments without an active reservation, and ones
+// held for a reservation awaiting approval without one, are freed; environments with an
+// active reservation are reserved and record it as their holder. Locked and held environments are left alone, since locks
+// and holds don't come from reservations. With dryRun nothing is changed. It returns the
+// corrections, leaving out environments that changed while they were being corrected.
+func (r *ReservationRepository) ReconcileEnvironmentStatuses(dryRun bool) ([]models.EnvironmentStatusFix, error) {
+	// Read the environments before the reservations, so a reservation made in between is
+	// seen as active rather than its environment being freed
+	envs, err := r.envRepo.ListEnvironments(true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active, err := r.ListActiveReservations(models.ActiveReservationFilter{}, now)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := r.ListPendingReservations()
+	if err != nil {
+		return nil, err
+	}
+
+	activeByEnv := make(map[string]models.Reservation, len(active))
+	for _, reservation := range active {
+		activeByEnv[reservation.EnvironmentID] = reservation
+	}
+	pendingEnvs := make(map[string]bool, len(pending))
+	for _, reservation := range pending {
+		pendingEnvs[reservation.EnvironmentID] = true
+	}
+
+	fixes := []models.EnvironmentStatusFix{}
+	for _, env := range envs {
+		// Work out the status the environment should have
+		fix := models.EnvironmentStatusFix{EnvironmentID: env.ID, OldStatus: env.Status}
+		reservation, reserved := activeByEnv[env.ID]
+		holder := ""
+		switch {
+		case env.Status == models.StatusLocked || env.Status == models.StatusHeld:
+			continue
+		case reserved:
+			// Also record the holder on environments reserved before it was recorded
+			holder = reservation.ID
+			fix.NewStatus = models.StatusReserved
+			fix.Reason = fmt.Sprintf("Reservation %s by %s is active until %s", reservation.ID, reservation.Username, formatTime(reservation.EndTime))
+		case env.Status == models.StatusPendingApproval && pendingEnvs[env.ID]:
+			continue
+		case env.Status == models.StatusPendingApproval:
+			fix.NewStatus = models.StatusFree
+			fix.Reason = "No reservation is awaiting approval"
+		default:
+			fix.NewStatus = models.StatusFree
+			fix.Reason = "No reservation is active"
+		}
+		if fix.NewStatus == env.Status && (holder == "" || holder == env.CurrentReservationID) {
+			continue
+		}
+
+		// Correct it unless the environment changed since it was read
+		if !dryRun {
+			err := r.envRepo.CorrectEnvironmentStatus(env, fix.NewStatus, holder)
+			if errors.Is(err, ErrEnvironmentChanged) {
+				log.Printf("Environment %s changed while reconciling its status, leaving it", env.ID)
+				continue
+			}
+			if err != nil {
+				return fixes, err
+			}
+		}
+		fixes = append(fixes, fix)
+	}
+
+	return fixes, nil
+}
+
+// RenewAutoRenewingReservations extends auto-renewing reservations that have reached their
+// end time by their renewal period, capped at AutoRenewUntil. It returns the renewed reservations.
+func (r *ReservationRepository) RenewAutoRenewingReservations() ([]models.Reservation, error) {
+	now := utcNow()
+
+	// Find auto-renewing reservations that have ended but may still renew
+	filt := expression.And(
+		expression.Name("autoRenew").Equal(expression.Value(true)),
+		expression.Or(
+			expression.Name("status").Equal(expression.Value(models.ReservationStatusActive)),
+			withoutStatus(),
+		),
+		expression.Or(
+			expression.And(
+				expression.Name("endTime").LessThanEqual(expression.Value(formatTime(now))),
+				expression.Name("autoRenewUntil").GreaterThan(expression.Name("endTime")),
+			),
+			notUTC("endTime"),
+			notUTC("autoRenewUntil"),
+		),
+	)
+
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table, following pagination since the filter is applied after each page is read
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for auto-renewing reservations: %w", err)
+	}
+
+	var candidates []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &candidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	var renewed []models.Reservation
+	envs := make(map[string]*models.Environment)
+	for _, reservation := range candidates {
+		period := reservation.RenewalPeriod()
+		if reservation.AutoRenewUntil == nil || period <= 0 || reservation.EndTime.After(now) {
+			continue
+		}
+
+		// Extend by whole periods until the reservation is active again
+		newEndTime := reservation.EndTime
+		for !newEndTime.After(now) {
+			newEndTime = newEndTime.Add(period)
+		}
+		if newEndTime.After(*reservation.AutoRenewUntil) {
+			newEndTime = *reservation.AutoRenewUntil
+		}
+		if !newEndTime.After(now) {
+			// AutoRenewUntil has passed, so normal expiry applies
+			continue
+		}
+
+		// Don't renew outside the environment's allowed hours; normal expiry applies instead
+		env, ok := envs[reservation.EnvironmentID]
+		if !ok {
+			env, err = r.envRepo.GetEnvironment(reservation.EnvironmentID)
+			if err != nil {
+				return renewed, fmt.Errorf("failed to get environment of reservation %s: %w", reservation.ID, err)
+			}
+			envs[reservation.EnvironmentID] = env
+		}
+		if env.AllowedHours != nil && !env.AllowedHours.Permits(now, newEndTime) {
+			continue
+		}
+
+		// Only renew if the reservation hasn't been released or renewed in the meantime
+		_, err := r.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:        aws.String(r.db.Tables.Reservations),
+			Key:              map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: reservation.ID}},
+			UpdateExpression: aws.String("SET #endTime = :newEndTime, #lastUpdated = :lastUpdated"),
+			ExpressionAttributeNames: map[string]string{
+				"#endTime":     "endTime",
+				"#lastUpdated": "lastUpdated",
+				"#autoRenew":   "autoRenew",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":newEndTime":  &types.AttributeValueMemberS{Value: formatTime(newEndTime)},
+				":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(now)},
+				":now":         &types.AttributeValueMemberS{Value: formatTime(now)},
+				":true":        &types.AttributeValueMemberBOOL{Value: true},
+				":utc":         &types.AttributeValueMemberS{Value: utcSuffix},
+			},
+			ConditionExpression: aws.String("(#endTime <= :now OR NOT contains(#endTime, :utc)) AND #autoRenew = :true"),
+		})
+		if err != nil {
+			var ccf *types.ConditionalCheckFailedException
+			if errors.As(err, &ccf) {
+				log.Printf("Reservation %s was released or renewed while renewing it, skipping it", reservation.ID)
+				continue
+			}
+			return renewed, fmt.Errorf("failed to renew reservation %s: %w", reservation.ID, err)
+		}
+
+		reservation.EndTime = newEndTime
+		reservation.LastUpdated = now
+		renewed = append(renewed, reservation)
+	}
+
+	return renewed, nil
+}
+
+// ActivateScheduledReservations activates scheduled reservations whose start time has passed,
+// reserving their environments. A reservation whose environment is still reserved, locked or
+// held by someone else stays scheduled and is tried again next time, until it reaches its end
+// time and is expired. It returns the reservations it activated.
+func (r *ReservationRepository) ActivateScheduledReservations() ([]models.Reservation, error) {
+	now := utcNow()
+
+	due, err := r.listDueScheduledReservations(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var activated []models.Reservation
+	for _, reservation := range due {
+		err := r.activateScheduledReservation(reservation, now)
+		if errors.Is(err, ErrEnvironmentUnavailable) {
+			log.Printf("Environment %s isn't free yet, leaving scheduled reservation %s for now", reservation.EnvironmentID, reservation.ID)
+			continue
+		}
+		if err != nil {
+			return activated, err
+		}
+		reservation.Status = models.ReservationStatusActive
+		reservation.LastUpdated = now
+		activated = append(activated, reservation)
+	}
+
+	return activated, nil
+}
+
+// listDueScheduledReservations finds scheduled reservations whose start time has passed at now
+// but whose end time hasn't, querying EndTimeStatusIndex or, if it isn't ready yet, scanning
+func (r *ReservationRepository) listDueScheduledReservations(now time.Time) ([]models.Reservation, error) {
+	filt := expression.Name("startTime").LessThanEqual(expression.Value(formatTime(now)))
+	keyCond := expression.KeyAnd(
+		expression.Key("status").Equal(expression.Value(models.ReservationStatusScheduled)),
+		expression.Key("endTime").GreaterThan(expression.Value(formatTime(now))),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	items, err := r.db.queryAll(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EndTimeStatusIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "ValidationException" || apiErr.ErrorCode() == "ResourceNotFoundException") {
+		log.Printf("EndTimeStatusIndex isn't available, scanning for scheduled reservations instead: %v", err)
+		return r.scanReservations(expression.And(
+			expression.Name("status").Equal(expression.Value(models.ReservationStatusScheduled)),
+			expression.Name("endTime").GreaterThan(expression.Value(formatTime(now))),
+			filt,
+		))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query for scheduled reservations: %w", err)
+	}
+
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// activateScheduledReservation makes a scheduled reservation ACTIVE and reserves its environment
+// in a single transaction. It returns ErrEnvironmentUnavailable if the environment isn't free,
+// held by the reservation's owner or held by a hold that has run out, or if the reservation
+// was cancelled or activated in the meantime.
+func (r *ReservationRepository) activateScheduledReservation(reservation models.Reservation, now time.Time) error {
+	// Activate the reservation, as long as it is still scheduled
+	updateReservation := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Reservations),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.ID},
+			},
+			UpdateExpression: aws.String("SET #status = :active, #lastUpdated = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":      "status",
+				"#lastUpdated": "lastUpdated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":active":    &types.AttributeValueMemberS{Value: string(models.ReservationStatusActive)},
+				":scheduled": &types.AttributeValueMemberS{Value: string(models.ReservationStatusScheduled)},
+				":now":       &types.AttributeValueMemberS{Value: formatTime(now)},
+			},
+			ConditionExpression: aws.String("#status = :scheduled"),
+		},
+	}
+
+	// Reserve the environment, turning its owner's hold into the reservation
+	updateEnv := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.db.Tables.Environments),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: reservation.EnvironmentID},
+			},
+			UpdateExpression: aws.String("SET #status = :reserved, #lastUpdated = :now, #currentReservationId = :reservationId REMOVE #heldBy, #heldUntil"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":               "status",
+				"#lastUpdated":          "lastUpdated",
+				"#archived":             "archived",
+				"#heldBy":               "heldBy",
+				"#heldUntil":            "heldUntil",
+				"#currentReservationId": "currentReservationId",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":reserved":      &types.AttributeValueMemberS{Value: string(models.StatusReserved)},
+				":now":           &types.AttributeValueMemberS{Value: formatTime(now)},
+				":reservationId": &types.AttributeValueMemberS{Value: reservation.ID},
+				":free":          &types.AttributeValueMemberS{Value: string(models.StatusFree)},
+				":held":          &types.AttributeValueMemberS{Value: string(models.StatusHeld)},
+				":username":      &types.AttributeValueMemberS{Value: reservation.Username},
+				":archived":      &types.AttributeValueMemberBOOL{Value: true},
+			},
+			ConditionExpression: aws.String("(#status = :free OR (#status = :held AND (#heldBy = :username OR #heldUntil <= :now))) AND " +
+				"(attribute_not_exists(#archived) OR #archived <> :archived)"),
+		},
+	}
+
+	// Execute the transaction
+	_, err := r.db.Client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{updateReservation, updateEnv},
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && !IsThrottled(err) {
+			return ErrEnvironmentUnavailable
+		}
+		return fmt.Errorf("failed to activate scheduled reservation: %w", err)
+	}
+
+	return nil
+}
+
+// CheckExpiredReservations marks reservations that have reached their end time as expired
+// and updates their environments to be free. It returns the reservations it expired.
+func (r *ReservationRepository) CheckExpiredReservations() ([]models.Reservation, error) {
+	now := utcNow()
+
+	candidates, err := r.listEndedReservations(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []models.Reservation
+	for _, reservation := range candidates {
+		if reservation.IsActiveAt(now) {
+			continue
+		}
+		ok, err := r.expireReservation(reservation)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			reservation.Status = models.ReservationStatusExpired
+			reservation.ReleaseType = models.ReleaseExpired
+			releasedAt := reservation.EndTime
+			reservation.ReleasedAt = &releasedAt
+			expired = append(expired, reservation)
+		}
+	}
+
+	return expired, nil
+}
+
+// listEndedReservations finds reservations that have reached their end time at now but
+// haven't been released or expired yet. Active, pending and scheduled ones are queried from
+// EndTimeStatusIndex; if the index isn't ready yet, the table is scanned instead.
+func (r *ReservationRepository) listEndedReservations(now time.Time) ([]models.Reservation, error) {
+	var candidates []models.Reservation
+	for _, status := range []models.ReservationStatus{models.ReservationStatusActive, models.ReservationStatusPending, models.ReservationStatusScheduled} {
+		reservations, err := r.queryEndedByStatus(status, now)
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "ValidationException" || apiErr.ErrorCode() == "ResourceNotFoundException") {
+			log.Printf("EndTimeStatusIndex isn't available, scanning for expired reservations instead: %v", err)
+			return r.scanEndedReservations(now)
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, reservations...)
+	}
+
+	// Reservations written before statuses were stored aren't in the index. Once none of
+	// them is left unended, no more can appear, so the scan for them stops.
+	if !r.legacyExpired.Load() {
+		legacy, err := r.scanUnendedWithoutStatus()
+		if err != nil {
+			return nil, err
+		}
+		if len(legacy) == 0 {
+			r.legacyExpired.Store(true)
+		}
+		candidates = append(candidates, legacy...)
+	}
+
+	return candidates, nil
+}
+
+// queryEndedByStatus queries EndTimeStatusIndex for reservations with the given status
+// whose end time is at or before now
+func (r *ReservationRepository) queryEndedByStatus(status models.ReservationStatus, now time.Time) ([]models.Reservation, error) {
+	keyCond := expression.KeyAnd(
+		expression.Key("status").Equal(expression.Value(status)),
+		expression.Key("endTime").LessThanEqual(expression.Value(formatTime(now))),
+	)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Query the index, following pagination in case the sweep fell behind
+	items, err := r.db.queryAll(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		IndexName:                 aws.String("EndTimeStatusIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query for expired reservations: %w", err)
+	}
+
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// scanEndedReservations scans the table for reservations that have ended but haven't been
+// released or expired yet, for when EndTimeStatusIndex can't be queried
+func (r *ReservationRepository) scanEndedReservations(now time.Time) ([]models.Reservation, error) {
+	filt := expression.And(
+		expression.Or(
+			expression.Name("endTime").LessThanEqual(expression.Value(formatTime(now))),
+			notUTC("endTime"),
+		),
+		expression.Name("releaseType").AttributeNotExists(),
+	)
+	return r.scanReservations(filt)
+}
+
+// scanUnendedWithoutStatus scans the table for reservations written before statuses were
+// stored that haven't been released or expired, whatever their end time
+func (r *ReservationRepository) scanUnendedWithoutStatus() ([]models.Reservation, error) {
+	filt := expression.And(
+		withoutStatus(),
+		expression.Name("releaseType").AttributeNotExists(),
+	)
+	return r.scanReservations(filt)
+}
+
+// scanReservations scans the whole table for reservations matching filt
+func (r *ReservationRepository) scanReservations(filt expression.ConditionBuilder) ([]models.Reservation, error) {
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table, following pagination since the filter is applied after each page is read
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Reservations),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan reservations: %w", err)
+	}
+
+	var reservations []models.Reservation
+	err = attributevalue.UnmarshalListOfMaps(items, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservations: %w", err)
+	}
+
+	return reservations, nil
+}
+
+// expireReservation marks a reservation as expired and frees its environment, unless the
+// environment has since been reserved again or the reservation was scheduled and never
+// activated. It returns false if the reservation was released in the meantime.
+func (r *ReservationRepository) expireReservation(reservation models.Reservation) (bool, error) {
+	// Mark the reservation as expired at its end time
+	_, err := r.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        aws.String(r.db.Tables.Reservations),
+		Key:              map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: reservation.ID}},
+		UpdateExpression: aws.String("SET #status = :status, #releaseType = :releaseType, #releasedAt = :releasedAt, #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":      "status",
+			"#releaseType": "releaseType",
+			"#releasedAt":  "releasedAt",
+			"#lastUpdated": "lastUpdated",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":      &types.AttributeValueMemberS{Value: string(models.ReservationStatusExpired)},
+			":releaseType": &types.AttributeValueMemberS{Value: string(models.ReleaseExpired)},
+			":releasedAt":  &types.AttributeValueMemberS{Value: formatTime(reservation.EndTime)},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+		},
+		// Skip reservations that were released in the meantime
+		ConditionExpression: aws.String("attribute_not_exists(#releaseType)"),
+	})
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to expire reservation: %w", err)
+	}
+
+	// Scheduled reservations that were never activated didn't hold the environment, and
+	// pending ones only hold it if so configured
+	if reservation.Status == models.ReservationStatusScheduled {
+		return true, nil
+	}
+	if reservation.IsPending() && !r.db.Config.ApprovalHoldsEnvironment {
+		return true, nil
+	}
+
+	// Only free the environment if nobody else holds it now
+	active, err := r.GetActiveReservationByEnvironmentID(reservation.EnvironmentID, time.Now())
+	if err != nil {
+		return true, fmt.Errorf("failed to get active reservation: %w", err)
+	}
+	if active != nil {
+		return true, nil
+	}
+
+	err = r.envRepo.FreeEnvironment(reservation.EnvironmentID, reservation.ID)
+	if err != nil && !errors.Is(err, ErrEnvironmentChanged) {
+		return true, fmt.Errorf("failed to update environment status: %w", err)
+	}
+
+	return true, nil
 }
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+)
+
+func TestSearchConditions(t *testing.T) {
+	from := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+	// The same instant as from, given with an offset
+	fromEST := from.In(time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		name       string
+		search     models.ReservationSearch
+		wantNames  []string
+		wantValues []string
+	}{
+		{"text only", models.ReservationSearch{Query: "PAY-1234"}, nil, nil},
+		{"user and environment only", models.ReservationSearch{Username: "alice", EnvironmentID: "env-1"}, nil, nil},
+		{"from", models.ReservationSearch{From: &from}, []string{"endTime"}, []string{"2024-03-04T09:00:00Z"}},
+		{"to", models.ReservationSearch{To: &to}, []string{"startTime"}, []string{"2024-03-05T09:00:00Z"}},
+		{"time range", models.ReservationSearch{From: &from, To: &to}, []string{"endTime", "startTime"}, []string{"2024-03-04T09:00:00Z", "2024-03-05T09:00:00Z"}},
+		{"from with an offset", models.ReservationSearch{From: &fromEST}, []string{"endTime"}, []string{"2024-03-04T09:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conds := searchConditions(tt.search)
+			if len(conds) != len(tt.wantNames) {
+				t.Fatalf("searchConditions returned %d conditions, want %d", len(conds), len(tt.wantNames))
+			}
+			if len(conds) == 0 {
+				return
+			}
+
+			filter := conds[0]
+			if len(conds) > 1 {
+				filter = expression.And(conds[0], conds[1], conds[2:]...)
+			}
+			expr, err := expression.NewBuilder().WithFilter(filter).Build()
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			names := make(map[string]bool)
+			for _, name := range expr.Names() {
+				names[name] = true
+			}
+			for _, name := range tt.wantNames {
+				if !names[name] {
+					t.Errorf("filter %s doesn't use %s; names %v", *expr.Filter(), name, expr.Names())
+				}
+			}
+			values := make(map[string]bool)
+			for _, value := range expr.Values() {
+				if s, ok := value.(*types.AttributeValueMemberS); ok {
+					values[s.Value] = true
+				}
+			}
+			for _, value := range tt.wantValues {
+				if !values[value] {
+					t.Errorf("filter %s doesn't compare with %s", *expr.Filter(), value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+)
+
+// StatsRepository handles operations on the EnvironmentStats table, which holds
+// usage counters for each environment
+type StatsRepository struct {
+	db *DynamoDBClient
+}
+
+// NewStatsRepository creates a new StatsRepository
+func NewStatsRepository(db *DynamoDBClient) *StatsRepository {
+	return &StatsRepository{db: db}
+}
+
+// RecordContention counts a request to reserve an environment that was already reserved
+func (r *StatsRepository) RecordContention(environmentID string) error {
+	now := utcNow()
+
+	// Create the input for the UpdateItem operation; ADD creates the counter if it doesn't exist
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.EnvironmentStats),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: environmentID},
+		},
+		UpdateExpression: aws.String("ADD #contentionCount :one SET #lastContendedAt = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#contentionCount": "contentionCount",
+			"#lastContendedAt": "lastContendedAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":now": &types.AttributeValueMemberS{Value: formatTime(now)},
+		},
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to record contention: %w", err)
+	}
+
+	return nil
+}
+
+// GetEnvironmentStats gets the usage counters of an environment. Environments
+// without any recorded usage get zero counters rather than ErrNotFound.
+func (r *StatsRepository) GetEnvironmentStats(environmentID string) (*models.EnvironmentStats, error) {
+	result, err := r.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.db.Tables.EnvironmentStats),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: environmentID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment stats: %w", err)
+	}
+
+	// Check if the item exists
+	stats := models.EnvironmentStats{EnvironmentID: environmentID}
+	if result.Item == nil {
+		return &stats, nil
+	}
+
+	// Unmarshal the item into an EnvironmentStats struct
+	err = attributevalue.UnmarshalMap(result.Item, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environment stats: %w", err)
+	}
+
+	return &stats, nil
+}
+
+// weeklyCountPrefix starts the names of the attributes holding each environment's count on
+// a week's item
+const weeklyCountPrefix = "reservations:"
+
+// WeekStart returns the start of the week t is in: Monday at midnight UTC
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+}
+
+// weekKey is the key of the item holding the reservation counts of the week starting at weekStart.
+// It shares the EnvironmentStats table with the environments' items, whose keys are their IDs.
+func weekKey(weekStart time.Time) string {
+	return "week#" + weekStart.Format("2006-01-02")
+}
+
+// RecordReservation counts a reservation of an environment made at the given time towards
+// that week's counts, kept on a single item so the whole week can be read at once
+func (r *StatsRepository) RecordReservation(environmentID string, at time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.EnvironmentStats),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: weekKey(WeekStart(at))},
+		},
+		UpdateExpression: aws.String("ADD #count :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": weeklyCountPrefix + environmentID,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+	}
+
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to record reservation: %w", err)
+	}
+
+	return nil
+}
+
+// GetWeeklyReservationCounts gets how many reservations were made of each environment in
+// the week starting at weekStart, by environment ID. Environments without any are left out.
+func (r *StatsRepository) GetWeeklyReservationCounts(weekStart time.Time) (map[string]int, error) {
+	result, err := r.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.db.Tables.EnvironmentStats),
+		Key: map[string]types.AttributeValue{
+			"environmentId": &types.AttributeValueMemberS{Value: weekKey(weekStart)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get weekly reservation counts: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for name, value := range result.Item {
+		number, ok := value.(*types.AttributeValueMemberN)
+		if !strings.HasPrefix(name, weeklyCountPrefix) || !ok {
+			continue
+		}
+		count, err := strconv.Atoi(number.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse weekly reservation count %s: %w", name, err)
+		}
+		counts[strings.TrimPrefix(name, weeklyCountPrefix)] = count
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// Timestamps are stored as UTC RFC3339 strings so that DynamoDB's lexicographic
+// string comparisons order them correctly. Records written before this was enforced
+// may carry other offsets, so filters on time attributes also match any value that
+// isn't in UTC and the results are checked again after unmarshalling.
+
+// utcSuffix ends every timestamp stored in UTC
+const utcSuffix = "Z"
+
+// utc converts t to UTC with whole-second precision, so that marshalled time.Time
+// fields are formatted exactly like formatTime
+func utc(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// utcNow returns the current time for storing or comparing in DynamoDB
+func utcNow() time.Time {
+	return utc(time.Now())
+}
+
+// formatTime formats t for storing or comparing in DynamoDB
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// notUTC matches time attributes that were stored with a non-UTC offset
+func notUTC(name string) expression.ConditionBuilder {
+	return expression.Not(expression.Contains(expression.Name(name), utcSuffix))
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+)
+
+// inZone runs f with the server's local time zone set to loc
+func inZone(t *testing.T, loc *time.Location, f func()) {
+	t.Helper()
+	saved := time.Local
+	time.Local = loc
+	defer func() { time.Local = saved }()
+	f()
+}
+
+func TestStoredTimesInNonUTCZones(t *testing.T) {
+	for _, loc := range []*time.Location{
+		time.FixedZone("UTC+14", 14*60*60),
+		time.FixedZone("UTC-10", -10*60*60),
+		time.FixedZone("UTC+5:30", 5*60*60+30*60),
+	} {
+		t.Run(loc.String(), func(t *testing.T) {
+			inZone(t, loc, func() {
+				now := utcNow()
+				if now.Location() != time.UTC {
+					t.Errorf("utcNow is in %s, want UTC", now.Location())
+				}
+
+				// Reservations are stored with UTC times, formatted like the comparisons made against them
+				local := time.Now()
+				item, err := attributevalue.MarshalMap(models.Reservation{StartTime: utc(local), EndTime: utc(local.Add(time.Hour))})
+				if err != nil {
+					t.Fatalf("MarshalMap: %v", err)
+				}
+				for _, name := range []string{"startTime", "endTime"} {
+					value, ok := item[name].(*types.AttributeValueMemberS)
+					if !ok || !strings.HasSuffix(value.Value, utcSuffix) {
+						t.Errorf("%s stored as %v, want a UTC timestamp", name, item[name])
+					}
+				}
+				if got, want := item["endTime"].(*types.AttributeValueMemberS).Value, formatTime(local.Add(time.Hour)); got != want {
+					t.Errorf("endTime stored as %s, want %s as compared by queries", got, want)
+				}
+
+				// The expiry sweep's string comparison orders times the same way as the times themselves
+				for _, offset := range []time.Duration{-30 * time.Minute, -time.Second, time.Second, 30 * time.Minute} {
+					end := local.Add(offset)
+					ended := formatTime(end) <= formatTime(now)
+					if want := !end.After(now); ended != want {
+						t.Errorf("reservation ending %s from now compares as ended %v, want %v", offset, ended, want)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestReadingNonUTCTimestamps(t *testing.T) {
+	// 10:00 UTC, as written by servers in other zones before times were stored in UTC
+	end := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	for _, stored := range []string{"2024-03-04T10:00:00Z", "2024-03-04T15:30:00+05:30", "2024-03-04T02:00:00-08:00"} {
+		t.Run(stored, func(t *testing.T) {
+			var reservation models.Reservation
+			if err := attributevalue.UnmarshalMap(map[string]types.AttributeValue{
+				"startTime": &types.AttributeValueMemberS{Value: "2024-03-04T09:00:00Z"},
+				"endTime":   &types.AttributeValueMemberS{Value: stored},
+			}, &reservation); err != nil {
+				t.Fatalf("UnmarshalMap: %v", err)
+			}
+			if !reservation.EndTime.Equal(end) {
+				t.Errorf("endTime = %s, want %s", reservation.EndTime, end)
+			}
+			if !reservation.IsActiveAt(end.Add(-time.Second)) || reservation.IsActiveAt(end) {
+				t.Error("reservation isn't active exactly until 10:00 UTC")
+			}
+		})
+	}
+}
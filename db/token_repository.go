@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/devreserve/server/models"
+)
+
+// TokenRepository handles operations on the RevokedTokens table, the denylist of JWTs
+// logged out before they expired
+type TokenRepository struct {
+	db *DynamoDBClient
+}
+
+// NewTokenRepository creates a new TokenRepository
+func NewTokenRepository(db *DynamoDBClient) *TokenRepository {
+	return &TokenRepository{
+		db: db,
+	}
+}
+
+// RevokeToken adds a JWT to the denylist until its expiry, after which DynamoDB deletes it
+func (r *TokenRepository) RevokeToken(token models.RevokedToken) error {
+	token.ExpiresAt = utc(token.ExpiresAt)
+	token.TTL = token.ExpiresAt.Unix()
+
+	// Convert the token to a DynamoDB item
+	item, err := attributevalue.MarshalMap(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revoked token: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.RevokedTokens),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+// ListRevokedTokens gets the revoked JWTs that haven't expired at now. DynamoDB deletes
+// expired items some time after they expire, so they are filtered out here as well.
+func (r *TokenRepository) ListRevokedTokens(now time.Time) ([]models.RevokedToken, error) {
+	filt := expression.Name("ttl").GreaterThan(expression.Value(now.Unix()))
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table, following pagination
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.RevokedTokens),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan revoked tokens: %w", err)
+	}
+
+	// Unmarshal the items into RevokedToken structs
+	tokens := []models.RevokedToken{}
+	err = attributevalue.UnmarshalListOfMaps(items, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal revoked tokens: %w", err)
+	}
+
+	return tokens, nil
+}
@@ -1,12 +1,16 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/devreserve/server/models"
 )
 
@@ -23,26 +27,26 @@ func NewUserRepository(db *DynamoDBClient) *UserRepository {
 // CreateUser creates a new user in the database
 func (r *UserRepository) CreateUser(user models.User) error {
 	// Set the timestamps
-	now := time.Now()
+	now := utcNow()
 	user.CreatedAt = now
 	user.LastUpdated = now
 
 	// Convert the user to a DynamoDB item
-	item, err := dynamodbattribute.MarshalMap(user)
+	item, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 
 	// Create the input for the PutItem operation
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(UsersTableName),
+		TableName: aws.String(r.db.Tables.Users),
 		Item:      item,
 		// Ensure the username doesn't already exist
 		ConditionExpression: aws.String("attribute_not_exists(username)"),
 	}
 
 	// Put the item in DynamoDB
-	_, err = r.db.Client.PutItem(input)
+	_, err = r.db.Client.PutItem(context.TODO(), input)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -50,32 +54,30 @@ func (r *UserRepository) CreateUser(user models.User) error {
 	return nil
 }
 
-// GetUser gets a user by username
+// GetUser gets a user by username, returning ErrNotFound if it doesn't exist
 func (r *UserRepository) GetUser(username string) (*models.User, error) {
 	// Create the input for the GetItem operation
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String(UsersTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(username),
-			},
+		TableName: aws.String(r.db.Tables.Users),
+		Key: map[string]types.AttributeValue{
+			"username": &types.AttributeValueMemberS{Value: username},
 		},
 	}
 
 	// Get the item from DynamoDB
-	result, err := r.db.Client.GetItem(input)
+	result, err := r.db.Client.GetItem(context.TODO(), input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	// Check if the item exists
 	if result.Item == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	// Unmarshal the item into a User struct
 	var user models.User
-	err = dynamodbattribute.UnmarshalMap(result.Item, &user)
+	err = attributevalue.UnmarshalMap(result.Item, &user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
 	}
@@ -83,22 +85,83 @@ func (r *UserRepository) GetUser(username string) (*models.User, error) {
 	return &user, nil
 }
 
+// GetUserByEmail gets a user by email address
+func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+	return r.findUser(expression.Name("email").Equal(expression.Value(email)))
+}
+
+// GetUserByResetTokenHash gets the user holding the given password reset token hash
+func (r *UserRepository) GetUserByResetTokenHash(tokenHash string) (*models.User, error) {
+	return r.findUser(expression.Name("resetTokenHash").Equal(expression.Value(tokenHash)))
+}
+
+// HasAdmin reports whether at least one admin user exists
+func (r *UserRepository) HasAdmin() (bool, error) {
+	_, err := r.findUser(expression.Name("role").Equal(expression.Value(models.RoleAdmin)))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// findUser scans the Users table for the first user matching the filter, returning ErrNotFound if there is none
+func (r *UserRepository) findUser(filt expression.ConditionBuilder) (*models.User, error) {
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Create the input for the Scan operation
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Users),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	// Scan the table page by page, since the filter is applied after each page is read, and
+	// stop at the first match
+	paginator := dynamodb.NewScanPaginator(r.db.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan users: %w", err)
+		}
+		if len(page.Items) == 0 {
+			continue
+		}
+
+		// Unmarshal the item into a User struct
+		var user models.User
+		err = attributevalue.UnmarshalMap(page.Items[0], &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+		}
+		return &user, nil
+	}
+
+	return nil, ErrNotFound
+}
+
 // ListUsers gets all users
 func (r *UserRepository) ListUsers() ([]models.UserResponse, error) {
 	// Create the input for the Scan operation
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(UsersTableName),
+		TableName: aws.String(r.db.Tables.Users),
 	}
 
-	// Scan the table
-	result, err := r.db.Client.Scan(input)
+	// Scan the table, following pagination
+	items, err := r.db.scanAll(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
 
 	// Unmarshal the items into User structs
 	var users []models.User
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &users)
+	err = attributevalue.UnmarshalListOfMaps(items, &users)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal users: %w", err)
 	}
@@ -114,25 +177,30 @@ func (r *UserRepository) ListUsers() ([]models.UserResponse, error) {
 
 // UpdateUser updates an existing user
 func (r *UserRepository) UpdateUser(user models.User) error {
-	// Set the last updated timestamp
-	user.LastUpdated = time.Now()
+	// Set the last updated timestamp, storing every timestamp in UTC
+	user.LastUpdated = utcNow()
+	user.CreatedAt = utc(user.CreatedAt)
+	if user.ResetTokenExpiresAt != nil {
+		expiresAt := utc(*user.ResetTokenExpiresAt)
+		user.ResetTokenExpiresAt = &expiresAt
+	}
 
 	// Convert the user to a DynamoDB item
-	item, err := dynamodbattribute.MarshalMap(user)
+	item, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 
 	// Create the input for the PutItem operation
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(UsersTableName),
+		TableName: aws.String(r.db.Tables.Users),
 		Item:      item,
 		// Ensure the username exists
 		ConditionExpression: aws.String("attribute_exists(username)"),
 	}
 
 	// Put the item in DynamoDB
-	_, err = r.db.Client.PutItem(input)
+	_, err = r.db.Client.PutItem(context.TODO(), input)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
@@ -144,19 +212,154 @@ func (r *UserRepository) UpdateUser(user models.User) error {
 func (r *UserRepository) DeleteUser(username string) error {
 	// Create the input for the DeleteItem operation
 	input := &dynamodb.DeleteItemInput{
-		TableName: aws.String(UsersTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(username),
-			},
+		TableName: aws.String(r.db.Tables.Users),
+		Key: map[string]types.AttributeValue{
+			"username": &types.AttributeValueMemberS{Value: username},
 		},
 	}
 
 	// Delete the item from DynamoDB
-	_, err := r.db.Client.DeleteItem(input)
+	_, err := r.db.Client.DeleteItem(context.TODO(), input)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
 	return nil
 }
+
+// SetPasswordResetToken stores a hashed password reset token and its expiry on a user,
+// replacing any previous token
+func (r *UserRepository) SetPasswordResetToken(username, tokenHash string, expiresAt time.Time) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Users),
+		Key: map[string]types.AttributeValue{
+			"username": &types.AttributeValueMemberS{Value: username},
+		},
+		UpdateExpression: aws.String("SET #resetTokenHash = :resetTokenHash, #resetTokenExpiresAt = :resetTokenExpiresAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#resetTokenHash":      "resetTokenHash",
+			"#resetTokenExpiresAt": "resetTokenExpiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":resetTokenHash":      &types.AttributeValueMemberS{Value: tokenHash},
+			":resetTokenExpiresAt": &types.AttributeValueMemberS{Value: formatTime(expiresAt)},
+		},
+		ConditionExpression: aws.String("attribute_exists(username)"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to set password reset token: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword replaces a user's password and consumes their reset token. The update
+// only succeeds if the token hash still matches, so a token can only be used once.
+func (r *UserRepository) ResetPassword(username, tokenHash, hashedPassword string) error {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Users),
+		Key: map[string]types.AttributeValue{
+			"username": &types.AttributeValueMemberS{Value: username},
+		},
+		UpdateExpression: aws.String("SET #password = :password, #lastUpdated = :lastUpdated REMOVE #resetTokenHash, #resetTokenExpiresAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#password":            "password",
+			"#lastUpdated":         "lastUpdated",
+			"#resetTokenHash":      "resetTokenHash",
+			"#resetTokenExpiresAt": "resetTokenExpiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":password":       &types.AttributeValueMemberS{Value: hashedPassword},
+			":lastUpdated":    &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+			":resetTokenHash": &types.AttributeValueMemberS{Value: tokenHash},
+		},
+		ConditionExpression: aws.String("#resetTokenHash = :resetTokenHash"),
+	}
+
+	// Update the item in DynamoDB
+	_, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	return nil
+}
+
+// IncrementTokenVersion bumps a user's token version, revoking every JWT issued to them
+// before, and returns the new version. It returns ErrNotFound if the user doesn't exist.
+func (r *UserRepository) IncrementTokenVersion(username string) (int, error) {
+	// Create the input for the UpdateItem operation
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.db.Tables.Users),
+		Key: map[string]types.AttributeValue{
+			"username": &types.AttributeValueMemberS{Value: username},
+		},
+		UpdateExpression: aws.String("ADD #tokenVersion :one SET #lastUpdated = :lastUpdated"),
+		ExpressionAttributeNames: map[string]string{
+			"#tokenVersion": "tokenVersion",
+			"#lastUpdated":  "lastUpdated",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":         &types.AttributeValueMemberN{Value: "1"},
+			":lastUpdated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+		},
+		ConditionExpression: aws.String("attribute_exists(username)"),
+		ReturnValues:        types.ReturnValueUpdatedNew,
+	}
+
+	// Update the item in DynamoDB
+	result, err := r.db.Client.UpdateItem(context.TODO(), input)
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to increment token version: %w", err)
+	}
+
+	var version int
+	if err := attributevalue.Unmarshal(result.Attributes["tokenVersion"], &version); err != nil {
+		return 0, fmt.Errorf("failed to parse token version: %w", err)
+	}
+	return version, nil
+}
+
+// ListTokenVersions gets the token version of every user whose tokens have ever been revoked
+func (r *UserRepository) ListTokenVersions() (map[string]int, error) {
+	filt := expression.Name("tokenVersion").AttributeExists()
+	proj := expression.NamesList(expression.Name("username"), expression.Name("tokenVersion"))
+	expr, err := expression.NewBuilder().WithFilter(filt).WithProjection(proj).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Scan the table, following pagination
+	items, err := r.db.scanAll(&dynamodb.ScanInput{
+		TableName:                 aws.String(r.db.Tables.Users),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan token versions: %w", err)
+	}
+
+	// Unmarshal the items into User structs
+	var users []models.User
+	err = attributevalue.UnmarshalListOfMaps(items, &users)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal users: %w", err)
+	}
+
+	versions := make(map[string]int, len(users))
+	for _, user := range users {
+		versions[user.Username] = user.TokenVersion
+	}
+	return versions, nil
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/devreserve/server/models"
+	"github.com/google/uuid"
+)
+
+// WebhookRepository handles operations on the Webhooks and WebhookDeliveries tables
+type WebhookRepository struct {
+	db *DynamoDBClient
+}
+
+// NewWebhookRepository creates a new WebhookRepository
+func NewWebhookRepository(db *DynamoDBClient) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// CreateWebhook creates a new webhook in the database
+func (r *WebhookRepository) CreateWebhook(webhook models.Webhook) (*models.Webhook, error) {
+	// Generate a new ID for the webhook
+	webhook.ID = uuid.New().String()
+	webhook.Active = true
+
+	// Set the timestamps
+	now := utcNow()
+	webhook.CreatedAt = now
+	webhook.LastUpdated = now
+
+	// Convert the webhook to a DynamoDB item
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Webhooks),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+// GetWebhook gets a webhook by ID, returning ErrNotFound if it doesn't exist
+func (r *WebhookRepository) GetWebhook(id string) (*models.Webhook, error) {
+	// Get the item from DynamoDB
+	result, err := r.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.db.Tables.Webhooks),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	// Check if the item exists
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	// Unmarshal the item into a Webhook struct
+	var webhook models.Webhook
+	err = attributevalue.UnmarshalMap(result.Item, &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+// ListWebhooks gets all webhooks
+func (r *WebhookRepository) ListWebhooks() ([]models.Webhook, error) {
+	// Scan the table
+	result, err := r.db.Client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName: aws.String(r.db.Tables.Webhooks),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhooks: %w", err)
+	}
+
+	// Unmarshal the items into Webhook structs
+	webhooks := []models.Webhook{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook updates an existing webhook
+func (r *WebhookRepository) UpdateWebhook(webhook models.Webhook) error {
+	// Set the last updated timestamp
+	webhook.LastUpdated = utcNow()
+	webhook.CreatedAt = utc(webhook.CreatedAt)
+
+	// Convert the webhook to a DynamoDB item
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.Webhooks),
+		Item:      item,
+		// Ensure the webhook ID exists
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes a webhook, returning ErrNotFound if it doesn't exist. Its
+// delivery history is kept.
+func (r *WebhookRepository) DeleteWebhook(id string) error {
+	_, err := r.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.db.Tables.Webhooks),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	return nil
+}
+
+// RecordDelivery records the outcome of delivering an event to a webhook
+func (r *WebhookRepository) RecordDelivery(delivery models.WebhookDelivery) error {
+	// Keep sub-second precision so deliveries of the same webhook don't overwrite each other
+	delivery.Timestamp = time.Now().UTC()
+
+	// Convert the delivery to a DynamoDB item
+	item, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	// Put the item in DynamoDB
+	_, err = r.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.db.Tables.WebhookDeliveries),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// ListRecentDeliveries gets the most recent deliveries to a webhook, newest first
+func (r *WebhookRepository) ListRecentDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	// Create a key condition for the webhook's deliveries
+	keyCond := expression.Key("webhookId").Equal(expression.Value(webhookID))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+
+	// Query the table
+	result, err := r.db.Client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:                 aws.String(r.db.Tables.WebhookDeliveries),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+
+	// Unmarshal the items into WebhookDelivery structs
+	deliveries := []models.WebhookDelivery{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
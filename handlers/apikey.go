@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyHandler handles API key management requests
+type APIKeyHandler struct {
+	apiKeyRepo *db.APIKeyRepository
+	userRepo   db.UserRepositoryInterface
+	auditRepo  db.AuditRepositoryInterface
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(apiKeyRepo *db.APIKeyRepository, userRepo db.UserRepositoryInterface, auditRepo db.AuditRepositoryInterface) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyRepo: apiKeyRepo,
+		userRepo:   userRepo,
+		auditRepo:  auditRepo,
+	}
+}
+
+// CreateAPIKey handles requests to create an API key for a user (admin only)
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the request body
+	var req models.APIKeyCreateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if req.Username == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
+		return
+	}
+	if req.Label == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Label is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []models.APIKeyScope{models.ScopeRead, models.ScopeWrite}
+	}
+	for _, scope := range req.Scopes {
+		if scope != models.ScopeRead && scope != models.ScopeWrite && scope != models.ScopeAdmin && scope != models.ScopeOncall {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidScope, "Invalid scope: "+string(scope))
+			return
+		}
+	}
+
+	// Check that the owning user exists
+	_, err := h.userRepo.GetUser(req.Username)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeUserNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get user")
+		return
+	}
+
+	// Create the key
+	key, plaintext, err := h.apiKeyRepo.CreateAPIKey(models.APIKey{
+		Username:  req.Username,
+		Label:     req.Label,
+		Scopes:    req.Scopes,
+		CreatedBy: admin.Username,
+	})
+	if err != nil {
+		respondWithServerError(w, err, "Failed to create API key")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionCreateAPIKey,
+		Description: fmt.Sprintf("Created API key %q for %s", key.Label, key.Username),
+		ResourceID:  key.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the key, including the plaintext which is only shown once
+	utils.RespondWithCreated(w, "", models.APIKeyCreateResponse{
+		APIKey: *key,
+		Key:    plaintext,
+	})
+}
+
+// ListAPIKeys handles requests to list all API keys (admin only)
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the keys
+	keys, err := h.apiKeyRepo.ListAPIKeys()
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list API keys")
+		return
+	}
+
+	// Respond with the keys
+	utils.RespondWithSuccess(w, keys)
+}
+
+// RevokeAPIKey handles requests to revoke an API key (admin only)
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the key ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "API key ID is required")
+		return
+	}
+
+	// Get the key
+	key, err := h.apiKeyRepo.GetAPIKey(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeAPIKeyNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get API key")
+		return
+	}
+
+	// Revoke the key
+	if err := h.apiKeyRepo.RevokeAPIKey(id, admin.Username); err != nil {
+		respondWithServerError(w, err, "Failed to revoke API key")
+		return
+	}
+	now := time.Now()
+	key.Revoked = true
+	key.RevokedAt = &now
+	key.RevokedBy = admin.Username
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionRevokeAPIKey,
+		Description: fmt.Sprintf("Revoked API key %q for %s", key.Label, key.Username),
+		ResourceID:  key.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the key
+	utils.RespondWithSuccess(w, key)
+}
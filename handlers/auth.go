@@ -1,26 +1,43 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/devreserve/server/config"
 	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/mailer"
+	"github.com/devreserve/server/middleware"
 	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/revocation"
 	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
 )
 
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
-	userRepo *db.UserRepository
-	config   config.Config
+	userRepo    db.UserRepositoryInterface
+	inviteRepo  *db.InviteRepository
+	auditRepo   db.AuditRepositoryInterface
+	revocations *revocation.Store
+	mailer      mailer.Mailer
+	config      config.Config
 }
 
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(userRepo *db.UserRepository, config config.Config) *AuthHandler {
+func NewAuthHandler(userRepo db.UserRepositoryInterface, inviteRepo *db.InviteRepository, auditRepo db.AuditRepositoryInterface,
+	revocations *revocation.Store, mailer mailer.Mailer, config config.Config) *AuthHandler {
 	return &AuthHandler{
-		userRepo: userRepo,
-		config:   config,
+		userRepo:    userRepo,
+		inviteRepo:  inviteRepo,
+		auditRepo:   auditRepo,
+		revocations: revocations,
+		mailer:      mailer,
+		config:      config,
 	}
 }
 
@@ -35,35 +52,54 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body
 	var req models.RegisterRequest
 	if err := utils.ParseJSONBody(r, &req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Without self-registration, accounts can only be created with an invite
+	if !h.config.SelfRegistrationEnabled() && req.InviteCode == "" {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeInviteRequired, "Self-registration is disabled; an invite code is required")
 		return
 	}
 
 	// Validate the username and password
 	if req.Username == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
 		return
 	}
 	if len(req.Password) < 8 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodePasswordTooShort, "Password must be at least 8 characters")
 		return
 	}
 
 	// Check if the username already exists
-	existingUser, err := h.userRepo.GetUser(req.Username)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check username")
+	_, err := h.userRepo.GetUser(req.Username)
+	if err == nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeUsernameTaken, "Username already exists")
 		return
 	}
-	if existingUser != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username already exists")
+	if !errors.Is(err, db.ErrNotFound) {
+		respondWithServerError(w, err, "Failed to check username")
 		return
 	}
 
+	// Check if the email is already in use
+	if req.Email != "" {
+		_, err = h.userRepo.GetUserByEmail(req.Email)
+		if err == nil {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEmailTaken, "Email already in use")
+			return
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			respondWithServerError(w, err, "Failed to check email")
+			return
+		}
+	}
+
 	// Hash the password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		respondWithServerError(w, err, "Failed to hash password")
 		return
 	}
 
@@ -71,25 +107,46 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	user := models.User{
 		Username:    req.Username,
 		Password:    hashedPassword,
+		Email:       req.Email,
 		Role:        models.RoleUser, // By default, new users are regular users
 		CreatedAt:   time.Now(),
 		LastUpdated: time.Now(),
 	}
 
-	if err := h.userRepo.CreateUser(user); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+	if req.InviteCode != "" {
+		// Use up the invite, which also decides the user's role
+		created, err := h.inviteRepo.RegisterWithInvite(req.InviteCode, user)
+		if errors.Is(err, db.ErrNotFound) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInviteInvalid, "Invalid invite code")
+			return
+		}
+		if errors.Is(err, db.ErrInviteUsed) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInviteUsed, "Invite code has already been used")
+			return
+		}
+		if errors.Is(err, db.ErrInviteExpired) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInviteExpired, "Invite code has expired")
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, err, "Failed to create user")
+			return
+		}
+		user = *created
+	} else if err := h.userRepo.CreateUser(user); err != nil {
+		respondWithServerError(w, err, "Failed to create user")
 		return
 	}
 
 	// Generate a token for the new user
 	token, err := utils.GenerateToken(user, h.config)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		respondWithServerError(w, err, "Failed to generate token")
 		return
 	}
 
 	// Respond with the token
-	utils.RespondWithSuccess(w, map[string]interface{}{
+	utils.RespondWithCreated(w, "/api/users/"+url.PathEscape(user.Username), map[string]interface{}{
 		"token": token,
 		"user":  user.ToResponse(),
 	})
@@ -106,43 +163,259 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body
 	var req models.LoginRequest
 	if err := utils.ParseJSONBody(r, &req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate the username and password
 	if req.Username == "" || req.Password == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username and password are required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username and password are required")
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepo.GetUser(req.Username)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get user")
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeInvalidCredentials, "Invalid username or password")
 		return
 	}
-	if user == nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get user")
 		return
 	}
 
 	// Check the password
 	if !utils.CheckPassword(req.Password, user.Password) {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+		utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeInvalidCredentials, "Invalid username or password")
 		return
 	}
 
 	// Generate a token
 	token, err := utils.GenerateToken(*user, h.config)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		respondWithServerError(w, err, "Failed to generate token")
 		return
 	}
 
+	// Record the login in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionLogin,
+		Description: "Logged in",
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
 	// Respond with the token
 	utils.RespondWithSuccess(w, map[string]interface{}{
 		"token": token,
 		"user":  user.ToResponse(),
 	})
 }
+
+// ForgotPassword handles requests to email a password reset token. It responds the same
+// way whether or not an account with the email exists, so it can't be used to probe for accounts.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse the request body
+	var req models.ForgotPasswordRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the email
+	if req.Email == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Email is required")
+		return
+	}
+
+	// Send the reset token if the account exists, in the background so the response takes
+	// as long whether or not it does; failures are only logged
+	go func(email string) {
+		if err := h.sendResetToken(email); err != nil {
+			log.Printf("Error sending password reset token: %v", err)
+		}
+	}(req.Email)
+
+	// Respond identically whether or not the email exists
+	utils.RespondWithSuccess(w, map[string]interface{}{
+		"message": "If an account with that email exists, a password reset token has been sent",
+	})
+}
+
+// sendResetToken generates, stores and emails a password reset token for the user with the given email
+func (h *AuthHandler) sendResetToken(email string) error {
+	// Get the user
+	user, err := h.userRepo.GetUserByEmail(email)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Generate the token and store its hash
+	token, err := utils.GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(h.config.PasswordResetTokenTTL)
+	if err := h.userRepo.SetPasswordResetToken(user.Username, utils.HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	// Email the plaintext token to the user
+	body := fmt.Sprintf("Use the following token to reset the password for %s:\n\n%s\n\nThe token expires at %s.",
+		user.Username, token, expiresAt.Format(time.RFC1123))
+	return h.mailer.Send(user.Email, "Dev Reserve password reset", body)
+}
+
+// ResetPassword handles requests to set a new password using a reset token
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse the request body
+	var req models.ResetPasswordRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the token and password
+	if req.Token == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Token is required")
+		return
+	}
+	if len(req.Password) < 8 {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodePasswordTooShort, "Password must be at least 8 characters")
+		return
+	}
+
+	// Get the user holding the token
+	tokenHash := utils.HashToken(req.Token)
+	user, err := h.userRepo.GetUserByResetTokenHash(tokenHash)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		respondWithServerError(w, err, "Failed to check reset token")
+		return
+	}
+	if user == nil || user.ResetTokenExpiresAt == nil || time.Now().After(*user.ResetTokenExpiresAt) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidResetToken, "Invalid or expired reset token")
+		return
+	}
+
+	// Hash the new password
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to hash password")
+		return
+	}
+
+	// Update the password and consume the token
+	if err := h.userRepo.ResetPassword(user.Username, tokenHash, hashedPassword); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidResetToken, "Invalid or expired reset token")
+		return
+	}
+
+	// Respond with success
+	utils.RespondWithSuccess(w, map[string]interface{}{
+		"message": "Password reset successfully",
+	})
+}
+
+// Logout handles requests to revoke the JWT the request was made with, so it stops working
+// before it expires
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the token's claims from the request context
+	claims, ok := r.Context().Value(middleware.TokenClaimsContextKey).(*utils.Claims)
+	if !ok {
+		utils.RespondWithError(w, http.StatusBadRequest, "Only JWTs can be logged out; API keys are revoked by an admin")
+		return
+	}
+	if claims.ID == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "This token was issued before tokens could be logged out; ask an admin to revoke your tokens instead")
+		return
+	}
+
+	// Keep the token revoked for as long as it would still be accepted
+	expiresAt := claims.ExpiresAt.Time.Add(h.config.JWTLeeway)
+	if err := h.revocations.RevokeToken(claims, expiresAt); err != nil {
+		respondWithServerError(w, err, "Failed to log out")
+		return
+	}
+
+	// Respond with no content
+	utils.RespondWithNoContent(w)
+}
+
+// RevokeUserTokens handles requests to revoke every JWT issued to a user so far, for example
+// when they leave (admin only). Tokens issued afterwards work as usual.
+func (h *AuthHandler) RevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the username from the URL parameters
+	vars := mux.Vars(r)
+	username := vars["username"]
+	if username == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
+		return
+	}
+
+	// Revoke the user's tokens
+	version, err := h.revocations.RevokeUserTokens(username)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeUserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to revoke tokens")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionRevokeTokens,
+		Description: fmt.Sprintf("Revoked all tokens of %s", username),
+		ResourceID:  username,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the user's new token version
+	utils.RespondWithSuccess(w, models.TokenRevocationResult{
+		Username:     username,
+		TokenVersion: version,
+	})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+func TestLogin(t *testing.T) {
+	hash, err := utils.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := alice
+	user.Password = hash
+	cfg := config.Config{JWTSecret: "test-secret", JWTExpirationHours: 1, JWTLeeway: time.Minute}
+	audit := newAuditLog()
+	handler := NewAuthHandler(newUserRepo(user), nil, audit, nil, nil, cfg)
+
+	var resp struct {
+		Token string              `json:"token"`
+		User  models.UserResponse `json:"user"`
+	}
+	decodeData(t, serve(handler.Login, request(http.MethodPost, "/api/auth/login", nil, nil,
+		`{"username": "alice", "password": "correct horse"}`)), &resp)
+	claims, err := utils.ValidateToken(resp.Token, cfg)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.Username != alice.Username || claims.Role != alice.Role || claims.Team != alice.Team {
+		t.Errorf("claims = %+v, want alice's", claims)
+	}
+	if resp.User.Username != alice.Username {
+		t.Errorf("user = %+v, want alice", resp.User)
+	}
+	if got, want := audit.recorded(), []models.AuditAction{models.AuditActionLogin}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"wrong password", `{"username": "alice", "password": "battery staple"}`, http.StatusUnauthorized, "INVALID_CREDENTIALS"},
+		{"unknown user", `{"username": "nobody", "password": "correct horse"}`, http.StatusUnauthorized, "INVALID_CREDENTIALS"},
+		{"no password", `{"username": "alice"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"malformed body", `{"username": "alice",`, http.StatusBadRequest, "INVALID_BODY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.Login, request(http.MethodPost, "/api/auth/login", nil, nil, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	cfg := config.Config{JWTSecret: "test-secret", JWTExpirationHours: 1, JWTLeeway: time.Minute, AllowSelfRegistration: true}
+	userRepo := newUserRepo(alice)
+	userRepo.GetUserByEmailFunc = func(string) (*models.User, error) {
+		return nil, db.ErrNotFound
+	}
+	var created models.User
+	userRepo.CreateUserFunc = func(user models.User) error {
+		created = user
+		return nil
+	}
+	handler := NewAuthHandler(userRepo, nil, newAuditLog(), nil, nil, cfg)
+
+	rec := serve(handler.Register, request(http.MethodPost, "/api/auth/register", nil, nil,
+		`{"username": "carol", "password": "correct horse", "email": "carol@example.com"}`))
+	resp := decode(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "/api/users/carol" {
+		t.Errorf("Location = %q, want /api/users/carol", got)
+	}
+	var registered struct {
+		Token string              `json:"token"`
+		User  models.UserResponse `json:"user"`
+	}
+	decodeRaw(t, resp.Data, &registered)
+	if claims, err := utils.ValidateToken(registered.Token, cfg); err != nil || claims.Username != "carol" {
+		t.Errorf("token claims = %+v, %v; want carol's", claims, err)
+	}
+	if registered.User.Username != "carol" || created.Role != models.RoleUser {
+		t.Errorf("registered %+v as %s, want carol as a user", registered.User, created.Role)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"username taken", cfg, `{"username": "alice", "password": "correct horse"}`, http.StatusBadRequest, "USERNAME_TAKEN"},
+		{"short password", cfg, `{"username": "carol", "password": "short"}`, http.StatusBadRequest, "PASSWORD_TOO_SHORT"},
+		{"no username", cfg, `{"password": "correct horse"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"self-registration disabled", config.Config{}, `{"username": "carol", "password": "correct horse"}`, http.StatusForbidden, "INVITE_REQUIRED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewAuthHandler(newUserRepo(alice), nil, newAuditLog(), nil, nil, tt.cfg)
+			rec := serve(handler.Register, request(http.MethodPost, "/api/auth/register", nil, nil, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+			if rec.Header().Get("Location") != "" {
+				t.Errorf("Location = %q on an error", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
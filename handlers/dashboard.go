@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// dashboardSnapshot holds what GetDashboardStats reads from DynamoDB, before it is narrowed
+// down to the environments a user can see
+type dashboardSnapshot struct {
+	fetchedAt time.Time
+	// environments includes archived ones, for naming this week's most reserved
+	environments []models.Environment
+	// active counts the active reservations by environment ID
+	active map[string]int
+	// weekStart is the start of the week weekly counts the reservations made in, by environment ID
+	weekStart time.Time
+	weekly    map[string]int
+}
+
+// dashboardCache keeps the last dashboard snapshot for a while, so dashboards polling
+// GET /api/stats don't each scan the environments table
+type dashboardCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	snapshot *dashboardSnapshot
+}
+
+// dashboardSnapshot returns the cached snapshot if it's younger than the cache TTL, reading
+// a new one otherwise. The lock is held while reading, so requests arriving meanwhile wait
+// for that read instead of starting their own.
+func (h *EnvironmentHandler) dashboardSnapshot(now time.Time) (*dashboardSnapshot, error) {
+	h.dashboard.mu.Lock()
+	defer h.dashboard.mu.Unlock()
+	if cached := h.dashboard.snapshot; cached != nil && now.Sub(cached.fetchedAt) < h.dashboard.ttl {
+		return cached, nil
+	}
+
+	environments, err := h.envRepo.ListEnvironments(true)
+	if err != nil {
+		return nil, err
+	}
+	active, err := h.reservationRepo.CountActiveReservationsByEnvironment(now)
+	if err != nil {
+		return nil, err
+	}
+	weekStart := db.WeekStart(now)
+	weekly, err := h.statsRepo.GetWeeklyReservationCounts(weekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	h.dashboard.snapshot = &dashboardSnapshot{
+		fetchedAt:    now,
+		environments: environments,
+		active:       active,
+		weekStart:    weekStart,
+		weekly:       weekly,
+	}
+	return h.dashboard.snapshot, nil
+}
+
+// GetDashboardStats handles requests for the headline numbers of an ops dashboard in one
+// call: environments by status, active reservations and the most reserved environment this
+// week, all over the environments the user can see. The environments are counted from a
+// scan of the table, the active reservations on an index and the week's reservations from
+// counters kept as reservations are made. What was read is reused for DASHBOARD_CACHE_TTL,
+// so the numbers can be that much out of date.
+func (h *EnvironmentHandler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environments and counts, cached or not
+	snapshot, err := h.dashboardSnapshot(time.Now())
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get dashboard stats")
+		return
+	}
+
+	// Count the environments the user can see by status, and their active reservations
+	environments := visibleEnvironments(user, snapshot.environments)
+	stats := models.DashboardStats{
+		ByStatus:  make(map[models.EnvironmentStatus]int),
+		WeekStart: snapshot.weekStart,
+	}
+	names := make(map[string]string, len(environments))
+	for _, env := range environments {
+		names[env.ID] = env.Name
+		stats.ActiveReservations += snapshot.active[env.ID]
+		if env.Archived {
+			continue
+		}
+		stats.TotalEnvironments++
+		stats.ByStatus[env.Status]++
+	}
+	stats.Free = stats.ByStatus[models.StatusFree]
+	stats.Reserved = stats.ByStatus[models.StatusReserved]
+	stats.Maintenance = stats.ByStatus[models.StatusLocked]
+
+	// Find the most reserved environment this week, breaking ties by name
+	for id, count := range snapshot.weekly {
+		name, ok := names[id]
+		if !ok {
+			continue // deleted since, or not visible to the user
+		}
+		best := stats.MostReservedThisWeek
+		if best == nil || count > best.Reservations || (count == best.Reservations && name < best.EnvironmentName) {
+			stats.MostReservedThisWeek = &models.EnvironmentReservations{EnvironmentID: id, EnvironmentName: name, Reservations: count}
+		}
+	}
+
+	// Respond with the stats
+	utils.RespondWithSuccess(w, stats)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+)
+
+func TestDashboardStatsCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantReads int
+	}{
+		{"cached", time.Minute, 1},
+		{"disabled", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reads := 0
+			envRepo := newEnvRepo(paymentsEnv, sharedEnv)
+			listEnvironments := envRepo.ListEnvironmentsFunc
+			envRepo.ListEnvironmentsFunc = func(includeArchived bool) ([]models.Environment, error) {
+				reads++
+				return listEnvironments(includeArchived)
+			}
+			reservationRepo := &mock.MockReservationRepository{
+				CountActiveReservationsByEnvironmentFunc: func(time.Time) (map[string]int, error) {
+					return map[string]int{}, nil
+				},
+			}
+			statsRepo := &mock.MockStatsRepository{
+				GetWeeklyReservationCountsFunc: func(time.Time) (map[string]int, error) {
+					return map[string]int{}, nil
+				},
+			}
+			handler := NewEnvironmentHandler(envRepo, reservationRepo, nil, statsRepo, nil, nil, nil, config.Config{DashboardCacheTTL: tt.ttl})
+
+			// Different users share what was read; only the filtering is per user
+			for _, user := range []models.User{alice, manager, admin} {
+				var stats models.DashboardStats
+				decodeData(t, serve(handler.GetDashboardStats, request(http.MethodGet, "/api/stats", &user, nil, "")), &stats)
+				if stats.TotalEnvironments != 2 {
+					t.Errorf("%s: totalEnvironments = %d, want 2", user.Username, stats.TotalEnvironments)
+				}
+			}
+			if reads != tt.wantReads {
+				t.Errorf("environments read %d times, want %d", reads, tt.wantReads)
+			}
+		})
+	}
+}
+
+func TestDashboardStatsRequiresAUser(t *testing.T) {
+	handler := NewEnvironmentHandler(nil, nil, nil, nil, nil, nil, nil, config.Config{})
+	expectError(t, serve(handler.GetDashboardStats, request(http.MethodGet, "/api/stats", nil, nil, "")), http.StatusUnauthorized, "UNAUTHORIZED")
+}
@@ -1,30 +1,58 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/devreserve/server/config"
 	"github.com/devreserve/server/db"
 	"github.com/devreserve/server/middleware"
 	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/seed"
+	"github.com/devreserve/server/service"
 	"github.com/devreserve/server/utils"
+	"github.com/devreserve/server/webhook"
 	"github.com/gorilla/mux"
 )
 
 // EnvironmentHandler handles environment-related requests
 type EnvironmentHandler struct {
-	envRepo        *db.EnvironmentRepository
-	reservationRepo *db.ReservationRepository
+	envRepo         db.EnvironmentRepositoryInterface
+	reservationRepo db.ReservationRepositoryInterface
+	auditRepo       db.AuditRepositoryInterface
+	statsRepo       db.StatsRepositoryInterface
+	envService      *service.EnvironmentService
+	webhooks        *webhook.Dispatcher
+	seeder          *seed.Seeder
+	dashboard       *dashboardCache
+	config          config.Config
 }
 
 // NewEnvironmentHandler creates a new EnvironmentHandler
-func NewEnvironmentHandler(envRepo *db.EnvironmentRepository, reservationRepo *db.ReservationRepository) *EnvironmentHandler {
+func NewEnvironmentHandler(envRepo db.EnvironmentRepositoryInterface, reservationRepo db.ReservationRepositoryInterface, auditRepo db.AuditRepositoryInterface,
+	statsRepo db.StatsRepositoryInterface, envService *service.EnvironmentService, webhooks *webhook.Dispatcher, seeder *seed.Seeder, config config.Config) *EnvironmentHandler {
 	return &EnvironmentHandler{
-		envRepo:        envRepo,
+		envRepo:         envRepo,
 		reservationRepo: reservationRepo,
+		auditRepo:       auditRepo,
+		statsRepo:       statsRepo,
+		envService:      envService,
+		webhooks:        webhooks,
+		seeder:          seeder,
+		dashboard:       &dashboardCache{ttl: config.DashboardCacheTTL},
+		config:          config,
 	}
 }
 
-// ListEnvironments handles requests to list all environments
+// ListEnvironments handles requests to list all environments. The optional search parameter
+// is matched against names and descriptions in memory after the full scan, so it doesn't
+// reduce the DynamoDB read cost.
 func (h *EnvironmentHandler) ListEnvironments(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
 	if r.Method != http.MethodGet {
@@ -32,31 +60,184 @@ func (h *EnvironmentHandler) ListEnvironments(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Get all environments
-	environments, err := h.envRepo.ListEnvironments()
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get all environments, including archived ones only if asked to
+	includeArchived := r.URL.Query().Get("includeArchived") == "true"
+	environments, err := h.envRepo.ListEnvironments(includeArchived)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to list environments")
+		respondWithServerError(w, err, "Failed to list environments")
 		return
 	}
 
-	// Get all active reservations
-	activeReservations, err := h.reservationRepo.ListActiveReservations()
+	// Keep only the environments the user can see, of the requested team if any, matching
+	// the search text if any
+	environments = visibleEnvironments(user, environments)
+	if team := strings.TrimSpace(r.URL.Query().Get("team")); team != "" {
+		environments = teamEnvironments(environments, team)
+	}
+	if search := strings.TrimSpace(r.URL.Query().Get("search")); search != "" {
+		environments = searchEnvironments(environments, search)
+	}
+
+	// Attach the current reservations, using the same instant for the whole response
+	now := time.Now()
+	result := h.envService.WithReservations(environments, now)
+	for i := range result {
+		if !canViewSecretDetails(user, result[i].CurrentReservation) {
+			result[i].SecretDetails = nil
+		}
+	}
+
+	// Render a plain-text table for CLI clients that asked for one
+	if utils.WantsTable(r) {
+		rows := make([][]string, len(result))
+		for i, env := range result {
+			reservedBy, feature, remaining := "", "", ""
+			if reservation := env.CurrentReservation; reservation != nil {
+				reservedBy = reservation.Username
+				feature = reservation.Feature
+				remaining = utils.HumanizeRemaining(reservation.EndTime, now)
+			}
+			rows[i] = []string{env.Name, string(env.Status), env.Pool, reservedBy, feature, remaining}
+		}
+		utils.RespondWithTable(w, []string{"NAME", "STATUS", "POOL", "RESERVED BY", "FEATURE", "REMAINING"}, rows)
+		return
+	}
+
+	// Respond with the environments
+	utils.RespondWithSuccess(w, result)
+}
+
+// ListAvailableEnvironments handles requests to list free environments, optionally filtered
+// by the tag and pool query parameters
+func (h *EnvironmentHandler) ListAvailableEnvironments(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the available environments the user can see, of the requested team if any
+	query := r.URL.Query()
+	environments, err := h.envRepo.ListAvailableEnvironments(query.Get("tag"), query.Get("pool"))
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to list reservations")
+		respondWithServerError(w, err, "Failed to list environments")
+		return
+	}
+	environments = visibleEnvironments(user, environments)
+	if team := strings.TrimSpace(query.Get("team")); team != "" {
+		environments = teamEnvironments(environments, team)
+	}
+
+	// Free environments have no reservation holder, so secret details are only shown to admins
+	if !canViewSecretDetails(user, nil) {
+		for i := range environments {
+			environments[i].SecretDetails = nil
+		}
+	}
+
+	// Render a plain-text table for CLI clients that asked for one
+	if utils.WantsTable(r) {
+		rows := make([][]string, len(environments))
+		for i, env := range environments {
+			rows[i] = []string{env.Name, env.Pool, strings.Join(env.Tags, ","), env.Description}
+		}
+		utils.RespondWithTable(w, []string{"NAME", "POOL", "TAGS", "DESCRIPTION"}, rows)
+		return
+	}
+
+	// Respond with the environments
+	utils.RespondWithSuccess(w, environments)
+}
+
+// maxBatchEnvironments is the most environments that can be requested in one batch
+const maxBatchEnvironments = 100
+
+// BatchGetEnvironments handles requests to get several environments by ID
+func (h *EnvironmentHandler) BatchGetEnvironments(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Create a map of environment ID to reservation
-	reservationMap := make(map[string]*models.Reservation)
-	for i := range activeReservations {
-		reservationMap[activeReservations[i].EnvironmentID] = &activeReservations[i]
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
 	}
 
-	// Create environment with reservation response objects
-	result := make([]models.EnvironmentWithReservation, len(environments))
-	for i, env := range environments {
-		result[i].Environment = env
-		result[i].CurrentReservation = reservationMap[env.ID]
+	// Parse the request body
+	var req models.EnvironmentBatchRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if len(req.IDs) == 0 {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "At least one environment ID is required")
+		return
+	}
+	if len(req.IDs) > maxBatchEnvironments {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeBatchTooLarge, fmt.Sprintf("Cannot request more than %d environments at once", maxBatchEnvironments))
+		return
+	}
+
+	// Get the environments, counting other teams' environments as missing
+	environments, missing, err := h.envRepo.BatchGetEnvironments(req.IDs)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environments")
+		return
+	}
+	visible := visibleEnvironments(user, environments)
+	if len(visible) < len(environments) {
+		for i := range environments {
+			if !environments[i].VisibleTo(user) {
+				missing = append(missing, environments[i].ID)
+			}
+		}
+		environments = visible
+	}
+
+	// Attach the current reservations
+	result := models.EnvironmentBatchResponse{
+		Environments: h.envService.WithReservations(environments, time.Now()),
+		Missing:      missing,
+	}
+	for i := range result.Environments {
+		if !canViewSecretDetails(user, result.Environments[i].CurrentReservation) {
+			result.Environments[i].SecretDetails = nil
+		}
 	}
 
 	// Respond with the environments
@@ -86,31 +267,186 @@ func (h *EnvironmentHandler) CreateEnvironment(w http.ResponseWriter, r *http.Re
 	// Parse the request body
 	var req models.EnvironmentCreateRequest
 	if err := utils.ParseJSONBody(r, &req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	// Validate the environment name
-	if req.Name == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Environment name is required")
+	// Validate the environment name and team
+	req.Name = strings.TrimSpace(req.Name)
+	if !h.validateEnvironmentName(w, req.Name, "") {
+		return
+	}
+	req.Team = strings.TrimSpace(req.Team)
+	if err := models.ValidateTeam(req.Team); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidTeam, err.Error())
 		return
 	}
+	if req.AllowedHours != nil {
+		if err := req.AllowedHours.Validate(); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.HealthCheckURL != "" {
+		if err := validateHealthCheckURL(req.HealthCheckURL); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	// Create the environment
 	env := models.Environment{
-		Name:        req.Name,
-		Description: req.Description,
-		Status:      models.StatusFree,
+		Name:          req.Name,
+		Description:   req.Description,
+		Status:        models.StatusFree,
+		Tags:          req.Tags,
+		Region:        req.Region,
+		Type:          req.Type,
+		Pool:          req.Pool,
+		GroupID:       req.GroupID,
+		Team:          req.Team,
+		Details:       req.Details,
+		SecretDetails: req.SecretDetails,
+
+		RequiresApproval: req.RequiresApproval,
+		AllowedHours:     req.AllowedHours,
+		HealthCheckURL:   req.HealthCheckURL,
+
+		MinReservationMins: req.MinReservationMins,
+		MaxReservationMins: req.MaxReservationMins,
+	}
+	if env.HealthCheckURL != "" {
+		env.HealthStatus = models.HealthUnknown
+	}
+	if err := env.ValidateReservationLimits(defaultReservationLimits(h.config)); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeDurationOutOfRange, err.Error())
+		return
 	}
 
 	createdEnv, err := h.envRepo.CreateEnvironment(env, user.Username)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create environment")
+		respondWithServerError(w, err, "Failed to create environment")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionCreateEnvironment,
+		Description: fmt.Sprintf("Created environment %s", createdEnv.Name),
+		ResourceID:  createdEnv.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	h.webhooks.Dispatch(models.EventEnvironmentCreated, createdEnv)
+
+	// Respond with the created environment
+	utils.RespondWithCreated(w, "/api/environments/"+url.PathEscape(createdEnv.ID), createdEnv)
+}
+
+// validateEnvironmentName checks that a trimmed environment name is valid and that no other
+// environment has it, ignoring case, responding and returning false if not. exceptID is the
+// environment being renamed, which may keep its own name.
+func (h *EnvironmentHandler) validateEnvironmentName(w http.ResponseWriter, name, exceptID string) bool {
+	if name == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment name is required")
+		return false
+	}
+	if err := models.ValidateEnvironmentName(name); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidEnvName, err.Error())
+		return false
+	}
+
+	existing, err := h.envRepo.GetEnvironmentByName(name)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		respondWithServerError(w, err, "Failed to check environment name")
+		return false
+	}
+	if existing != nil && existing.ID != exceptID {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEnvNameTaken,
+			fmt.Sprintf("Environment name %q is already used by environment %s", existing.Name, existing.ID))
+		return false
+	}
+	return true
+}
+
+// CloneEnvironment handles requests to create an environment with the same description, tags
+// and configuration as an existing one (admin only). The clone starts out free, with the
+// caller as its creator, and none of the source's reservations.
+func (h *EnvironmentHandler) CloneEnvironment(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the optional request body
+	var req models.EnvironmentCloneRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil && err != io.EOF {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Get the source environment
+	source, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Create the clone
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = source.Name + "-copy"
+	}
+	if !h.validateEnvironmentName(w, name, "") {
+		return
+	}
+	createdEnv, err := h.envRepo.CreateEnvironment(source.Clone(name), user.Username)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to create environment")
 		return
 	}
 
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionCreateEnvironment,
+		Description: fmt.Sprintf("Created environment %s as a clone of %s", createdEnv.Name, source.Name),
+		ResourceID:  createdEnv.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	h.webhooks.Dispatch(models.EventEnvironmentCreated, createdEnv)
+
 	// Respond with the created environment
-	utils.RespondWithSuccess(w, createdEnv)
+	utils.RespondWithCreated(w, "/api/environments/"+url.PathEscape(createdEnv.ID), createdEnv)
 }
 
 // GetEnvironment handles requests to get an environment by ID
@@ -121,38 +457,546 @@ func (h *EnvironmentHandler) GetEnvironment(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Get the environment with the reservation holding it right now
+	result, err := h.envService.GetEnvironment(id, time.Now())
+	// Other teams' environments are reported as not found rather than revealed
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !result.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	if !canViewSecretDetails(user, result.CurrentReservation) {
+		result.SecretDetails = nil
+	}
+	limits := result.Environment.ReservationLimits(defaultReservationLimits(h.config))
+	result.ReservationLimits = &limits
+
+	// Respond with the environment
+	utils.RespondWithSuccess(w, result)
+}
+
+// UpdateEnvironment handles requests to update an environment's name, description and details
+func (h *EnvironmentHandler) UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the request body
+	var req models.EnvironmentUpdateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Get the environment
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Apply the changes
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !h.validateEnvironmentName(w, name, env.ID) {
+			return
+		}
+		env.Name = name
+	}
+	if req.Description != nil {
+		env.Description = *req.Description
+	}
+	if req.Tags != nil {
+		env.Tags = *req.Tags
+	}
+	if req.Region != nil {
+		env.Region = *req.Region
+	}
+	if req.Type != nil {
+		env.Type = *req.Type
+	}
+	if req.Pool != nil {
+		env.Pool = *req.Pool
+	}
+	if req.GroupID != nil {
+		env.GroupID = *req.GroupID
+	}
+	if req.Team != nil {
+		team := strings.TrimSpace(*req.Team)
+		if err := models.ValidateTeam(team); err != nil {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidTeam, err.Error())
+			return
+		}
+		env.Team = team
+	}
+	if req.Details != nil {
+		env.Details = *req.Details
+	}
+	if req.SecretDetails != nil {
+		env.SecretDetails = *req.SecretDetails
+	}
+	if req.RequiresApproval != nil {
+		env.RequiresApproval = *req.RequiresApproval
+	}
+	if req.AllowedHours != nil {
+		if req.AllowedHours.Start == "" && req.AllowedHours.End == "" {
+			env.AllowedHours = nil
+		} else if err := req.AllowedHours.Validate(); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		} else {
+			env.AllowedHours = req.AllowedHours
+		}
+	}
+	if req.HealthCheckURL != nil && *req.HealthCheckURL != env.HealthCheckURL {
+		if *req.HealthCheckURL != "" {
+			if err := validateHealthCheckURL(*req.HealthCheckURL); err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		// Forget the previous URL's results
+		env.HealthCheckURL = *req.HealthCheckURL
+		env.HealthStatus = ""
+		env.LastHealthCheckAt = nil
+		env.LastHealthError = ""
+		if env.HealthCheckURL != "" {
+			env.HealthStatus = models.HealthUnknown
+		}
+	}
+	if req.MinReservationMins != nil {
+		env.MinReservationMins = *req.MinReservationMins
+	}
+	if req.MaxReservationMins != nil {
+		env.MaxReservationMins = *req.MaxReservationMins
+	}
+	if err := env.ValidateReservationLimits(defaultReservationLimits(h.config)); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeDurationOutOfRange, err.Error())
+		return
+	}
+
+	if err := h.envRepo.UpdateEnvironment(*env); err != nil {
+		respondWithServerError(w, err, "Failed to update environment")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionUpdateEnvironment,
+		Description: fmt.Sprintf("Updated environment %s", env.Name),
+		ResourceID:  env.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the updated environment
+	utils.RespondWithSuccess(w, env)
+}
+
+// GetEnvironmentStats handles requests to get an environment's usage counters and a summary
+// of how its reservations ended
+func (h *EnvironmentHandler) GetEnvironmentStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Check that the environment exists and the user can see it
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Get the stats
+	stats, err := h.statsRepo.GetEnvironmentStats(id)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment stats")
+		return
+	}
+
+	// Summarize how the environment's reservations ended
+	reservations, err := h.reservationRepo.ListReservationsByEnvironmentID(id)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation history")
+		return
+	}
+	stats.Releases = models.SummarizeReleases(reservations, time.Now())
+
+	// Respond with the stats
+	utils.RespondWithSuccess(w, stats)
+}
+
+// validateHealthCheckURL checks that a health check URL is an absolute http or https URL
+func validateHealthCheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("health check URL must be an absolute http or https URL, got %q", rawURL)
+	}
+	return nil
+}
+
+// searchEnvironments returns the environments whose name or description contains search, ignoring case
+func searchEnvironments(environments []models.Environment, search string) []models.Environment {
+	search = strings.ToLower(search)
+	matches := make([]models.Environment, 0, len(environments))
+	for _, env := range environments {
+		if strings.Contains(strings.ToLower(env.Name), search) || strings.Contains(strings.ToLower(env.Description), search) {
+			matches = append(matches, env)
+		}
+	}
+	return matches
+}
+
+// visibleEnvironments returns the environments the user can see, those of their team and
+// shared ones unless their role can see every team's; see Environment.VisibleTo
+func visibleEnvironments(user models.User, environments []models.Environment) []models.Environment {
+	visible := make([]models.Environment, 0, len(environments))
+	for i := range environments {
+		if environments[i].VisibleTo(user) {
+			visible = append(visible, environments[i])
+		}
+	}
+	return visible
+}
+
+// visibleReservations returns the reservations of environments the user can see, dropping
+// those of other teams' environments and of deleted ones. The environments are only looked
+// up if the user's role can't see every team's anyway.
+func visibleReservations(envRepo db.EnvironmentRepositoryInterface, user models.User, reservations []models.Reservation) ([]models.Reservation, error) {
+	if len(reservations) == 0 || user.Role.HasPermission(models.PermissionViewAllTeams) {
+		return reservations, nil
+	}
+
+	// Look up each environment once
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, reservation := range reservations {
+		if !seen[reservation.EnvironmentID] {
+			seen[reservation.EnvironmentID] = true
+			ids = append(ids, reservation.EnvironmentID)
+		}
+	}
+	environments, _, err := envRepo.BatchGetEnvironments(ids)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(environments))
+	for i := range environments {
+		if environments[i].VisibleTo(user) {
+			visible[environments[i].ID] = true
+		}
+	}
+
+	result := make([]models.Reservation, 0, len(reservations))
+	for _, reservation := range reservations {
+		if visible[reservation.EnvironmentID] {
+			result = append(result, reservation)
+		}
+	}
+	return result, nil
+}
+
+// teamEnvironments returns the environments belonging to a team, ignoring case
+func teamEnvironments(environments []models.Environment, team string) []models.Environment {
+	matching := make([]models.Environment, 0, len(environments))
+	for _, env := range environments {
+		if strings.EqualFold(env.Team, team) {
+			matching = append(matching, env)
+		}
+	}
+	return matching
+}
+
+// canViewSecretDetails reports whether a user may see an environment's secret details:
+// users who manage environments always can, others only while they hold the active reservation
+func canViewSecretDetails(user models.User, activeReservation *models.ReservationResponse) bool {
+	if user.Role.HasPermission(models.PermissionManageEnvironments) {
+		return true
+	}
+	return activeReservation != nil && activeReservation.Username == user.Username
+}
+
+// ArchiveEnvironment handles requests to archive an environment (admin only)
+func (h *EnvironmentHandler) ArchiveEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true)
+}
+
+// UnarchiveEnvironment handles requests to unarchive an environment (admin only)
+func (h *EnvironmentHandler) UnarchiveEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false)
+}
+
+// setArchived archives or unarchives the environment identified in the request
+func (h *EnvironmentHandler) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
 	// Get the environment ID from the URL parameters
 	vars := mux.Vars(r)
 	id := vars["id"]
 	if id == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Environment ID is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
 		return
 	}
 
 	// Get the environment
 	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get environment")
+		respondWithServerError(w, err, "Failed to get environment")
 		return
 	}
-	if env == nil {
-		utils.RespondWithError(w, http.StatusNotFound, "Environment not found")
+
+	action := models.AuditActionUnarchiveEnvironment
+	if archived {
+		// Refuse to archive an environment somebody is using
+		reservation, err := h.reservationRepo.GetActiveReservationByEnvironmentID(id, time.Now())
+		if err != nil {
+			respondWithServerError(w, err, "Failed to get reservation")
+			return
+		}
+		if reservation != nil || env.Status != models.StatusFree {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvHasActiveReservation, "Environment has an active reservation")
+			return
+		}
+
+		if err := h.envRepo.ArchiveEnvironment(id, user.Username); err != nil {
+			respondWithServerError(w, err, "Failed to archive environment")
+			return
+		}
+		now := time.Now()
+		env.Archived = true
+		env.ArchivedAt = &now
+		env.ArchivedBy = user.Username
+		action = models.AuditActionArchiveEnvironment
+	} else {
+		if err := h.envRepo.UnarchiveEnvironment(id); err != nil {
+			respondWithServerError(w, err, "Failed to unarchive environment")
+			return
+		}
+		env.Archived = false
+		env.ArchivedAt = nil
+		env.ArchivedBy = ""
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      action,
+		Description: fmt.Sprintf("Set archived to %t on environment %s", archived, env.Name),
+		ResourceID:  env.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+	h.webhooks.Dispatch(models.EventEnvironmentMaintenance, env)
+
+	// Respond with the environment
+	utils.RespondWithSuccess(w, env)
+}
+
+// LockEnvironment handles requests to lock a free environment so it can't be reserved,
+// with an optional lockedReason (admin only)
+func (h *EnvironmentHandler) LockEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setLocked(w, r, true)
+}
+
+// UnlockEnvironment handles requests to unlock a locked environment, freeing it (admin only)
+func (h *EnvironmentHandler) UnlockEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setLocked(w, r, false)
+}
+
+// setLocked locks or unlocks an environment, recording the change in the audit log
+func (h *EnvironmentHandler) setLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Get the active reservation for the environment
-	reservation, err := h.reservationRepo.GetActiveReservationByEnvironmentID(id)
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the optional request body
+	var req models.EnvironmentLockRequest
+	if locked {
+		if err := utils.ParseJSONBody(r, &req); err != nil && err != io.EOF {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+			return
+		}
+	}
+
+	// Get the environment
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get reservation")
+		respondWithServerError(w, err, "Failed to get environment")
 		return
 	}
 
-	// Create the response
-	result := models.EnvironmentWithReservation{
-		Environment:        *env,
-		CurrentReservation: reservation,
+	action := models.AuditActionUnlockEnvironment
+	description := fmt.Sprintf("Unlocked environment %s", env.Name)
+	if locked {
+		// Only free environments can be locked, so nobody is forced off
+		err := h.envRepo.LockEnvironment(id, user.Username, req.LockedReason)
+		if errors.Is(err, db.ErrEnvironmentUnavailable) {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnavailable, "Only free environments can be locked")
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, err, "Failed to lock environment")
+			return
+		}
+		now := time.Now()
+		env.Status = models.StatusLocked
+		env.LockedReason = req.LockedReason
+		env.LockedBy = user.Username
+		env.LockedAt = &now
+		action = models.AuditActionLockEnvironment
+		description = fmt.Sprintf("Locked environment %s", env.Name)
+		if req.LockedReason != "" {
+			description += ": " + req.LockedReason
+		}
+	} else {
+		err := h.envRepo.UnlockEnvironment(id)
+		if errors.Is(err, db.ErrNotLocked) {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvNotLocked, "Environment is not locked")
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, err, "Failed to unlock environment")
+			return
+		}
+		env.Status = models.StatusFree
+		env.LockedReason = ""
+		env.LockedBy = ""
+		env.LockedAt = nil
 	}
 
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      action,
+		Description: description,
+		ResourceID:  env.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+	h.webhooks.Dispatch(models.EventEnvironmentMaintenance, env)
+
 	// Respond with the environment
-	utils.RespondWithSuccess(w, result)
+	utils.RespondWithSuccess(w, env)
 }
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// environmentCSVColumns are the columns used for environment CSV import and export
+var environmentCSVColumns = []string{"name", "description", "tags", "url", "region", "type"}
+
+// reservationCSVColumns are the columns used for reservation history CSV exports
+var reservationCSVColumns = []string{"id", "username", "startTime", "endTime", "feature", "gitBranch", "jiraUrl", "releaseType", "releasedBy", "releasedFromIp", "releasedUserAgent"}
+
+// maxImportSize is the maximum size of an uploaded CSV file
+const maxImportSize = 10 << 20 // 10 MB
+
+// ImportEnvironments handles requests to create environments in bulk from an uploaded CSV file (admin only).
+// Rows that fail validation are reported in the response without aborting the rest of the import.
+// A JSON or YAML body is applied as a seed file instead.
+func (h *EnvironmentHandler) ImportEnvironments(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// JSON and YAML bodies are seed files; anything else is a CSV upload
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); seedMediaTypes[mediaType] {
+		h.importSeedFile(w, r, user)
+		return
+	}
+
+	// Get the uploaded file
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid multipart form")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "CSV file is required in the 'file' field")
+		return
+	}
+	defer file.Close()
+
+	// Read and check the header row
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to read CSV header")
+		return
+	}
+	columns, err := csvColumnIndexes(header, environmentCSVColumns)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get the names in use, so rows can't duplicate them or each other
+	existing, err := h.envRepo.ListEnvironments(true)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list environments")
+		return
+	}
+	names := make(map[string]bool, len(existing))
+	for _, env := range existing {
+		names[strings.ToLower(env.Name)] = true
+	}
+
+	// Validate each row, collecting failures instead of aborting
+	result := models.EnvironmentImportResult{
+		Created: []models.Environment{},
+		Failed:  []models.EnvironmentImportError{},
+	}
+	var envs []models.Environment
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, models.EnvironmentImportError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		env, err := environmentFromCSV(record, columns)
+		if err != nil {
+			result.Failed = append(result.Failed, models.EnvironmentImportError{Row: row, Error: err.Error()})
+			continue
+		}
+		if names[strings.ToLower(env.Name)] {
+			result.Failed = append(result.Failed, models.EnvironmentImportError{Row: row, Error: fmt.Sprintf("environment name %q is already taken", env.Name)})
+			continue
+		}
+		names[strings.ToLower(env.Name)] = true
+		envs = append(envs, env)
+	}
+
+	// Create the valid environments
+	if len(envs) > 0 {
+		created, err := h.envRepo.BatchCreateEnvironments(envs, user.Username)
+		result.Created = append(result.Created, created...)
+		if err != nil {
+			log.Printf("Error importing environments: %v", err)
+			respondWithServerError(w, err,
+				fmt.Sprintf("Failed to create environments (%d of %d created)", len(created), len(envs)))
+			return
+		}
+	}
+
+	for _, env := range result.Created {
+		h.webhooks.Dispatch(models.EventEnvironmentCreated, env)
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionCreateEnvironment,
+		Description: fmt.Sprintf("Imported %d environments from CSV", len(result.Created)),
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the import result
+	utils.RespondWithSuccess(w, result)
+}
+
+// ExportEnvironments handles requests to download the environments the user can see as a
+// CSV file (admin only)
+func (h *EnvironmentHandler) ExportEnvironments(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get all environments the user can see
+	environments, err := h.envRepo.ListEnvironments(true)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list environments")
+		return
+	}
+	environments = visibleEnvironments(user, environments)
+
+	// Write the CSV
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=environments.csv")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(environmentCSVColumns)
+	for _, env := range environments {
+		writer.Write([]string{
+			env.Name,
+			env.Description,
+			strings.Join(env.Tags, ";"),
+			env.Details["url"],
+			env.Region,
+			env.Type,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing environments CSV: %v", err)
+	}
+}
+
+// csvColumnIndexes maps each expected column to its index in the header row
+func csvColumnIndexes(header, expected []string) (map[string]int, error) {
+	indexes := make(map[string]int, len(header))
+	for i, name := range header {
+		indexes[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range expected {
+		if _, ok := indexes[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", name)
+		}
+	}
+	return indexes, nil
+}
+
+// csvValue gets the trimmed value of a column from a CSV record
+func csvValue(record []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// environmentFromCSV builds and validates an environment from a CSV record
+func environmentFromCSV(record []string, columns map[string]int) (models.Environment, error) {
+	env := models.Environment{
+		Name:        csvValue(record, columns, "name"),
+		Description: csvValue(record, columns, "description"),
+		Region:      csvValue(record, columns, "region"),
+		Type:        csvValue(record, columns, "type"),
+	}
+	if err := models.ValidateEnvironmentName(env.Name); err != nil {
+		return env, err
+	}
+
+	// Tags are separated by semicolons
+	for _, tag := range strings.Split(csvValue(record, columns, "tags"), ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			env.Tags = append(env.Tags, tag)
+		}
+	}
+
+	if url := csvValue(record, columns, "url"); url != "" {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return env, fmt.Errorf("invalid url %q", url)
+		}
+		env.Details = map[string]string{"url": url}
+	}
+
+	return env, nil
+}
+
+// GetReservationHistory handles requests to get every reservation of an environment,
+// newest first (admin only), optionally only those that ended with the releaseType query
+// parameter's release type. With an Accept: text/csv header it is downloaded as CSV.
+func (h *EnvironmentHandler) GetReservationHistory(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the optional release type filter
+	releaseType := models.ReleaseType(r.URL.Query().Get("releaseType"))
+	if releaseType != "" && !releaseType.IsValid() {
+		utils.RespondWithError(w, http.StatusBadRequest, invalidReleaseTypeMessage)
+		return
+	}
+
+	// Check that the environment exists and the user can see it
+	if env, err := h.envRepo.GetEnvironment(id); errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	} else if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Get the reservations
+	reservations, err := h.reservationRepo.ListReservationsByEnvironmentID(id)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation history")
+		return
+	}
+
+	// Fill in the computed fields, including inferred release types, and filter on them
+	now := time.Now()
+	models.SetComputedTimes(reservations, now)
+	if releaseType != "" {
+		filtered := []models.Reservation{}
+		for _, reservation := range reservations {
+			if reservation.ReleaseType == releaseType {
+				filtered = append(filtered, reservation)
+			}
+		}
+		reservations = filtered
+	}
+
+	// Respond with JSON unless CSV was asked for
+	if !strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		utils.RespondWithSuccess(w, models.ReservationResponses(reservations, now))
+		return
+	}
+
+	// Write the CSV
+	filename := fmt.Sprintf("reservations-%s-%s.csv", id, now.UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(reservationCSVColumns)
+	for _, reservation := range reservations {
+		writer.Write([]string{
+			reservation.ID,
+			reservation.Username,
+			reservation.StartTime.UTC().Format(time.RFC3339),
+			reservation.EndTime.UTC().Format(time.RFC3339),
+			reservation.Feature,
+			reservation.GitBranch,
+			reservation.JiraURL,
+			string(reservation.ReleaseType),
+			reservation.ReleasedBy,
+			reservation.ReleasedFromIP,
+			reservation.ReleasedUserAgent,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing reservation history CSV: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/service"
+)
+
+// detailedEnv is the shared environment with public and secret connection details
+func detailedEnv() models.Environment {
+	env := sharedEnv
+	env.Details = map[string]string{"url": "https://shared-1.internal"}
+	env.SecretDetails = map[string]string{"dbPassword": "hunter2"}
+	return env
+}
+
+// heldBy returns a reservation repository in which username holds every environment, or
+// nobody does if username is empty
+func heldBy(username string) *mock.MockReservationRepository {
+	return &mock.MockReservationRepository{
+		GetActiveReservationByEnvironmentIDFunc: func(id string, now time.Time) (*models.Reservation, error) {
+			if username == "" {
+				return nil, nil
+			}
+			reservation := reservationOf("res-1", models.Environment{ID: id}, username)
+			return &reservation, nil
+		},
+	}
+}
+
+func TestSecretDetailsVisibility(t *testing.T) {
+	env := detailedEnv()
+	tests := []struct {
+		name       string
+		user       models.User
+		holder     string
+		wantSecret bool
+	}{
+		{"reservation holder", alice, "alice", true},
+		{"another user", bob, "alice", false},
+		{"no reservation", alice, "", false},
+		{"admin", admin, "alice", true},
+		{"admin without a reservation", admin, "", true},
+		{"manager", manager, "alice", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo, reservationRepo := newEnvRepo(env), heldBy(tt.holder)
+			handler := NewEnvironmentHandler(envRepo, reservationRepo, nil, nil, service.NewEnvironmentService(envRepo, reservationRepo, 1),
+				nil, nil, config.Config{MinReservationMins: 1, MaxReservationMins: 480})
+
+			check := func(t *testing.T, got models.EnvironmentWithReservation) {
+				t.Helper()
+				if !reflect.DeepEqual(got.Details, env.Details) {
+					t.Errorf("details = %v, want %v", got.Details, env.Details)
+				}
+				if gotSecret := got.SecretDetails != nil; gotSecret != tt.wantSecret {
+					t.Errorf("secretDetails = %v, want shown %v", got.SecretDetails, tt.wantSecret)
+				} else if gotSecret && !reflect.DeepEqual(got.SecretDetails, env.SecretDetails) {
+					t.Errorf("secretDetails = %v, want %v", got.SecretDetails, env.SecretDetails)
+				}
+			}
+
+			t.Run("get", func(t *testing.T) {
+				var got models.EnvironmentWithReservation
+				decodeData(t, serve(handler.GetEnvironment,
+					request(http.MethodGet, "/api/environments/env-shared", &tt.user, map[string]string{"id": env.ID}, "")), &got)
+				check(t, got)
+			})
+			t.Run("list", func(t *testing.T) {
+				var got []models.EnvironmentWithReservation
+				decodeData(t, serve(handler.ListEnvironments, request(http.MethodGet, "/api/environments", &tt.user, nil, "")), &got)
+				if len(got) != 1 {
+					t.Fatalf("listed %d environments, want 1", len(got))
+				}
+				check(t, got[0])
+			})
+		})
+	}
+}
+
+func TestAvailableEnvironmentsShowSecretDetailsToEnvironmentManagers(t *testing.T) {
+	env := detailedEnv()
+	envRepo := newEnvRepo(env)
+	envRepo.ListAvailableEnvironmentsFunc = func(tag, pool string) ([]models.Environment, error) {
+		return []models.Environment{env}, nil
+	}
+	handler := NewEnvironmentHandler(envRepo, nil, nil, nil, nil, nil, nil, config.Config{MinReservationMins: 1, MaxReservationMins: 480})
+
+	tests := []struct {
+		name       string
+		user       models.User
+		wantSecret bool
+	}{
+		{"user", alice, false},
+		{"manager", manager, true},
+		{"admin", admin, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []models.Environment
+			decodeData(t, serve(handler.ListAvailableEnvironments, request(http.MethodGet, "/api/environments/available", &tt.user, nil, "")), &got)
+			if len(got) != 1 {
+				t.Fatalf("listed %d environments, want 1", len(got))
+			}
+			if gotSecret := got[0].SecretDetails != nil; gotSecret != tt.wantSecret {
+				t.Errorf("secretDetails = %v, want shown %v", got[0].SecretDetails, tt.wantSecret)
+			}
+			if !reflect.DeepEqual(got[0].Details, env.Details) {
+				t.Errorf("details = %v, want %v", got[0].Details, env.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// HoldEnvironment handles requests to hold a free environment for HOLD_TTL while the user
+// decides whether to reserve it. Nobody else can reserve a held environment; the holder
+// confirms by reserving it as usual, and holding it again extends the hold. Holds that
+// aren't confirmed are freed by the expiry sweep.
+func (h *ReservationHandler) HoldEnvironment(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Get the environment, using a consistent read to see the latest status
+	env, err := h.envRepo.GetEnvironmentConsistent(id)
+	// Other teams' environments are reported as not found rather than revealed
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	if env.Archived {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvArchived, "Environment is archived")
+		return
+	}
+	// Holding an environment that couldn't then be reserved would only keep others waiting
+	if h.config.BlockUnhealthyReservations && env.HealthStatus == models.HealthUnhealthy {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnhealthy, fmt.Sprintf("Environment is unhealthy: %s", env.LastHealthError))
+		return
+	}
+	now := time.Now()
+	if !env.IsReservableBy(user.Username, now) {
+		switch env.Status {
+		case models.StatusHeld:
+			h.respondHeld(w, env)
+		case models.StatusLocked:
+			utils.RespondWithErrorCode(w, http.StatusLocked, utils.ErrCodeEnvLocked, "Environment is locked")
+		default:
+			h.respondAlreadyReserved(w, env)
+		}
+		return
+	}
+
+	// Hold the environment; it may have been taken since it was read
+	heldUntil := now.Add(h.config.HoldTTL).UTC().Truncate(time.Second)
+	err = h.envRepo.HoldEnvironment(env.ID, user.Username, heldUntil)
+	if errors.Is(err, db.ErrEnvironmentUnavailable) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnavailable, "Environment was reserved or held by someone else in the meantime")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to hold environment")
+		return
+	}
+	h.realtime.EnvironmentChanged(env.ID)
+
+	// Respond with the hold
+	utils.RespondWithSuccess(w, models.EnvironmentHold{
+		EnvironmentID: env.ID,
+		HeldBy:        user.Username,
+		HeldUntil:     heldUntil,
+	})
+}
+
+// ReleaseHold handles requests to give up a hold on an environment before it runs out,
+// freeing the environment for the next user waiting for it
+func (h *ReservationHandler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Release the hold
+	err := h.envRepo.ReleaseHold(id, user.Username)
+	if errors.Is(err, db.ErrNotHeld) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvNotHeld, "You aren't holding this environment")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to release hold")
+		return
+	}
+	h.promoteQueued(id)
+	h.realtime.EnvironmentChanged(id)
+
+	utils.RespondWithNoContent(w)
+}
+
+// respondHeld rejects a request for an environment someone else is holding, with a
+// Retry-After header for the time left on the hold
+func (h *ReservationHandler) respondHeld(w http.ResponseWriter, env *models.Environment) {
+	message := "Environment is held"
+	if !h.config.HideReservationHolder {
+		message += " by " + env.HeldBy
+	}
+	if env.HeldUntil != nil {
+		message += fmt.Sprintf(" until %s", env.HeldUntil.UTC().Format(time.RFC3339))
+		retryAfter := int(math.Ceil(time.Until(*env.HeldUntil).Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvHeld, message)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+)
+
+func TestHoldEnvironment(t *testing.T) {
+	heldUntil := time.Now().Add(5 * time.Minute)
+	held := models.Environment{ID: "env-held", Team: "payments", Status: models.StatusHeld, HeldBy: "bob", HeldUntil: &heldUntil}
+	locked := models.Environment{ID: "env-locked", Team: "payments", Status: models.StatusLocked}
+	reserved := models.Environment{ID: "env-reserved", Team: "payments", Status: models.StatusReserved}
+	archived := models.Environment{ID: "env-archived", Team: "payments", Status: models.StatusFree, Archived: true}
+	unhealthy := models.Environment{ID: "env-unhealthy", Team: "payments", Status: models.StatusFree, HealthStatus: models.HealthUnhealthy}
+	envRepo := newEnvRepo(paymentsEnv, searchEnv, held, locked, reserved, archived, unhealthy)
+
+	tests := []struct {
+		name      string
+		id        string
+		holdErr   error
+		status    int
+		wantCode  string
+		wantRetry bool
+	}{
+		{"free environment", paymentsEnv.ID, nil, http.StatusOK, "", false},
+		{"taken in the meantime", paymentsEnv.ID, db.ErrEnvironmentUnavailable, http.StatusConflict, "ENV_UNAVAILABLE", false},
+		{"held by someone else", held.ID, nil, http.StatusConflict, "ENV_HELD", true},
+		{"locked", locked.ID, nil, http.StatusLocked, "ENV_LOCKED", false},
+		{"reserved", reserved.ID, nil, http.StatusConflict, "ENV_ALREADY_RESERVED", false},
+		{"archived", archived.ID, nil, http.StatusConflict, "ENV_ARCHIVED", false},
+		{"unhealthy", unhealthy.ID, nil, http.StatusConflict, "ENV_UNHEALTHY", false},
+		{"other team's", searchEnv.ID, nil, http.StatusNotFound, "ENV_NOT_FOUND", false},
+		{"unknown", "env-gone", nil, http.StatusNotFound, "ENV_NOT_FOUND", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo.HoldEnvironmentFunc = func(id, username string, until time.Time) error {
+				return tt.holdErr
+			}
+			handler := newReservationHandler(envRepo, newReservationRepo(), newAuditLog())
+			handler.config.HoldTTL = 5 * time.Minute
+			handler.config.BlockUnhealthyReservations = true
+
+			rec := serve(handler.HoldEnvironment, request(http.MethodPost, "/api/environments/"+tt.id+"/hold", &alice,
+				map[string]string{"id": tt.id}, ""))
+			if tt.wantCode == "" {
+				var hold models.EnvironmentHold
+				decodeData(t, rec, &hold)
+				if hold.EnvironmentID != tt.id || hold.HeldBy != alice.Username {
+					t.Errorf("hold = %+v, want %s held by alice", hold, tt.id)
+				}
+				return
+			}
+			expectError(t, rec, tt.status, tt.wantCode)
+			if got := rec.Header().Get("Retry-After") != ""; got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want one %v", rec.Header().Get("Retry-After"), tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestReleaseHold(t *testing.T) {
+	tests := []struct {
+		name       string
+		releaseErr error
+		status     int
+		wantCode   string
+	}{
+		{"own hold", nil, http.StatusNoContent, ""},
+		{"not holding it", db.ErrNotHeld, http.StatusConflict, "ENV_NOT_HELD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo := newEnvRepo(paymentsEnv)
+			envRepo.ReleaseHoldFunc = func(id, username string) error {
+				return tt.releaseErr
+			}
+			handler := newReservationHandler(envRepo, newReservationRepo(), newAuditLog())
+
+			rec := serve(handler.ReleaseHold, request(http.MethodDelete, "/api/environments/env-pay/hold", &alice,
+				map[string]string{"id": paymentsEnv.ID}, ""))
+			if tt.wantCode != "" {
+				expectError(t, rec, tt.status, tt.wantCode)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/notifier"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// NotifyEnvironmentUsers handles requests to send a message to the holders of an
+// environment's active reservations, such as a warning before emergency maintenance (admin
+// only). Each holder is notified once, by email; pending reservations are left out. The
+// response lists who was notified and who couldn't be.
+func (h *ReservationHandler) NotifyEnvironmentUsers(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the request body
+	var req models.EnvironmentNotifyRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Message is required")
+		return
+	}
+	if n := len([]rune(message)); n > models.MaxEnvironmentMessageLength {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeFieldTooLong,
+			fmt.Sprintf("message must be at most %d characters, got %d", models.MaxEnvironmentMessageLength, n))
+		return
+	}
+
+	// Get the environment
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Find the environment's active reservations
+	now := time.Now()
+	reservations, err := h.reservationRepo.ListReservationsByEnvironmentID(env.ID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Notify each holder once
+	result := models.EnvironmentNotifyResult{
+		Notified: []string{},
+		Failed:   []models.EnvironmentNotifyFailure{},
+	}
+	seen := make(map[string]bool)
+	for i := range reservations {
+		reservation := &reservations[i]
+		if !reservation.IsActiveAt(now) || reservation.IsPending() || seen[reservation.Username] {
+			continue
+		}
+		seen[reservation.Username] = true
+
+		if err := notifier.NotifyEnvironmentMessage(h.notifier, reservation, env.Name, user.Username, message); err != nil {
+			log.Printf("Error sending %s's message about environment %s to %s: %v", user.Username, env.Name, reservation.Username, err)
+			result.Failed = append(result.Failed, models.EnvironmentNotifyFailure{Username: reservation.Username, Error: err.Error()})
+			continue
+		}
+		log.Printf("Sent %s's message about environment %s to %s", user.Username, env.Name, reservation.Username)
+		result.Notified = append(result.Notified, reservation.Username)
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:  user.Username,
+		Action: models.AuditActionNotifyEnvironmentUsers,
+		Description: fmt.Sprintf("Sent a message about environment %s to %d users (%d failed): %s",
+			env.Name, len(result.Notified), len(result.Failed), message),
+		ResourceID: env.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with who was notified
+	utils.RespondWithSuccess(w, result)
+}
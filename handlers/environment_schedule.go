@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// GetEnvironmentSchedule handles requests for an environment's schedule: its current
+// reservation and the scheduled ones starting in the next seven days, sorted by start
+// time, with any overlapping reservations flagged as conflicts
+func (h *EnvironmentHandler) GetEnvironmentSchedule(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Check that the environment exists and the user can see it
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Get the current and scheduled reservations
+	now := time.Now()
+	reservations, err := h.reservationRepo.ListScheduledReservationsByEnvironmentID(id, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get scheduled reservations")
+		return
+	}
+	current, err := h.reservationRepo.GetActiveReservationByEnvironmentID(id, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get current reservation")
+		return
+	}
+	if current != nil {
+		reservations = append(reservations, *current)
+	}
+
+	// Respond with the schedule
+	utils.RespondWithSuccess(w, models.NewEnvironmentSchedule(id, reservations, now))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/seed"
+	"github.com/devreserve/server/utils"
+)
+
+// seedMediaTypes are the content types of environment imports sent as seed files
+var seedMediaTypes = map[string]bool{
+	"application/json":   true,
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
+// importSeedFile applies a seed file sent as the request body, creating the environments that
+// don't exist yet and, with ?mode=sync, updating the others. The mode defaults to SEED_MODE.
+func (h *EnvironmentHandler) importSeedFile(w http.ResponseWriter, r *http.Request, user models.User) {
+	// Parse the mode
+	modeParam := r.URL.Query().Get("mode")
+	if modeParam == "" {
+		modeParam = h.config.SeedMode
+	}
+	mode, err := seed.ParseMode(modeParam)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Read and validate the file
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Failed to read the seed file")
+		return
+	}
+	seeds, err := seed.Parse(data, defaultReservationLimits(h.config))
+	var fileErr *seed.FileError
+	if errors.As(err, &fileErr) {
+		utils.RespondWithJSON(w, http.StatusBadRequest, utils.Response{
+			Success:   false,
+			Data:      fileErr.Problems,
+			Error:     fileErr.Error(),
+			ErrorCode: utils.ErrCodeInvalidSeedFile,
+		})
+		return
+	}
+	if err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidSeedFile, err.Error())
+		return
+	}
+
+	// Apply it
+	result, created, err := h.seeder.Apply(seeds, mode, user.Username)
+	for _, env := range created {
+		h.webhooks.Dispatch(models.EventEnvironmentCreated, env)
+	}
+	if errors.Is(err, seed.ErrInProgress) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeConflict, "Another environment import is in progress, try again shortly")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err,
+			fmt.Sprintf("Failed to seed environments (%d created, %d updated before the failure)", result.Created, result.Updated))
+		return
+	}
+
+	// Record the action in the audit log
+	if result.Created > 0 || result.Updated > 0 {
+		if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+			Actor:       user.Username,
+			Action:      models.AuditActionCreateEnvironment,
+			Description: fmt.Sprintf("Seeded environments in %s mode: %d created, %d updated, %d skipped", mode, result.Created, result.Updated, result.Skipped),
+		}); err != nil {
+			log.Printf("Error recording audit log entry: %v", err)
+		}
+	}
+
+	// Respond with the counts
+	utils.RespondWithSuccess(w, result)
+}
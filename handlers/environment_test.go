@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/webhook"
+)
+
+// newEnvironmentHandler returns an EnvironmentHandler over envRepo that records its audit log
+// entries in audit. Webhooks are queued but never delivered.
+func newEnvironmentHandler(envRepo *mock.MockEnvironmentRepository, audit db.AuditRepositoryInterface) *EnvironmentHandler {
+	return NewEnvironmentHandler(envRepo, &mock.MockReservationRepository{}, audit, &mock.MockStatsRepository{}, nil,
+		webhook.NewDispatcher(nil, 0), nil, config.Config{MinReservationMins: 1, MaxReservationMins: 480})
+}
+
+// withNames makes envRepo look environments up by name, ignoring case, among envs
+func withNames(envRepo *mock.MockEnvironmentRepository, envs ...models.Environment) *mock.MockEnvironmentRepository {
+	envRepo.GetEnvironmentByNameFunc = func(name string) (*models.Environment, error) {
+		for _, env := range envs {
+			if strings.EqualFold(env.Name, name) {
+				return &env, nil
+			}
+		}
+		return nil, db.ErrNotFound
+	}
+	return envRepo
+}
+
+func TestCreateEnvironment(t *testing.T) {
+	envRepo := withNames(newEnvRepo(paymentsEnv), paymentsEnv)
+	var createdBy string
+	envRepo.CreateEnvironmentFunc = func(env models.Environment, username string) (*models.Environment, error) {
+		createdBy = username
+		env.ID = "env-new"
+		return &env, nil
+	}
+	audit := newAuditLog()
+	handler := newEnvironmentHandler(envRepo, audit)
+
+	rec := serve(handler.CreateEnvironment, request(http.MethodPost, "/api/environments", &admin, nil,
+		`{"name": " payments-2 ", "team": "payments"}`))
+	resp := decode(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "/api/environments/env-new" {
+		t.Errorf("Location = %q, want /api/environments/env-new", got)
+	}
+	var env models.Environment
+	decodeRaw(t, resp.Data, &env)
+	if env.Name != "payments-2" || env.Team != "payments" || env.Status != models.StatusFree {
+		t.Errorf("created %+v, want free environment payments-2 of team payments", env)
+	}
+	if createdBy != admin.Username {
+		t.Errorf("created by %q, want %q", createdBy, admin.Username)
+	}
+	if got, want := audit.recorded(), []models.AuditAction{models.AuditActionCreateEnvironment}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %v, want %v", got, want)
+	}
+}
+
+func TestCreateEnvironmentRejectsInvalidRequests(t *testing.T) {
+	// None of these get as far as creating an environment
+	handler := newEnvironmentHandler(withNames(newEnvRepo(paymentsEnv), paymentsEnv), newAuditLog())
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"no user", nil, `{"name": "payments-2"}`, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"malformed body", &admin, `{"name"`, http.StatusBadRequest, "INVALID_BODY"},
+		{"no name", &admin, `{"name": "  "}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"invalid name", &admin, `{"name": "payments/2"}`, http.StatusBadRequest, "INVALID_ENV_NAME"},
+		{"name taken", &admin, `{"name": "PAYMENTS-1"}`, http.StatusBadRequest, "ENV_NAME_TAKEN"},
+		{"minimum over the maximum", &admin, `{"name": "payments-2", "minReservationMins": 120, "maxReservationMins": 60}`, http.StatusBadRequest, "DURATION_OUT_OF_RANGE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.CreateEnvironment, request(http.MethodPost, "/api/environments", tt.user, nil, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
+
+func TestLockEnvironment(t *testing.T) {
+	reserved := paymentsEnv
+	reserved.ID = "env-pay-reserved"
+	reserved.Status = models.StatusReserved
+
+	tests := []struct {
+		name     string
+		id       string
+		status   int
+		wantCode string
+	}{
+		{"free environment", paymentsEnv.ID, http.StatusOK, ""},
+		{"reserved environment", reserved.ID, http.StatusConflict, "ENV_UNAVAILABLE"},
+		{"unknown environment", "env-gone", http.StatusNotFound, "ENV_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo := newEnvRepo(paymentsEnv, reserved)
+			envRepo.LockEnvironmentFunc = func(id, username, reason string) error {
+				if id == reserved.ID {
+					return db.ErrEnvironmentUnavailable
+				}
+				return nil
+			}
+			audit := newAuditLog()
+			handler := newEnvironmentHandler(envRepo, audit)
+
+			rec := serve(handler.LockEnvironment, request(http.MethodPost, "/api/admin/environments/"+tt.id+"/lock", &admin,
+				map[string]string{"id": tt.id}, `{"lockedReason": "upgrading"}`))
+			if tt.wantCode != "" {
+				expectError(t, rec, tt.status, tt.wantCode)
+				if len(audit.recorded()) != 0 {
+					t.Errorf("audit log = %v, want nothing recorded", audit.recorded())
+				}
+				return
+			}
+
+			var env models.Environment
+			decodeData(t, rec, &env)
+			if env.Status != models.StatusLocked || env.LockedBy != admin.Username || env.LockedReason != "upgrading" {
+				t.Errorf("environment = %+v, want locked by %s for upgrading", env, admin.Username)
+			}
+			if got, want := audit.recorded(), []models.AuditAction{models.AuditActionLockEnvironment}; !reflect.DeepEqual(got, want) {
+				t.Errorf("audit log = %v, want %v", got, want)
+			}
+		})
+	}
+}
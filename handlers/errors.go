@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/utils"
+)
+
+// throttledRetryAfter is the Retry-After, in seconds, sent while DynamoDB is throttling requests
+const throttledRetryAfter = "1"
+
+// respondWithServerError responds with 503 and a Retry-After header if err is DynamoDB
+// throttling that outlasted the client's retries, and with a 500 and message otherwise
+func respondWithServerError(w http.ResponseWriter, err error, message string) {
+	if db.IsThrottled(err) {
+		w.Header().Set("Retry-After", throttledRetryAfter)
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "The database is busy, please retry shortly")
+		return
+	}
+	utils.RespondWithError(w, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestRespondWithServerError(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException", Message: "slow down"}
+	tests := []struct {
+		name      string
+		err       error
+		status    int
+		wantCode  string
+		wantRetry string
+	}{
+		{"unclassified", errors.New("connection reset"), http.StatusInternalServerError, "INTERNAL", ""},
+		{"throttled", throttled, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", throttledRetryAfter},
+		{"wrapped throttling", fmt.Errorf("failed to get environment: %w", throttled), http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", throttledRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			respondWithServerError(rec, tt.err, "Failed to get environment")
+			expectError(t, rec, tt.status, tt.wantCode)
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/utils"
+)
+
+// eventHeartbeatInterval is how often an idle event stream gets a comment line, so
+// proxies don't close it for inactivity
+const eventHeartbeatInterval = 15 * time.Second
+
+// EventsHandler handles server-sent event streams of reservation changes
+type EventsHandler struct {
+	bus     *realtime.EventBus
+	envRepo db.EnvironmentRepositoryInterface
+}
+
+// NewEventsHandler creates a new EventsHandler
+func NewEventsHandler(bus *realtime.EventBus, envRepo db.EnvironmentRepositoryInterface) *EventsHandler {
+	return &EventsHandler{bus: bus, envRepo: envRepo}
+}
+
+// StreamEvents handles requests to follow reservation changes as server-sent events, for
+// clients that can't use the WebSocket. Each reservation that is created, released or
+// expires is sent as a data line holding its type, environment ID and username, unless
+// the environment belongs to a team the user can't see.
+func (h *EventsHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// The stream outlives the server's write timeout, so lift it for this request
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		respondWithServerError(w, err, "Streaming is not supported")
+		return
+	}
+
+	// Subscribe before responding so no event is missed after the headers arrive
+	events := h.bus.Subscribe()
+	defer h.bus.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("X-API-Version", utils.APIVersion)
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		log.Printf("Error flushing event stream: %v", err)
+		return
+	}
+
+	// Send events and heartbeats until the client goes away
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				return
+			}
+		case event := <-events:
+			if !h.visible(user, event.EnvironmentID) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// visible reports whether the user can see the environment an event is about. The
+// environment is only looked up if the user's role can't see every team's; if it can't be
+// found the event is left out.
+func (h *EventsHandler) visible(user models.User, environmentID string) bool {
+	if user.Role.HasPermission(models.PermissionViewAllTeams) {
+		return true
+	}
+	env, err := h.envRepo.GetEnvironment(environmentID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error getting environment %s for an event stream: %v", environmentID, err)
+		}
+		return false
+	}
+	return env.VisibleTo(user)
+}
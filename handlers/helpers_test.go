@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/service"
+	"github.com/devreserve/server/webhook"
+	"github.com/gorilla/mux"
+)
+
+// Users and environments shared by the handler tests. Alice and the manager are on the
+// payments team; the search team's environment is hidden from them.
+var (
+	alice   = models.User{Username: "alice", Role: models.RoleUser, Team: "payments"}
+	bob     = models.User{Username: "bob", Role: models.RoleUser, Team: "search"}
+	manager = models.User{Username: "mona", Role: models.RoleManager, Team: "payments"}
+	admin   = models.User{Username: "root", Role: models.RoleAdmin}
+
+	paymentsEnv = models.Environment{ID: "env-pay", Name: "payments-1", Team: "payments", Status: models.StatusFree}
+	searchEnv   = models.Environment{ID: "env-search", Name: "search-1", Team: "search", Status: models.StatusReserved}
+	sharedEnv   = models.Environment{ID: "env-shared", Name: "shared-1", Status: models.StatusFree}
+)
+
+// testResponse is a utils.Response with its data left undecoded
+type testResponse struct {
+	Success   bool            `json:"success"`
+	Data      json.RawMessage `json:"data"`
+	Error     string          `json:"error"`
+	ErrorCode string          `json:"errorCode"`
+}
+
+// request builds a request made by user, or by nobody if user is nil, with the given
+// route variables and JSON body
+func request(method, target string, user *models.User, vars map[string]string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, *user))
+	}
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	return r
+}
+
+// serve runs handler on r and returns the recorded response
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+// decode decodes a recorded JSON response, failing the test if its status isn't wantStatus
+func decode(t *testing.T, rec *httptest.ResponseRecorder, wantStatus int) testResponse {
+	t.Helper()
+	if rec.Code != wantStatus {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, wantStatus, rec.Body.String())
+	}
+	var resp testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+// decodeData decodes the data of a successful response into dest
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, dest interface{}) {
+	t.Helper()
+	decodeRaw(t, decode(t, rec, http.StatusOK).Data, dest)
+}
+
+// decodeRaw decodes the data of a decoded response into dest
+func decodeRaw(t *testing.T, data json.RawMessage, dest interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, dest); err != nil {
+		t.Fatalf("decoding data %s: %v", data, err)
+	}
+}
+
+// expectError checks that a recorded response is an error with the given status and code
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, wantStatus int, wantCode string) {
+	t.Helper()
+	resp := decode(t, rec, wantStatus)
+	if resp.Success || resp.ErrorCode != wantCode {
+		t.Errorf("response = %+v, want error code %s", resp, wantCode)
+	}
+}
+
+// newEnvRepo returns a mock environment repository that looks environments up in envs
+func newEnvRepo(envs ...models.Environment) *mock.MockEnvironmentRepository {
+	byID := make(map[string]models.Environment, len(envs))
+	for _, env := range envs {
+		byID[env.ID] = env
+	}
+	get := func(id string) (*models.Environment, error) {
+		env, ok := byID[id]
+		if !ok {
+			return nil, db.ErrNotFound
+		}
+		return &env, nil
+	}
+	return &mock.MockEnvironmentRepository{
+		GetEnvironmentFunc:           get,
+		GetEnvironmentConsistentFunc: get,
+		BatchGetEnvironmentsFunc: func(ids []string) ([]models.Environment, []string, error) {
+			found := []models.Environment{}
+			missing := []string{}
+			for _, id := range ids {
+				if env, ok := byID[id]; ok {
+					found = append(found, env)
+				} else {
+					missing = append(missing, id)
+				}
+			}
+			return found, missing, nil
+		},
+		ListEnvironmentsFunc: func(includeArchived bool) ([]models.Environment, error) {
+			list := []models.Environment{}
+			for _, env := range envs {
+				if includeArchived || !env.Archived {
+					list = append(list, env)
+				}
+			}
+			return list, nil
+		},
+	}
+}
+
+// reservationOf returns a reservation of env by username that started a minute ago and ends in an hour
+func reservationOf(id string, env models.Environment, username string) models.Reservation {
+	now := time.Now()
+	return models.Reservation{
+		ID:            id,
+		EnvironmentID: env.ID,
+		Username:      username,
+		Status:        models.ReservationStatusActive,
+		StartTime:     now.Add(-time.Minute),
+		EndTime:       now.Add(time.Hour),
+	}
+}
+
+// reservationIDs returns the IDs of reservations in a response
+func reservationIDs(reservations []models.ReservationResponse) []string {
+	ids := make([]string, len(reservations))
+	for i := range reservations {
+		ids[i] = reservations[i].ID
+	}
+	return ids
+}
+
+// auditLog is a mock audit repository that keeps the actions recorded in it
+type auditLog struct {
+	mock.MockAuditRepository
+
+	mu      sync.Mutex
+	actions []models.AuditAction
+}
+
+// newAuditLog returns an empty auditLog
+func newAuditLog() *auditLog {
+	a := &auditLog{}
+	a.RecordEventFunc = func(entry models.AuditLogEntry) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.actions = append(a.actions, entry.Action)
+		return nil
+	}
+	return a
+}
+
+// recorded returns the actions recorded so far
+func (a *auditLog) recorded() []models.AuditAction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]models.AuditAction(nil), a.actions...)
+}
+
+// discardNotifier is a notifier.Notifier that sends nothing
+type discardNotifier struct{}
+
+// Notify does nothing
+func (discardNotifier) Notify(username, subject, message string) error {
+	return nil
+}
+
+// newReservationHandler returns a ReservationHandler over the given mocks, with nobody
+// queued for any environment. Webhooks are queued but never delivered. The realtime hub
+// reads changed environments in the background, so envRepo and reservationRepo need
+// GetEnvironmentFunc and GetActiveReservationByEnvironmentIDFunc for any route that changes one.
+func newReservationHandler(envRepo *mock.MockEnvironmentRepository, reservationRepo *mock.MockReservationRepository, audit db.AuditRepositoryInterface) *ReservationHandler {
+	queueRepo := &mock.MockQueueRepository{
+		PromoteNextFunc: func(string) (*models.Reservation, *models.QueueEntry, error) {
+			return nil, nil, nil
+		},
+	}
+	statsRepo := &mock.MockStatsRepository{
+		RecordContentionFunc: func(string) error { return nil },
+	}
+	hub := realtime.NewHub(service.NewEnvironmentService(envRepo, reservationRepo, 1))
+	return NewReservationHandler(reservationRepo, envRepo, &mock.MockUserRepository{}, audit, statsRepo, queueRepo,
+		discardNotifier{}, webhook.NewDispatcher(nil, 0), hub, realtime.NewEventBus(), config.Config{MinReservationMins: 1, MaxReservationMins: 480})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/devreserve/server/jobs"
+	"github.com/devreserve/server/utils"
+)
+
+// InternalSecretHeader is the header callers of the internal endpoints send the shared secret in
+const InternalSecretHeader = "X-Internal-Secret"
+
+// InternalHandler handles requests from the deployment itself rather than from users, such as
+// a scheduler running the expiry sweep when the server has no background jobs of its own
+type InternalHandler struct {
+	sweeper *jobs.Sweeper
+	secret  string
+}
+
+// NewInternalHandler creates a new InternalHandler. With an empty secret every request is
+// refused, so the internal endpoints are off unless a secret is configured.
+func NewInternalHandler(sweeper *jobs.Sweeper, secret string) *InternalHandler {
+	return &InternalHandler{
+		sweeper: sweeper,
+		secret:  secret,
+	}
+}
+
+// RunExpirySweep handles requests to run the expiry sweep and activate scheduled
+// reservations once, e.g. from a scheduled EventBridge rule invoking the Lambda function
+func (h *InternalHandler) RunExpirySweep(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Check the shared secret
+	if !h.authorized(r) {
+		utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid or missing "+InternalSecretHeader+" header")
+		return
+	}
+
+	// Run the sweep, then start the scheduled reservations it may have made room for
+	result := h.sweeper.SweepExpired()
+	result.Activated = h.sweeper.ActivateScheduled()
+
+	// Send the notifications before responding, as the caller may be suspended afterwards
+	h.sweeper.Wait()
+
+	// Respond with what the sweep did
+	utils.RespondWithSuccess(w, result)
+}
+
+// authorized reports whether the request carries the shared secret
+func (h *InternalHandler) authorized(r *http.Request) bool {
+	if h.secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(InternalSecretHeader)), []byte(h.secret)) == 1
+}
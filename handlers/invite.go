@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// InviteHandler handles invite-related requests
+type InviteHandler struct {
+	inviteRepo *db.InviteRepository
+	auditRepo  db.AuditRepositoryInterface
+}
+
+// NewInviteHandler creates a new InviteHandler
+func NewInviteHandler(inviteRepo *db.InviteRepository, auditRepo db.AuditRepositoryInterface) *InviteHandler {
+	return &InviteHandler{
+		inviteRepo: inviteRepo,
+		auditRepo:  auditRepo,
+	}
+}
+
+// CreateInvite handles requests to create a single-use invite code (admin only)
+func (h *InviteHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the request body
+	var req models.InviteCreateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, utils.InvalidBodyMessage(err))
+		return
+	}
+
+	// Validate the request
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if !req.Role.IsValid() {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidRole, "Role must be ADMIN, MANAGER or USER")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	// Create the invite
+	invite, code, err := h.inviteRepo.CreateInvite(models.Invite{
+		Role:      req.Role,
+		CreatedBy: admin.Username,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		respondWithServerError(w, err, "Failed to create invite")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionCreateInvite,
+		Description: fmt.Sprintf("Created an invite for role %s", invite.Role),
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the invite, including the code which is only shown once
+	utils.RespondWithCreated(w, "", models.InviteCreateResponse{
+		Invite: *invite,
+		Code:   code,
+	})
+}
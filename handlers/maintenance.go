@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/utils"
+)
+
+// MaintenanceHandler handles requests to repair the server's data
+type MaintenanceHandler struct {
+	reservationRepo db.ReservationRepositoryInterface
+	auditRepo       db.AuditRepositoryInterface
+	realtime        *realtime.Hub
+}
+
+// NewMaintenanceHandler creates a new MaintenanceHandler
+func NewMaintenanceHandler(reservationRepo db.ReservationRepositoryInterface, auditRepo db.AuditRepositoryInterface, hub *realtime.Hub) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		reservationRepo: reservationRepo,
+		auditRepo:       auditRepo,
+		realtime:        hub,
+	}
+}
+
+// Reconcile handles requests to correct environment statuses that disagree with the active
+// reservations, such as after the expiry sweep was down (admin only). With dryRun=true the
+// corrections are only reported.
+func (h *MaintenanceHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Reconcile the statuses
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	fixes, err := h.reservationRepo.ReconcileEnvironmentStatuses(dryRun)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to reconcile environment statuses")
+		return
+	}
+
+	if !dryRun && len(fixes) > 0 {
+		// Record the action in the audit log
+		if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+			Actor:       user.Username,
+			Action:      models.AuditActionReconcileEnvironments,
+			Description: fmt.Sprintf("Corrected the status of %d environments from their reservations", len(fixes)),
+		}); err != nil {
+			log.Printf("Error recording audit log entry: %v", err)
+		}
+
+		// Tell WebSocket clients about the corrected environments
+		for _, fix := range fixes {
+			h.realtime.EnvironmentChanged(fix.EnvironmentID)
+		}
+	}
+
+	// Respond with the report
+	utils.RespondWithSuccess(w, models.ReconcileReport{DryRun: dryRun, Fixes: fixes})
+}
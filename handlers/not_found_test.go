@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/service"
+	"github.com/devreserve/server/webhook"
+)
+
+func TestMissingResourcesRespondNotFound(t *testing.T) {
+	// A missing item is a 404 with the resource's code; any other failure to read it is a 500.
+	// The mocks return no item either way, as the repositories do, so a handler that used the
+	// item without checking the error would panic.
+	lookups := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"not found", db.ErrNotFound, http.StatusNotFound},
+		{"read failed", errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, lookup := range lookups {
+		getEnvironment := func(string) (*models.Environment, error) { return nil, lookup.err }
+		envRepo := &mock.MockEnvironmentRepository{GetEnvironmentFunc: getEnvironment, GetEnvironmentConsistentFunc: getEnvironment}
+		reservationRepo := newReservationRepo()
+		reservationRepo.GetReservationFunc = func(string) (*models.Reservation, error) { return nil, lookup.err }
+		userRepo := &mock.MockUserRepository{GetUserFunc: func(string) (*models.User, error) { return nil, lookup.err }}
+
+		envHandler := NewEnvironmentHandler(envRepo, reservationRepo, newAuditLog(), &mock.MockStatsRepository{},
+			service.NewEnvironmentService(envRepo, reservationRepo, 1), webhook.NewDispatcher(nil, 0), nil, config.Config{})
+		reservationHandler := newReservationHandler(envRepo, reservationRepo, newAuditLog())
+		userHandler := NewUserHandler(userRepo, reservationRepo, newAuditLog(), nil)
+
+		routes := []struct {
+			name     string
+			handler  http.HandlerFunc
+			method   string
+			user     models.User
+			vars     map[string]string
+			body     string
+			wantCode string
+		}{
+			{"get user", userHandler.GetUser, http.MethodGet, alice, map[string]string{"username": "nobody"}, "", "USER_NOT_FOUND"},
+			{"set user team", userHandler.SetUserTeam, http.MethodPut, admin, map[string]string{"username": "nobody"}, `{"team": "search"}`, "USER_NOT_FOUND"},
+			{"get environment", envHandler.GetEnvironment, http.MethodGet, alice, map[string]string{"id": "env-gone"}, "", "ENV_NOT_FOUND"},
+			{"update environment", envHandler.UpdateEnvironment, http.MethodPut, admin, map[string]string{"id": "env-gone"}, `{"description": "gone"}`, "ENV_NOT_FOUND"},
+			{"archive environment", envHandler.ArchiveEnvironment, http.MethodPost, admin, map[string]string{"id": "env-gone"}, "", "ENV_NOT_FOUND"},
+			{"unlock environment", envHandler.UnlockEnvironment, http.MethodPost, admin, map[string]string{"id": "env-gone"}, "", "ENV_NOT_FOUND"},
+			{"update reservation", reservationHandler.UpdateReservation, http.MethodPatch, alice, map[string]string{"id": "res-gone"}, `{"feature": "checkout"}`, "RESERVATION_NOT_FOUND"},
+			{"transfer reservation", reservationHandler.TransferReservation, http.MethodPost, alice, map[string]string{"id": "res-gone"}, `{"toUsername": "bob"}`, "RESERVATION_NOT_FOUND"},
+			{"approve reservation", reservationHandler.ApproveReservation, http.MethodPost, admin, map[string]string{"id": "res-gone"}, "", "RESERVATION_NOT_FOUND"},
+		}
+		for _, route := range routes {
+			t.Run(route.name+", "+lookup.name, func(t *testing.T) {
+				wantCode := route.wantCode
+				if lookup.status == http.StatusInternalServerError {
+					wantCode = "INTERNAL"
+				}
+				rec := serve(route.handler, request(route.method, "/", &route.user, route.vars, route.body))
+				expectError(t, rec, lookup.status, wantCode)
+			})
+		}
+	}
+}
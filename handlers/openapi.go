@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// routeDoc describes a route for the OpenAPI document. Routes without an entry are still
+// listed (they are discovered from the router) but without a summary or body schemas.
+type routeDoc struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+	Status   int // success status, 200 if zero
+}
+
+// routeDocs holds the hand-maintained part of the OpenAPI document, keyed by "METHOD path"
+var routeDocs = map[string]routeDoc{
+	"POST /api/auth/register":        {Summary: "Register a new user, with an invite code if self-registration is disabled", Request: models.RegisterRequest{}, Status: http.StatusCreated},
+	"POST /api/auth/login":           {Summary: "Login and get a JWT token", Request: models.LoginRequest{}},
+	"POST /api/auth/forgot-password": {Summary: "Email a password reset token", Request: models.ForgotPasswordRequest{}},
+	"POST /api/auth/reset-password":  {Summary: "Set a new password using a reset token", Request: models.ResetPasswordRequest{}},
+	"POST /api/auth/logout":          {Summary: "Revoke the JWT the request is made with", Status: http.StatusNoContent},
+	"GET /api/openapi.json":          {Summary: "Get this OpenAPI document"},
+
+	"GET /api/users":                                          {Summary: "List all users", Response: []models.UserResponse{}},
+	"GET /api/users/{username}":                               {Summary: "Get a user by username", Response: models.UserResponse{}},
+	"POST /api/admin/users":                                   {Summary: "Create a new user (requires users:manage)", Response: models.UserResponse{}, Status: http.StatusCreated},
+	"POST /api/admin/users/import":                            {Summary: "Create users from an uploaded CSV file (requires users:manage)", Response: models.UserImportResult{}},
+	"GET /api/admin/system/config":                            {Summary: "Get the configuration in effect, without secrets (requires system:read)", Response: config.Snapshot{}},
+	"PUT /api/admin/system/config/check-interval":             {Summary: "Change how often expired reservations are checked for, between 5 and 3600 seconds, on the replica receiving the request (requires system:manage)", Request: models.CheckIntervalUpdateRequest{}, Response: config.Snapshot{}},
+	"POST /api/internal/run-expiry-sweep":                     {Summary: "Run the expiry sweep and activate due scheduled reservations once; authenticated by the X-Internal-Secret header instead of a user", Response: models.ExpirySweepResult{}},
+	"POST /api/admin/maintenance/reconcile":                   {Summary: "Correct environment statuses that disagree with the active reservations, or with dryRun=true only report them (requires system:manage)", Response: models.ReconcileReport{}},
+	"POST /api/admin/invites":                                 {Summary: "Create a single-use invite code; the code is only returned once (requires users:manage)", Request: models.InviteCreateRequest{}, Response: models.InviteCreateResponse{}, Status: http.StatusCreated},
+	"PUT /api/admin/users/{username}/team":                    {Summary: "Move a user to another team, or to none with an empty team, revoking their tokens (requires users:manage)", Request: models.UserTeamRequest{}, Response: models.UserResponse{}},
+	"POST /api/admin/users/{username}/revoke-tokens":          {Summary: "Revoke every JWT issued to a user so far (requires users:manage)", Response: models.TokenRevocationResult{}},
+	"GET /api/admin/users/{username}/activity":                {Summary: "Get a user's recent activity (requires audit:read)", Response: []models.ActivityEvent{}},
+	"GET /api/stats":                                          {Summary: "Get the headline numbers for a dashboard in one call, over the environments you can see: environments by status, active reservations and the environment with the most reservations made this week (since Monday, UTC). Status counts come from a scan of the environments table; all numbers are cached for DASHBOARD_CACHE_TTL", Response: models.DashboardStats{}},
+	"GET /api/environments":                                   {Summary: "List the environments you can see, shared ones and your team's (pass includeArchived=true to include archived ones, search=text to match names and descriptions, team=name to keep one team's)", Response: []models.EnvironmentWithReservation{}},
+	"GET /api/environments/available":                         {Summary: "List free environments you can see, optionally filtered by the tag, pool and team query parameters", Response: []models.Environment{}},
+	"POST /api/environments/batch":                            {Summary: "Get up to 100 environments by ID, with their current reservations and the IDs that weren't found", Request: models.EnvironmentBatchRequest{}, Response: models.EnvironmentBatchResponse{}},
+	"GET /api/environments/{id}/queue":                        {Summary: "See an environment's waitlist, numbered from 1 for the next in line; only your own place unless you have environments:manage", Response: []models.QueuePosition{}},
+	"DELETE /api/environments/{id}/queue/me":                  {Summary: "Leave an environment's waitlist (404 if you aren't queued)", Status: http.StatusNoContent},
+	"POST /api/environments/{id}/hold":                        {Summary: "Hold a free environment for HOLD_TTL so nobody else can reserve it while you decide; reserving it confirms the hold", Response: models.EnvironmentHold{}},
+	"DELETE /api/environments/{id}/hold":                      {Summary: "Give up your hold on an environment (409 if you aren't holding it)", Status: http.StatusNoContent},
+	"GET /api/environments/{id}/schedule":                     {Summary: "Get an environment's current reservation and the scheduled ones starting in the next seven days, sorted by start time, with overlapping reservations listed as conflicts", Response: models.EnvironmentSchedule{}},
+	"GET /api/environments/{id}/stats":                        {Summary: "Get an environment's usage counters, such as how often it was requested while reserved, and how its reservations ended, with the early-release rate", Response: models.EnvironmentStats{}},
+	"GET /api/environments/{id}":                              {Summary: "Get an environment by ID with its effective reservation duration limits; other teams' environments are not found", Response: models.EnvironmentWithReservation{}},
+	"POST /api/admin/environments":                            {Summary: "Create a new environment (requires environments:manage)", Request: models.EnvironmentCreateRequest{}, Response: models.Environment{}, Status: http.StatusCreated},
+	"PUT /api/admin/environments/{id}":                        {Summary: "Update an environment's name, description and details (requires environments:manage)", Request: models.EnvironmentUpdateRequest{}, Response: models.Environment{}},
+	"POST /api/admin/environments/import":                     {Summary: "Create environments from an uploaded CSV file, or from a JSON or YAML seed file sent as the body, creating missing environments and with mode=sync updating existing ones (requires environments:manage)", Response: models.EnvironmentImportResult{}},
+	"GET /api/admin/environments/export":                      {Summary: "Download the environments you can see as CSV (requires environments:manage)"},
+	"POST /api/admin/environments/{id}/clone":                 {Summary: "Create a free environment with the same description, tags and configuration as another, named name or the source's name with a -copy suffix (requires environments:manage)", Request: models.EnvironmentCloneRequest{}, Response: models.Environment{}, Status: http.StatusCreated},
+	"POST /api/admin/environments/{id}/lock":                  {Summary: "Lock a free environment so it can't be reserved, with an optional lockedReason (requires environments:manage)", Request: models.EnvironmentLockRequest{}, Response: models.Environment{}},
+	"POST /api/admin/environments/{id}/unlock":                {Summary: "Unlock a locked environment, making it free again (requires environments:manage)", Response: models.Environment{}},
+	"POST /api/admin/environments/{id}/archive":               {Summary: "Archive a free environment (requires environments:manage)", Response: models.Environment{}},
+	"POST /api/admin/environments/{id}/unarchive":             {Summary: "Unarchive an environment (requires environments:manage)", Response: models.Environment{}},
+	"GET /api/admin/environments/{id}/reservations/history":   {Summary: "Get every reservation of an environment, newest first, optionally only those with the given releaseType, as JSON or as CSV with Accept: text/csv (requires environments:manage)", Response: []models.ReservationResponse{}},
+	"POST /api/admin/environments/{id}/reassign-reservations": {Summary: "Move an environment's active reservations to another free environment, notifying their holders (requires environments:manage)", Request: models.ReservationReassignRequest{}, Response: models.ReservationReassignResult{}},
+	"POST /api/admin/environments/{id}/notify-users":          {Summary: "Email a message to the holders of an environment's active reservations, e.g. before emergency maintenance, listing who was notified and who couldn't be (requires environments:manage)", Request: models.EnvironmentNotifyRequest{}, Response: models.EnvironmentNotifyResult{}},
+	"POST /api/admin/apikeys":                                 {Summary: "Create an API key for a user; the key is only returned once (requires users:manage)", Request: models.APIKeyCreateRequest{}, Response: models.APIKeyCreateResponse{}, Status: http.StatusCreated},
+	"GET /api/admin/apikeys":                                  {Summary: "List all API keys (requires users:manage)", Response: []models.APIKey{}},
+	"POST /api/admin/apikeys/{id}/revoke":                     {Summary: "Revoke an API key (requires users:manage)", Response: models.APIKey{}},
+	"POST /api/admin/webhooks":                                {Summary: "Register a webhook (requires webhooks:manage)", Request: models.WebhookCreateRequest{}, Response: models.Webhook{}, Status: http.StatusCreated},
+	"GET /api/admin/webhooks":                                 {Summary: "List all webhooks (requires webhooks:manage)", Response: []models.Webhook{}},
+	"GET /api/admin/webhooks/{id}":                            {Summary: "Get a webhook (requires webhooks:manage)", Response: models.Webhook{}},
+	"PUT /api/admin/webhooks/{id}":                            {Summary: "Update a webhook (requires webhooks:manage)", Request: models.WebhookUpdateRequest{}, Response: models.Webhook{}},
+	"DELETE /api/admin/webhooks/{id}":                         {Summary: "Delete a webhook (requires webhooks:manage)", Status: http.StatusNoContent},
+	"GET /api/admin/webhooks/{id}/deliveries":                 {Summary: "Get a webhook's most recent deliveries (requires webhooks:manage)", Response: []models.WebhookDelivery{}},
+	"POST /api/reservations":                                  {Summary: "Reserve an environment, or with queue=true join its waitlist if it is reserved (202 with the queue entry); with environmentGroupId, reserve every environment in the group at once; with a future startTime, schedule the reservation for later; with recurrenceDays, make that many reservations one day apart (201 with the series)", Request: models.ReservationCreateRequest{}, Response: models.ReservationResponse{}, Status: http.StatusCreated},
+	"GET /api/reservations":                                   {Summary: "List the active reservations of the environments you can see, optionally filtered by environmentId, username, expiringWithinMins and purpose", Response: []models.ReservationResponse{}},
+	"GET /api/reservations/mine":                              {Summary: "List the current user's active reservations, including pending ones, with their time remaining", Response: []models.ReservationWithTimeRemaining{}},
+	"GET /api/reservations/search":                            {Summary: "Search reservations, including ended ones, by text in the feature, Git branch or Jira URL (q), user, environmentId, releaseType and a from/to time range, with limit and pageToken; at least one filter is required", Response: models.ReservationResponsePage{}},
+	"PATCH /api/reservations/{id}":                            {Summary: "Change an active reservation's auto-renew settings, purpose or Jira link (owner only)", Request: models.ReservationUpdateRequest{}, Response: models.ReservationResponse{}},
+	"GET /api/admin/reservations":                             {Summary: "List every reservation including ended ones, filtered by status=all|active|expired, environmentId and username, limit per page (default 50, max 100) and pageToken from the previous page's nextToken (requires audit:read)", Response: models.ReservationResponsePage{}},
+	"GET /api/admin/reservations/pending":                     {Summary: "List reservations of the environments you can see awaiting approval (requires reservations:approve)", Response: []models.ReservationResponse{}},
+	"POST /api/admin/reservations/{id}/approve":               {Summary: "Approve a pending reservation, starting it now (requires reservations:approve)", Response: models.ReservationResponse{}},
+	"POST /api/reservations/{id}/transfer":                    {Summary: "Hand a reservation over to another user (owner, or any with reservations:force-release)", Request: models.ReservationTransferRequest{}, Response: models.ReservationResponse{}},
+	"POST /api/reservations/bulk-release":                     {Summary: "Release all your active reservations, with an optional reason; 207 if some could not be released", Request: models.ReservationReleaseRequest{}, Response: models.BulkReleaseResult{}},
+	"DELETE /api/reservations/series/{seriesID}":              {Summary: "Cancel the reservations of a recurring reservation that haven't started yet; needs reservations:force-release for other users' series", Response: models.ReservationSeriesCancelResult{}},
+	"POST /api/reservations/preempt":                          {Summary: "Take a reserved environment over from a lower-priority holder during an incident, notifying them (admins, or API keys with the oncall scope)", Request: models.ReservationPreemptRequest{}, Response: models.PreemptionResult{}, Status: http.StatusCreated},
+	"POST /api/reservations/{id}/release":                     {Summary: "Release a reservation, or every reservation of a group reservation (200 with how many were released) (owner, or any with reservations:force-release)", Request: models.ReservationReleaseRequest{}, Status: http.StatusNoContent},
+	"GET /api/events":                                         {Summary: "Stream reservation created, released and expired events of the environments you can see as server-sent events (text/event-stream), with a heartbeat comment every 15 seconds; EventSource clients may pass the JWT as ?token="},
+	"GET /ws/environments":                                    {Summary: "Upgrade to a WebSocket streaming the list of environments you can see, then an environment_updated message whenever a reservation is created, released or expires; browsers may pass the JWT as ?token=", Status: http.StatusSwitchingProtocols},
+}
+
+// publicPaths are the routes that don't require a bearer token
+var publicPaths = map[string]bool{
+	"/api/auth/register":        true,
+	"/api/auth/login":           true,
+	"/api/auth/forgot-password": true,
+	"/api/auth/reset-password":  true,
+	"/api/openapi.json":         true,
+}
+
+// pathParamPattern matches path parameters such as {id}
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// OpenAPIHandler serves an OpenAPI 3 document describing the API
+type OpenAPIHandler struct {
+	router *mux.Router
+}
+
+// NewOpenAPIHandler creates a new OpenAPIHandler for the routes registered on router
+func NewOpenAPIHandler(router *mux.Router) *OpenAPIHandler {
+	return &OpenAPIHandler{
+		router: router,
+	}
+}
+
+// GetSpec handles requests for the OpenAPI document
+func (h *OpenAPIHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	spec, err := h.buildSpec()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build OpenAPI document")
+		return
+	}
+
+	// The document is served as-is rather than wrapped in the response envelope
+	utils.RespondWithJSON(w, http.StatusOK, spec)
+}
+
+// buildSpec walks the router and builds the OpenAPI document
+func (h *OpenAPIHandler) buildSpec() (map[string]interface{}, error) {
+	// Register the response envelope that every operation refers to
+	schemas := map[string]interface{}{}
+	schemaFor(reflect.TypeOf(utils.Response{}), schemas)
+
+	paths := map[string]map[string]interface{}{}
+	err := h.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods and aren't endpoints themselves
+			return nil
+		}
+
+		for _, method := range methods {
+			if paths[path] == nil {
+				paths[path] = map[string]interface{}{}
+			}
+			paths[path][strings.ToLower(method)] = buildOperation(method, path, schemas)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Dev Reserve API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}, nil
+}
+
+// buildOperation builds the OpenAPI operation object for a route
+func buildOperation(method, path string, schemas map[string]interface{}) map[string]interface{} {
+	doc := routeDocs[method+" "+path]
+
+	operation := map[string]interface{}{}
+	if doc.Summary != "" {
+		operation["summary"] = doc.Summary
+	}
+
+	// Path parameters
+	var params []interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+
+	// Request body
+	if doc.Request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaFor(reflect.TypeOf(doc.Request), schemas),
+				},
+			},
+		}
+	}
+
+	// Responses are always wrapped in the Response envelope
+	envelope := map[string]interface{}{"$ref": "#/components/schemas/Response"}
+	if doc.Response != nil {
+		envelope = map[string]interface{}{
+			"allOf": []interface{}{
+				envelope,
+				map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"data": schemaFor(reflect.TypeOf(doc.Response), schemas),
+					},
+				},
+			},
+		}
+	}
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if status != http.StatusNoContent {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": envelope},
+		}
+	}
+	operation["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Response"},
+				},
+			},
+		},
+	}
+
+	if !publicPaths[path] {
+		operation["security"] = []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+		}
+	}
+
+	return operation
+}
+
+// schemaFor derives a JSON schema from a Go type using its json struct tags. Named
+// struct types are registered in schemas and referenced with $ref.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema builds an object schema from a struct's exported fields
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+
+			// Embedded structs without a json name are flattened into the parent
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaFor(field.Type, schemas)
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// QueueHandler handles requests about environment waitlists
+type QueueHandler struct {
+	queueRepo db.QueueRepositoryInterface
+	envRepo   db.EnvironmentRepositoryInterface
+}
+
+// NewQueueHandler creates a new QueueHandler
+func NewQueueHandler(queueRepo db.QueueRepositoryInterface, envRepo db.EnvironmentRepositoryInterface) *QueueHandler {
+	return &QueueHandler{
+		queueRepo: queueRepo,
+		envRepo:   envRepo,
+	}
+}
+
+// GetEnvironmentQueue handles requests to see who is waiting for an environment. Users
+// with environments:manage see the whole queue; everyone else only sees their own place.
+func (h *QueueHandler) GetEnvironmentQueue(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Check that the environment exists and the user can see it
+	env, err := h.envRepo.GetEnvironment(id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+
+	// Get the queue, first in line first
+	queue, err := h.queueRepo.ListQueue(id)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get queue")
+		return
+	}
+
+	// Number the entries by their place in line, keeping only the user's own unless they can see everyone
+	seeAll := user.Role.HasPermission(models.PermissionManageEnvironments)
+	result := []models.QueuePosition{}
+	for i, entry := range queue {
+		if !seeAll && entry.Username != user.Username {
+			continue
+		}
+		result = append(result, models.QueuePosition{
+			Position:              i + 1,
+			Username:              entry.Username,
+			RequestedDurationMins: entry.RequestedDurationMins,
+			QueuedAt:              entry.QueuedAt,
+		})
+	}
+
+	// Respond with the queue
+	utils.RespondWithSuccess(w, result)
+}
+
+// WithdrawFromQueue handles requests to leave an environment's waitlist. The positions of
+// the entries behind the user aren't renumbered; only their order matters.
+func (h *QueueHandler) WithdrawFromQueue(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the environment ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Find the user's entry in the queue
+	queue, err := h.queueRepo.ListQueue(id)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get queue")
+		return
+	}
+	var entry *models.QueueEntry
+	for i := range queue {
+		if queue[i].Username == user.Username {
+			entry = &queue[i]
+			break
+		}
+	}
+	if entry == nil {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeNotQueued, "You are not queued for this environment")
+		return
+	}
+
+	// Remove it
+	if err := h.queueRepo.DeleteEntry(id, entry.Position); err != nil {
+		respondWithServerError(w, err, "Failed to leave queue")
+		return
+	}
+
+	// Respond with no content
+	utils.RespondWithNoContent(w)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+)
+
+// newQueueRepo returns a mock queue repository holding queue, in order, for every environment
+func newQueueRepo(queue ...models.QueueEntry) *mock.MockQueueRepository {
+	return &mock.MockQueueRepository{
+		ListQueueFunc: func(string) ([]models.QueueEntry, error) {
+			return queue, nil
+		},
+	}
+}
+
+func TestGetEnvironmentQueue(t *testing.T) {
+	now := time.Now()
+	queueRepo := newQueueRepo(
+		models.QueueEntry{EnvironmentID: paymentsEnv.ID, Position: 3, Username: "mona", RequestedDurationMins: 30, QueuedAt: now},
+		models.QueueEntry{EnvironmentID: paymentsEnv.ID, Position: 5, Username: "alice", RequestedDurationMins: 60, QueuedAt: now},
+	)
+	handler := NewQueueHandler(queueRepo, newEnvRepo(paymentsEnv))
+	vars := map[string]string{"id": paymentsEnv.ID}
+
+	// Positions are places in line rather than the stored positions
+	tests := []struct {
+		user models.User
+		want []string
+	}{
+		{manager, []string{"1 mona", "2 alice"}},
+		{alice, []string{"2 alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user.Username, func(t *testing.T) {
+			var queue []models.QueuePosition
+			decodeData(t, serve(handler.GetEnvironmentQueue, request(http.MethodGet, "/api/environments/env-pay/queue", &tt.user, vars, "")), &queue)
+			got := make([]string, len(queue))
+			for i, position := range queue {
+				got[i] = strconv.Itoa(position.Position) + " " + position.Username
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rec := serve(handler.GetEnvironmentQueue, request(http.MethodGet, "/api/environments/env-gone/queue", &alice, map[string]string{"id": "env-gone"}, ""))
+	expectError(t, rec, http.StatusNotFound, "ENV_NOT_FOUND")
+}
+
+func TestWithdrawFromQueue(t *testing.T) {
+	queueRepo := newQueueRepo(models.QueueEntry{EnvironmentID: paymentsEnv.ID, Position: 4, Username: "alice"})
+	var deleted []int
+	queueRepo.DeleteEntryFunc = func(environmentID string, position int) error {
+		deleted = append(deleted, position)
+		return nil
+	}
+	handler := NewQueueHandler(queueRepo, newEnvRepo(paymentsEnv))
+	vars := map[string]string{"id": paymentsEnv.ID}
+
+	rec := serve(handler.WithdrawFromQueue, request(http.MethodDelete, "/api/environments/env-pay/queue", &alice, vars, ""))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if !reflect.DeepEqual(deleted, []int{4}) {
+		t.Errorf("deleted positions %v, want [4]", deleted)
+	}
+
+	rec = serve(handler.WithdrawFromQueue, request(http.MethodDelete, "/api/environments/env-pay/queue", &manager, vars, ""))
+	expectError(t, rec, http.StatusNotFound, "NOT_QUEUED")
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/service"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/websocket"
+)
+
+// RealtimeHandler handles WebSocket connections that stream environment changes
+type RealtimeHandler struct {
+	hub        *realtime.Hub
+	envRepo    db.EnvironmentRepositoryInterface
+	envService *service.EnvironmentService
+	upgrader   websocket.Upgrader
+}
+
+// NewRealtimeHandler creates a new RealtimeHandler accepting connections from pages served
+// by the allowed origins, or from anywhere if they include *
+func NewRealtimeHandler(hub *realtime.Hub, envRepo db.EnvironmentRepositoryInterface, envService *service.EnvironmentService, allowedOrigins []string) *RealtimeHandler {
+	return &RealtimeHandler{
+		hub:        hub,
+		envRepo:    envRepo,
+		envService: envService,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: 10 * time.Second,
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if origin == "" {
+					return true
+				}
+				for _, allowed := range allowedOrigins {
+					if allowed == "*" || allowed == origin {
+						return true
+					}
+				}
+				return false
+			},
+		},
+	}
+}
+
+// StreamEnvironments handles requests to follow environment changes over a WebSocket. The
+// list of environments the user can see is sent on connect, followed by an
+// environment_updated message whenever a reservation of one of them is created, released or
+// expires. Secret details are never sent.
+func (h *RealtimeHandler) StreamEnvironments(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the current environments before upgrading, so a failure can still be reported over HTTP
+	environments, err := h.envRepo.ListEnvironments(false)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list environments")
+		return
+	}
+	result := h.envService.WithReservations(visibleEnvironments(user, environments), time.Now())
+	for i := range result {
+		result[i].SecretDetails = nil
+	}
+
+	// Upgrade the connection; the upgrader responds with the error itself if it fails
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading WebSocket connection: %v", err)
+		return
+	}
+
+	// Serve the connection until the client goes away
+	h.hub.Serve(conn, user, realtime.Message{
+		Type:         realtime.MessageEnvironments,
+		Environments: &result,
+	})
+}
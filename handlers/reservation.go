@@ -1,27 +1,100 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/devreserve/server/config"
 	"github.com/devreserve/server/db"
 	"github.com/devreserve/server/middleware"
 	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/notifier"
+	"github.com/devreserve/server/realtime"
 	"github.com/devreserve/server/utils"
+	"github.com/devreserve/server/webhook"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+// bulkReleaseWorkers is the number of reservations a bulk release releases at once
+const bulkReleaseWorkers = 5
+
+// Page sizes for reservation listings
+const (
+	defaultReservationPageSize = 50
+	maxReservationPageSize     = 100
+)
+
+// queueEntryTTL is how long a waitlist entry can wait to reach the front of the queue before it is dropped
+const queueEntryTTL = time.Hour
+
 // ReservationHandler handles reservation-related requests
 type ReservationHandler struct {
-	reservationRepo *db.ReservationRepository
-	envRepo         *db.EnvironmentRepository
+	reservationRepo db.ReservationRepositoryInterface
+	envRepo         db.EnvironmentRepositoryInterface
+	userRepo        db.UserRepositoryInterface
+	auditRepo       db.AuditRepositoryInterface
+	statsRepo       db.StatsRepositoryInterface
+	queueRepo       db.QueueRepositoryInterface
+	notifier        notifier.Notifier
+	webhooks        *webhook.Dispatcher
+	realtime        *realtime.Hub
+	events          *realtime.EventBus
+	trustedProxies  []*net.IPNet
+	config          config.Config
 }
 
 // NewReservationHandler creates a new ReservationHandler
-func NewReservationHandler(reservationRepo *db.ReservationRepository, envRepo *db.EnvironmentRepository) *ReservationHandler {
+func NewReservationHandler(reservationRepo db.ReservationRepositoryInterface, envRepo db.EnvironmentRepositoryInterface, userRepo db.UserRepositoryInterface,
+	auditRepo db.AuditRepositoryInterface, statsRepo db.StatsRepositoryInterface, queueRepo db.QueueRepositoryInterface, notifier notifier.Notifier, webhooks *webhook.Dispatcher, hub *realtime.Hub, events *realtime.EventBus, config config.Config) *ReservationHandler {
 	return &ReservationHandler{
 		reservationRepo: reservationRepo,
 		envRepo:         envRepo,
+		userRepo:        userRepo,
+		auditRepo:       auditRepo,
+		statsRepo:       statsRepo,
+		queueRepo:       queueRepo,
+		notifier:        notifier,
+		webhooks:        webhooks,
+		realtime:        hub,
+		events:          events,
+		trustedProxies:  config.TrustedProxyNetworks(),
+		config:          config,
+	}
+}
+
+// requestMeta records who made a request and where it came from, for storing with the change
+// it makes
+func (h *ReservationHandler) requestMeta(r *http.Request, username string) models.RequestMeta {
+	userAgent := r.UserAgent()
+	if runes := []rune(userAgent); len(runes) > models.MaxUserAgentLength {
+		userAgent = string(runes[:models.MaxUserAgentLength])
+	}
+	return models.RequestMeta{
+		Username:  username,
+		IP:        utils.ClientIP(r, h.trustedProxies),
+		UserAgent: userAgent,
+	}
+}
+
+// invalidReleaseTypeMessage is the error for a releaseType filter that isn't a known release type
+const invalidReleaseTypeMessage = "releaseType must be one of MANUAL, FORCE_RELEASED, EXPIRED or PREEMPTED"
+
+// defaultReservationLimits returns the reservation duration bounds of environments that don't set their own
+func defaultReservationLimits(cfg config.Config) models.ReservationLimits {
+	return models.ReservationLimits{
+		MinMins: cfg.MinReservationMins,
+		MaxMins: cfg.MaxReservationMins,
 	}
 }
 
@@ -48,47 +121,124 @@ func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Re
 	// Parse the request body
 	var req models.ReservationCreateRequest
 	if err := utils.ParseJSONBody(r, &req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, utils.InvalidBodyMessage(err))
 		return
 	}
 
 	// Validate the request
-	if req.EnvironmentID == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Environment ID is required")
+	if req.EnvironmentID == "" && req.EnvironmentGroupID == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID or environment group ID is required")
 		return
 	}
-	if req.DurationMins < 10 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Duration must be at least 10 minutes")
+	if req.EnvironmentID != "" && req.EnvironmentGroupID != "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeConflictingFields, "Send either an environment ID or an environment group ID, not both")
 		return
 	}
-	if req.DurationMins > 4320 { // 3 days = 4320 minutes
-		utils.RespondWithError(w, http.StatusBadRequest, "Duration cannot exceed 3 days (4320 minutes)")
+	if req.DurationMins < 1 {
+		// The environment's own limits are checked once it has been read
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeDurationOutOfRange, "Duration must be at least 1 minute")
 		return
 	}
 	if req.Feature == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Feature description is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Feature description is required")
+		return
+	}
+	if req.Purpose != "" && !h.isAllowedPurpose(req.Purpose) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidPurpose, h.invalidPurposeMessage())
+		return
+	}
+	if !h.validateReservationDetails(w, req.Feature, req.GitBranch, req.JiraURL) {
+		return
+	}
+	if req.RecurrenceDays < 0 || req.RecurrenceDays > models.MaxRecurrenceDays {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeRecurrenceOutOfRange, fmt.Sprintf("recurrenceDays must be between 0 and %d", models.MaxRecurrenceDays))
+		return
+	}
+
+	// Reserve a whole group if asked to
+	if req.EnvironmentGroupID != "" && req.RecurrenceDays > 1 {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEnvGroupUnsupported, "Environment groups can't be reserved every day")
+		return
+	}
+	if req.EnvironmentGroupID != "" && req.StartTime != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEnvGroupUnsupported, "Environment groups can't be reserved for later")
+		return
+	}
+	if req.EnvironmentGroupID != "" {
+		h.createGroupReservation(w, user, req)
 		return
 	}
 
-	// Get the environment to check if it's available
-	env, err := h.envRepo.GetEnvironment(req.EnvironmentID)
+	// Get the environment to check if it's available, using a consistent read so a
+	// release or reservation made just before is reflected in the status
+	env, err := h.envRepo.GetEnvironmentConsistent(req.EnvironmentID)
+	// Other teams' environments are reported as not found rather than revealed
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get environment")
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	if env.Archived {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvArchived, "Environment is archived")
+		return
+	}
+	if limits := env.ReservationLimits(defaultReservationLimits(h.config)); !limits.Permits(req.DurationMins) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeDurationOutOfRange,
+			fmt.Sprintf("Reservations of environment %s must last %s", env.Name, limits))
+		return
+	}
+
+	// Recurring reservations are checked as a whole before any of them is made
+	seriesID := ""
+	if req.RecurrenceDays > 1 {
+		if !h.checkSeries(w, req, env) {
+			return
+		}
+		seriesID = uuid.New().String()
+	}
+
+	// Reservations that start later leave the environment alone until then
+	if req.StartTime != nil && req.StartTime.After(time.Now()) {
+		h.scheduleReservation(w, user, req, env, seriesID)
 		return
 	}
-	if env == nil {
-		utils.RespondWithError(w, http.StatusNotFound, "Environment not found")
+
+	if env.Status == models.StatusLocked {
+		message := "Environment is locked"
+		if env.LockedReason != "" {
+			message += ": " + env.LockedReason
+		}
+		utils.RespondWithErrorCode(w, http.StatusLocked, utils.ErrCodeEnvLocked, message)
 		return
 	}
-	if env.Status != models.StatusFree {
-		utils.RespondWithError(w, http.StatusBadRequest, "Environment is already reserved")
+	if !env.IsReservableBy(user.Username, time.Now()) {
+		// Count the attempt so over-subscribed environments show up in their stats
+		if err := h.statsRepo.RecordContention(env.ID); err != nil {
+			log.Printf("Error recording contention for environment %s: %v", env.ID, err)
+		}
+		if req.Queue {
+			h.joinQueue(w, user, req)
+			return
+		}
+		if env.Status == models.StatusHeld {
+			h.respondHeld(w, env)
+			return
+		}
+		h.respondAlreadyReserved(w, env)
 		return
 	}
 
-	// Create the reservation
+	// Create the reservation, checking it against the rules queued reservations follow too
 	now := time.Now()
 	endTime := now.Add(time.Duration(req.DurationMins) * time.Minute)
-	
+	if err := h.reservationRepo.CheckReservationRules(*env, user, now, endTime); err != nil {
+		respondRuleBroken(w, err, now)
+		return
+	}
+
 	reservation := models.Reservation{
 		EnvironmentID: req.EnvironmentID,
 		Username:      user.Username,
@@ -97,16 +247,309 @@ func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Re
 		Feature:       req.Feature,
 		GitBranch:     req.GitBranch,
 		JiraURL:       req.JiraURL,
+		DurationMins:  req.DurationMins,
+		Purpose:       req.Purpose,
+		SeriesID:      seriesID,
+	}
+
+	// Set up auto-renew, bounded by the maximum auto-renew duration
+	if req.AutoRenew {
+		autoRenewUntil, errMsg := h.autoRenewUntil(req.AutoRenewUntil, now, endTime)
+		if errMsg != "" {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidAutoRenew, errMsg)
+			return
+		}
+		reservation.AutoRenew = true
+		reservation.AutoRenewUntil = &autoRenewUntil
 	}
 
 	createdReservation, err := h.reservationRepo.CreateReservation(reservation)
+	if errors.Is(err, db.ErrEnvironmentUnavailable) {
+		// Someone else took the environment since it was read
+		h.respondTaken(w, env.ID)
+		return
+	}
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create reservation: "+err.Error())
+		respondWithServerError(w, err, "Failed to create reservation: "+err.Error())
+		return
+	}
+
+	// Let the admins know a reservation is waiting for their approval
+	if createdReservation.IsPending() {
+		go h.notifyApprovers(*createdReservation, env.Name)
+	} else {
+		go h.notifyConfirmed(*createdReservation, env.Name)
+	}
+	h.webhooks.Dispatch(models.EventReservationCreated, createdReservation)
+	h.events.Publish(models.EventReservationCreated, createdReservation)
+	h.realtime.EnvironmentChanged(createdReservation.EnvironmentID)
+
+	// Schedule the rest of a recurring reservation
+	if seriesID != "" {
+		h.scheduleSeries(w, req, createdReservation)
 		return
 	}
 
 	// Respond with the created reservation
-	utils.RespondWithSuccess(w, createdReservation)
+	utils.RespondWithCreated(w, "/api/reservations/"+url.PathEscape(createdReservation.ID), createdReservation.ToResponse(time.Now()))
+}
+
+// respondRuleBroken rejects a reservation starting at start that breaks one of the reservation
+// rules, or responds with a server error if they couldn't be checked
+func respondRuleBroken(w http.ResponseWriter, err error, start time.Time) {
+	var broken *models.ReservationRuleError
+	if !errors.As(err, &broken) {
+		respondWithServerError(w, err, "Failed to check reservation")
+		return
+	}
+	switch broken.Rule {
+	case models.RuleNotVisible:
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, broken.Message)
+	case models.RuleUnhealthy:
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnhealthy, broken.Message)
+	case models.RuleOutsideAllowedHours:
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeOutsideAllowedHours, broken.Message)
+	case models.RuleScheduled:
+		respondScheduled(w, broken.Conflict, start)
+	default:
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeConflict, broken.Message)
+	}
+}
+
+// respondTaken rejects a reservation of an environment that was reserved or held by someone
+// else after it was checked, reporting who holds it now
+func (h *ReservationHandler) respondTaken(w http.ResponseWriter, envID string) {
+	env, err := h.envRepo.GetEnvironmentConsistent(envID)
+	if err != nil {
+		log.Printf("Error getting environment %s: %v", envID, err)
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvAlreadyReserved, "Environment was reserved by someone else in the meantime")
+		return
+	}
+	if env.Status == models.StatusHeld {
+		h.respondHeld(w, env)
+		return
+	}
+	h.respondAlreadyReserved(w, env)
+}
+
+// respondAlreadyReserved rejects a reservation of an environment that isn't free, telling the
+// client who holds it and until when, with a Retry-After header for the time left. Environments
+// held for a pending reservation, or whose holder can't be looked up, get no details.
+func (h *ReservationHandler) respondAlreadyReserved(w http.ResponseWriter, env *models.Environment) {
+	now := time.Now()
+	current, err := h.reservationRepo.GetActiveReservationByEnvironmentID(env.ID, now)
+	if err != nil {
+		log.Printf("Error getting the reservation holding environment %s: %v", env.ID, err)
+	}
+	if current == nil {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvAlreadyReserved, "Environment is already reserved")
+		return
+	}
+
+	current.SetComputedTimes(now)
+	conflict := models.ReservationConflict{
+		EnvironmentID: env.ID,
+		EndTime:       &current.EndTime,
+		RemainingMins: current.RemainingMins,
+	}
+	message := "Environment is already reserved"
+	if !h.config.HideReservationHolder {
+		conflict.Holder = current.Username
+		message += " by " + current.Username
+	}
+	message += fmt.Sprintf(" until %s", current.EndTime.UTC().Format(time.RFC3339))
+
+	// Round the wait up to a whole second so clients don't retry just before the end time
+	retryAfter := int(math.Ceil(current.EndTime.Sub(now).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	utils.RespondWithJSON(w, http.StatusConflict, utils.Response{
+		Success:   false,
+		Data:      conflict,
+		Error:     message,
+		ErrorCode: utils.ErrCodeEnvAlreadyReserved,
+	})
+}
+
+// createGroupReservation reserves every environment in the requested group at once, or none
+// of them if any can't be reserved
+func (h *ReservationHandler) createGroupReservation(w http.ResponseWriter, user models.User, req models.ReservationCreateRequest) {
+	if req.Queue {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEnvGroupUnsupported, "Environment groups can't be queued for")
+		return
+	}
+
+	// Get the group's environments
+	envs, err := h.envRepo.ListEnvironmentsByGroup(req.EnvironmentGroupID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment group")
+		return
+	}
+	// Groups reaching into other teams' environments are reported as not found
+	if len(envs) == 0 || len(visibleEnvironments(user, envs)) < len(envs) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvGroupNotFound, "Environment group not found")
+		return
+	}
+	if len(envs) > db.MaxGroupSize {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeEnvGroupTooLarge, fmt.Sprintf("Environment groups of more than %d environments can't be reserved together", db.MaxGroupSize))
+		return
+	}
+
+	// Check that every environment can be reserved, collecting the ones that can't
+	now := time.Now()
+	endTime := now.Add(time.Duration(req.DurationMins) * time.Minute)
+	defaults := defaultReservationLimits(h.config)
+	blockers := []models.GroupReservationBlocker{}
+	for _, env := range envs {
+		limits := env.ReservationLimits(defaults)
+		conflict, err := h.scheduledConflict(env.ID, now, endTime)
+		if err != nil {
+			respondWithServerError(w, err, "Failed to get scheduled reservations")
+			return
+		}
+		reason := ""
+		switch {
+		case !limits.Permits(req.DurationMins):
+			reason = "reservations must last " + limits.String()
+		case env.Status == models.StatusLocked:
+			reason = "locked"
+		case env.Status != models.StatusFree:
+			reason = "not free"
+			if err := h.statsRepo.RecordContention(env.ID); err != nil {
+				log.Printf("Error recording contention for environment %s: %v", env.ID, err)
+			}
+		case env.RequiresApproval:
+			reason = "requires approval, so it must be reserved on its own"
+		case h.config.BlockUnhealthyReservations && env.HealthStatus == models.HealthUnhealthy:
+			reason = "unhealthy: " + env.LastHealthError
+		case env.AllowedHours != nil && !env.AllowedHours.Permits(now, endTime):
+			reason = "can only be reserved " + env.AllowedHours.String()
+		case conflict != nil:
+			reason = "scheduled for another reservation from " + conflict.StartTime.UTC().Format(time.RFC3339)
+		}
+		if reason != "" {
+			blockers = append(blockers, models.GroupReservationBlocker{
+				EnvironmentID: env.ID,
+				Name:          env.Name,
+				Status:        env.Status,
+				Reason:        reason,
+			})
+		}
+	}
+	if len(blockers) > 0 {
+		names := make([]string, len(blockers))
+		for i, blocker := range blockers {
+			names[i] = blocker.Name + " (" + blocker.Reason + ")"
+		}
+		utils.RespondWithJSON(w, http.StatusConflict, utils.Response{
+			Success:   false,
+			Data:      blockers,
+			Error:     "Environment group can't be reserved: " + strings.Join(names, ", "),
+			ErrorCode: utils.ErrCodeEnvGroupUnavailable,
+		})
+		return
+	}
+
+	template := models.Reservation{
+		Username:     user.Username,
+		StartTime:    now,
+		EndTime:      endTime,
+		Feature:      req.Feature,
+		GitBranch:    req.GitBranch,
+		JiraURL:      req.JiraURL,
+		DurationMins: req.DurationMins,
+		Purpose:      req.Purpose,
+	}
+
+	// Set up auto-renew, bounded by the maximum auto-renew duration
+	if req.AutoRenew {
+		autoRenewUntil, errMsg := h.autoRenewUntil(req.AutoRenewUntil, now, endTime)
+		if errMsg != "" {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidAutoRenew, errMsg)
+			return
+		}
+		template.AutoRenew = true
+		template.AutoRenewUntil = &autoRenewUntil
+	}
+
+	// Reserve the environments in a single transaction
+	reservations, err := h.reservationRepo.CreateGroupReservation(envs, template)
+	if errors.Is(err, db.ErrEnvironmentUnavailable) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvGroupUnavailable, "An environment in the group was reserved in the meantime")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to reserve environment group")
+		return
+	}
+	for i := range reservations {
+		h.webhooks.Dispatch(models.EventReservationCreated, &reservations[i])
+		h.events.Publish(models.EventReservationCreated, &reservations[i])
+		h.realtime.EnvironmentChanged(reservations[i].EnvironmentID)
+	}
+
+	// Respond with the created reservations
+	utils.RespondWithCreated(w, "", models.GroupReservation{
+		GroupReservationID: reservations[0].GroupReservationID,
+		Reservations:       models.ReservationResponses(reservations, time.Now()),
+	})
+}
+
+// joinQueue adds the user to the waitlist of the reserved environment they asked for
+func (h *ReservationHandler) joinQueue(w http.ResponseWriter, user models.User, req models.ReservationCreateRequest) {
+	// Each user can only wait in line once per environment
+	queue, err := h.queueRepo.ListQueue(req.EnvironmentID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get queue")
+		return
+	}
+	for _, entry := range queue {
+		if entry.Username == user.Username {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeAlreadyQueued, "You are already queued for this environment")
+			return
+		}
+	}
+
+	now := time.Now()
+	entry, err := h.queueRepo.Enqueue(models.QueueEntry{
+		EnvironmentID:         req.EnvironmentID,
+		Username:              user.Username,
+		RequestedDurationMins: req.DurationMins,
+		Feature:               req.Feature,
+		GitBranch:             req.GitBranch,
+		JiraURL:               req.JiraURL,
+		Purpose:               req.Purpose,
+		QueuedAt:              now,
+		ExpiresAt:             now.Add(queueEntryTTL),
+	})
+	if err != nil {
+		respondWithServerError(w, err, "Failed to join queue")
+		return
+	}
+
+	// Respond with the queue entry; the reservation is created once it reaches the front
+	utils.RespondWithJSON(w, http.StatusAccepted, utils.Response{
+		Success: true,
+		Data:    entry,
+	})
+}
+
+// promoteQueued reserves a just-freed environment for the first user waiting for it, if any
+func (h *ReservationHandler) promoteQueued(environmentID string) {
+	reservation, _, err := h.queueRepo.PromoteNext(environmentID)
+	if err != nil {
+		log.Printf("Error promoting queued reservation for environment %s: %v", environmentID, err)
+		return
+	}
+	if reservation != nil {
+		h.webhooks.Dispatch(models.EventReservationCreated, reservation)
+		h.events.Publish(models.EventReservationCreated, reservation)
+		if err := notifier.NotifyHandoff(h.notifier, reservation); err != nil {
+			log.Printf("Error sending handoff notification: %v", err)
+		}
+	}
 }
 
 // ReleaseReservation handles requests to release a reserved environment
@@ -133,24 +576,229 @@ func (h *ReservationHandler) ReleaseReservation(w http.ResponseWriter, r *http.R
 	vars := mux.Vars(r)
 	id := vars["id"]
 	if id == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Reservation ID is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Reservation ID is required")
+		return
+	}
+
+	// Parse the optional request body
+	var req models.ReservationReleaseRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil && err != io.EOF {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
 		return
 	}
 
-	// Release the reservation
-	err := h.reservationRepo.ReleaseReservation(id, user.Username)
+	// Release the reservation; users with the force-release permission may release anyone's
+	force := canForceRelease(r, user)
+	meta := h.requestMeta(r, user.Username)
+	err := h.reservationRepo.ReleaseReservation(id, meta, req.Reason, force)
+	if errors.Is(err, db.ErrNotFound) {
+		// The ID may be a group reservation ID
+		h.releaseGroupReservation(w, id, meta, req.Reason, force)
+		return
+	}
+	if errors.Is(err, db.ErrNotOwner) {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeNotOwner, "You can only release your own reservations")
+		return
+	}
+	if errors.Is(err, db.ErrReservationChanged) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeReservationChanged, "Reservation was already released or expired")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to release reservation: "+err.Error())
+		return
+	}
+
+	// Send the released reservation to webhooks
+	if released, err := h.reservationRepo.GetReservation(id); err != nil {
+		log.Printf("Error getting released reservation %s: %v", id, err)
+	} else {
+		h.webhooks.Dispatch(models.EventReservationReleased, released)
+		h.events.Publish(models.EventReservationReleased, released)
+		h.promoteQueued(released.EnvironmentID)
+		h.realtime.EnvironmentChanged(released.EnvironmentID)
+
+		// Reservations made for an environment group are released together
+		if released.GroupReservationID != "" {
+			if _, err := h.releaseGroupMembers(released.GroupReservationID, released.ID, meta, req.Reason, force); err != nil {
+				log.Printf("Error releasing the rest of group reservation %s: %v", released.GroupReservationID, err)
+			}
+		}
+	}
+
+	// Respond with no content
+	utils.RespondWithNoContent(w)
+}
+
+// releaseGroupReservation handles a release request whose ID turned out to be a group
+// reservation ID rather than a reservation ID, releasing every member of the group
+func (h *ReservationHandler) releaseGroupReservation(w http.ResponseWriter, groupReservationID string, meta models.RequestMeta, reason string, force bool) {
+	members, err := h.reservationRepo.ListReservationsByGroup(groupReservationID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get group reservation")
+		return
+	}
+	if len(members) == 0 {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	if !force && members[0].Username != meta.Username {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeNotOwner, "You can only release your own reservations")
+		return
+	}
+
+	released, err := h.releaseGroupMembers(groupReservationID, "", meta, reason, force)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to release reservation: "+err.Error())
+		respondWithServerError(w, err, "Failed to release group reservation: "+err.Error())
 		return
 	}
 
 	// Respond with success
 	utils.RespondWithSuccess(w, map[string]interface{}{
-		"message": "Reservation released successfully",
+		"message":  "Group reservation released successfully",
+		"released": released,
 	})
 }
 
-// GetActiveReservations handles requests to get all active reservations
+// releaseGroupMembers releases the members of a group reservation that are still active,
+// apart from releasedID which the caller already released, telling webhooks and the
+// environments' queues about each. It returns how many it released, stopping at the first failure.
+func (h *ReservationHandler) releaseGroupMembers(groupReservationID, releasedID string, meta models.RequestMeta, reason string, force bool) (int, error) {
+	members, err := h.reservationRepo.ListReservationsByGroup(groupReservationID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	released := 0
+	for _, member := range members {
+		if member.ID == releasedID || member.ReleaseType != "" || !member.IsActiveAt(now) {
+			continue
+		}
+		err := h.reservationRepo.ReleaseReservation(member.ID, meta, reason, force)
+		if errors.Is(err, db.ErrReservationChanged) {
+			// Someone else released it or it expired in the meantime
+			continue
+		}
+		if err != nil {
+			return released, fmt.Errorf("failed to release reservation %s: %w", member.ID, err)
+		}
+		released++
+
+		if updated, err := h.reservationRepo.GetReservation(member.ID); err != nil {
+			log.Printf("Error getting released reservation %s: %v", member.ID, err)
+		} else {
+			h.webhooks.Dispatch(models.EventReservationReleased, updated)
+			h.events.Publish(models.EventReservationReleased, updated)
+			h.promoteQueued(updated.EnvironmentID)
+			h.realtime.EnvironmentChanged(updated.EnvironmentID)
+		}
+	}
+	return released, nil
+}
+
+// BulkReleaseReservations handles requests to release all of the current user's active
+// reservations, including ones waiting for approval. It responds 207 Multi-Status if any
+// of them could not be released.
+func (h *ReservationHandler) BulkReleaseReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the optional request body
+	var req models.ReservationReleaseRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil && err != io.EOF {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Get the user's active reservations
+	reservations, err := h.reservationRepo.ListActiveReservationsByUsername(user.Username, time.Now())
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Release them with a fixed pool of workers, collecting failures
+	meta := h.requestMeta(r, user.Username)
+	result := models.BulkReleaseResult{
+		Failed: []models.ReservationReleaseFailure{},
+	}
+	var mu sync.Mutex
+	jobs := make(chan models.Reservation)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkReleaseWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for reservation := range jobs {
+				err := h.releaseOwnReservation(reservation, meta, req.Reason)
+				mu.Lock()
+				if err != nil {
+					result.Failed = append(result.Failed, models.ReservationReleaseFailure{
+						ReservationID: reservation.ID,
+						EnvironmentID: reservation.EnvironmentID,
+						Error:         err.Error(),
+					})
+				} else {
+					result.Released++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, reservation := range reservations {
+		jobs <- reservation
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Respond with the summary, flagging partial failure with 207
+	if len(result.Failed) > 0 {
+		utils.RespondWithJSON(w, http.StatusMultiStatus, utils.Response{
+			Success: false,
+			Data:    result,
+		})
+		return
+	}
+	utils.RespondWithSuccess(w, result)
+}
+
+// releaseOwnReservation releases one of the user's reservations and tells webhooks and
+// the environment's queue about it
+func (h *ReservationHandler) releaseOwnReservation(reservation models.Reservation, meta models.RequestMeta, reason string) error {
+	if err := h.reservationRepo.ReleaseReservation(reservation.ID, meta, reason, false); err != nil {
+		return err
+	}
+
+	if released, err := h.reservationRepo.GetReservation(reservation.ID); err != nil {
+		log.Printf("Error getting released reservation %s: %v", reservation.ID, err)
+	} else {
+		h.webhooks.Dispatch(models.EventReservationReleased, released)
+		h.events.Publish(models.EventReservationReleased, released)
+		h.promoteQueued(released.EnvironmentID)
+		h.realtime.EnvironmentChanged(released.EnvironmentID)
+	}
+	return nil
+}
+
+// GetActiveReservations handles requests to get the active reservations of the environments
+// the user can see, optionally filtered by environmentId, username, expiringWithinMins and
+// purpose, which all have to match
 func (h *ReservationHandler) GetActiveReservations(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
 	if r.Method != http.MethodGet {
@@ -158,13 +806,719 @@ func (h *ReservationHandler) GetActiveReservations(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Get all active reservations
-	reservations, err := h.reservationRepo.ListActiveReservations()
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the filters
+	query := r.URL.Query()
+	filter := models.ActiveReservationFilter{
+		EnvironmentID: query.Get("environmentId"),
+		Username:      query.Get("username"),
+	}
+	if raw := query.Get("expiringWithinMins"); raw != "" {
+		mins, err := strconv.Atoi(raw)
+		if err != nil || mins < 1 {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidFilter, "expiringWithinMins must be a whole number of minutes, at least 1")
+			return
+		}
+		filter.ExpiringWithin = time.Duration(mins) * time.Minute
+	}
+	if filter.EnvironmentID != "" {
+		env, err := h.envRepo.GetEnvironment(filter.EnvironmentID)
+		if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+			utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, err, "Failed to get environment")
+			return
+		}
+	}
+
+	// Get the matching reservations active right now, using the same instant for the whole response
+	now := time.Now()
+	reservations, err := h.reservationRepo.ListActiveReservations(filter, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Leave out reservations of other teams' environments
+	if filter.EnvironmentID == "" {
+		reservations, err = visibleReservations(h.envRepo, user, reservations)
+		if err != nil {
+			respondWithServerError(w, err, "Failed to get environments")
+			return
+		}
+	}
+
+	// Filter by purpose if asked to; reservations without one count as OTHER
+	if purpose := models.ReservationPurpose(r.URL.Query().Get("purpose")); purpose != "" {
+		filtered := []models.Reservation{}
+		for _, reservation := range reservations {
+			if reservation.EffectivePurpose() == purpose {
+				filtered = append(filtered, reservation)
+			}
+		}
+		reservations = filtered
+	}
+
+	// Render a plain-text table for CLI clients that asked for one
+	if utils.WantsTable(r) {
+		rows := make([][]string, len(reservations))
+		for i, reservation := range reservations {
+			rows[i] = []string{reservation.ID, reservation.EnvironmentID, reservation.Username, reservation.Feature,
+				reservation.EndTime.UTC().Format("2006-01-02 15:04 MST"), utils.HumanizeRemaining(reservation.EndTime, now)}
+		}
+		utils.RespondWithTable(w, []string{"ID", "ENVIRONMENT", "USER", "FEATURE", "ENDS", "REMAINING"}, rows)
+		return
+	}
+
+	// Respond with the reservations
+	utils.RespondWithSuccess(w, models.ReservationResponses(reservations, now))
+}
+
+// GetMyReservations handles requests to get the current user's active reservations
+func (h *ReservationHandler) GetMyReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the user's reservations active right now
+	now := time.Now()
+	reservations, err := h.reservationRepo.ListActiveReservationsByUsername(user.Username, now)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to list reservations")
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Work out how long each reservation has left
+	result := make([]models.ReservationWithTimeRemaining, len(reservations))
+	for i, reservation := range reservations {
+		result[i] = models.ReservationWithTimeRemaining{
+			ReservationResponse: reservation.ToResponse(now),
+			Remaining:           utils.HumanizeRemaining(reservation.EndTime, now),
+		}
+	}
+
+	// Render a plain-text table for CLI clients that asked for one
+	if utils.WantsTable(r) {
+		rows := make([][]string, len(result))
+		for i, reservation := range result {
+			rows[i] = []string{reservation.ID, reservation.EnvironmentID, reservation.Feature,
+				reservation.EndTime.UTC().Format("2006-01-02 15:04 MST"), reservation.Remaining}
+		}
+		utils.RespondWithTable(w, []string{"ID", "ENVIRONMENT", "FEATURE", "ENDS", "REMAINING"}, rows)
 		return
 	}
 
 	// Respond with the reservations
-	utils.RespondWithSuccess(w, reservations)
+	utils.RespondWithSuccess(w, result)
+}
+
+// UpdateReservation handles requests to change an active reservation's auto-renew settings,
+// purpose or Jira link (owner only)
+func (h *ReservationHandler) UpdateReservation(w http.ResponseWriter, r *http.Request) {
+	// Only allow PATCH requests
+	if r.Method != http.MethodPatch {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the reservation ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Reservation ID is required")
+		return
+	}
+
+	// Parse the request body
+	var req models.ReservationUpdateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, utils.InvalidBodyMessage(err))
+		return
+	}
+
+	// Get the reservation and check that it's the user's active reservation
+	reservation, err := h.reservationRepo.GetReservation(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation")
+		return
+	}
+	if reservation.Username != user.Username {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeNotOwner, "You can only update your own reservations")
+		return
+	}
+	now := time.Now()
+	if !reservation.IsActiveAt(now) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeReservationNotActive, "Reservation is no longer active")
+		return
+	}
+
+	var purpose models.ReservationPurpose
+	if req.Purpose != nil {
+		if !h.isAllowedPurpose(*req.Purpose) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidPurpose, h.invalidPurposeMessage())
+			return
+		}
+		purpose = *req.Purpose
+	}
+	if req.JiraURL != nil {
+		if err := models.ValidateJiraURL(*req.JiraURL, h.config.JiraAllowedHosts, h.config.JiraBaseURL); err != nil {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidJiraURL, err.Error())
+			return
+		}
+	}
+
+	// Work out the new auto-renew settings
+	autoRenew := reservation.AutoRenew
+	if req.AutoRenew != nil {
+		autoRenew = *req.AutoRenew
+	}
+	var autoRenewUntil *time.Time
+	if autoRenew && (req.AutoRenewUntil != nil || reservation.AutoRenewUntil == nil) {
+		until, errMsg := h.autoRenewUntil(req.AutoRenewUntil, reservation.StartTime, reservation.EndTime)
+		if errMsg != "" {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidAutoRenew, errMsg)
+			return
+		}
+		autoRenewUntil = &until
+	}
+
+	// Update the reservation
+	if err := h.reservationRepo.UpdateReservation(id, autoRenew, autoRenewUntil, purpose, req.JiraURL); err != nil {
+		respondWithServerError(w, err, "Failed to update reservation")
+		return
+	}
+
+	reservation.AutoRenew = autoRenew
+	if autoRenewUntil != nil {
+		reservation.AutoRenewUntil = autoRenewUntil
+	}
+	if purpose != "" {
+		reservation.Purpose = purpose
+	}
+	if req.JiraURL != nil {
+		reservation.JiraURL = *req.JiraURL
+	}
+	reservation.LastUpdated = now
+
+	// Respond with the updated reservation
+	utils.RespondWithSuccess(w, reservation.ToResponse(now))
+}
+
+// TransferReservation handles requests to hand a reservation over to another user. Users can
+// transfer their own reservations; users with the force-release permission can transfer anyone's.
+func (h *ReservationHandler) TransferReservation(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the reservation ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Reservation ID is required")
+		return
+	}
+
+	// Parse the request body
+	var req models.ReservationTransferRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, utils.InvalidBodyMessage(err))
+		return
+	}
+	if req.ToUsername == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "toUsername is required")
+		return
+	}
+
+	// Get the reservation and check that the user may transfer it
+	reservation, err := h.reservationRepo.GetReservation(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation")
+		return
+	}
+	if reservation.Username != user.Username && !canForceRelease(r, user) {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeNotOwner, "You can only transfer your own reservations")
+		return
+	}
+	if !reservation.IsActiveAt(time.Now()) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeReservationNotActive, "Reservation is no longer active")
+		return
+	}
+	if reservation.Username == req.ToUsername {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeAlreadyOwner, "Reservation already belongs to "+req.ToUsername)
+		return
+	}
+
+	// Check that the new owner exists
+	if _, err := h.userRepo.GetUser(req.ToUsername); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeUserNotFound, "User "+req.ToUsername+" not found")
+			return
+		}
+		respondWithServerError(w, err, "Failed to get user")
+		return
+	}
+
+	// Transfer the reservation
+	from := reservation.Username
+	err = h.reservationRepo.TransferReservation(id, from, req.ToUsername)
+	if errors.Is(err, db.ErrReservationChanged) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeReservationChanged, "Reservation was released, expired or transferred in the meantime")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to transfer reservation")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionTransferReservation,
+		Description: fmt.Sprintf("Transferred reservation of environment %s from %s to %s", reservation.EnvironmentID, from, req.ToUsername),
+		ResourceID:  reservation.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	reservation.Username = req.ToUsername
+	reservation.LastUpdated = time.Now()
+
+	// Respond with the transferred reservation
+	utils.RespondWithSuccess(w, reservation.ToResponse(reservation.LastUpdated))
+}
+
+// canForceRelease reports whether the user may release or transfer other users' reservations.
+// Requests made with an API key also need the key's admin scope.
+func canForceRelease(r *http.Request, user models.User) bool {
+	if !user.Role.HasPermission(models.PermissionForceReleaseReservations) {
+		return false
+	}
+	if key, ok := r.Context().Value(middleware.APIKeyContextKey).(models.APIKey); ok && !key.HasScope(models.ScopeAdmin) {
+		return false
+	}
+	return true
+}
+
+// autoRenewUntil validates a requested auto-renew deadline for a reservation starting at
+// startTime and currently ending at endTime. If none was requested it defaults to the latest
+// allowed deadline. It returns an error message if the deadline is invalid.
+func (h *ReservationHandler) autoRenewUntil(requested *time.Time, startTime, endTime time.Time) (time.Time, string) {
+	latest := startTime.Add(h.config.AutoRenewMaxDuration)
+	if requested == nil {
+		return latest, ""
+	}
+	if !requested.After(endTime) {
+		return time.Time{}, "Auto-renew deadline must be after the reservation end time"
+	}
+	if requested.After(latest) {
+		return time.Time{}, "Auto-renew deadline exceeds the maximum of " + h.config.AutoRenewMaxDuration.String()
+	}
+	return *requested, ""
+}
+
+// ListAllReservations handles requests to list every reservation, including ended ones, a page
+// at a time (admin only). It can be filtered by status (all, active or expired), environmentId
+// and username; pass the nextToken of one page as pageToken to get the next.
+func (h *ReservationHandler) ListAllReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse the filters
+	query := r.URL.Query()
+	filter := models.ReservationFilter{
+		Status:        models.ReservationListStatus(query.Get("status")),
+		EnvironmentID: query.Get("environmentId"),
+		Username:      query.Get("username"),
+	}
+	switch filter.Status {
+	case "":
+		filter.Status = models.ReservationListAll
+	case models.ReservationListAll, models.ReservationListActive, models.ReservationListExpired:
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, "Status must be all, active or expired")
+		return
+	}
+
+	// Parse the page size
+	limit, ok := parsePageLimit(w, query.Get("limit"))
+	if !ok {
+		return
+	}
+
+	// Get the page
+	page, err := h.reservationRepo.ListReservations(filter, time.Now(), limit, query.Get("pageToken"))
+	if errors.Is(err, db.ErrInvalidPageToken) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidPageToken, "Invalid page token")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Respond with the page
+	utils.RespondWithSuccess(w, page.ToResponse(time.Now()))
+}
+
+// SearchReservations handles requests to find reservations, including ended ones, whose
+// feature, Git branch or Jira URL contains the q parameter, narrowed down by the user,
+// environmentId, from and to parameters. At least one of them is required so a search
+// can't dump the whole table by accident.
+func (h *ReservationHandler) SearchReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the search
+	query := r.URL.Query()
+	search := models.ReservationSearch{
+		Query:         strings.TrimSpace(query.Get("q")),
+		Username:      query.Get("user"),
+		EnvironmentID: query.Get("environmentId"),
+		ReleaseType:   models.ReleaseType(query.Get("releaseType")),
+	}
+	if search.ReleaseType != "" && !search.ReleaseType.IsValid() {
+		utils.RespondWithError(w, http.StatusBadRequest, invalidReleaseTypeMessage)
+		return
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", &search.From}, {"to", &search.To}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Invalid %s %s: timestamps must be RFC3339 with an explicit offset, e.g. 2024-01-02T15:04:05Z", param.name, raw))
+			return
+		}
+		*param.dest = &parsed
+	}
+	if search.IsEmpty() {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "At least one of q, user, environmentId, from, to or releaseType is required")
+		return
+	}
+	if search.From != nil && search.To != nil && !search.From.Before(*search.To) {
+		utils.RespondWithError(w, http.StatusBadRequest, "From must be before to")
+		return
+	}
+
+	// Parse the page size
+	limit, ok := parsePageLimit(w, query.Get("limit"))
+	if !ok {
+		return
+	}
+
+	// Get the page
+	page, err := h.reservationRepo.SearchReservations(search, limit, query.Get("pageToken"))
+	if errors.Is(err, db.ErrInvalidPageToken) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidPageToken, "Invalid page token")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to search reservations")
+		return
+	}
+
+	// Leave out reservations of other teams' environments; the page may end up shorter than the limit
+	page.Reservations, err = visibleReservations(h.envRepo, user, page.Reservations)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environments")
+		return
+	}
+
+	// Respond with the page
+	utils.RespondWithSuccess(w, page.ToResponse(time.Now()))
+}
+
+// parsePageLimit parses the optional limit parameter of a reservation listing, responding
+// with an error and returning false if it is invalid
+func parsePageLimit(w http.ResponseWriter, rawLimit string) (int, bool) {
+	if rawLimit == "" {
+		return defaultReservationPageSize, true
+	}
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 1 || limit > maxReservationPageSize {
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxReservationPageSize))
+		return 0, false
+	}
+	return limit, true
+}
+
+// ListPendingReservations handles requests to list reservations awaiting approval of the
+// environments the user can see (admin only)
+func (h *ReservationHandler) ListPendingReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the pending reservations of environments the user can see
+	reservations, err := h.reservationRepo.ListPendingReservations()
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get pending reservations")
+		return
+	}
+	reservations, err = visibleReservations(h.envRepo, user, reservations)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environments")
+		return
+	}
+
+	// Respond with the reservations
+	utils.RespondWithSuccess(w, models.ReservationResponses(reservations, time.Now()))
+}
+
+// ApproveReservation handles requests to approve a pending reservation (admin only)
+func (h *ReservationHandler) ApproveReservation(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the reservation ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Reservation ID is required")
+		return
+	}
+
+	// The reservation starts when it is approved, so check that's within the environment's allowed hours
+	pending, err := h.reservationRepo.GetReservation(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation")
+		return
+	}
+	env, err := h.envRepo.GetEnvironment(pending.EnvironmentID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	if !env.VisibleTo(admin) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	now := time.Now()
+	if env.AllowedHours != nil && !env.AllowedHours.Permits(now, now.Add(pending.RenewalPeriod())) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeOutsideAllowedHours,
+			fmt.Sprintf("Environment %s can only be reserved %s; approve the reservation within those hours", env.Name, env.AllowedHours))
+		return
+	}
+
+	// Approve the reservation
+	reservation, err := h.reservationRepo.ApproveReservation(id, admin.Username)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	if errors.Is(err, db.ErrNotPending) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeReservationNotPending, "Reservation is not pending approval")
+		return
+	}
+	if errors.Is(err, db.ErrEnvironmentUnavailable) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnavailable, "Environment is no longer available")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to approve reservation")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionApproveReservation,
+		Description: fmt.Sprintf("Approved reservation of environment %s by %s", reservation.EnvironmentID, reservation.Username),
+		ResourceID:  reservation.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Tell the requester their reservation has started
+	if err := h.notifier.Notify(reservation.Username, "Reservation approved",
+		fmt.Sprintf("Your reservation of environment %s was approved by %s and ends at %s.",
+			reservation.EnvironmentID, admin.Username, reservation.EndTime.Format(time.RFC1123))); err != nil {
+		log.Printf("Error notifying %s of approval: %v", reservation.Username, err)
+	}
+
+	// Respond with the approved reservation
+	utils.RespondWithSuccess(w, reservation.ToResponse(time.Now()))
+}
+
+// isAllowedPurpose reports whether purpose is one of the configured reservation purposes
+func (h *ReservationHandler) isAllowedPurpose(purpose models.ReservationPurpose) bool {
+	for _, allowed := range h.config.ReservationPurposes {
+		if string(purpose) == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validateReservationDetails checks a new reservation's feature description, git branch and
+// Jira link, responding with 400 and returning false if any of them is invalid
+func (h *ReservationHandler) validateReservationDetails(w http.ResponseWriter, feature, gitBranch, jiraURL string) bool {
+	if err := models.ValidateFeature(feature); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeFieldTooLong, err.Error())
+		return false
+	}
+	if err := models.ValidateGitBranch(gitBranch); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidGitBranch, err.Error())
+		return false
+	}
+	if err := models.ValidateJiraURL(jiraURL, h.config.JiraAllowedHosts, h.config.JiraBaseURL); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidJiraURL, err.Error())
+		return false
+	}
+	return true
+}
+
+// invalidPurposeMessage returns the error message for a purpose that isn't allowed
+func (h *ReservationHandler) invalidPurposeMessage() string {
+	return "Purpose must be one of " + strings.Join(h.config.ReservationPurposes, ", ")
+}
+
+// notifyApprovers tells every user who can approve reservations that one is waiting for approval
+func (h *ReservationHandler) notifyApprovers(reservation models.Reservation, envName string) {
+	users, err := h.userRepo.ListUsers()
+	if err != nil {
+		log.Printf("Error listing approvers to notify: %v", err)
+		return
+	}
+
+	message := fmt.Sprintf("%s requested environment %s for %d minutes (%s). Approve it with POST /api/admin/reservations/%s/approve.",
+		reservation.Username, envName, reservation.DurationMins, reservation.Feature, reservation.ID)
+	for _, user := range users {
+		if !user.Role.HasPermission(models.PermissionApproveReservations) {
+			continue
+		}
+		if err := h.notifier.Notify(user.Username, "Reservation awaiting approval", message); err != nil {
+			log.Printf("Error notifying %s of pending reservation: %v", user.Username, err)
+		}
+	}
+}
+
+// notifyConfirmed emails the owner of a new reservation its times, with a link to release it
+// early if BASE_URL is set
+func (h *ReservationHandler) notifyConfirmed(reservation models.Reservation, envName string) {
+	releaseURL := ""
+	if h.config.BaseURL != "" {
+		releaseURL = strings.TrimRight(h.config.BaseURL, "/") + "/reservations/" + url.PathEscape(reservation.ID) + "/release"
+	}
+	if err := notifier.NotifyReservationConfirmed(h.notifier, &reservation, envName, releaseURL); err != nil {
+		log.Printf("Error sending reservation confirmation to %s: %v", reservation.Username, err)
+	}
 }
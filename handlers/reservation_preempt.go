@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// PreemptReservation handles requests to take a reserved environment over from its holder
+// during an incident. The holder's reservation is ended and a new one created for the
+// requester in a single transaction, and the holder is notified. Only admins and API keys
+// with the oncall scope may preempt, and only reservations of lower priority.
+func (h *ReservationHandler) PreemptReservation(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+	if !canPreempt(r, user) {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodePermissionRequired, "Only admins and on-call API keys can preempt reservations")
+		return
+	}
+
+	// Parse the request body
+	var req models.ReservationPreemptRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if req.EnvironmentID == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+	if req.Feature == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Feature description is required")
+		return
+	}
+	if req.Reason == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "A reason is required to preempt a reservation")
+		return
+	}
+	if !h.validateReservationDetails(w, req.Feature, req.GitBranch, req.JiraURL) {
+		return
+	}
+	if req.Priority == 0 {
+		req.Priority = 1
+	}
+	if req.Priority < 1 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Priority must be at least 1")
+		return
+	}
+
+	// Get the environment, using a consistent read to see the latest status
+	env, err := h.envRepo.GetEnvironmentConsistent(req.EnvironmentID)
+	// Other teams' environments are reported as not found rather than revealed
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !env.VisibleTo(user)) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	if env.Archived {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvArchived, "Environment is archived")
+		return
+	}
+	if limits := env.ReservationLimits(defaultReservationLimits(h.config)); !limits.Permits(req.DurationMins) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeDurationOutOfRange,
+			fmt.Sprintf("Reservations of environment %s must last %s", env.Name, limits))
+		return
+	}
+	if env.Status != models.StatusReserved {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeNotPreemptable,
+			fmt.Sprintf("Environment is %s, not reserved, so there is nothing to preempt", env.Status))
+		return
+	}
+
+	// Get the reservation holding it, which must have a lower priority
+	now := time.Now()
+	current, err := h.reservationRepo.GetActiveReservationByEnvironmentID(env.ID, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get current reservation")
+		return
+	}
+	if current == nil {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeNotPreemptable, "Environment has no active reservation to preempt")
+		return
+	}
+	if current.Priority >= req.Priority {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeNotPreemptable,
+			fmt.Sprintf("Environment is held by a reservation of priority %d, which can't be preempted at priority %d", current.Priority, req.Priority))
+		return
+	}
+
+	// Replace the reservation; approval and allowed hours don't apply during an incident
+	reservation, err := h.reservationRepo.PreemptReservation(*current, models.Reservation{
+		Username:     user.Username,
+		StartTime:    now,
+		EndTime:      now.Add(time.Duration(req.DurationMins) * time.Minute),
+		Feature:      req.Feature,
+		GitBranch:    req.GitBranch,
+		JiraURL:      req.JiraURL,
+		DurationMins: req.DurationMins,
+		Priority:     req.Priority,
+	}, user.Username, req.Reason)
+	if errors.Is(err, db.ErrReservationChanged) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeReservationChanged, "The reservation changed while it was being preempted, try again")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to preempt reservation")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionPreemptReservation,
+		Description: fmt.Sprintf("Preempted %s's reservation of environment %s: %s", current.Username, env.Name, req.Reason),
+		ResourceID:  reservation.ID,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Tell webhooks and the preempted holder
+	preempted := *current
+	releasedAt := reservation.CreatedAt
+	preempted.EndTime = releasedAt
+	preempted.AutoRenew = false
+	preempted.Status = models.ReservationStatusReleased
+	preempted.ReleaseType = models.ReleasePreempted
+	preempted.ReleaseReason = req.Reason
+	preempted.ReleasedAt = &releasedAt
+	preempted.ReleasedBy = user.Username
+	h.webhooks.Dispatch(models.EventReservationReleased, &preempted)
+	h.webhooks.Dispatch(models.EventReservationCreated, reservation)
+	h.events.Publish(models.EventReservationReleased, &preempted)
+	h.events.Publish(models.EventReservationCreated, reservation)
+	if err := h.notifier.Notify(preempted.Username, "Reservation preempted",
+		fmt.Sprintf("Your reservation of environment %s was ended early by %s for an urgent reservation: %s",
+			env.Name, user.Username, req.Reason)); err != nil {
+		log.Printf("Error notifying %s of preemption: %v", preempted.Username, err)
+	}
+
+	// Respond with both reservations
+	utils.RespondWithCreated(w, "/api/reservations/"+url.PathEscape(reservation.ID), models.PreemptionResult{
+		Reservation: reservation.ToResponse(now),
+		Preempted:   preempted.ToResponse(now),
+	})
+}
+
+// canPreempt reports whether the user may preempt reservations: admins, whose API keys also
+// need the admin scope, and API keys with the oncall scope whatever their user's role
+func canPreempt(r *http.Request, user models.User) bool {
+	if key, ok := r.Context().Value(middleware.APIKeyContextKey).(models.APIKey); ok {
+		if key.HasScope(models.ScopeOncall) {
+			return true
+		}
+		return key.HasScope(models.ScopeAdmin) && user.Role.HasPermission(models.PermissionPreemptReservations)
+	}
+	return user.Role.HasPermission(models.PermissionPreemptReservations)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// ReassignReservations handles requests to move an environment's active reservations to
+// another, free environment, such as when the environment is being decommissioned (admin
+// only). Each reservation is moved in a transaction that frees the old environment and
+// reserves the new one, and its holder is notified. Pending reservations are left alone.
+func (h *ReservationHandler) ReassignReservations(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the source environment ID from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Environment ID is required")
+		return
+	}
+
+	// Parse the request body
+	var req models.ReservationReassignRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.ToEnvironmentID == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Target environment ID is required")
+		return
+	}
+	if req.ToEnvironmentID == id {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidRequest, "Reservations can't be moved to the environment they are on")
+		return
+	}
+
+	// Get both environments, using consistent reads to see their latest status
+	source, err := h.envRepo.GetEnvironmentConsistent(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get environment")
+		return
+	}
+	target, err := h.envRepo.GetEnvironmentConsistent(req.ToEnvironmentID)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeEnvNotFound, "Target environment not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get target environment")
+		return
+	}
+	if target.Archived {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvArchived, "Target environment is archived")
+		return
+	}
+	if target.Status != models.StatusFree {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvUnavailable,
+			fmt.Sprintf("Target environment %s is %s, not free", target.Name, target.Status))
+		return
+	}
+
+	// Find the source's active reservations
+	now := time.Now()
+	reservations, err := h.reservationRepo.ListReservationsByEnvironmentID(source.ID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list reservations")
+		return
+	}
+
+	// Move them one at a time
+	moved := 0
+	for _, reservation := range reservations {
+		if !reservation.IsActiveAt(now) || reservation.IsPending() {
+			continue
+		}
+
+		err := h.reservationRepo.ReassignReservation(reservation, target.ID)
+		if errors.Is(err, db.ErrReservationChanged) {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeReservationChanged,
+				fmt.Sprintf("Moved %d reservations, then a reservation or environment changed in the meantime, try again", moved))
+			return
+		}
+		if err != nil {
+			respondWithServerError(w, err, "Failed to reassign reservation")
+			return
+		}
+		moved++
+
+		// Record the action in the audit log
+		if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+			Actor:       user.Username,
+			Action:      models.AuditActionReassignReservation,
+			Description: fmt.Sprintf("Moved %s's reservation from environment %s to %s", reservation.Username, source.Name, target.Name),
+			ResourceID:  reservation.ID,
+		}); err != nil {
+			log.Printf("Error recording audit log entry: %v", err)
+		}
+
+		// Tell the holder where their reservation went
+		if err := h.notifier.Notify(reservation.Username, "Reservation moved",
+			fmt.Sprintf("Your reservation of environment %s was moved to environment %s by %s", source.Name, target.Name, user.Username)); err != nil {
+			log.Printf("Error notifying %s of reassignment: %v", reservation.Username, err)
+		}
+	}
+
+	// Tell WebSocket clients about both environments
+	if moved > 0 {
+		h.realtime.EnvironmentChanged(source.ID)
+		h.realtime.EnvironmentChanged(target.ID)
+	}
+
+	// Respond with the number of reservations moved
+	utils.RespondWithSuccess(w, models.ReservationReassignResult{Moved: moved})
+}
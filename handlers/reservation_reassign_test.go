@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+)
+
+func TestReassignReservations(t *testing.T) {
+	target := models.Environment{ID: "env-target", Name: "payments-2", Team: "payments", Status: models.StatusFree}
+	busy := models.Environment{ID: "env-busy", Name: "payments-3", Team: "payments", Status: models.StatusReserved}
+	archived := models.Environment{ID: "env-archived", Name: "payments-4", Team: "payments", Status: models.StatusFree, Archived: true}
+	envRepo := newEnvRepo(paymentsEnv, target, busy, archived)
+
+	var moved []string
+	reservationRepo := newReservationRepo()
+	reservationRepo.ListReservationsByEnvironmentIDFunc = func(string) ([]models.Reservation, error) {
+		ended := reservationOf("res-ended", paymentsEnv, "bob")
+		ended.Status = models.ReservationStatusReleased
+		return []models.Reservation{reservationOf("res-1", paymentsEnv, "alice"), ended}, nil
+	}
+	reservationRepo.ReassignReservationFunc = func(reservation models.Reservation, toEnvironmentID string) error {
+		moved = append(moved, reservation.ID)
+		return nil
+	}
+	audit := newAuditLog()
+	handler := newReservationHandler(envRepo, reservationRepo, audit)
+
+	var result models.ReservationReassignResult
+	decodeData(t, serve(handler.ReassignReservations, request(http.MethodPost, "/api/admin/environments/env-pay/reassign-reservations", &admin,
+		map[string]string{"id": paymentsEnv.ID}, `{"toEnvironmentId": "env-target"}`)), &result)
+	if result.Moved != 1 || !reflect.DeepEqual(moved, []string{"res-1"}) {
+		t.Errorf("moved %v (%d), want only the active res-1", moved, result.Moved)
+	}
+	if got, want := audit.recorded(), []models.AuditAction{models.AuditActionReassignReservation}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %v, want %v", got, want)
+	}
+
+	reservationRepo.ReassignReservationFunc = func(models.Reservation, string) error {
+		return db.ErrReservationChanged
+	}
+	tests := []struct {
+		name     string
+		id       string
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"no target", paymentsEnv.ID, `{}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"malformed body", paymentsEnv.ID, `{"toEnvironmentId":`, http.StatusBadRequest, "INVALID_BODY"},
+		{"same environment", paymentsEnv.ID, `{"toEnvironmentId": "env-pay"}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"unknown source", "env-gone", `{"toEnvironmentId": "env-target"}`, http.StatusNotFound, "ENV_NOT_FOUND"},
+		{"unknown target", paymentsEnv.ID, `{"toEnvironmentId": "env-gone"}`, http.StatusNotFound, "ENV_NOT_FOUND"},
+		{"archived target", paymentsEnv.ID, `{"toEnvironmentId": "env-archived"}`, http.StatusConflict, "ENV_ARCHIVED"},
+		{"target not free", paymentsEnv.ID, `{"toEnvironmentId": "env-busy"}`, http.StatusConflict, "ENV_UNAVAILABLE"},
+		{"reservation changed meanwhile", paymentsEnv.ID, `{"toEnvironmentId": "env-target"}`, http.StatusConflict, "RESERVATION_CHANGED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.ReassignReservations, request(http.MethodPost, "/api/admin/environments/"+tt.id+"/reassign-reservations", &admin,
+				map[string]string{"id": tt.id}, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
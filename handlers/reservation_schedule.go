@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// scheduleReservation reserves an environment for a time window that starts later. The
+// reservation is SCHEDULED until the activation loop reserves the environment at its start
+// time, and nobody else can reserve the environment for that window in the meantime. A
+// non-empty seriesID makes it the first reservation of a recurring reservation.
+func (h *ReservationHandler) scheduleReservation(w http.ResponseWriter, user models.User, req models.ReservationCreateRequest, env *models.Environment, seriesID string) {
+	if env.RequiresApproval {
+		utils.RespondWithError(w, http.StatusBadRequest, "Environments that require approval can't be reserved for later")
+		return
+	}
+
+	// Check the time window
+	now := time.Now()
+	startTime := *req.StartTime
+	endTime := startTime.Add(time.Duration(req.DurationMins) * time.Minute)
+	if env.AllowedHours != nil && !env.AllowedHours.Permits(startTime, endTime) {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeOutsideAllowedHours,
+			fmt.Sprintf("Environment %s can only be reserved %s; reservations must start and end within those hours", env.Name, env.AllowedHours))
+		return
+	}
+
+	// The window must be clear of the current reservation and other scheduled ones
+	current, err := h.reservationRepo.GetActiveReservationByEnvironmentID(env.ID, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get current reservation")
+		return
+	}
+	if current != nil && current.EndTime.After(startTime) {
+		utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvAlreadyReserved,
+			fmt.Sprintf("Environment is reserved until %s, after the requested start time", current.EndTime.UTC().Format(time.RFC3339)))
+		return
+	}
+	conflict, err := h.scheduledConflict(env.ID, startTime, endTime)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get scheduled reservations")
+		return
+	}
+	if conflict != nil {
+		respondScheduled(w, conflict, startTime)
+		return
+	}
+
+	reservation := models.Reservation{
+		EnvironmentID: env.ID,
+		Username:      user.Username,
+		StartTime:     startTime,
+		EndTime:       endTime,
+		Feature:       req.Feature,
+		GitBranch:     req.GitBranch,
+		JiraURL:       req.JiraURL,
+		DurationMins:  req.DurationMins,
+		Purpose:       req.Purpose,
+		SeriesID:      seriesID,
+	}
+
+	// Set up auto-renew, bounded by the maximum auto-renew duration from the start time
+	if req.AutoRenew {
+		autoRenewUntil, errMsg := h.autoRenewUntil(req.AutoRenewUntil, startTime, endTime)
+		if errMsg != "" {
+			utils.RespondWithError(w, http.StatusBadRequest, errMsg)
+			return
+		}
+		reservation.AutoRenew = true
+		reservation.AutoRenewUntil = &autoRenewUntil
+	}
+
+	scheduled, err := h.reservationRepo.ScheduleReservation(reservation)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to schedule reservation")
+		return
+	}
+	h.webhooks.Dispatch(models.EventReservationCreated, scheduled)
+	h.events.Publish(models.EventReservationCreated, scheduled)
+	go h.notifyConfirmed(*scheduled, env.Name)
+
+	// Schedule the rest of a recurring reservation
+	if seriesID != "" {
+		h.scheduleSeries(w, req, scheduled)
+		return
+	}
+
+	// Respond with the scheduled reservation
+	utils.RespondWithCreated(w, "/api/reservations/"+url.PathEscape(scheduled.ID), scheduled.ToResponse(time.Now()))
+}
+
+// scheduledConflict returns the first scheduled reservation of an environment whose time
+// window overlaps the one from start to end, or nil if there is none
+func (h *ReservationHandler) scheduledConflict(environmentID string, start, end time.Time) (*models.Reservation, error) {
+	scheduled, err := h.reservationRepo.ListScheduledReservationsByEnvironmentID(environmentID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range scheduled {
+		if scheduled[i].Overlaps(start, end) {
+			return &scheduled[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// respondScheduled rejects a reservation whose time window, starting at start, overlaps a
+// scheduled reservation, telling the client how long it could reserve the environment for instead
+func respondScheduled(w http.ResponseWriter, conflict *models.Reservation, start time.Time) {
+	message := fmt.Sprintf("Environment is scheduled for another reservation from %s to %s",
+		conflict.StartTime.UTC().Format(time.RFC3339), conflict.EndTime.UTC().Format(time.RFC3339))
+	if maxMins := int(conflict.StartTime.Sub(start) / time.Minute); maxMins > 0 {
+		message += fmt.Sprintf("; reserve it for at most %d minutes", maxMins)
+	}
+	utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvScheduled, message)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// seriesInterval is the time between the reservations of a recurring reservation
+const seriesInterval = 24 * time.Hour
+
+// checkSeries checks that a recurring reservation can be made before any of its reservations
+// is created: every day after the first must be clear of the environment's current and
+// scheduled reservations and within its allowed hours. It responds and returns false if not.
+// The first day is checked by the usual reservation or scheduling path.
+func (h *ReservationHandler) checkSeries(w http.ResponseWriter, req models.ReservationCreateRequest, env *models.Environment) bool {
+	message := ""
+	switch {
+	case req.Queue:
+		message = "Recurring reservations can't join the waitlist"
+	case req.AutoRenew:
+		message = "Recurring reservations can't auto-renew"
+	case env.RequiresApproval:
+		message = "Environments that require approval can't be reserved every day"
+	case time.Duration(req.DurationMins)*time.Minute >= seriesInterval:
+		message = "Recurring reservations must last less than a day"
+	}
+	if message != "" {
+		utils.RespondWithError(w, http.StatusBadRequest, message)
+		return false
+	}
+
+	now := time.Now()
+	start := now
+	if req.StartTime != nil && req.StartTime.After(now) {
+		start = *req.StartTime
+	}
+	current, err := h.reservationRepo.GetActiveReservationByEnvironmentID(env.ID, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get current reservation")
+		return false
+	}
+	scheduled, err := h.reservationRepo.ListScheduledReservationsByEnvironmentID(env.ID, now)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get scheduled reservations")
+		return false
+	}
+
+	for day := 1; day < req.RecurrenceDays; day++ {
+		dayStart := start.Add(time.Duration(day) * seriesInterval)
+		dayEnd := dayStart.Add(time.Duration(req.DurationMins) * time.Minute)
+		if env.AllowedHours != nil && !env.AllowedHours.Permits(dayStart, dayEnd) {
+			utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeOutsideAllowedHours,
+				fmt.Sprintf("Environment %s can only be reserved %s; day %d of the series falls outside those hours", env.Name, env.AllowedHours, day+1))
+			return false
+		}
+		if current != nil && current.EndTime.After(dayStart) {
+			utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvAlreadyReserved,
+				fmt.Sprintf("Environment is reserved until %s, after day %d of the series starts", current.EndTime.UTC().Format(time.RFC3339), day+1))
+			return false
+		}
+		for i := range scheduled {
+			if scheduled[i].Overlaps(dayStart, dayEnd) {
+				utils.RespondWithErrorCode(w, http.StatusConflict, utils.ErrCodeEnvScheduled,
+					fmt.Sprintf("Environment is scheduled for another reservation from %s to %s, overlapping day %d of the series",
+						scheduled[i].StartTime.UTC().Format(time.RFC3339), scheduled[i].EndTime.UTC().Format(time.RFC3339), day+1))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// scheduleSeries schedules the reservations of a recurring reservation after its first one,
+// one a day at the same time, and responds with the whole series
+func (h *ReservationHandler) scheduleSeries(w http.ResponseWriter, req models.ReservationCreateRequest, first *models.Reservation) {
+	now := time.Now()
+	series := models.ReservationSeries{
+		SeriesID:     first.SeriesID,
+		Reservations: []models.ReservationResponse{first.ToResponse(now)},
+	}
+	for day := 1; day < req.RecurrenceDays; day++ {
+		startTime := first.StartTime.Add(time.Duration(day) * seriesInterval)
+		scheduled, err := h.reservationRepo.ScheduleReservation(models.Reservation{
+			EnvironmentID: first.EnvironmentID,
+			Username:      first.Username,
+			StartTime:     startTime,
+			EndTime:       startTime.Add(time.Duration(first.DurationMins) * time.Minute),
+			Feature:       first.Feature,
+			GitBranch:     first.GitBranch,
+			JiraURL:       first.JiraURL,
+			DurationMins:  first.DurationMins,
+			Purpose:       first.Purpose,
+			SeriesID:      first.SeriesID,
+		})
+		if err != nil {
+			respondWithServerError(w, err, fmt.Sprintf("Failed to schedule day %d of the series; cancel series %s to remove the reservations already made", day+1, first.SeriesID))
+			return
+		}
+		h.webhooks.Dispatch(models.EventReservationCreated, scheduled)
+		h.events.Publish(models.EventReservationCreated, scheduled)
+		series.Reservations = append(series.Reservations, scheduled.ToResponse(now))
+	}
+
+	// Respond with the series
+	utils.RespondWithCreated(w, "", series)
+}
+
+// CancelReservationSeries handles requests to cancel the reservations of a recurring
+// reservation that haven't started yet. Reservations that have started or ended are left alone.
+func (h *ReservationHandler) CancelReservationSeries(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the series ID from the URL parameters
+	vars := mux.Vars(r)
+	seriesID := vars["seriesID"]
+	if seriesID == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Series ID is required")
+		return
+	}
+
+	// Get the series' reservations; users with the force-release permission may cancel anyone's
+	members, err := h.reservationRepo.ListReservationsBySeries(seriesID)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get reservation series")
+		return
+	}
+	if len(members) == 0 {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeReservationNotFound, "Reservation series not found")
+		return
+	}
+	force := canForceRelease(r, user)
+	if members[0].Username != user.Username && !force {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeNotOwner, "You can only cancel your own reservation series")
+		return
+	}
+
+	// Cancel the reservations that haven't started yet
+	now := time.Now()
+	meta := h.requestMeta(r, user.Username)
+	result := models.ReservationSeriesCancelResult{SeriesID: seriesID}
+	for _, member := range members {
+		if !member.IsScheduledAt(now) || !member.StartTime.After(now) {
+			continue
+		}
+		err := h.reservationRepo.ReleaseReservation(member.ID, meta, "Reservation series cancelled", force)
+		if errors.Is(err, db.ErrReservationChanged) {
+			// It was cancelled on its own in the meantime
+			continue
+		}
+		if err != nil {
+			respondWithServerError(w, err, fmt.Sprintf("Failed to cancel reservation %s after cancelling %d", member.ID, result.Cancelled))
+			return
+		}
+		result.Cancelled++
+
+		if cancelled, err := h.reservationRepo.GetReservation(member.ID); err != nil {
+			log.Printf("Error getting cancelled reservation %s: %v", member.ID, err)
+		} else {
+			h.webhooks.Dispatch(models.EventReservationReleased, cancelled)
+			h.events.Publish(models.EventReservationReleased, cancelled)
+		}
+	}
+
+	// Respond with the number of reservations cancelled
+	utils.RespondWithSuccess(w, result)
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+)
+
+// newReservationRepo returns a mock reservation repository in which no environment has an
+// active reservation and every reservation passes the reservation rules
+func newReservationRepo() *mock.MockReservationRepository {
+	return &mock.MockReservationRepository{
+		GetActiveReservationByEnvironmentIDFunc: func(string, time.Time) (*models.Reservation, error) {
+			return nil, nil
+		},
+		CheckReservationRulesFunc: func(models.Environment, models.User, time.Time, time.Time) error {
+			return nil
+		},
+	}
+}
+
+func TestCreateReservation(t *testing.T) {
+	reservationRepo := newReservationRepo()
+	var created models.Reservation
+	reservationRepo.CreateReservationFunc = func(reservation models.Reservation) (*models.Reservation, error) {
+		created = reservation
+		reservation.ID = "res-1"
+		reservation.Status = models.ReservationStatusActive
+		return &reservation, nil
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv, searchEnv), reservationRepo, newAuditLog())
+
+	rec := serve(handler.CreateReservation, request(http.MethodPost, "/api/reservations", &alice, nil,
+		`{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout"}`))
+	resp := decode(t, rec, http.StatusCreated)
+	if !resp.Success {
+		t.Fatalf("response = %+v, want success", resp)
+	}
+	if got := rec.Header().Get("Location"); got != "/api/reservations/res-1" {
+		t.Errorf("Location = %q, want /api/reservations/res-1", got)
+	}
+	if created.EnvironmentID != paymentsEnv.ID || created.Username != alice.Username || created.Feature != "checkout" {
+		t.Errorf("created %+v, want alice's reservation of env-pay for checkout", created)
+	}
+	if got := created.EndTime.Sub(created.StartTime); got != time.Hour {
+		t.Errorf("reservation lasts %v, want 1h", got)
+	}
+}
+
+func TestCreateReservationRejectsInvalidRequests(t *testing.T) {
+	// None of these get as far as creating a reservation
+	handler := newReservationHandler(newEnvRepo(paymentsEnv, searchEnv), newReservationRepo(), newAuditLog())
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"no user", nil, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout"}`, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"malformed body", &alice, `{"environmentId":`, http.StatusBadRequest, "INVALID_BODY"},
+		{"no environment", &alice, `{"durationMins": 60, "feature": "checkout"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"no feature", &alice, `{"environmentId": "env-pay", "durationMins": 60}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"environment and group", &alice, `{"environmentId": "env-pay", "environmentGroupId": "grp-pay", "durationMins": 60, "feature": "checkout"}`, http.StatusBadRequest, "CONFLICTING_FIELDS"},
+		{"negative recurrence", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "recurrenceDays": -1}`, http.StatusBadRequest, "RECURRENCE_OUT_OF_RANGE"},
+		{"too many recurrences", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "recurrenceDays": 31}`, http.StatusBadRequest, "RECURRENCE_OUT_OF_RANGE"},
+		{"recurring group", &alice, `{"environmentGroupId": "grp-pay", "durationMins": 60, "feature": "checkout", "recurrenceDays": 2}`, http.StatusBadRequest, "ENV_GROUP_UNSUPPORTED"},
+		{"scheduled group", &alice, `{"environmentGroupId": "grp-pay", "durationMins": 60, "feature": "checkout", "startTime": "2099-01-01T09:00:00Z"}`, http.StatusBadRequest, "ENV_GROUP_UNSUPPORTED"},
+		{"queued group", &alice, `{"environmentGroupId": "grp-pay", "durationMins": 60, "feature": "checkout", "queue": true}`, http.StatusBadRequest, "ENV_GROUP_UNSUPPORTED"},
+		{"auto-renew deadline before the end", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "autoRenew": true, "autoRenewUntil": "2000-01-01T00:00:00Z"}`, http.StatusBadRequest, "INVALID_AUTO_RENEW"},
+		{"no duration", &alice, `{"environmentId": "env-pay", "feature": "checkout"}`, http.StatusBadRequest, "DURATION_OUT_OF_RANGE"},
+		{"too long", &alice, `{"environmentId": "env-pay", "durationMins": 481, "feature": "checkout"}`, http.StatusBadRequest, "DURATION_OUT_OF_RANGE"},
+		{"feature too long", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "` + strings.Repeat("a", models.MaxFeatureLength+1) + `"}`, http.StatusBadRequest, "FIELD_TOO_LONG"},
+		{"invalid git branch", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "gitBranch": "a..b"}`, http.StatusBadRequest, "INVALID_GIT_BRANCH"},
+		{"script Jira URL", &alice, `{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "jiraUrl": "javascript:alert(1)"}`, http.StatusBadRequest, "INVALID_JIRA_URL"},
+		{"unknown environment", &alice, `{"environmentId": "env-gone", "durationMins": 60, "feature": "checkout"}`, http.StatusNotFound, "ENV_NOT_FOUND"},
+		{"other team's environment", &alice, `{"environmentId": "env-search", "durationMins": 60, "feature": "checkout"}`, http.StatusNotFound, "ENV_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.CreateReservation, request(http.MethodPost, "/api/reservations", tt.user, nil, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
+
+func TestCreateReservationOfReservedEnvironment(t *testing.T) {
+	reservationRepo := newReservationRepo()
+	holder := reservationOf("res-carol", searchEnv, "carol")
+	reservationRepo.GetActiveReservationByEnvironmentIDFunc = func(string, time.Time) (*models.Reservation, error) {
+		reservation := holder
+		return &reservation, nil
+	}
+	handler := newReservationHandler(newEnvRepo(searchEnv), reservationRepo, newAuditLog())
+
+	rec := serve(handler.CreateReservation, request(http.MethodPost, "/api/reservations", &bob, nil,
+		`{"environmentId": "env-search", "durationMins": 60, "feature": "ranking"}`))
+	resp := decode(t, rec, http.StatusConflict)
+	if resp.ErrorCode != "ENV_ALREADY_RESERVED" {
+		t.Errorf("error code = %s, want ENV_ALREADY_RESERVED", resp.ErrorCode)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	var conflict models.ReservationConflict
+	decodeRaw(t, resp.Data, &conflict)
+	if conflict.Holder != "carol" {
+		t.Errorf("holder = %q, want carol", conflict.Holder)
+	}
+}
+
+func TestCreateReservationTakenInTheMeantime(t *testing.T) {
+	// The environment is free when read but someone else reserves it before the write
+	reservationRepo := newReservationRepo()
+	reservationRepo.CreateReservationFunc = func(models.Reservation) (*models.Reservation, error) {
+		return nil, db.ErrEnvironmentUnavailable
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+
+	rec := serve(handler.CreateReservation, request(http.MethodPost, "/api/reservations", &alice, nil,
+		`{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout"}`))
+	expectError(t, rec, http.StatusConflict, "ENV_ALREADY_RESERVED")
+}
+
+func TestReleaseReservation(t *testing.T) {
+	released := reservationOf("res-1", paymentsEnv, alice.Username)
+	released.Status = models.ReservationStatusReleased
+
+	tests := []struct {
+		name       string
+		user       models.User
+		releaseErr error
+		status     int
+		wantCode   string
+		wantForce  bool
+	}{
+		{"own reservation", alice, nil, http.StatusNoContent, "", false},
+		{"someone else's", bob, db.ErrNotOwner, http.StatusForbidden, "NOT_OWNER", false},
+		{"someone else's as an admin", admin, nil, http.StatusNoContent, "", true},
+		{"already released", alice, db.ErrReservationChanged, http.StatusConflict, "RESERVATION_CHANGED", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservationRepo := newReservationRepo()
+			var force bool
+			reservationRepo.ReleaseReservationFunc = func(id string, meta models.RequestMeta, reason string, f bool) error {
+				if id != "res-1" || meta.Username != tt.user.Username {
+					t.Errorf("released %s as %s, want res-1 as %s", id, meta.Username, tt.user.Username)
+				}
+				force = f
+				return tt.releaseErr
+			}
+			reservationRepo.GetReservationFunc = func(string) (*models.Reservation, error) {
+				reservation := released
+				return &reservation, nil
+			}
+			handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+
+			rec := serve(handler.ReleaseReservation, request(http.MethodPost, "/api/reservations/res-1/release", &tt.user,
+				map[string]string{"id": "res-1"}, ""))
+			if tt.wantCode != "" {
+				expectError(t, rec, tt.status, tt.wantCode)
+				return
+			}
+			if rec.Code != tt.status || rec.Body.Len() != 0 {
+				t.Fatalf("status = %d, want %d with no body; body %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if force != tt.wantForce {
+				t.Errorf("force = %v, want %v", force, tt.wantForce)
+			}
+		})
+	}
+}
+
+func TestReleaseUnknownReservation(t *testing.T) {
+	// IDs that aren't reservations are tried as group reservations before giving up
+	reservationRepo := newReservationRepo()
+	reservationRepo.ReleaseReservationFunc = func(string, models.RequestMeta, string, bool) error {
+		return db.ErrNotFound
+	}
+	reservationRepo.ListReservationsByGroupFunc = func(string) ([]models.Reservation, error) {
+		return nil, nil
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+
+	rec := serve(handler.ReleaseReservation, request(http.MethodPost, "/api/reservations/res-gone/release", &alice,
+		map[string]string{"id": "res-gone"}, ""))
+	expectError(t, rec, http.StatusNotFound, "RESERVATION_NOT_FOUND")
+}
+
+func TestReleaseReservationFailure(t *testing.T) {
+	reservationRepo := newReservationRepo()
+	reservationRepo.ReleaseReservationFunc = func(string, models.RequestMeta, string, bool) error {
+		return errors.New("throttled")
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+
+	rec := serve(handler.ReleaseReservation, request(http.MethodPost, "/api/reservations/res-1/release", &alice,
+		map[string]string{"id": "res-1"}, ""))
+	decode(t, rec, http.StatusInternalServerError)
+}
+
+func TestReleaseReservationRecordsWhoReleasedIt(t *testing.T) {
+	reservationRepo := newReservationRepo()
+	var meta models.RequestMeta
+	reservationRepo.ReleaseReservationFunc = func(id string, m models.RequestMeta, reason string, force bool) error {
+		meta = m
+		return nil
+	}
+	reservationRepo.GetReservationFunc = func(string) (*models.Reservation, error) {
+		reservation := reservationOf("res-1", paymentsEnv, alice.Username)
+		return &reservation, nil
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+	handler.trustedProxies = config.Config{TrustedProxies: []string{"10.0.0.0/8"}}.TrustedProxyNetworks()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		wantIP     string
+	}{
+		{"through the proxy", "10.1.2.3:443", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"around the proxy", "203.0.113.9:5000", "10.1.2.3", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := request(http.MethodPost, "/api/reservations/res-1/release", &alice, map[string]string{"id": "res-1"}, "")
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+			r.Header.Set("User-Agent", "devreserve-cli/1.2")
+			if rec := serve(handler.ReleaseReservation, r); rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusNoContent, rec.Body.String())
+			}
+			want := models.RequestMeta{Username: alice.Username, IP: tt.wantIP, UserAgent: "devreserve-cli/1.2"}
+			if meta != want {
+				t.Errorf("release recorded %+v, want %+v", meta, want)
+			}
+		})
+	}
+}
+
+func TestGetActiveReservationsFilters(t *testing.T) {
+	// The mock applies the filter the way the repository does
+	soon := reservationOf("res-soon", paymentsEnv, "alice")
+	soon.EndTime = time.Now().Add(10 * time.Minute)
+	later := reservationOf("res-later", paymentsEnv, "mona")
+	shared := reservationOf("res-shared", sharedEnv, "alice")
+	hidden := reservationOf("res-hidden", searchEnv, "alice")
+	reservationRepo := newReservationRepo()
+	reservationRepo.ListActiveReservationsFunc = func(filter models.ActiveReservationFilter, now time.Time) ([]models.Reservation, error) {
+		matching := []models.Reservation{}
+		for _, reservation := range []models.Reservation{soon, later, shared, hidden} {
+			if filter.Matches(reservation, now) {
+				matching = append(matching, reservation)
+			}
+		}
+		return matching, nil
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv, searchEnv, sharedEnv), reservationRepo, newAuditLog())
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"no filter", "", []string{"res-soon", "res-later", "res-shared"}},
+		{"environment", "?environmentId=env-pay", []string{"res-soon", "res-later"}},
+		{"username", "?username=alice", []string{"res-soon", "res-shared"}},
+		{"expiring soon", "?expiringWithinMins=30", []string{"res-soon"}},
+		{"environment and username", "?environmentId=env-pay&username=mona", []string{"res-later"}},
+		{"every filter", "?environmentId=env-pay&username=alice&expiringWithinMins=30", []string{"res-soon"}},
+		{"nothing matching", "?environmentId=env-shared&username=mona", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.GetActiveReservations, request(http.MethodGet, "/api/reservations"+tt.query, &alice, nil, ""))
+			resp := decode(t, rec, http.StatusOK)
+			var reservations []models.ReservationResponse
+			decodeRaw(t, resp.Data, &reservations)
+			if reservations == nil {
+				t.Fatalf("data = %s, want a list", resp.Data)
+			}
+			if got := reservationIDs(reservations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reservations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		query    string
+		status   int
+		wantCode string
+	}{
+		{"minutes not a number", "?expiringWithinMins=soon", http.StatusBadRequest, "INVALID_FILTER"},
+		{"minutes not positive", "?expiringWithinMins=0", http.StatusBadRequest, "INVALID_FILTER"},
+		{"unknown environment", "?environmentId=env-gone", http.StatusNotFound, "ENV_NOT_FOUND"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.GetActiveReservations, request(http.MethodGet, "/api/reservations"+tt.query, &alice, nil, ""))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
+
+func TestSearchReservations(t *testing.T) {
+	// Two pages; the first holds a reservation of an environment alice can't see
+	var searched []models.ReservationSearch
+	reservationRepo := newReservationRepo()
+	reservationRepo.SearchReservationsFunc = func(search models.ReservationSearch, limit int, pageToken string) (*models.ReservationPage, error) {
+		searched = append(searched, search)
+		switch pageToken {
+		case "":
+			return &models.ReservationPage{
+				Reservations: []models.Reservation{reservationOf("res-1", paymentsEnv, "alice"), reservationOf("res-hidden", searchEnv, "alice")},
+				NextToken:    "page-2",
+			}, nil
+		case "page-2":
+			return &models.ReservationPage{Reservations: []models.Reservation{reservationOf("res-2", sharedEnv, "alice")}}, nil
+		}
+		return nil, db.ErrInvalidPageToken
+	}
+	handler := newReservationHandler(newEnvRepo(paymentsEnv, searchEnv, sharedEnv), reservationRepo, newAuditLog())
+
+	var first models.ReservationResponsePage
+	decodeData(t, serve(handler.SearchReservations, request(http.MethodGet,
+		"/api/reservations/search?q=+PAY-1234+&user=alice&from=2024-03-04T09:00:00-05:00&to=2024-03-05T00:00:00Z", &alice, nil, "")), &first)
+	if got := reservationIDs(first.Reservations); !reflect.DeepEqual(got, []string{"res-1"}) || first.NextToken != "page-2" {
+		t.Fatalf("first page = %v, next %q; want [res-1], next page-2", got, first.NextToken)
+	}
+	from := time.Date(2024, 3, 4, 14, 0, 0, 0, time.UTC)
+	if search := searched[0]; search.Query != "PAY-1234" || search.Username != "alice" || search.From == nil || !search.From.Equal(from) || search.To == nil {
+		t.Errorf("search = %+v, want q PAY-1234 by alice from %s", search, from)
+	}
+
+	// The token from the first page gets the second, with the same search
+	var second models.ReservationResponsePage
+	decodeData(t, serve(handler.SearchReservations, request(http.MethodGet,
+		"/api/reservations/search?q=PAY-1234&user=alice&pageToken="+first.NextToken, &alice, nil, "")), &second)
+	if got := reservationIDs(second.Reservations); !reflect.DeepEqual(got, []string{"res-2"}) || second.NextToken != "" {
+		t.Errorf("second page = %v, next %q; want [res-2] and no next page", got, second.NextToken)
+	}
+
+	// Filters alone are enough
+	decodeData(t, serve(handler.SearchReservations, request(http.MethodGet, "/api/reservations/search?environmentId=env-pay", &alice, nil, "")), &first)
+	if search := searched[len(searched)-1]; search.Query != "" || search.EnvironmentID != "env-pay" {
+		t.Errorf("search = %+v, want only environment env-pay", search)
+	}
+
+	invalid := []struct {
+		name     string
+		query    string
+		user     *models.User
+		status   int
+		wantCode string
+	}{
+		{"no constraints", "", &alice, http.StatusBadRequest, "MISSING_FIELD"},
+		{"blank query", "?q=+++", &alice, http.StatusBadRequest, "MISSING_FIELD"},
+		{"time without an offset", "?from=2024-03-04T09:00:00", &alice, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"from after to", "?from=2024-03-05T00:00:00Z&to=2024-03-04T00:00:00Z", &alice, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"unknown release type", "?releaseType=LOST", &alice, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"limit too large", "?q=PAY&limit=100000", &alice, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"invalid page token", "?q=PAY&pageToken=bogus", &alice, http.StatusBadRequest, "INVALID_PAGE_TOKEN"},
+		{"no user", "?q=PAY", nil, http.StatusUnauthorized, "UNAUTHORIZED"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.SearchReservations, request(http.MethodGet, "/api/reservations/search"+tt.query, tt.user, nil, ""))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
+
+func TestCreateScheduledReservationStartTime(t *testing.T) {
+	year := time.Now().Year() + 1
+	tests := []struct {
+		name      string
+		startTime string
+		status    int
+		wantStart time.Time
+	}{
+		{"UTC", fmt.Sprintf("%d-01-02T15:04:05Z", year), http.StatusCreated, time.Date(year, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"with an offset", fmt.Sprintf("%d-01-02T15:04:05+05:30", year), http.StatusCreated, time.Date(year, 1, 2, 9, 34, 5, 0, time.UTC)},
+		{"without an offset", fmt.Sprintf("%d-01-02T15:04:05", year), http.StatusBadRequest, time.Time{}},
+		{"not RFC3339", fmt.Sprintf("01/02/%d 15:04", year), http.StatusBadRequest, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservationRepo := newReservationRepo()
+			reservationRepo.ListScheduledReservationsByEnvironmentIDFunc = func(string, time.Time) ([]models.Reservation, error) {
+				return nil, nil
+			}
+			var scheduled models.Reservation
+			reservationRepo.ScheduleReservationFunc = func(reservation models.Reservation) (*models.Reservation, error) {
+				scheduled = reservation
+				reservation.ID = "res-1"
+				reservation.Status = models.ReservationStatusScheduled
+				return &reservation, nil
+			}
+			handler := newReservationHandler(newEnvRepo(paymentsEnv), reservationRepo, newAuditLog())
+
+			rec := serve(handler.CreateReservation, request(http.MethodPost, "/api/reservations", &alice, nil,
+				`{"environmentId": "env-pay", "durationMins": 60, "feature": "checkout", "startTime": "`+tt.startTime+`"}`))
+			if tt.status != http.StatusCreated {
+				expectError(t, rec, tt.status, "INVALID_BODY")
+				if resp := decode(t, rec, tt.status); !strings.Contains(resp.Error, "explicit offset") {
+					t.Errorf("error = %q, want it to explain the timestamp format", resp.Error)
+				}
+				return
+			}
+			decode(t, rec, tt.status)
+			if !scheduled.StartTime.Equal(tt.wantStart) {
+				t.Errorf("scheduled from %s, want %s", scheduled.StartTime, tt.wantStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// Bounds for the expiry check interval set at runtime
+const (
+	minCheckInterval = 5 * time.Second
+	maxCheckInterval = time.Hour
+)
+
+// SystemConfigHandler handles requests about the server's own configuration
+type SystemConfigHandler struct {
+	mu              sync.Mutex
+	config          config.Config
+	auditRepo       db.AuditRepositoryInterface
+	checkIntervalCh chan time.Duration
+}
+
+// NewSystemConfigHandler creates a new SystemConfigHandler. New expiry check intervals
+// are sent on checkIntervalCh, which the expiry sweep reads from.
+func NewSystemConfigHandler(config config.Config, auditRepo db.AuditRepositoryInterface, checkIntervalCh chan time.Duration) *SystemConfigHandler {
+	return &SystemConfigHandler{
+		config:          config,
+		auditRepo:       auditRepo,
+		checkIntervalCh: checkIntervalCh,
+	}
+}
+
+// GetConfig handles requests to see the configuration in effect, without its secrets (admin only)
+func (h *SystemConfigHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Respond with the sanitized configuration
+	h.mu.Lock()
+	snapshot := h.config.Snapshot()
+	h.mu.Unlock()
+	utils.RespondWithSuccess(w, snapshot)
+}
+
+// SetCheckInterval handles requests to change how often expired reservations are checked
+// for without restarting the server (admin only). Only the replica that receives the
+// request changes its interval; others keep theirs until they restart.
+func (h *SystemConfigHandler) SetCheckInterval(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the request body
+	var req models.CheckIntervalUpdateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the interval
+	interval := time.Duration(req.IntervalSeconds) * time.Second
+	if interval < minCheckInterval || interval > maxCheckInterval {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeIntervalOutOfRange,
+			fmt.Sprintf("Interval must be between %d and %d seconds", int(minCheckInterval.Seconds()), int(maxCheckInterval.Seconds())))
+		return
+	}
+
+	// Hand the interval to the expiry sweep, replacing any change it hasn't picked up yet
+	h.mu.Lock()
+	select {
+	case <-h.checkIntervalCh:
+	default:
+	}
+	h.checkIntervalCh <- interval
+	previous := h.config.ExpiryCheckInterval
+	h.config.ExpiryCheckInterval = interval
+	snapshot := h.config.Snapshot()
+	h.mu.Unlock()
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       user.Username,
+		Action:      models.AuditActionUpdateSystemConfig,
+		Description: fmt.Sprintf("Changed the reservation check interval from %s to %s", previous, interval),
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the updated configuration
+	utils.RespondWithSuccess(w, snapshot)
+}
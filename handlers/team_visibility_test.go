@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+)
+
+// newVisibilityHandlers returns environment, reservation and queue handlers over the test
+// environments and reservationRepo. Repositories the routes under test must not reach are nil.
+func newVisibilityHandlers(envRepo *mock.MockEnvironmentRepository, reservationRepo *mock.MockReservationRepository, statsRepo *mock.MockStatsRepository) (*EnvironmentHandler, *ReservationHandler, *QueueHandler) {
+	envHandler := NewEnvironmentHandler(envRepo, reservationRepo, nil, statsRepo, nil, nil, nil, config.Config{})
+	reservationHandler := NewReservationHandler(reservationRepo, envRepo, nil, nil, statsRepo, nil, nil, nil, nil, nil, config.Config{})
+	return envHandler, reservationHandler, NewQueueHandler(nil, envRepo)
+}
+
+func TestOtherTeamsEnvironmentRoutesRespondNotFound(t *testing.T) {
+	// No repository call is expected after the environment lookup, so the mocks panic if one is made
+	envHandler, reservationHandler, queueHandler := newVisibilityHandlers(
+		newEnvRepo(paymentsEnv, searchEnv, sharedEnv), &mock.MockReservationRepository{}, &mock.MockStatsRepository{})
+	vars := map[string]string{"id": searchEnv.ID}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		r       *http.Request
+	}{
+		{"stats", envHandler.GetEnvironmentStats, request(http.MethodGet, "/api/environments/env-search/stats", &alice, vars, "")},
+		{"schedule", envHandler.GetEnvironmentSchedule, request(http.MethodGet, "/api/environments/env-search/schedule", &alice, vars, "")},
+		{"queue", queueHandler.GetEnvironmentQueue, request(http.MethodGet, "/api/environments/env-search/queue", &alice, vars, "")},
+		{"active reservations by environment", reservationHandler.GetActiveReservations, request(http.MethodGet, "/api/reservations?environmentId=env-search", &alice, nil, "")},
+		{"reservation history as a manager", envHandler.GetReservationHistory, request(http.MethodGet, "/api/admin/environments/env-search/reservations/history", &manager, vars, "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, serve(tt.handler, tt.r), http.StatusNotFound, "ENV_NOT_FOUND")
+		})
+	}
+}
+
+func TestEnvironmentScheduleVisibleToItsTeamAndAdmins(t *testing.T) {
+	reservationRepo := &mock.MockReservationRepository{
+		ListScheduledReservationsByEnvironmentIDFunc: func(string, time.Time) ([]models.Reservation, error) {
+			return []models.Reservation{}, nil
+		},
+		GetActiveReservationByEnvironmentIDFunc: func(string, time.Time) (*models.Reservation, error) {
+			reservation := reservationOf("res-1", searchEnv, "bob")
+			return &reservation, nil
+		},
+	}
+	envHandler, _, _ := newVisibilityHandlers(newEnvRepo(searchEnv), reservationRepo, nil)
+
+	for _, user := range []models.User{bob, admin} {
+		t.Run(user.Username, func(t *testing.T) {
+			rec := serve(envHandler.GetEnvironmentSchedule,
+				request(http.MethodGet, "/api/environments/env-search/schedule", &user, map[string]string{"id": searchEnv.ID}, ""))
+			var schedule models.EnvironmentSchedule
+			decodeData(t, rec, &schedule)
+		})
+	}
+}
+
+func TestActiveReservationsLeaveOutOtherTeams(t *testing.T) {
+	reservations := []models.Reservation{
+		reservationOf("res-pay", paymentsEnv, "alice"),
+		reservationOf("res-search", searchEnv, "bob"),
+		reservationOf("res-shared", sharedEnv, "bob"),
+		reservationOf("res-deleted", models.Environment{ID: "env-deleted"}, "bob"),
+	}
+	reservationRepo := &mock.MockReservationRepository{
+		ListActiveReservationsFunc: func(models.ActiveReservationFilter, time.Time) ([]models.Reservation, error) {
+			return reservations, nil
+		},
+	}
+
+	tests := []struct {
+		name string
+		user models.User
+		want []string
+	}{
+		{"team member", alice, []string{"res-pay", "res-shared"}},
+		{"other team", bob, []string{"res-search", "res-shared"}},
+		{"all teams", admin, []string{"res-pay", "res-search", "res-shared", "res-deleted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo := newEnvRepo(paymentsEnv, searchEnv, sharedEnv)
+			if tt.user.Role.HasPermission(models.PermissionViewAllTeams) {
+				envRepo.BatchGetEnvironmentsFunc = nil // users who see every team's shouldn't cost a lookup
+			}
+			_, reservationHandler, _ := newVisibilityHandlers(envRepo, reservationRepo, nil)
+
+			var got []models.ReservationResponse
+			decodeData(t, serve(reservationHandler.GetActiveReservations, request(http.MethodGet, "/api/reservations", &tt.user, nil, "")), &got)
+			if ids := reservationIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("reservations = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchReservationsLeaveOutOtherTeams(t *testing.T) {
+	reservationRepo := &mock.MockReservationRepository{
+		SearchReservationsFunc: func(models.ReservationSearch, int, string) (*models.ReservationPage, error) {
+			return &models.ReservationPage{
+				Reservations: []models.Reservation{reservationOf("res-pay", paymentsEnv, "bob"), reservationOf("res-search", searchEnv, "bob")},
+				NextToken:    "next",
+			}, nil
+		},
+	}
+	_, reservationHandler, _ := newVisibilityHandlers(newEnvRepo(paymentsEnv, searchEnv), reservationRepo, nil)
+
+	var page models.ReservationResponsePage
+	decodeData(t, serve(reservationHandler.SearchReservations, request(http.MethodGet, "/api/reservations/search?user=bob", &alice, nil, "")), &page)
+	if ids := reservationIDs(page.Reservations); !reflect.DeepEqual(ids, []string{"res-pay"}) {
+		t.Errorf("reservations = %v, want [res-pay]", ids)
+	}
+	if page.NextToken != "next" {
+		t.Errorf("nextToken = %q, want the repository's", page.NextToken)
+	}
+}
+
+func TestPendingReservationsLeaveOutOtherTeams(t *testing.T) {
+	pending := func(id string, env models.Environment) models.Reservation {
+		reservation := reservationOf(id, env, "bob")
+		reservation.Status = models.ReservationStatusPending
+		return reservation
+	}
+	reservationRepo := &mock.MockReservationRepository{
+		ListPendingReservationsFunc: func() ([]models.Reservation, error) {
+			return []models.Reservation{pending("res-pay", paymentsEnv), pending("res-search", searchEnv)}, nil
+		},
+		GetReservationFunc: func(id string) (*models.Reservation, error) {
+			reservation := pending(id, searchEnv)
+			return &reservation, nil
+		},
+	}
+	_, reservationHandler, _ := newVisibilityHandlers(newEnvRepo(paymentsEnv, searchEnv), reservationRepo, nil)
+
+	var got []models.ReservationResponse
+	decodeData(t, serve(reservationHandler.ListPendingReservations, request(http.MethodGet, "/api/admin/reservations/pending", &manager, nil, "")), &got)
+	if ids := reservationIDs(got); !reflect.DeepEqual(ids, []string{"res-pay"}) {
+		t.Errorf("pending reservations = %v, want [res-pay]", ids)
+	}
+
+	// Approving the other team's reservation must not reach ApproveReservation
+	rec := serve(reservationHandler.ApproveReservation,
+		request(http.MethodPost, "/api/admin/reservations/res-search/approve", &manager, map[string]string{"id": "res-search"}, ""))
+	expectError(t, rec, http.StatusNotFound, "RESERVATION_NOT_FOUND")
+}
+
+func TestExportEnvironmentsLeavesOutOtherTeams(t *testing.T) {
+	envHandler, _, _ := newVisibilityHandlers(newEnvRepo(paymentsEnv, searchEnv, sharedEnv), nil, nil)
+
+	rec := serve(envHandler.ExportEnvironments, request(http.MethodGet, "/api/admin/environments/export", &manager, nil, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, paymentsEnv.Name) || !strings.Contains(body, sharedEnv.Name) {
+		t.Errorf("export %q is missing visible environments", body)
+	}
+	if strings.Contains(body, searchEnv.Name) {
+		t.Errorf("export %q includes the other team's environment", body)
+	}
+}
+
+func TestDashboardStatsCountVisibleEnvironments(t *testing.T) {
+	reservationRepo := &mock.MockReservationRepository{
+		CountActiveReservationsByEnvironmentFunc: func(time.Time) (map[string]int, error) {
+			return map[string]int{searchEnv.ID: 1, sharedEnv.ID: 1}, nil
+		},
+	}
+	statsRepo := &mock.MockStatsRepository{
+		GetWeeklyReservationCountsFunc: func(time.Time) (map[string]int, error) {
+			return map[string]int{searchEnv.ID: 5, paymentsEnv.ID: 1}, nil
+		},
+	}
+	envHandler, _, _ := newVisibilityHandlers(newEnvRepo(paymentsEnv, searchEnv, sharedEnv), reservationRepo, statsRepo)
+
+	tests := []struct {
+		name         string
+		user         models.User
+		wantTotal    int
+		wantReserved int
+		wantActive   int
+		wantMost     string
+	}{
+		{"team member", alice, 2, 0, 1, paymentsEnv.Name},
+		{"all teams", admin, 3, 1, 2, searchEnv.Name},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats models.DashboardStats
+			decodeData(t, serve(envHandler.GetDashboardStats, request(http.MethodGet, "/api/stats", &tt.user, nil, "")), &stats)
+			if stats.TotalEnvironments != tt.wantTotal || stats.Reserved != tt.wantReserved || stats.ActiveReservations != tt.wantActive {
+				t.Errorf("stats = %d total, %d reserved, %d active; want %d, %d, %d",
+					stats.TotalEnvironments, stats.Reserved, stats.ActiveReservations, tt.wantTotal, tt.wantReserved, tt.wantActive)
+			}
+			if stats.MostReservedThisWeek == nil || stats.MostReservedThisWeek.EnvironmentName != tt.wantMost {
+				t.Errorf("mostReservedThisWeek = %+v, want %s", stats.MostReservedThisWeek, tt.wantMost)
+			}
+		})
+	}
+}
+
+func TestStreamEventsLeavesOutOtherTeams(t *testing.T) {
+	bus := realtime.NewEventBus()
+	handler := NewEventsHandler(bus, newEnvRepo(paymentsEnv, searchEnv, sharedEnv))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.StreamEvents(w, r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, alice)))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The handler subscribes before sending the headers, so both events reach it
+	search := reservationOf("res-search", searchEnv, "bob")
+	shared := reservationOf("res-shared", sharedEnv, "bob")
+	bus.Publish(models.EventReservationCreated, &search)
+	bus.Publish(models.EventReservationCreated, &shared)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event realtime.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatalf("decoding event %q: %v", line, err)
+		}
+		if event.EnvironmentID != sharedEnv.ID {
+			t.Errorf("first event is for %s, want %s", event.EnvironmentID, sharedEnv.ID)
+		}
+		return
+	}
+	t.Fatalf("stream ended without an event: %v", scanner.Err())
+}
@@ -1,25 +1,42 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/devreserve/server/db"
 	"github.com/devreserve/server/middleware"
 	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/revocation"
 	"github.com/devreserve/server/utils"
 	"github.com/gorilla/mux"
 )
 
+// activityLimit is the number of reservations and audit log entries included in a user's activity feed
+const activityLimit = 10
+
 // UserHandler handles user-related requests
 type UserHandler struct {
-	userRepo *db.UserRepository
+	userRepo        db.UserRepositoryInterface
+	reservationRepo db.ReservationRepositoryInterface
+	auditRepo       db.AuditRepositoryInterface
+	revocations     *revocation.Store
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(userRepo *db.UserRepository) *UserHandler {
+func NewUserHandler(userRepo db.UserRepositoryInterface, reservationRepo db.ReservationRepositoryInterface, auditRepo db.AuditRepositoryInterface, revocations *revocation.Store) *UserHandler {
 	return &UserHandler{
-		userRepo: userRepo,
+		userRepo:        userRepo,
+		reservationRepo: reservationRepo,
+		auditRepo:       auditRepo,
+		revocations:     revocations,
 	}
 }
 
@@ -34,7 +51,17 @@ func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	// Get all users
 	users, err := h.userRepo.ListUsers()
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to list users")
+		respondWithServerError(w, err, "Failed to list users")
+		return
+	}
+
+	// Render a plain-text table for CLI clients that asked for one
+	if utils.WantsTable(r) {
+		rows := make([][]string, len(users))
+		for i, user := range users {
+			rows[i] = []string{user.Username, string(user.Role), user.Email}
+		}
+		utils.RespondWithTable(w, []string{"USERNAME", "ROLE", "EMAIL"}, rows)
 		return
 	}
 
@@ -62,9 +89,9 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify that the user is an admin
-	if admin.Role != models.RoleAdmin {
-		utils.RespondWithError(w, http.StatusForbidden, "Admin access required")
+	// Verify that the user may manage users
+	if !admin.Role.HasPermission(models.PermissionManageUsers) {
+		utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodePermissionRequired, "Permission users:manage required")
 		return
 	}
 
@@ -72,45 +99,52 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string         `json:"username"`
 		Password string         `json:"password"`
+		Email    string         `json:"email"`
 		Role     models.UserRole `json:"role"`
+		Team     string         `json:"team"`
 	}
 	if err := utils.ParseJSONBody(r, &req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	// Validate the request
 	if req.Username == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
 		return
 	}
 	if len(req.Password) < 8 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodePasswordTooShort, "Password must be at least 8 characters")
 		return
 	}
 	if req.Role == "" {
 		req.Role = models.RoleUser
 	}
-	if req.Role != models.RoleUser && req.Role != models.RoleAdmin {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid role")
+	if !req.Role.IsValid() {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidRole, "Invalid role")
+		return
+	}
+	req.Team = strings.TrimSpace(req.Team)
+	if err := models.ValidateTeam(req.Team); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidTeam, err.Error())
 		return
 	}
 
 	// Check if the username already exists
-	existingUser, err := h.userRepo.GetUser(req.Username)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check username")
+	_, err := h.userRepo.GetUser(req.Username)
+	if err == nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeUsernameTaken, "Username already exists")
 		return
 	}
-	if existingUser != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username already exists")
+	if !errors.Is(err, db.ErrNotFound) {
+		respondWithServerError(w, err, "Failed to check username")
 		return
 	}
 
 	// Hash the password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		respondWithServerError(w, err, "Failed to hash password")
 		return
 	}
 
@@ -118,18 +152,30 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	user := models.User{
 		Username:    req.Username,
 		Password:    hashedPassword,
+		Email:       req.Email,
 		Role:        req.Role,
+		Team:        req.Team,
 		CreatedAt:   time.Now(),
 		LastUpdated: time.Now(),
 	}
 
 	if err := h.userRepo.CreateUser(user); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		respondWithServerError(w, err, "Failed to create user")
 		return
 	}
 
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionCreateUser,
+		Description: fmt.Sprintf("Created user %s with role %s", user.Username, user.Role),
+		ResourceID:  user.Username,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
 	// Respond with the created user
-	utils.RespondWithSuccess(w, user.ToResponse())
+	utils.RespondWithCreated(w, "/api/users/"+url.PathEscape(user.Username), user.ToResponse())
 }
 
 // GetUser handles requests to get a user by username
@@ -144,21 +190,97 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["username"]
 	if username == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username is required")
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepo.GetUser(username)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get user")
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeUserNotFound, "User not found")
 		return
 	}
-	if user == nil {
-		utils.RespondWithError(w, http.StatusNotFound, "User not found")
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get user")
 		return
 	}
 
 	// Respond with the user
 	utils.RespondWithSuccess(w, user.ToResponse())
 }
+
+// GetUserActivity handles requests to get a user's recent activity (admin only)
+func (h *UserHandler) GetUserActivity(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the username from the URL parameters
+	vars := mux.Vars(r)
+	username := vars["username"]
+	if username == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
+		return
+	}
+
+	// Fetch the user's reservations and audit log entries concurrently
+	var (
+		wg             sync.WaitGroup
+		reservations   []models.Reservation
+		auditEntries   []models.AuditLogEntry
+		reservationErr error
+		auditErr       error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		reservations, reservationErr = h.reservationRepo.ListRecentReservationsByUsername(username, activityLimit)
+	}()
+	go func() {
+		defer wg.Done()
+		auditEntries, auditErr = h.auditRepo.ListRecentEventsByActor(username, activityLimit)
+	}()
+	wg.Wait()
+
+	if reservationErr != nil {
+		respondWithServerError(w, reservationErr, "Failed to list reservations")
+		return
+	}
+	if auditErr != nil {
+		respondWithServerError(w, auditErr, "Failed to list audit log entries")
+		return
+	}
+
+	// Merge both sources into a single activity feed
+	events := make([]models.ActivityEvent, 0, len(reservations)+len(auditEntries))
+	for _, reservation := range reservations {
+		events = append(events, models.ActivityEvent{
+			Type:        models.ActivityReservation,
+			Timestamp:   reservation.CreatedAt,
+			Description: fmt.Sprintf("Reserved environment %s for %s", reservation.EnvironmentID, reservation.Feature),
+			ResourceID:  reservation.ID,
+		})
+	}
+	for _, entry := range auditEntries {
+		eventType := models.ActivityAdminAction
+		if entry.Action == models.AuditActionLogin {
+			eventType = models.ActivityLogin
+		}
+		events = append(events, models.ActivityEvent{
+			Type:        eventType,
+			Timestamp:   entry.Timestamp,
+			Description: entry.Description,
+			ResourceID:  entry.ResourceID,
+		})
+	}
+
+	// Sort the events newest first
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+
+	// Respond with the activity feed
+	utils.RespondWithSuccess(w, events)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// userCSVColumns are the columns expected in a user CSV import
+var userCSVColumns = []string{"username", "password", "role", "email"}
+
+// ImportUsers handles requests to create users in bulk from an uploaded CSV file (admin only).
+// The import is not transactional: users from valid rows are created even if other rows fail.
+func (h *UserHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the uploaded file
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid multipart form")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "CSV file is required in the 'file' field")
+		return
+	}
+	defer file.Close()
+
+	// Read and check the header row
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to read CSV header")
+		return
+	}
+	columns, err := csvColumnIndexes(header, userCSVColumns)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Create a user for each valid row, collecting failures
+	result := models.UserImportResult{
+		Created: []models.UserResponse{},
+		Failed:  []models.UserImportError{},
+	}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, models.UserImportError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		user, err := h.importUser(record, columns)
+		if err != nil {
+			result.Failed = append(result.Failed, models.UserImportError{
+				Row:      row,
+				Username: csvValue(record, columns, "username"),
+				Error:    err.Error(),
+			})
+			continue
+		}
+		result.Created = append(result.Created, user.ToResponse())
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionCreateUser,
+		Description: fmt.Sprintf("Imported %d users from CSV", len(result.Created)),
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the import result
+	utils.RespondWithSuccess(w, result)
+}
+
+// importUser validates a CSV record and creates the user it describes
+func (h *UserHandler) importUser(record []string, columns map[string]int) (*models.User, error) {
+	username := csvValue(record, columns, "username")
+	password := csvValue(record, columns, "password")
+	role := models.UserRole(csvValue(record, columns, "role"))
+	email := csvValue(record, columns, "email")
+
+	// Validate the row
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if len(password) < 8 {
+		return nil, fmt.Errorf("password must be at least 8 characters")
+	}
+	if role == "" {
+		role = models.RoleUser
+	}
+	if !role.IsValid() {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+
+	// Check if the username already exists
+	_, err := h.userRepo.GetUser(username)
+	if err == nil {
+		return nil, fmt.Errorf("username already exists")
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("failed to check username")
+	}
+
+	// Hash the password
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password")
+	}
+
+	// Create the user
+	user := models.User{
+		Username:    username,
+		Password:    hashedPassword,
+		Email:       email,
+		Role:        role,
+		CreatedAt:   time.Now(),
+		LastUpdated: time.Now(),
+	}
+	if err := h.userRepo.CreateUser(user); err != nil {
+		return nil, fmt.Errorf("failed to create user")
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// SetUserTeam handles requests to move a user to another team, or to none with an empty team
+// (admin only). The user's tokens are revoked, since they carry the old team, so they have to
+// log in again.
+func (h *UserHandler) SetUserTeam(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Get the username from the URL parameters
+	vars := mux.Vars(r)
+	username := vars["username"]
+	if username == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Username is required")
+		return
+	}
+
+	// Parse and validate the request body
+	var req models.UserTeamRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+	req.Team = strings.TrimSpace(req.Team)
+	if err := models.ValidateTeam(req.Team); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidTeam, err.Error())
+		return
+	}
+
+	// Get the user
+	user, err := h.userRepo.GetUser(username)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeUserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get user")
+		return
+	}
+
+	// Update the team and revoke the tokens carrying the old one
+	previous := user.Team
+	user.Team = req.Team
+	if err := h.userRepo.UpdateUser(*user); err != nil {
+		respondWithServerError(w, err, "Failed to update user")
+		return
+	}
+	if _, err := h.revocations.RevokeUserTokens(username); err != nil {
+		respondWithServerError(w, err, "Team updated but failed to revoke the user's tokens")
+		return
+	}
+
+	// Record the action in the audit log
+	if err := h.auditRepo.RecordEvent(models.AuditLogEntry{
+		Actor:       admin.Username,
+		Action:      models.AuditActionSetUserTeam,
+		Description: fmt.Sprintf("Moved %s from team %q to %q", username, previous, user.Team),
+		ResourceID:  username,
+	}); err != nil {
+		log.Printf("Error recording audit log entry: %v", err)
+	}
+
+	// Respond with the updated user
+	utils.RespondWithSuccess(w, user.ToResponse())
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// newUserRepo returns a mock user repository that looks users up in users
+func newUserRepo(users ...models.User) *mock.MockUserRepository {
+	return &mock.MockUserRepository{
+		GetUserFunc: func(username string) (*models.User, error) {
+			for _, user := range users {
+				if user.Username == username {
+					return &user, nil
+				}
+			}
+			return nil, db.ErrNotFound
+		},
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	handler := NewUserHandler(newUserRepo(alice), &mock.MockReservationRepository{}, newAuditLog(), nil)
+
+	var user models.UserResponse
+	decodeData(t, serve(handler.GetUser, request(http.MethodGet, "/api/users/alice", &bob, map[string]string{"username": "alice"}, "")), &user)
+	if user.Username != alice.Username || user.Team != alice.Team {
+		t.Errorf("user = %+v, want alice", user)
+	}
+
+	rec := serve(handler.GetUser, request(http.MethodGet, "/api/users/nobody", &bob, map[string]string{"username": "nobody"}, ""))
+	expectError(t, rec, http.StatusNotFound, "USER_NOT_FOUND")
+}
+
+func TestCreateUser(t *testing.T) {
+	userRepo := newUserRepo(alice)
+	var created models.User
+	userRepo.CreateUserFunc = func(user models.User) error {
+		created = user
+		return nil
+	}
+	audit := newAuditLog()
+	handler := NewUserHandler(userRepo, &mock.MockReservationRepository{}, audit, nil)
+
+	rec := serve(handler.CreateUser, request(http.MethodPost, "/api/users", &admin, nil,
+		`{"username": "carol", "password": "correct horse", "team": "search"}`))
+	resp := decode(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "/api/users/carol" {
+		t.Errorf("Location = %q, want /api/users/carol", got)
+	}
+	var user models.UserResponse
+	decodeRaw(t, resp.Data, &user)
+	if user.Username != "carol" || user.Role != models.RoleUser || user.Team != "search" {
+		t.Errorf("user = %+v, want carol as a user of team search", user)
+	}
+	if created.Password == "correct horse" || !utils.CheckPassword("correct horse", created.Password) {
+		t.Error("password wasn't stored hashed")
+	}
+	if got, want := audit.recorded(), []models.AuditAction{models.AuditActionCreateUser}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %v, want %v", got, want)
+	}
+}
+
+func TestCreateUserRejectsInvalidRequests(t *testing.T) {
+	// None of these get as far as creating a user
+	handler := NewUserHandler(newUserRepo(alice), &mock.MockReservationRepository{}, newAuditLog(), nil)
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"no user", nil, `{"username": "carol", "password": "correct horse"}`, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"without users:manage", &manager, `{"username": "carol", "password": "correct horse"}`, http.StatusForbidden, "PERMISSION_REQUIRED"},
+		{"no username", &admin, `{"password": "correct horse"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"short password", &admin, `{"username": "carol", "password": "short"}`, http.StatusBadRequest, "PASSWORD_TOO_SHORT"},
+		{"unknown role", &admin, `{"username": "carol", "password": "correct horse", "role": "owner"}`, http.StatusBadRequest, "INVALID_ROLE"},
+		{"username taken", &admin, `{"username": "alice", "password": "correct horse"}`, http.StatusBadRequest, "USERNAME_TAKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.CreateUser, request(http.MethodPost, "/api/users", tt.user, nil, tt.body))
+			expectError(t, rec, tt.status, tt.wantCode)
+		})
+	}
+}
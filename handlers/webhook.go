@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// deliveriesLimit is the number of recent deliveries returned for a webhook
+const deliveriesLimit = 50
+
+// WebhookHandler handles webhook management requests
+type WebhookHandler struct {
+	webhookRepo *db.WebhookRepository
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(webhookRepo *db.WebhookRepository) *WebhookHandler {
+	return &WebhookHandler{
+		webhookRepo: webhookRepo,
+	}
+}
+
+// CreateWebhook handles requests to create a webhook (admin only)
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the admin user from the request context
+	userValue := r.Context().Value(middleware.UserContextKey)
+	if userValue == nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	admin, ok := userValue.(models.User)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+		return
+	}
+
+	// Parse the request body
+	var req models.WebhookCreateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if errMsg := validateWebhook(req.URL, req.Secret, req.Events); errMsg != "" {
+		utils.RespondWithError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	// Create the webhook
+	webhook, err := h.webhookRepo.CreateWebhook(models.Webhook{
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		CreatedBy: admin.Username,
+	})
+	if err != nil {
+		respondWithServerError(w, err, "Failed to create webhook")
+		return
+	}
+
+	// Respond with the created webhook
+	utils.RespondWithCreated(w, "/api/admin/webhooks/"+url.PathEscape(webhook.ID), webhook)
+}
+
+// ListWebhooks handles requests to list all webhooks (admin only)
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the webhooks
+	webhooks, err := h.webhookRepo.ListWebhooks()
+	if err != nil {
+		respondWithServerError(w, err, "Failed to list webhooks")
+		return
+	}
+
+	// Respond with the webhooks
+	utils.RespondWithSuccess(w, webhooks)
+}
+
+// GetWebhook handles requests to get a webhook by ID (admin only)
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get the webhook
+	webhook, ok := h.webhookFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Respond with the webhook
+	utils.RespondWithSuccess(w, webhook)
+}
+
+// UpdateWebhook handles requests to update a webhook (admin only)
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse the request body
+	var req models.WebhookUpdateRequest
+	if err := utils.ParseJSONBody(r, &req); err != nil {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	// Get the webhook
+	webhook, ok := h.webhookFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Apply the changes
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	if req.Events != nil {
+		webhook.Events = *req.Events
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	if errMsg := validateWebhook(webhook.URL, webhook.Secret, webhook.Events); errMsg != "" {
+		utils.RespondWithError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	if err := h.webhookRepo.UpdateWebhook(*webhook); err != nil {
+		respondWithServerError(w, err, "Failed to update webhook")
+		return
+	}
+
+	// Respond with the updated webhook
+	utils.RespondWithSuccess(w, webhook)
+}
+
+// DeleteWebhook handles requests to delete a webhook (admin only)
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Delete the webhook
+	err := h.webhookRepo.DeleteWebhook(mux.Vars(r)["id"])
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeWebhookNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to delete webhook")
+		return
+	}
+
+	// Respond with no content
+	utils.RespondWithNoContent(w)
+}
+
+// ListDeliveries handles requests to get a webhook's recent deliveries (admin only)
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Check that the webhook exists
+	webhook, ok := h.webhookFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the deliveries
+	deliveries, err := h.webhookRepo.ListRecentDeliveries(webhook.ID, deliveriesLimit)
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get webhook deliveries")
+		return
+	}
+
+	// Respond with the deliveries
+	utils.RespondWithSuccess(w, deliveries)
+}
+
+// webhookFromRequest gets the webhook identified in the URL, responding with an error
+// and returning false if it can't
+func (h *WebhookHandler) webhookFromRequest(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	// Get the webhook ID from the URL parameters
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		utils.RespondWithErrorCode(w, http.StatusBadRequest, utils.ErrCodeMissingField, "Webhook ID is required")
+		return nil, false
+	}
+
+	// Get the webhook
+	webhook, err := h.webhookRepo.GetWebhook(id)
+	if errors.Is(err, db.ErrNotFound) {
+		utils.RespondWithErrorCode(w, http.StatusNotFound, utils.ErrCodeWebhookNotFound, "Webhook not found")
+		return nil, false
+	}
+	if err != nil {
+		respondWithServerError(w, err, "Failed to get webhook")
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// validateWebhook checks a webhook's settings, returning an error message if they're invalid
+func validateWebhook(rawURL string, secret string, events []models.WebhookEventType) string {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "URL must be an absolute http or https URL"
+	}
+	if secret == "" {
+		return "Secret is required"
+	}
+	if len(events) == 0 {
+		return "At least one event type is required"
+	}
+	for _, event := range events {
+		if !isWebhookEventType(event) {
+			return "Invalid event type: " + string(event)
+		}
+	}
+	return ""
+}
+
+// isWebhookEventType reports whether event is a known webhook event type
+func isWebhookEventType(event models.WebhookEventType) bool {
+	for _, known := range models.WebhookEventTypes {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
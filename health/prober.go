@@ -0,0 +1,84 @@
+package health
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+)
+
+// Prober checks the health check URLs of environments and records the results
+type Prober struct {
+	envRepo db.EnvironmentRepositoryInterface
+	client  *http.Client
+	workers int
+}
+
+// NewProber creates a new Prober that gives each check timeout to respond and
+// probes at most workers environments at once
+func NewProber(envRepo db.EnvironmentRepositoryInterface, timeout time.Duration, workers int) *Prober {
+	return &Prober{
+		envRepo: envRepo,
+		client:  &http.Client{Timeout: timeout},
+		workers: workers,
+	}
+}
+
+// ProbeAll checks every non-archived environment that has a health check URL and
+// waits for all the checks to finish
+func (p *Prober) ProbeAll() error {
+	environments, err := p.envRepo.ListEnvironments(false)
+	if err != nil {
+		return fmt.Errorf("failed to list environments: %w", err)
+	}
+
+	// Feed the environments to a fixed pool of workers
+	jobs := make(chan models.Environment)
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for env := range jobs {
+				p.probe(env)
+			}
+		}()
+	}
+	for _, env := range environments {
+		if env.HealthCheckURL == "" {
+			continue
+		}
+		jobs <- env
+	}
+	close(jobs)
+	wg.Wait()
+
+	return nil
+}
+
+// probe checks a single environment and records the result
+func (p *Prober) probe(env models.Environment) {
+	status, lastError := p.Check(env.HealthCheckURL)
+	if err := p.envRepo.UpdateHealthStatus(env.ID, env.HealthCheckURL, status, time.Now(), lastError); err != nil {
+		log.Printf("Error recording health of environment %s: %v", env.ID, err)
+	}
+}
+
+// Check sends a GET request to url. Any 2xx or 3xx response is healthy; errors,
+// timeouts and other statuses are unhealthy and come with a description.
+func (p *Prober) Check(url string) (models.HealthStatus, string) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return models.HealthUnhealthy, err.Error()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return models.HealthUnhealthy, fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return models.HealthHealthy, ""
+}
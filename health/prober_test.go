@@ -0,0 +1,132 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+)
+
+// statusServer returns a test server that responds to every request with status
+func statusServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheck(t *testing.T) {
+	healthy := statusServer(t, http.StatusOK)
+	redirect := httptest.NewServer(http.RedirectHandler(healthy.URL, http.StatusFound))
+	t.Cleanup(redirect.Close)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		want      models.HealthStatus
+		wantError string
+	}{
+		{"ok", healthy.URL, models.HealthHealthy, ""},
+		{"no content", statusServer(t, http.StatusNoContent).URL, models.HealthHealthy, ""},
+		{"redirected to a healthy page", redirect.URL, models.HealthHealthy, ""},
+		{"not modified", statusServer(t, http.StatusNotModified).URL, models.HealthHealthy, ""},
+		{"not found", statusServer(t, http.StatusNotFound).URL, models.HealthUnhealthy, "unexpected status 404"},
+		{"server error", statusServer(t, http.StatusInternalServerError).URL, models.HealthUnhealthy, "unexpected status 500"},
+		{"unavailable", statusServer(t, http.StatusServiceUnavailable).URL, models.HealthUnhealthy, "unexpected status 503"},
+		{"timeout", slow.URL, models.HealthUnhealthy, "Timeout"},
+		{"connection refused", closed.URL, models.HealthUnhealthy, "connect"},
+	}
+	prober := NewProber(nil, 100*time.Millisecond, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, lastError := prober.Check(tt.url)
+			if status != tt.want {
+				t.Errorf("status = %s, want %s", status, tt.want)
+			}
+			if (tt.wantError == "" && lastError != "") || !strings.Contains(lastError, tt.wantError) {
+				t.Errorf("error = %q, want one containing %q", lastError, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestProbeAll(t *testing.T) {
+	// Each check takes a moment, so concurrent ones overlap
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	environments := []models.Environment{
+		{ID: "env-no-url"},
+		{ID: "env-down", HealthCheckURL: server.URL + "/down"},
+	}
+	for _, id := range []string{"env-1", "env-2", "env-3", "env-4", "env-5"} {
+		environments = append(environments, models.Environment{ID: id, HealthCheckURL: server.URL + "/up"})
+	}
+	recorded := make(map[string]models.HealthStatus)
+	envRepo := &mock.MockEnvironmentRepository{
+		ListEnvironmentsFunc: func(includeArchived bool) ([]models.Environment, error) {
+			if includeArchived {
+				t.Error("ProbeAll listed archived environments")
+			}
+			return environments, nil
+		},
+		UpdateHealthStatusFunc: func(id, url string, status models.HealthStatus, checkedAt time.Time, lastError string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			recorded[id] = status
+			return nil
+		},
+	}
+
+	if err := NewProber(envRepo, time.Second, 2).ProbeAll(); err != nil {
+		t.Fatalf("ProbeAll: %v", err)
+	}
+
+	if _, ok := recorded["env-no-url"]; ok {
+		t.Error("environment without a health check URL was probed")
+	}
+	if recorded["env-down"] != models.HealthUnhealthy {
+		t.Errorf("env-down = %q, want %s", recorded["env-down"], models.HealthUnhealthy)
+	}
+	for _, id := range []string{"env-1", "env-2", "env-3", "env-4", "env-5"} {
+		if recorded[id] != models.HealthHealthy {
+			t.Errorf("%s = %q, want %s", id, recorded[id], models.HealthHealthy)
+		}
+	}
+	if maxInFlight > 2 {
+		t.Errorf("%d checks ran at once, want at most 2", maxInFlight)
+	}
+}
@@ -0,0 +1,156 @@
+// Package jobs holds the periodic work on reservations, such as expiring them, shared by the
+// background loops of the long-running server and the internal endpoint that runs it on demand
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/notifier"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/webhook"
+)
+
+// Sweeper renews, expires and activates reservations and tells everyone affected
+type Sweeper struct {
+	reservationRepo *db.ReservationRepository
+	envRepo         *db.EnvironmentRepository
+	queueRepo       *db.QueueRepository
+	notify          notifier.Notifier
+	webhooks        *webhook.Dispatcher
+	hub             *realtime.Hub
+	events          *realtime.EventBus
+
+	// notifications sent in the background and not yet finished
+	pending sync.WaitGroup
+}
+
+// NewSweeper creates a new Sweeper
+func NewSweeper(reservationRepo *db.ReservationRepository, envRepo *db.EnvironmentRepository, queueRepo *db.QueueRepository,
+	notify notifier.Notifier, webhooks *webhook.Dispatcher, hub *realtime.Hub, events *realtime.EventBus) *Sweeper {
+	return &Sweeper{
+		reservationRepo: reservationRepo,
+		envRepo:         envRepo,
+		queueRepo:       queueRepo,
+		notify:          notify,
+		webhooks:        webhooks,
+		hub:             hub,
+		events:          events,
+	}
+}
+
+// SweepExpired renews auto-renewing reservations, expires the reservations and holds that
+// have ended and hands the freed environments to the first user queued for them. Failures
+// are logged and the rest of the sweep carries on.
+func (s *Sweeper) SweepExpired() models.ExpirySweepResult {
+	var result models.ExpirySweepResult
+
+	// Renew auto-renewing reservations before anything is expired
+	renewed, err := s.reservationRepo.RenewAutoRenewingReservations()
+	if err != nil {
+		log.Printf("Error renewing reservations: %v", err)
+	}
+	for _, reservation := range renewed {
+		// Email the owner without holding up the sweep
+		s.pending.Add(1)
+		go func(reservation models.Reservation) {
+			defer s.pending.Done()
+			s.notifyRenewed(reservation)
+		}(reservation)
+	}
+	result.Renewed = len(renewed)
+
+	expired, err := s.reservationRepo.CheckExpiredReservations()
+	if err != nil {
+		log.Printf("Error checking expired reservations: %v", err)
+	}
+	for _, reservation := range expired {
+		s.webhooks.Dispatch(models.EventReservationExpired, reservation)
+		s.events.Publish(models.EventReservationExpired, &reservation)
+		// Email the former holder without holding up the sweep
+		s.pending.Add(1)
+		go func(reservation models.Reservation) {
+			defer s.pending.Done()
+			s.notifyExpired(reservation)
+		}(reservation)
+		s.promoteQueued(reservation.EnvironmentID)
+	}
+	result.Expired = len(expired)
+
+	// Free environments whose holds ran out without being reserved
+	freed, err := s.envRepo.ReleaseExpiredHolds()
+	if err != nil {
+		log.Printf("Error releasing expired holds: %v", err)
+	}
+	for _, environmentID := range freed {
+		s.promoteQueued(environmentID)
+	}
+	result.HoldsReleased = len(freed)
+
+	return result
+}
+
+// ActivateScheduled activates the scheduled reservations whose start time has passed,
+// reserving their environments and telling their owners, and returns how many it activated
+func (s *Sweeper) ActivateScheduled() int {
+	activated, err := s.reservationRepo.ActivateScheduledReservations()
+	if err != nil {
+		log.Printf("Error activating scheduled reservations: %v", err)
+	}
+	for _, reservation := range activated {
+		s.hub.EnvironmentChanged(reservation.EnvironmentID)
+		message := fmt.Sprintf("Your scheduled reservation of environment %s for %s has started and lasts until %s.",
+			reservation.EnvironmentID, reservation.Feature, reservation.EndTime.Format(time.RFC1123))
+		if err := s.notify.Notify(reservation.Username, "Reservation started", message); err != nil {
+			log.Printf("Error sending scheduled reservation notification: %v", err)
+		}
+	}
+	return len(activated)
+}
+
+// Wait waits for the notifications the sweep sends in the background. Callers that are
+// suspended once they respond, such as Lambda functions, call it before responding.
+func (s *Sweeper) Wait() {
+	s.pending.Wait()
+}
+
+// promoteQueued hands a freed environment to the first user waiting for it
+func (s *Sweeper) promoteQueued(environmentID string) {
+	promoted, _, err := s.queueRepo.PromoteNext(environmentID)
+	if err != nil {
+		log.Printf("Error promoting queued reservation for environment %s: %v", environmentID, err)
+	} else if promoted != nil {
+		s.webhooks.Dispatch(models.EventReservationCreated, promoted)
+		s.events.Publish(models.EventReservationCreated, promoted)
+		if err := notifier.NotifyHandoff(s.notify, promoted); err != nil {
+			log.Printf("Error sending handoff notification: %v", err)
+		}
+	}
+	s.hub.EnvironmentChanged(environmentID)
+}
+
+// notifyRenewed tells the owner of an auto-renewing reservation that it was renewed
+func (s *Sweeper) notifyRenewed(reservation models.Reservation) {
+	message := fmt.Sprintf("Your reservation of environment %s for %s was automatically renewed until %s.",
+		reservation.EnvironmentID, reservation.Feature, reservation.EndTime.Format(time.RFC1123))
+	if err := s.notify.Notify(reservation.Username, "Reservation renewed", message); err != nil {
+		log.Printf("Error sending renewal notification: %v", err)
+	}
+}
+
+// notifyExpired tells the former holder of an expired reservation that it has ended
+func (s *Sweeper) notifyExpired(reservation models.Reservation) {
+	environmentName := reservation.EnvironmentID
+	if env, err := s.envRepo.GetEnvironment(reservation.EnvironmentID); err != nil {
+		log.Printf("Error getting environment %s for expiry notification: %v", reservation.EnvironmentID, err)
+	} else {
+		environmentName = env.Name
+	}
+	if err := notifier.NotifyExpired(s.notify, &reservation, environmentName); err != nil {
+		log.Printf("Error sending expiry notification: %v", err)
+	}
+}
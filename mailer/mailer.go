@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+
+	"github.com/devreserve/server/config"
+)
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer creates an SMTP mailer if an SMTP host is configured, and a
+// logging mailer otherwise (for local development)
+func NewMailer(cfg config.Config) Mailer {
+	if cfg.SMTPHost == "" {
+		return &LogMailer{}
+	}
+	return &SMTPMailer{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// Send sends an email through the SMTP server
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	// Only authenticate if credentials are configured
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	// Build the message
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	// Send the message
+	if err := smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
+
+// LogMailer writes emails to the log instead of sending them
+type LogMailer struct{}
+
+// Send logs the email
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
@@ -3,18 +3,16 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/devreserve/server/config"
-	"github.com/devreserve/server/db"
-	"github.com/devreserve/server/handlers"
-	"github.com/devreserve/server/middleware"
-	"github.com/gorilla/mux"
+	"github.com/devreserve/server/server"
 	"github.com/joho/godotenv"
-	"github.com/rs/cors"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
@@ -27,92 +25,69 @@ func main() {
 
 	// Load the application configuration
 	cfg := config.LoadConfig()
-
-	// Create the DynamoDB client
-	dbClient, err := db.NewDynamoDBClient(cfg)
-	if err != nil {
-		log.Fatalf("Failed to create DynamoDB client: %v", err)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// Ensure the required tables exist
-	if err := dbClient.CreateTablesIfNotExist(); err != nil {
-		log.Fatalf("Failed to create DynamoDB tables: %v", err)
+	// Connect to DynamoDB and create the repositories and services
+	deps, err := server.NewDeps(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
 	}
 
-	// Create the repositories
-	userRepo := db.NewUserRepository(dbClient)
-	envRepo := db.NewEnvironmentRepository(dbClient)
-	reservationRepo := db.NewReservationRepository(dbClient, envRepo)
-
-	// Create the handlers
-	authHandler := handlers.NewAuthHandler(userRepo, cfg)
-	userHandler := handlers.NewUserHandler(userRepo)
-	envHandler := handlers.NewEnvironmentHandler(envRepo, reservationRepo)
-	reservationHandler := handlers.NewReservationHandler(reservationRepo, envRepo)
-
-	// Create the router
-	router := mux.NewRouter()
-
-	// Public routes
-	router.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")
-	router.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")
-
-	// Protected routes
-	authRouter := router.PathPrefix("/api").Subrouter()
-	authRouter.Use(middleware.AuthMiddleware(cfg))
-
-	// User routes
-	authRouter.HandleFunc("/users", userHandler.ListUsers).Methods("GET")
-	authRouter.HandleFunc("/users/{username}", userHandler.GetUser).Methods("GET")
-
-	// Admin-only routes
-	adminRouter := authRouter.PathPrefix("/admin").Subrouter()
-	adminRouter.Use(middleware.AdminMiddleware)
-	adminRouter.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
-
-	// Environment routes
-	authRouter.HandleFunc("/environments", envHandler.ListEnvironments).Methods("GET")
-	authRouter.HandleFunc("/environments/{id}", envHandler.GetEnvironment).Methods("GET")
-	adminRouter.HandleFunc("/environments", envHandler.CreateEnvironment).Methods("POST")
-
-	// Reservation routes
-	authRouter.HandleFunc("/reservations", reservationHandler.CreateReservation).Methods("POST")
-	authRouter.HandleFunc("/reservations", reservationHandler.GetActiveReservations).Methods("GET")
-	authRouter.HandleFunc("/reservations/{id}/release", reservationHandler.ReleaseReservation).Methods("POST")
-
-	// Set up CORS
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // You should restrict this in production
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
-
-	// Start a background goroutine to check for expired reservations
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			if err := reservationRepo.CheckExpiredReservations(); err != nil {
-				log.Printf("Error checking expired reservations: %v", err)
-			}
-		}
-	}()
+	// Start the background jobs, such as the expiry sweep
+	server.StartBackgroundJobs(deps)
 
 	// Create the server
-	server := &http.Server{
+	httpServer := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      corsMiddleware.Handler(router),
+		Handler:      server.NewRouter(deps),
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// With TLS, also serve plain HTTP on port 80 to redirect to HTTPS
+	var redirectServer *http.Server
+	if cfg.TLSEnabled {
+		redirect := httpsRedirect(cfg.Port)
+		if cfg.TLSCertFile == "" {
+			// Get certificates from Let's Encrypt, which sends its challenges to port 80
+			manager := &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				HostPolicy: autocert.HostWhitelist(cfg.TLSDomain),
+				Cache:      autocert.DirCache(cfg.TLSCacheDir),
+			}
+			httpServer.TLSConfig = manager.TLSConfig()
+			redirect = manager.HTTPHandler(redirect)
+		}
+		redirectServer = &http.Server{
+			Addr:         ":80",
+			Handler:      redirect,
+			WriteTimeout: 15 * time.Second,
+			ReadTimeout:  15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			log.Printf("Redirecting HTTP to HTTPS on port 80")
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start HTTP redirect server: %v", err)
+			}
+		}()
+	}
+
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled {
+			log.Printf("Server starting with TLS on port %s", cfg.Port)
+			// The certificate files are empty when autocert supplies the certificates
+			err = httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Server starting on port %s", cfg.Port)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -128,8 +103,27 @@ func main() {
 
 	// Attempt to gracefully shut down the server
 	log.Println("Server shutting down...")
-	if err := server.Shutdown(ctx); err != nil {
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			log.Printf("HTTP redirect server shutdown failed: %v", err)
+		}
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
 	log.Println("Server stopped")
 }
+
+// httpsRedirect redirects plain HTTP requests to the same URL over HTTPS on the given port
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
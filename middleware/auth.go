@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -16,20 +17,74 @@ type ContextKey string
 // UserContextKey is the key for the user context
 const UserContextKey ContextKey = "user"
 
-// AuthMiddleware is middleware for authenticating requests
-func AuthMiddleware(cfg config.Config) func(next http.Handler) http.Handler {
+// APIKeyContextKey is the key for the API key context, set when a request is
+// authenticated with an API key instead of a JWT
+const APIKeyContextKey ContextKey = "apiKey"
+
+// TokenClaimsContextKey is the key for the validated JWT's claims, set when a request is
+// authenticated with a JWT
+const TokenClaimsContextKey ContextKey = "tokenClaims"
+
+// TokenRevocations reports whether a validated JWT has been revoked before it expired
+type TokenRevocations interface {
+	IsRevoked(claims *utils.Claims) bool
+}
+
+// APIKeyAuthenticator resolves a plaintext API key to the key and the user that owns it
+type APIKeyAuthenticator interface {
+	Authenticate(key string) (*models.APIKey, *models.User, error)
+}
+
+// AuthMiddleware is middleware for authenticating requests with either a JWT bearer
+// token, which must not have been revoked, or an X-API-Key header
+func AuthMiddleware(cfg config.Config, apiKeys APIKeyAuthenticator, revocations TokenRevocations) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get the Authorization header
+			// Use the API key if one was sent
+			if apiKeyHeader := r.Header.Get("X-API-Key"); apiKeyHeader != "" {
+				key, owner, err := apiKeys.Authenticate(apiKeyHeader)
+				if err != nil {
+					utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeInvalidAPIKey, "Invalid API key")
+					return
+				}
+
+				// Reads need the read scope and everything else the write scope
+				scope := models.ScopeWrite
+				if r.Method == http.MethodGet || r.Method == http.MethodHead {
+					scope = models.ScopeRead
+				}
+				if !key.HasScope(scope) {
+					utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeMissingScope, "API key lacks the "+string(scope)+" scope")
+					return
+				}
+
+				// Add the owning user and the key to the request context
+				user := models.User{
+					Username: owner.Username,
+					Role:     owner.Role,
+					Team:     owner.Team,
+				}
+				ctx := context.WithValue(r.Context(), UserContextKey, user)
+				ctx = context.WithValue(ctx, APIKeyContextKey, *key)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			// Get the Authorization header. Browsers can't set headers on WebSocket handshakes
+			// or EventSource requests, so those may pass the token in the token query parameter instead.
 			authHeader := r.Header.Get("Authorization")
+			if token := r.URL.Query().Get("token"); authHeader == "" && token != "" && isStreamRequest(r) {
+				authHeader = "Bearer " + token
+			}
 			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				utils.RespondWithError(w, http.StatusUnauthorized, "Authorization header required")
 				return
 			}
 
 			// Check if the Authorization header has the Bearer prefix
 			if !strings.HasPrefix(authHeader, "Bearer ") {
-				http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+				utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeInvalidToken, "Invalid Authorization header format")
 				return
 			}
 
@@ -39,7 +94,11 @@ func AuthMiddleware(cfg config.Config) func(next http.Handler) http.Handler {
 			// Validate the token
 			claims, err := utils.ValidateToken(tokenString, cfg)
 			if err != nil {
-				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeInvalidToken, "Invalid token: "+err.Error())
+				return
+			}
+			if revocations.IsRevoked(claims) {
+				utils.RespondWithErrorCode(w, http.StatusUnauthorized, utils.ErrCodeTokenRevoked, "Token has been revoked")
 				return
 			}
 
@@ -47,10 +106,12 @@ func AuthMiddleware(cfg config.Config) func(next http.Handler) http.Handler {
 			user := models.User{
 				Username: claims.Username,
 				Role:     claims.Role,
+				Team:     claims.Team,
 			}
 
-			// Add the user to the request context
+			// Add the user and the token's claims to the request context
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = context.WithValue(ctx, TokenClaimsContextKey, claims)
 
 			// Call the next handler with the updated context
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -58,30 +119,44 @@ func AuthMiddleware(cfg config.Config) func(next http.Handler) http.Handler {
 	}
 }
 
-// AdminMiddleware is middleware for restricting access to admin users
-func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the user from the context
-		userValue := r.Context().Value(UserContextKey)
-		if userValue == nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		// Convert the user value to a User struct
-		user, ok := userValue.(models.User)
-		if !ok {
-			http.Error(w, "Invalid user context", http.StatusInternalServerError)
-			return
-		}
-
-		// Check if the user is an admin
-		if user.Role != models.RoleAdmin {
-			http.Error(w, "Admin access required", http.StatusForbidden)
-			return
-		}
-
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+// RequirePermission returns middleware that restricts access to users whose role grants perm
+func RequirePermission(perm models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Get the user from the context
+			userValue := r.Context().Value(UserContextKey)
+			if userValue == nil {
+				utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			// Convert the user value to a User struct
+			user, ok := userValue.(models.User)
+			if !ok {
+				utils.RespondWithError(w, http.StatusInternalServerError, "Invalid user context")
+				return
+			}
+
+			// Check that the user's role grants the permission
+			if !user.Role.HasPermission(perm) {
+				utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodePermissionRequired, fmt.Sprintf("Permission %s required", perm))
+				return
+			}
+
+			// API keys also need the admin scope
+			if key, ok := r.Context().Value(APIKeyContextKey).(models.APIKey); ok && !key.HasScope(models.ScopeAdmin) {
+				utils.RespondWithErrorCode(w, http.StatusForbidden, utils.ErrCodeMissingScope, "API key lacks the admin scope")
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isStreamRequest reports whether a request is a WebSocket handshake or asks for server-sent events
+func isStreamRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
 }
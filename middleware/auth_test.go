@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// testConfig returns a configuration signing tokens with a test secret
+func testConfig() config.Config {
+	return config.Config{JWTSecret: "test-secret", JWTExpirationHours: 1, JWTLeeway: time.Minute}
+}
+
+// apiKeys is an APIKeyAuthenticator knowing the keys in it by their plaintext
+type apiKeys map[string]models.APIKey
+
+// Authenticate returns the key and its owner, an admin on the search team
+func (k apiKeys) Authenticate(key string) (*models.APIKey, *models.User, error) {
+	apiKey, ok := k[key]
+	if !ok {
+		return nil, nil, errors.New("unknown key")
+	}
+	return &apiKey, &models.User{Username: apiKey.Username, Role: models.RoleAdmin, Team: "search"}, nil
+}
+
+// revokedUsers is a TokenRevocations revoking every token of the users in it
+type revokedUsers map[string]bool
+
+// IsRevoked reports whether the token's user is in r
+func (r revokedUsers) IsRevoked(claims *utils.Claims) bool {
+	return r[claims.Username]
+}
+
+// echoUser is a handler responding with the user in the request context
+var echoUser = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithSuccess(w, r.Context().Value(UserContextKey))
+})
+
+// errorCode returns the error code of a recorded error response, or "" if it succeeded
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp utils.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	return string(resp.ErrorCode)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := testConfig()
+	token := func(user models.User) string {
+		token, err := utils.GenerateToken(user, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	alice := models.User{Username: "alice", Role: models.RoleUser, Team: "payments"}
+	otherSecret := cfg
+	otherSecret.JWTSecret = "other-secret"
+	forged, err := utils.GenerateToken(alice, otherSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := apiKeys{
+		"read-key":  {Username: "bob", Scopes: []models.APIKeyScope{models.ScopeRead}},
+		"write-key": {Username: "bob", Scopes: []models.APIKeyScope{models.ScopeRead, models.ScopeWrite}},
+	}
+	handler := AuthMiddleware(cfg, keys, revokedUsers{"mallory": true})(echoUser)
+
+	tests := []struct {
+		name     string
+		method   string
+		header   string
+		value    string
+		status   int
+		wantCode string
+		wantUser string
+	}{
+		{"valid token", http.MethodGet, "Authorization", "Bearer " + token(alice), http.StatusOK, "", "alice"},
+		{"no credentials", http.MethodGet, "", "", http.StatusUnauthorized, "UNAUTHORIZED", ""},
+		{"not a bearer token", http.MethodGet, "Authorization", "Basic YWxpY2U6cGFzcw==", http.StatusUnauthorized, "INVALID_TOKEN", ""},
+		{"malformed token", http.MethodGet, "Authorization", "Bearer not.a.token", http.StatusUnauthorized, "INVALID_TOKEN", ""},
+		{"token signed with another secret", http.MethodGet, "Authorization", "Bearer " + forged, http.StatusUnauthorized, "INVALID_TOKEN", ""},
+		{"revoked token", http.MethodGet, "Authorization", "Bearer " + token(models.User{Username: "mallory", Role: models.RoleUser}), http.StatusUnauthorized, "TOKEN_REVOKED", ""},
+		{"API key", http.MethodGet, "X-API-Key", "read-key", http.StatusOK, "", "bob"},
+		{"unknown API key", http.MethodGet, "X-API-Key", "lost-key", http.StatusUnauthorized, "INVALID_API_KEY", ""},
+		{"API key without the write scope", http.MethodPost, "X-API-Key", "read-key", http.StatusForbidden, "MISSING_SCOPE", ""},
+		{"API key with the write scope", http.MethodPost, "X-API-Key", "write-key", http.StatusOK, "", "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/environments", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				if got := errorCode(t, rec); got != tt.wantCode {
+					t.Errorf("error code = %s, want %s", got, tt.wantCode)
+				}
+				return
+			}
+			var resp struct {
+				Data models.User `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+			}
+			if resp.Data.Username != tt.wantUser {
+				t.Errorf("user = %+v, want %s", resp.Data, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission(models.PermissionManageEnvironments)(echoUser)
+	adminKey := models.APIKey{Scopes: []models.APIKeyScope{models.ScopeRead, models.ScopeWrite, models.ScopeAdmin}}
+	writeKey := models.APIKey{Scopes: []models.APIKeyScope{models.ScopeRead, models.ScopeWrite}}
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		key      *models.APIKey
+		status   int
+		wantCode string
+	}{
+		{"no user", nil, nil, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"user", &models.User{Username: "alice", Role: models.RoleUser}, nil, http.StatusForbidden, "PERMISSION_REQUIRED"},
+		{"manager", &models.User{Username: "mona", Role: models.RoleManager}, nil, http.StatusOK, ""},
+		{"admin", &models.User{Username: "root", Role: models.RoleAdmin}, nil, http.StatusOK, ""},
+		{"admin's key with the admin scope", &models.User{Username: "root", Role: models.RoleAdmin}, &adminKey, http.StatusOK, ""},
+		{"admin's key without the admin scope", &models.User{Username: "root", Role: models.RoleAdmin}, &writeKey, http.StatusForbidden, "MISSING_SCOPE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/environments", nil)
+			ctx := r.Context()
+			if tt.user != nil {
+				ctx = context.WithValue(ctx, UserContextKey, *tt.user)
+			}
+			if tt.key != nil {
+				ctx = context.WithValue(ctx, APIKeyContextKey, *tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r.WithContext(ctx))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := errorCode(t, rec); got != tt.wantCode {
+				t.Errorf("error code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+	"github.com/gorilla/mux"
+)
+
+// RateLimitStore records requests per key and reports how many fall within the window
+type RateLimitStore interface {
+	// Increment records a request for the key and returns the number of requests
+	// made within the window, including this one
+	Increment(key string, window time.Duration) (int, error)
+}
+
+// MemoryRateLimitStore is an in-memory sliding-window RateLimitStore. Each key keeps a
+// circular buffer of its most recent request timestamps, so memory per key is bounded
+// by the buffer capacity regardless of how many requests are made.
+type MemoryRateLimitStore struct {
+	capacity int
+	windows  sync.Map // map[string]*slidingWindow
+}
+
+// slidingWindow is a circular buffer of request timestamps for a single key
+type slidingWindow struct {
+	mu         sync.Mutex
+	timestamps []time.Time
+	next       int
+}
+
+// NewMemoryRateLimitStore creates a MemoryRateLimitStore that tracks up to capacity
+// requests per key. Counts saturate at capacity, so it should be larger than the limit.
+func NewMemoryRateLimitStore(capacity int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{capacity: capacity}
+}
+
+// Increment records a request for the key and returns the number of requests within the window
+func (s *MemoryRateLimitStore) Increment(key string, window time.Duration) (int, error) {
+	value, _ := s.windows.LoadOrStore(key, &slidingWindow{
+		timestamps: make([]time.Time, s.capacity),
+	})
+	sw := value.(*slidingWindow)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	// Overwrite the oldest timestamp with this request
+	now := time.Now()
+	sw.timestamps[sw.next] = now
+	sw.next = (sw.next + 1) % len(sw.timestamps)
+
+	// Count the requests that fall within the window
+	cutoff := now.Add(-window)
+	count := 0
+	for _, ts := range sw.timestamps {
+		if ts.After(cutoff) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// RateLimits configures RateLimitMiddleware
+type RateLimits struct {
+	// PerMinute is the limit for every route together; 0 disables it
+	PerMinute int
+	// Routes holds stricter limits for expensive routes, keyed by method and path template
+	// as registered with the router, e.g. "GET /api/reservations/search"
+	Routes map[string]int
+	// ExemptUsers are never rate limited
+	ExemptUsers []string
+}
+
+// Capacity returns the store capacity needed to count up to the largest limit
+func (l RateLimits) Capacity() int {
+	largest := l.PerMinute
+	for _, limit := range l.Routes {
+		if limit > largest {
+			largest = limit
+		}
+	}
+	return largest + 1
+}
+
+// RateLimitMiddleware limits each authenticated user to limits.PerMinute requests in any
+// sliding one-minute window, and to the route's own limit on routes that have one, which
+// counts separately. It must run after AuthMiddleware so the user is in the context, and
+// be added with Use on a router so the matched route is known.
+func RateLimitMiddleware(limits RateLimits, store RateLimitStore) func(next http.Handler) http.Handler {
+	const window = time.Minute
+
+	exempt := make(map[string]bool, len(limits.ExemptUsers))
+	for _, username := range limits.ExemptUsers {
+		exempt[username] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Get the user from the context
+			user, ok := r.Context().Value(UserContextKey).(models.User)
+			if !ok || exempt[user.Username] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Find the limits that apply: the route's own, if it has one, and the global one
+			type bucket struct {
+				key   string
+				limit int
+			}
+			var buckets []bucket
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					name := r.Method + " " + template
+					if limit, ok := limits.Routes[name]; ok {
+						buckets = append(buckets, bucket{key: user.Username + " " + name, limit: limit})
+					}
+				}
+			}
+			if limits.PerMinute > 0 {
+				buckets = append(buckets, bucket{key: user.Username, limit: limits.PerMinute})
+			}
+
+			// Record the request against each limit, reporting the one with the fewest requests left
+			exceeded := false
+			reported := -1
+			for _, b := range buckets {
+				count, err := store.Increment(b.key, window)
+				if err != nil {
+					// Fail open so a broken store doesn't take down the API
+					continue
+				}
+
+				remaining := b.limit - count
+				if remaining < 0 {
+					remaining = 0
+				}
+				if reported < 0 || remaining < reported {
+					w.Header().Set("X-RateLimit-Limit", strconv.Itoa(b.limit))
+					w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+					reported = remaining
+				}
+				if count > b.limit {
+					exceeded = true
+				}
+			}
+
+			// Reject the request if a limit has been exceeded
+			if exceeded {
+				w.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+				utils.RespondWithErrorCode(w, http.StatusTooManyRequests, utils.ErrCodeRateLimited, "Rate limit exceeded")
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
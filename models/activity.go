@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditAction identifies the kind of action recorded in the audit log
+type AuditAction string
+
+const (
+	// AuditActionLogin is recorded when a user logs in
+	AuditActionLogin AuditAction = "LOGIN"
+	// AuditActionCreateUser is recorded when an admin creates a user
+	AuditActionCreateUser AuditAction = "CREATE_USER"
+	// AuditActionCreateEnvironment is recorded when an admin creates an environment
+	AuditActionCreateEnvironment AuditAction = "CREATE_ENVIRONMENT"
+	// AuditActionUpdateEnvironment is recorded when an admin updates an environment
+	AuditActionUpdateEnvironment AuditAction = "UPDATE_ENVIRONMENT"
+	// AuditActionArchiveEnvironment is recorded when an admin archives an environment
+	AuditActionArchiveEnvironment AuditAction = "ARCHIVE_ENVIRONMENT"
+	// AuditActionUnarchiveEnvironment is recorded when an admin unarchives an environment
+	AuditActionUnarchiveEnvironment AuditAction = "UNARCHIVE_ENVIRONMENT"
+	// AuditActionLockEnvironment is recorded when an admin locks an environment
+	AuditActionLockEnvironment AuditAction = "LOCK_ENVIRONMENT"
+	// AuditActionUnlockEnvironment is recorded when an admin unlocks an environment
+	AuditActionUnlockEnvironment AuditAction = "UNLOCK_ENVIRONMENT"
+	// AuditActionApproveReservation is recorded when an admin approves a reservation
+	AuditActionApproveReservation AuditAction = "APPROVE_RESERVATION"
+	// AuditActionCreateAPIKey is recorded when an admin creates an API key
+	AuditActionCreateAPIKey AuditAction = "CREATE_API_KEY"
+	// AuditActionRevokeAPIKey is recorded when an admin revokes an API key
+	AuditActionRevokeAPIKey AuditAction = "REVOKE_API_KEY"
+	// AuditActionCreateInvite is recorded when an admin creates an invite code
+	AuditActionCreateInvite AuditAction = "CREATE_INVITE"
+	// AuditActionTransferReservation is recorded when a reservation is handed over to another user
+	AuditActionTransferReservation AuditAction = "TRANSFER_RESERVATION"
+	// AuditActionPreemptReservation is recorded when an urgent reservation takes an environment from its holder
+	AuditActionPreemptReservation AuditAction = "PREEMPT_RESERVATION"
+	// AuditActionReassignReservation is recorded when an admin moves a reservation to another environment
+	AuditActionReassignReservation AuditAction = "REASSIGN_RESERVATION"
+	// AuditActionReconcileEnvironments is recorded when an admin corrects environment statuses from reservations
+	AuditActionReconcileEnvironments AuditAction = "RECONCILE_ENVIRONMENTS"
+	// AuditActionUpdateSystemConfig is recorded when an admin changes the configuration at runtime
+	AuditActionUpdateSystemConfig AuditAction = "UPDATE_SYSTEM_CONFIG"
+	// AuditActionRevokeTokens is recorded when an admin revokes all of a user's JWTs
+	AuditActionRevokeTokens AuditAction = "REVOKE_TOKENS"
+	// AuditActionNotifyEnvironmentUsers is recorded when an admin messages the holders of an environment's reservations
+	AuditActionNotifyEnvironmentUsers AuditAction = "NOTIFY_ENVIRONMENT_USERS"
+	// AuditActionSetUserTeam is recorded when an admin changes a user's team
+	AuditActionSetUserTeam AuditAction = "SET_USER_TEAM"
+)
+
+// AuditLogEntry represents an action performed by a user
+type AuditLogEntry struct {
+	Actor       string      `json:"actor" dynamodbav:"actor"`
+	Timestamp   time.Time   `json:"timestamp" dynamodbav:"timestamp"`
+	Action      AuditAction `json:"action" dynamodbav:"action"`
+	Description string      `json:"description" dynamodbav:"description"`
+	ResourceID  string      `json:"resourceId,omitempty" dynamodbav:"resourceId,omitempty"`
+}
+
+// ActivityEventType identifies the kind of event in a user's activity feed
+type ActivityEventType string
+
+const (
+	// ActivityReservation is an event for a reservation made by the user
+	ActivityReservation ActivityEventType = "RESERVATION"
+	// ActivityLogin is an event for a login by the user
+	ActivityLogin ActivityEventType = "LOGIN"
+	// ActivityAdminAction is an event for any other action recorded in the audit log
+	ActivityAdminAction ActivityEventType = "ADMIN_ACTION"
+)
+
+// ActivityEvent represents a single entry in a user's activity feed
+type ActivityEvent struct {
+	Type        ActivityEventType `json:"type"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Description string            `json:"description"`
+	ResourceID  string            `json:"resourceId,omitempty"`
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clockLayout is the format of AllowedHours start and end times
+const clockLayout = "15:04"
+
+// weekdays maps the day names used in AllowedHours to time.Weekday values
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday,
+	"MON": time.Monday,
+	"TUE": time.Tuesday,
+	"WED": time.Wednesday,
+	"THU": time.Thursday,
+	"FRI": time.Friday,
+	"SAT": time.Saturday,
+}
+
+// AllowedHours limits when an environment can be reserved to a daily window, e.g.
+// 09:00-18:00 Monday to Friday. A reservation must start and end within a single window.
+type AllowedHours struct {
+	Start    string   `json:"start" dynamodbav:"start"`                           // "HH:MM"
+	End      string   `json:"end" dynamodbav:"end"`                               // "HH:MM", after Start
+	Days     []string `json:"days,omitempty" dynamodbav:"days,omitempty"`         // e.g. ["MON", "TUE"]; every day if empty
+	Timezone string   `json:"timezone,omitempty" dynamodbav:"timezone,omitempty"` // IANA name, UTC if empty
+}
+
+// Validate checks that the window is well formed, returning a descriptive error if it isn't
+func (a *AllowedHours) Validate() error {
+	start, err := time.Parse(clockLayout, a.Start)
+	if err != nil {
+		return fmt.Errorf("allowed hours start must be HH:MM, got %q", a.Start)
+	}
+	end, err := time.Parse(clockLayout, a.End)
+	if err != nil {
+		return fmt.Errorf("allowed hours end must be HH:MM, got %q", a.End)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("allowed hours end must be after start")
+	}
+	for _, day := range a.Days {
+		if _, ok := weekdays[strings.ToUpper(day)]; !ok {
+			return fmt.Errorf("invalid day %q, expected one of SUN, MON, TUE, WED, THU, FRI, SAT", day)
+		}
+	}
+	if _, err := time.LoadLocation(a.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", a.Timezone)
+	}
+	return nil
+}
+
+// Permits reports whether a reservation from start to end falls within a single window
+func (a *AllowedHours) Permits(start, end time.Time) bool {
+	loc, err := time.LoadLocation(a.Timezone)
+	if err != nil {
+		return false
+	}
+	windowStart, windowEnd, ok := a.window(start.In(loc))
+	if !ok {
+		return false
+	}
+	return !start.Before(windowStart) && !end.After(windowEnd)
+}
+
+// String describes the window, e.g. "09:00-18:00 on MON, TUE (Europe/London)"
+func (a *AllowedHours) String() string {
+	days := "every day"
+	if len(a.Days) > 0 {
+		days = "on " + strings.ToUpper(strings.Join(a.Days, ", "))
+	}
+	timezone := a.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return fmt.Sprintf("%s-%s %s (%s)", a.Start, a.End, days, timezone)
+}
+
+// window returns the window on the day of t, in t's location, or false if t's day isn't allowed
+func (a *AllowedHours) window(t time.Time) (time.Time, time.Time, bool) {
+	if !a.allowsDay(t.Weekday()) {
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := time.Parse(clockLayout, a.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := time.Parse(clockLayout, a.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	year, month, day := t.Date()
+	return time.Date(year, month, day, start.Hour(), start.Minute(), 0, 0, t.Location()),
+		time.Date(year, month, day, end.Hour(), end.Minute(), 0, 0, t.Location()), true
+}
+
+// allowsDay reports whether reservations are allowed on the given day of the week
+func (a *AllowedHours) allowsDay(weekday time.Weekday) bool {
+	if len(a.Days) == 0 {
+		return true
+	}
+	for _, day := range a.Days {
+		if weekdays[strings.ToUpper(day)] == weekday {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKeyScope limits what an API key can be used for
+type APIKeyScope string
+
+const (
+	// ScopeRead allows GET requests
+	ScopeRead APIKeyScope = "read"
+	// ScopeWrite allows requests that change data, such as reserving an environment
+	ScopeWrite APIKeyScope = "write"
+	// ScopeAdmin allows admin routes, if the owning user is an admin
+	ScopeAdmin APIKeyScope = "admin"
+	// ScopeOncall allows preempting reservations, whatever the owning user's role
+	ScopeOncall APIKeyScope = "oncall"
+)
+
+// APIKey represents a long-lived key that machine clients use instead of a JWT
+type APIKey struct {
+	ID        string        `json:"id" dynamodbav:"id"`
+	KeyHash   string        `json:"-" dynamodbav:"keyHash"` // Only the hash of the secret part is stored
+	Username  string        `json:"username" dynamodbav:"username"`
+	Label     string        `json:"label" dynamodbav:"label"`
+	Scopes    []APIKeyScope `json:"scopes" dynamodbav:"scopes"`
+	CreatedBy string        `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt time.Time     `json:"createdAt" dynamodbav:"createdAt"`
+	Revoked   bool          `json:"revoked" dynamodbav:"revoked"`
+	RevokedAt *time.Time    `json:"revokedAt,omitempty" dynamodbav:"revokedAt,omitempty"`
+	RevokedBy string        `json:"revokedBy,omitempty" dynamodbav:"revokedBy,omitempty"`
+}
+
+// HasScope reports whether the key was granted the given scope
+func (k *APIKey) HasScope(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyCreateRequest represents the data needed to create an API key
+type APIKeyCreateRequest struct {
+	Username string        `json:"username"`
+	Label    string        `json:"label"`
+	Scopes   []APIKeyScope `json:"scopes,omitempty"`
+}
+
+// APIKeyCreateResponse is returned when an API key is created. The plaintext key is
+// only ever returned here.
+type APIKeyCreateResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
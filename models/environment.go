@@ -1,6 +1,12 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -12,6 +18,26 @@ const (
 	StatusFree EnvironmentStatus = "FREE"
 	// StatusReserved indicates that the environment is currently reserved
 	StatusReserved EnvironmentStatus = "RESERVED"
+	// StatusPendingApproval indicates that the environment is held for a reservation
+	// awaiting an admin's approval
+	StatusPendingApproval EnvironmentStatus = "PENDING_APPROVAL"
+	// StatusLocked indicates that an admin has locked the environment so it can't be reserved
+	StatusLocked EnvironmentStatus = "LOCKED"
+	// StatusHeld indicates that a user is holding the environment for a short time while
+	// they decide whether to reserve it
+	StatusHeld EnvironmentStatus = "HELD"
+)
+
+// HealthStatus is the outcome of an environment's most recent health check
+type HealthStatus string
+
+const (
+	// HealthHealthy indicates that the health check URL responded with a 2xx or 3xx status
+	HealthHealthy HealthStatus = "HEALTHY"
+	// HealthUnhealthy indicates that the health check URL failed to respond or returned an error status
+	HealthUnhealthy HealthStatus = "UNHEALTHY"
+	// HealthUnknown indicates that the environment hasn't been checked since its health check URL was set
+	HealthUnknown HealthStatus = "UNKNOWN"
 )
 
 // Environment represents a testing environment that can be reserved by users
@@ -20,17 +46,295 @@ type Environment struct {
 	Name        string            `json:"name" dynamodbav:"name"`
 	Description string            `json:"description,omitempty" dynamodbav:"description"`
 	Status      EnvironmentStatus `json:"status" dynamodbav:"status"`
-	CreatedBy   string            `json:"createdBy" dynamodbav:"createdBy"`
-	CreatedAt   time.Time         `json:"createdAt" dynamodbav:"createdAt"`
-	LastUpdated time.Time         `json:"lastUpdated" dynamodbav:"lastUpdated"`
+	Tags        []string          `json:"tags,omitempty" dynamodbav:"tags,omitempty"`
+	Region      string            `json:"region,omitempty" dynamodbav:"region,omitempty"`
+	Type        string            `json:"type,omitempty" dynamodbav:"type,omitempty"`
+	Pool        string            `json:"pool,omitempty" dynamodbav:"pool,omitempty"`
+	// Environments sharing a GroupID, such as an API box and its database, can be reserved together
+	GroupID string `json:"groupId,omitempty" dynamodbav:"groupId,omitempty"`
+	// Team owns the environment; only its members and admins can see and reserve it. Environments
+	// without a team are shared with everyone.
+	Team        string    `json:"team,omitempty" dynamodbav:"team,omitempty"`
+	CreatedBy   string    `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	LastUpdated time.Time `json:"lastUpdated" dynamodbav:"lastUpdated"`
+
+	// Connection details such as the URL, SSH host or dashboard link
+	Details map[string]string `json:"details,omitempty" dynamodbav:"details,omitempty"`
+	// Sensitive connection details, only returned to admins and the current reservation holder
+	SecretDetails map[string]string `json:"secretDetails,omitempty" dynamodbav:"secretDetails,omitempty"`
+
+	// Reservations of environments that require approval wait for an admin to approve them
+	RequiresApproval bool `json:"requiresApproval" dynamodbav:"requiresApproval"`
+
+	// AllowedHours limits reservations to a daily window; the environment can be reserved at any time if nil
+	AllowedHours *AllowedHours `json:"allowedHours,omitempty" dynamodbav:"allowedHours,omitempty"`
+
+	// Reservation duration bounds in minutes; the server-wide defaults apply where zero
+	MinReservationMins int `json:"minReservationMins,omitempty" dynamodbav:"minReservationMins,omitempty"`
+	MaxReservationMins int `json:"maxReservationMins,omitempty" dynamodbav:"maxReservationMins,omitempty"`
+
+	// Environments with a health check URL are probed in the background; the other fields hold the latest result
+	HealthCheckURL    string       `json:"healthCheckUrl,omitempty" dynamodbav:"healthCheckUrl,omitempty"`
+	HealthStatus      HealthStatus `json:"healthStatus,omitempty" dynamodbav:"healthStatus,omitempty"`
+	LastHealthCheckAt *time.Time   `json:"lastHealthCheckAt,omitempty" dynamodbav:"lastHealthCheckAt,omitempty"`
+	LastHealthError   string       `json:"lastHealthError,omitempty" dynamodbav:"lastHealthError,omitempty"`
+
+	// Set while an admin has locked the environment
+	LockedReason string     `json:"lockedReason,omitempty" dynamodbav:"lockedReason,omitempty"`
+	LockedBy     string     `json:"lockedBy,omitempty" dynamodbav:"lockedBy,omitempty"`
+	LockedAt     *time.Time `json:"lockedAt,omitempty" dynamodbav:"lockedAt,omitempty"`
+
+	// Set while a user holds the environment before reserving it
+	HeldBy    string     `json:"heldBy,omitempty" dynamodbav:"heldBy,omitempty"`
+	HeldUntil *time.Time `json:"heldUntil,omitempty" dynamodbav:"heldUntil,omitempty"`
+
+	// CurrentReservationID is the reservation holding the environment, so releasing a
+	// reservation only frees the environment if it still holds it
+	CurrentReservationID string `json:"-" dynamodbav:"currentReservationId,omitempty"`
+
+	// Archived environments are hidden from listings and can't be reserved, but keep their history
+	Archived   bool       `json:"archived" dynamodbav:"archived"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty" dynamodbav:"archivedAt,omitempty"`
+	ArchivedBy string     `json:"archivedBy,omitempty" dynamodbav:"archivedBy,omitempty"`
 }
 
 // EnvironmentCreateRequest represents the data needed to create a new environment
 type EnvironmentCreateRequest struct {
-	Name        string `json:"name" validate:"required"`
-	Description string `json:"description,omitempty"`
+	Name          string            `json:"name" validate:"required"`
+	Description   string            `json:"description,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Region        string            `json:"region,omitempty"`
+	Type          string            `json:"type,omitempty"`
+	Pool          string            `json:"pool,omitempty"`
+	GroupID       string            `json:"groupId,omitempty"`
+	Team          string            `json:"team,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+	SecretDetails map[string]string `json:"secretDetails,omitempty"`
+
+	RequiresApproval bool          `json:"requiresApproval,omitempty"`
+	AllowedHours     *AllowedHours `json:"allowedHours,omitempty"`
+	HealthCheckURL   string        `json:"healthCheckUrl,omitempty"`
+
+	// Reservation duration bounds in minutes; the server-wide defaults apply if omitted
+	MinReservationMins int `json:"minReservationMins,omitempty"`
+	MaxReservationMins int `json:"maxReservationMins,omitempty"`
+}
+
+// EnvironmentUpdateRequest represents the data that can be changed on an existing environment.
+// Fields that are omitted are left unchanged.
+type EnvironmentUpdateRequest struct {
+	Name          *string            `json:"name,omitempty"`
+	Description   *string            `json:"description,omitempty"`
+	Tags          *[]string          `json:"tags,omitempty"`
+	Region        *string            `json:"region,omitempty"`
+	Type          *string            `json:"type,omitempty"`
+	Pool          *string            `json:"pool,omitempty"`
+	GroupID       *string            `json:"groupId,omitempty"`
+	Team          *string            `json:"team,omitempty"`
+	Details       *map[string]string `json:"details,omitempty"`
+	SecretDetails *map[string]string `json:"secretDetails,omitempty"`
+
+	RequiresApproval *bool `json:"requiresApproval,omitempty"`
+	// AllowedHours replaces the environment's allowed hours; an empty object removes them
+	AllowedHours *AllowedHours `json:"allowedHours,omitempty"`
+	// HealthCheckURL replaces the environment's health check URL; an empty string removes it
+	HealthCheckURL *string `json:"healthCheckUrl,omitempty"`
+	// Reservation duration bounds in minutes; 0 goes back to the server-wide default
+	MinReservationMins *int `json:"minReservationMins,omitempty"`
+	MaxReservationMins *int `json:"maxReservationMins,omitempty"`
+}
+
+// ReservationLimits bounds how long a reservation of an environment can be, in minutes
+type ReservationLimits struct {
+	MinMins int `json:"minMins"`
+	MaxMins int `json:"maxMins"`
+}
+
+// Permits reports whether a reservation of durationMins is within the limits
+func (l ReservationLimits) Permits(durationMins int) bool {
+	return durationMins >= l.MinMins && durationMins <= l.MaxMins
+}
+
+// String describes the limits, e.g. "between 10 and 240 minutes"
+func (l ReservationLimits) String() string {
+	return fmt.Sprintf("between %d and %d minutes", l.MinMins, l.MaxMins)
+}
+
+// ReservationLimits returns the environment's effective reservation duration bounds, using
+// defaults for the ones it doesn't set
+func (e *Environment) ReservationLimits(defaults ReservationLimits) ReservationLimits {
+	limits := defaults
+	if e.MinReservationMins > 0 {
+		limits.MinMins = e.MinReservationMins
+	}
+	if e.MaxReservationMins > 0 {
+		limits.MaxMins = e.MaxReservationMins
+	}
+	return limits
+}
+
+// IsHeldAt reports whether a hold on the environment is still in force at t. Holds that have
+// run out stay stored until the expiry sweep frees the environment.
+func (e *Environment) IsHeldAt(t time.Time) bool {
+	return e.Status == StatusHeld && e.HeldUntil != nil && t.Before(*e.HeldUntil)
+}
+
+// IsReservableBy reports whether username may reserve the environment at t: it must be free,
+// held by them, or held by a hold that has run out
+func (e *Environment) IsReservableBy(username string, t time.Time) bool {
+	switch e.Status {
+	case StatusFree:
+		return true
+	case StatusHeld:
+		return e.HeldBy == username || !e.IsHeldAt(t)
+	}
+	return false
+}
+
+// ValidateReservationLimits checks the environment's own reservation duration bounds, and that
+// they leave some durations allowed once combined with defaults
+func (e *Environment) ValidateReservationLimits(defaults ReservationLimits) error {
+	if e.MinReservationMins < 0 || e.MaxReservationMins < 0 {
+		return fmt.Errorf("reservation duration limits must not be negative")
+	}
+	limits := e.ReservationLimits(defaults)
+	if limits.MinMins > limits.MaxMins {
+		return fmt.Errorf("minimum reservation duration (%d minutes) can't exceed the maximum (%d minutes)", limits.MinMins, limits.MaxMins)
+	}
+	return nil
+}
+
+// VisibleTo reports whether a user can see and reserve the environment: it's shared, it
+// belongs to the user's team, or the user's role can see every team's environments
+func (e *Environment) VisibleTo(user User) bool {
+	return e.Team == "" || strings.EqualFold(e.Team, user.Team) || user.Role.HasPermission(PermissionViewAllTeams)
+}
+
+// Clone returns a new, free environment named name with the environment's description, tags
+// and configuration. Its ID, timestamps and creator are left for CreateEnvironment to set.
+// Its group isn't copied, since a clone in the same group would be reserved along with it,
+// and neither are its lock, hold, archive state or health check results.
+func (e *Environment) Clone(name string) Environment {
+	clone := Environment{
+		Name:        name,
+		Description: e.Description,
+		Status:      StatusFree,
+		Region:      e.Region,
+		Type:        e.Type,
+		Pool:        e.Pool,
+		Team:        e.Team,
+
+		RequiresApproval: e.RequiresApproval,
+		HealthCheckURL:   e.HealthCheckURL,
+
+		MinReservationMins: e.MinReservationMins,
+		MaxReservationMins: e.MaxReservationMins,
+	}
+	if e.Tags != nil {
+		clone.Tags = append([]string{}, e.Tags...)
+	}
+	clone.Details = copyDetails(e.Details)
+	clone.SecretDetails = copyDetails(e.SecretDetails)
+	if e.AllowedHours != nil {
+		allowedHours := *e.AllowedHours
+		allowedHours.Days = append([]string(nil), e.AllowedHours.Days...)
+		clone.AllowedHours = &allowedHours
+	}
+	if clone.HealthCheckURL != "" {
+		clone.HealthStatus = HealthUnknown
+	}
+	return clone
+}
+
+// copyDetails returns a copy of an environment's details, or nil if there are none
+func copyDetails(details map[string]string) map[string]string {
+	if details == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(details))
+	for key, value := range details {
+		copied[key] = value
+	}
+	return copied
+}
+
+// EnvironmentCloneRequest represents the optional data sent when cloning an environment
+type EnvironmentCloneRequest struct {
+	// Name of the new environment; the source's name with a "-copy" suffix if omitted
+	Name string `json:"name,omitempty"`
+}
+
+// EnvironmentLockRequest represents the optional data sent when locking an environment
+type EnvironmentLockRequest struct {
+	LockedReason string `json:"lockedReason,omitempty"`
+}
+
+// ReleaseType records how a reservation ended. It is empty while the reservation is active.
+type ReleaseType string
+
+const (
+	// ReleaseManual indicates that the reservation's owner released it before its end time
+	ReleaseManual ReleaseType = "MANUAL"
+	// ReleaseForced indicates that someone else released the reservation before its end time
+	// using the force-release permission
+	ReleaseForced ReleaseType = "FORCE_RELEASED"
+	// ReleaseExpired indicates that the reservation reached its end time and was freed by the expiry sweep
+	ReleaseExpired ReleaseType = "EXPIRED"
+	// ReleasePreempted indicates that a higher-priority reservation took the environment over
+	ReleasePreempted ReleaseType = "PREEMPTED"
+)
+
+// IsValid reports whether the release type is one the server records
+func (t ReleaseType) IsValid() bool {
+	switch t {
+	case ReleaseManual, ReleaseForced, ReleaseExpired, ReleasePreempted:
+		return true
+	}
+	return false
+}
+
+// IsEarly reports whether the release type means the reservation ended before its end time
+func (t ReleaseType) IsEarly() bool {
+	return t == ReleaseManual || t == ReleaseForced || t == ReleasePreempted
+}
+
+// ReservationStatus records where a reservation is in its lifecycle. Reservations of
+// environments that require approval start out PENDING and reservations made for later
+// start out SCHEDULED; every other reservation starts out ACTIVE and ends up RELEASED or EXPIRED.
+type ReservationStatus string
+
+const (
+	// ReservationStatusPending indicates that the reservation is waiting for an admin's approval
+	ReservationStatusPending ReservationStatus = "PENDING"
+	// ReservationStatusScheduled indicates that the reservation starts at a later start time,
+	// when it becomes ACTIVE. Nobody else can reserve its environment for its time window.
+	ReservationStatusScheduled ReservationStatus = "SCHEDULED"
+	// ReservationStatusActive indicates that the reservation holds its environment until its end time
+	ReservationStatusActive ReservationStatus = "ACTIVE"
+	// ReservationStatusReleased indicates that the reservation was released or preempted before its end time
+	ReservationStatusReleased ReservationStatus = "RELEASED"
+	// ReservationStatusExpired indicates that the reservation reached its end time, or was
+	// never approved before it, and was freed by the expiry sweep
+	ReservationStatusExpired ReservationStatus = "EXPIRED"
+	// ReservationStatusApproved was stored for approved reservations before the lifecycle
+	// statuses existed. Such reservations are treated like ones without a status, whose
+	// state follows from their end time and release type.
+	ReservationStatusApproved ReservationStatus = "APPROVED"
+)
+
+// IsEnded reports whether the status means the reservation no longer holds its environment
+func (s ReservationStatus) IsEnded() bool {
+	return s == ReservationStatusReleased || s == ReservationStatusExpired
 }
 
+// ReservationPurpose categorizes what a reservation is used for. The allowed values
+// come from the RESERVATION_PURPOSES setting.
+type ReservationPurpose string
+
+// PurposeOther is reported for reservations made without a purpose
+const PurposeOther ReservationPurpose = "OTHER"
+
 // Reservation represents a reservation of an environment by a user
 type Reservation struct {
 	ID            string    `json:"id" dynamodbav:"id"`
@@ -43,19 +347,795 @@ type Reservation struct {
 	JiraURL       string    `json:"jiraUrl,omitempty" dynamodbav:"jiraUrl,omitempty"`
 	CreatedAt     time.Time `json:"createdAt" dynamodbav:"createdAt"`
 	LastUpdated   time.Time `json:"lastUpdated" dynamodbav:"lastUpdated"`
+
+	// Purpose is optional; see EffectivePurpose
+	Purpose ReservationPurpose `json:"purpose,omitempty" dynamodbav:"purpose,omitempty"`
+
+	// DurationMins is the originally requested duration, used as the auto-renew period
+	DurationMins int `json:"durationMins,omitempty" dynamodbav:"durationMins,omitempty"`
+	// RemainingMins is how long the reservation has left by the server's clock. It is
+	// computed by SetComputedTimes when responding and never stored.
+	RemainingMins int `json:"remainingMins" dynamodbav:"-"`
+	// AutoRenew extends the reservation by DurationMins each time it reaches its end
+	// time, until AutoRenewUntil passes
+	AutoRenew      bool       `json:"autoRenew" dynamodbav:"autoRenew"`
+	AutoRenewUntil *time.Time `json:"autoRenewUntil,omitempty" dynamodbav:"autoRenewUntil,omitempty"`
+
+	// Release metadata, set once the reservation has ended
+	ReleaseType   ReleaseType `json:"releaseType,omitempty" dynamodbav:"releaseType,omitempty"`
+	ReleaseReason string      `json:"releaseReason,omitempty" dynamodbav:"releaseReason,omitempty"`
+	ReleasedAt    *time.Time  `json:"releasedAt,omitempty" dynamodbav:"releasedAt,omitempty"`
+	ReleasedBy    string      `json:"releasedBy,omitempty" dynamodbav:"releasedBy,omitempty"`
+	// Where the release request came from, for settling who ended a reservation; not set
+	// for reservations that expired or were released before these were recorded
+	ReleasedFromIP    string `json:"releasedFromIp,omitempty" dynamodbav:"releasedFromIp,omitempty"`
+	ReleasedUserAgent string `json:"releasedUserAgent,omitempty" dynamodbav:"releasedUserAgent,omitempty"`
+
+	// Status is the reservation's lifecycle state; see EffectiveStatus for reservations
+	// written before it was stored
+	Status ReservationStatus `json:"status,omitempty" dynamodbav:"status,omitempty"`
+
+	// Approval metadata, only set for environments that require approval
+	ApprovedBy string     `json:"approvedBy,omitempty" dynamodbav:"approvedBy,omitempty"`
+	ApprovedAt *time.Time `json:"approvedAt,omitempty" dynamodbav:"approvedAt,omitempty"`
+
+	// Reservations made together for an environment group share a GroupReservationID
+	GroupReservationID string `json:"groupReservationId,omitempty" dynamodbav:"groupReservationId,omitempty"`
+
+	// Recurring reservations, one a day at the same time, share a SeriesID
+	SeriesID string `json:"seriesId,omitempty" dynamodbav:"seriesId,omitempty"`
+
+	// Priority ranks reservations for preemption. Normal reservations have priority 0; only
+	// preempting reservations have more, and they can only take over lower-priority ones.
+	Priority int `json:"priority,omitempty" dynamodbav:"priority,omitempty"`
+}
+
+// IsActiveAt reports whether the reservation holds its environment at t. A reservation stops
+// being active once it is released or expired, and at the exact instant of its end time even
+// if the expiry sweep hasn't marked it expired yet. Scheduled reservations aren't active until
+// they have been activated. This is the single definition of "active" used by listings and
+// the expiry sweep.
+func (r *Reservation) IsActiveAt(t time.Time) bool {
+	return !r.Status.IsEnded() && r.Status != ReservationStatusScheduled && t.Before(r.EndTime)
+}
+
+// IsScheduledAt reports whether the reservation is scheduled and hasn't reached its end time at t
+func (r *Reservation) IsScheduledAt(t time.Time) bool {
+	return r.Status == ReservationStatusScheduled && t.Before(r.EndTime)
+}
+
+// Overlaps reports whether the reservation's time window overlaps the one from start to end
+func (r *Reservation) Overlaps(start, end time.Time) bool {
+	return r.StartTime.Before(end) && start.Before(r.EndTime)
+}
+
+// IsPending reports whether the reservation is still waiting for approval
+func (r *Reservation) IsPending() bool {
+	return r.Status == ReservationStatusPending
+}
+
+// EffectivePurpose returns the reservation's purpose, or PurposeOther if it has none
+func (r *Reservation) EffectivePurpose() ReservationPurpose {
+	if r.Purpose == "" {
+		return PurposeOther
+	}
+	return r.Purpose
+}
+
+// RenewalPeriod returns how long the reservation is extended by on each auto-renewal
+func (r *Reservation) RenewalPeriod() time.Duration {
+	if r.DurationMins > 0 {
+		return time.Duration(r.DurationMins) * time.Minute
+	}
+	// Reservations created before the duration was stored
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// EffectiveReleaseType returns how the reservation ended as of now. Reservations that ended
+// without a recorded release type, such as ones written before release types were stored or
+// not yet reached by the expiry sweep, are reported as EXPIRED. Active and scheduled reservations
+// have none.
+func (r *Reservation) EffectiveReleaseType(now time.Time) ReleaseType {
+	if r.ReleaseType != "" {
+		return r.ReleaseType
+	}
+	if r.IsActiveAt(now) || r.IsScheduledAt(now) {
+		return ""
+	}
+	return ReleaseExpired
+}
+
+// EffectiveStatus returns the reservation's lifecycle state as of now. Reservations past their
+// end time that the expiry sweep hasn't reached yet are reported as EXPIRED, and the state of
+// reservations written before statuses were stored follows from their release type.
+func (r *Reservation) EffectiveStatus(now time.Time) ReservationStatus {
+	switch releaseType := r.EffectiveReleaseType(now); {
+	case releaseType == ReleaseExpired:
+		return ReservationStatusExpired
+	case releaseType != "":
+		return ReservationStatusReleased
+	case r.Status == ReservationStatusPending:
+		return ReservationStatusPending
+	case r.Status == ReservationStatusScheduled:
+		return ReservationStatusScheduled
+	}
+	return ReservationStatusActive
+}
+
+// SetComputedTimes fills in the fields computed at response time as of now: RemainingMins,
+// which is never negative, DurationMins for reservations created before it was stored, and
+// ReleaseType and Status for reservations written or swept before they were stored (see
+// EffectiveReleaseType and EffectiveStatus)
+func (r *Reservation) SetComputedTimes(now time.Time) {
+	if r.DurationMins == 0 {
+		r.DurationMins = int(r.EndTime.Sub(r.StartTime) / time.Minute)
+	}
+	r.Status = r.EffectiveStatus(now)
+	r.ReleaseType = r.EffectiveReleaseType(now)
+	r.RemainingMins = 0
+	if r.IsActiveAt(now) {
+		r.RemainingMins = int(r.EndTime.Sub(now) / time.Minute)
+	}
+}
+
+// SetComputedTimes fills in the computed fields of each reservation as of now
+func SetComputedTimes(reservations []Reservation, now time.Time) {
+	for i := range reservations {
+		reservations[i].SetComputedTimes(now)
+	}
+}
+
+// ReservationResponse is used for returning reservations in API responses. Besides the
+// computed fields of Reservation it carries the exact seconds left and whether the
+// reservation is active, so clients don't have to derive them from endTime.
+type ReservationResponse struct {
+	Reservation
+	RemainingSeconds int64 `json:"remainingSeconds"` // Never negative
+	IsActive         bool  `json:"isActive"`
+}
+
+// ToResponse converts a Reservation to a ReservationResponse as of now, leaving the
+// reservation itself unchanged
+func (r *Reservation) ToResponse(now time.Time) ReservationResponse {
+	computed := *r
+	computed.SetComputedTimes(now)
+	response := ReservationResponse{
+		Reservation: computed,
+		IsActive:    computed.IsActiveAt(now),
+	}
+	if response.IsActive {
+		response.RemainingSeconds = int64(computed.EndTime.Sub(now) / time.Second)
+	}
+	return response
+}
+
+// ReservationResponses converts each reservation to a ReservationResponse as of now
+func ReservationResponses(reservations []Reservation, now time.Time) []ReservationResponse {
+	responses := make([]ReservationResponse, len(reservations))
+	for i := range reservations {
+		responses[i] = reservations[i].ToResponse(now)
+	}
+	return responses
 }
 
 // ReservationCreateRequest represents the data needed to create a new reservation
 type ReservationCreateRequest struct {
-	EnvironmentID string `json:"environmentId" validate:"required"`
-	DurationMins  int    `json:"durationMins" validate:"required,min=10,max=4320"` // Min 10 mins, Max 3 days (4320 mins)
+	EnvironmentID string `json:"environmentId"`
+	DurationMins  int    `json:"durationMins" validate:"required"` // Bounded by the environment's reservation limits
 	Feature       string `json:"feature" validate:"required"`
 	GitBranch     string `json:"gitBranch,omitempty"`
 	JiraURL       string `json:"jiraUrl,omitempty"`
+
+	// Purpose must be one of the configured reservation purposes
+	Purpose ReservationPurpose `json:"purpose,omitempty"`
+
+	// AutoRenew keeps renewing the reservation until AutoRenewUntil (defaults to the
+	// maximum auto-renew duration from now)
+	AutoRenew      bool       `json:"autoRenew,omitempty"`
+	AutoRenewUntil *time.Time `json:"autoRenewUntil,omitempty"`
+
+	// Queue joins the environment's waitlist if it is already reserved, instead of failing
+	Queue bool `json:"queue,omitempty"`
+
+	// StartTime schedules the reservation to start later instead of now
+	StartTime *time.Time `json:"startTime,omitempty"`
+
+	// EnvironmentGroupID reserves every environment in the group together instead of EnvironmentID
+	EnvironmentGroupID string `json:"environmentGroupId,omitempty"`
+
+	// RecurrenceDays makes the reservation recur at the same time each day, creating this many
+	// reservations in total, at most MaxRecurrenceDays
+	RecurrenceDays int `json:"recurrenceDays,omitempty"`
+}
+
+// MaxRecurrenceDays is the most reservations a recurring reservation can create
+const MaxRecurrenceDays = 30
+
+// ReservationSeries represents the reservations created for a recurring reservation
+type ReservationSeries struct {
+	SeriesID     string                `json:"seriesId"`
+	Reservations []ReservationResponse `json:"reservations"`
+}
+
+// ReservationSeriesCancelResult reports how many of a series' future reservations were cancelled
+type ReservationSeriesCancelResult struct {
+	SeriesID  string `json:"seriesId"`
+	Cancelled int    `json:"cancelled"`
+}
+
+// GroupReservation represents the reservations made together for an environment group
+type GroupReservation struct {
+	GroupReservationID string                `json:"groupReservationId"`
+	Reservations       []ReservationResponse `json:"reservations"`
+}
+
+// GroupReservationBlocker describes a member of an environment group that stopped the
+// group from being reserved
+type GroupReservationBlocker struct {
+	EnvironmentID string            `json:"environmentId"`
+	Name          string            `json:"name"`
+	Status        EnvironmentStatus `json:"status"`
+	Reason        string            `json:"reason"`
+}
+
+// EnvironmentHold is returned when a user holds an environment
+type EnvironmentHold struct {
+	EnvironmentID string    `json:"environmentId"`
+	HeldBy        string    `json:"heldBy"`
+	HeldUntil     time.Time `json:"heldUntil"`
+}
+
+// ReservationConflict describes the reservation holding an environment that couldn't be
+// reserved, so clients can show when it will be free
+type ReservationConflict struct {
+	EnvironmentID string     `json:"environmentId"`
+	Holder        string     `json:"holder,omitempty"` // Left out if HIDE_RESERVATION_HOLDER is set
+	EndTime       *time.Time `json:"endTime,omitempty"`
+	RemainingMins int        `json:"remainingMins"`
+}
+
+// ReservationPreemptRequest represents the data needed to take a reserved environment over
+// from its holder during an incident
+type ReservationPreemptRequest struct {
+	EnvironmentID string `json:"environmentId"`
+	DurationMins  int    `json:"durationMins"`
+	Feature       string `json:"feature"`
+	GitBranch     string `json:"gitBranch,omitempty"`
+	JiraURL       string `json:"jiraUrl,omitempty"`
+	// Reason is required and is passed on to the preempted holder
+	Reason string `json:"reason"`
+	// Priority of the new reservation, 1 if omitted; the holder's must be lower
+	Priority int `json:"priority,omitempty"`
+}
+
+// PreemptionResult holds the reservation created by a preemption and the one it ended
+type PreemptionResult struct {
+	Reservation ReservationResponse `json:"reservation"`
+	Preempted   ReservationResponse `json:"preempted"`
+}
+
+// MaxUserAgentLength is the longest User-Agent header kept in a RequestMeta; longer ones are cut short
+const MaxUserAgentLength = 512
+
+// RequestMeta identifies who made a request and where it came from, so it can be recorded
+// alongside the change it made
+type RequestMeta struct {
+	Username  string
+	IP        string
+	UserAgent string
+}
+
+// ReservationReleaseRequest represents the optional data sent when releasing a reservation
+type ReservationReleaseRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReservationListStatus selects reservations by whether they have ended when listing them
+type ReservationListStatus string
+
+const (
+	// ReservationListAll selects every reservation
+	ReservationListAll ReservationListStatus = "all"
+	// ReservationListActive selects reservations that haven't ended
+	ReservationListActive ReservationListStatus = "active"
+	// ReservationListExpired selects reservations that have ended, whether they expired or were released
+	ReservationListExpired ReservationListStatus = "expired"
+)
+
+// ReservationFilter narrows down a reservation listing. Empty fields don't filter.
+type ReservationFilter struct {
+	Status        ReservationListStatus
+	EnvironmentID string
+	Username      string
+}
+
+// ActiveReservationFilter narrows down a listing of active reservations. Empty fields don't filter.
+type ActiveReservationFilter struct {
+	EnvironmentID string
+	Username      string
+	// ExpiringWithin selects reservations ending within this long
+	ExpiringWithin time.Duration
+}
+
+// Matches reports whether a reservation matches the filter at now
+func (f ActiveReservationFilter) Matches(r Reservation, now time.Time) bool {
+	return (f.EnvironmentID == "" || r.EnvironmentID == f.EnvironmentID) &&
+		(f.Username == "" || r.Username == f.Username) &&
+		(f.ExpiringWithin <= 0 || !r.EndTime.After(now.Add(f.ExpiringWithin)))
+}
+
+// ReservationSearch finds reservations whose feature, Git branch or Jira URL contains
+// Query, ignoring case, narrowed down by user, environment and a time range the reservation
+// overlaps. Empty fields don't filter.
+type ReservationSearch struct {
+	Query         string
+	Username      string
+	EnvironmentID string
+	From          *time.Time
+	To            *time.Time
+	// ReleaseType matches the reservation's effective release type, see EffectiveReleaseType
+	ReleaseType ReleaseType
+}
+
+// IsEmpty reports whether the search has no constraints at all, so it would match every reservation
+func (s ReservationSearch) IsEmpty() bool {
+	return s.Query == "" && s.Username == "" && s.EnvironmentID == "" && s.From == nil && s.To == nil && s.ReleaseType == ""
+}
+
+// Matches reports whether the reservation satisfies every constraint of the search
+func (s ReservationSearch) Matches(r Reservation) bool {
+	if s.Username != "" && r.Username != s.Username {
+		return false
+	}
+	if s.EnvironmentID != "" && r.EnvironmentID != s.EnvironmentID {
+		return false
+	}
+	if s.From != nil && !r.EndTime.After(*s.From) {
+		return false
+	}
+	if s.To != nil && !r.StartTime.Before(*s.To) {
+		return false
+	}
+	if s.ReleaseType != "" && r.EffectiveReleaseType(time.Now()) != s.ReleaseType {
+		return false
+	}
+	if s.Query == "" {
+		return true
+	}
+	query := strings.ToLower(s.Query)
+	for _, field := range []string{r.Feature, r.GitBranch, r.JiraURL} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservationPage is one page of a reservation listing. NextToken is sent back to get
+// the next page and is empty on the last one.
+type ReservationPage struct {
+	Reservations []Reservation `json:"reservations"`
+	NextToken    string        `json:"nextToken,omitempty"`
+}
+
+// ReservationResponsePage is a ReservationPage as returned in API responses
+type ReservationResponsePage struct {
+	Reservations []ReservationResponse `json:"reservations"`
+	NextToken    string                `json:"nextToken,omitempty"`
+}
+
+// ToResponse converts the page's reservations to ReservationResponses as of now
+func (p *ReservationPage) ToResponse(now time.Time) ReservationResponsePage {
+	return ReservationResponsePage{
+		Reservations: ReservationResponses(p.Reservations, now),
+		NextToken:    p.NextToken,
+	}
+}
+
+// ReservationReleaseFailure describes a reservation a bulk release could not release
+type ReservationReleaseFailure struct {
+	ReservationID string `json:"reservationId"`
+	EnvironmentID string `json:"environmentId"`
+	Error         string `json:"error"`
+}
+
+// BulkReleaseResult represents the outcome of releasing all of a user's reservations
+type BulkReleaseResult struct {
+	Released int                         `json:"released"`
+	Failed   []ReservationReleaseFailure `json:"failed"`
+}
+
+// ReservationReassignRequest represents the data sent when moving an environment's active
+// reservations to another environment
+type ReservationReassignRequest struct {
+	ToEnvironmentID string `json:"toEnvironmentId"`
+}
+
+// ReservationReassignResult represents the outcome of moving an environment's active reservations
+type ReservationReassignResult struct {
+	Moved int `json:"moved"`
+}
+
+// MaxEnvironmentMessageLength is the longest message an admin can send to an environment's users
+const MaxEnvironmentMessageLength = 2000
+
+// EnvironmentNotifyRequest represents the message an admin sends to the holders of an
+// environment's active reservations
+type EnvironmentNotifyRequest struct {
+	Message string `json:"message"`
+}
+
+// EnvironmentNotifyFailure represents a user who couldn't be sent an admin's message
+type EnvironmentNotifyFailure struct {
+	Username string `json:"username"`
+	Error    string `json:"error"`
+}
+
+// EnvironmentNotifyResult represents the outcome of messaging an environment's users
+type EnvironmentNotifyResult struct {
+	Notified []string                   `json:"notified"`
+	Failed   []EnvironmentNotifyFailure `json:"failed"`
+}
+
+// ReservationTransferRequest represents the data sent when handing a reservation over to another user
+type ReservationTransferRequest struct {
+	ToUsername string `json:"toUsername"`
+}
+
+// ReservationUpdateRequest represents the data that can be changed on an active reservation.
+// Fields that are omitted are left unchanged.
+type ReservationUpdateRequest struct {
+	AutoRenew      *bool               `json:"autoRenew,omitempty"`
+	AutoRenewUntil *time.Time          `json:"autoRenewUntil,omitempty"`
+	Purpose        *ReservationPurpose `json:"purpose,omitempty"`
+	// JiraURL replaces the reservation's Jira link; an empty string removes it
+	JiraURL *string `json:"jiraUrl,omitempty"`
+}
+
+// ValidateJiraURL checks that a reservation's Jira link is an absolute http or https URL
+// and, if allowedHosts isn't empty, that it points at one of those hosts. If baseURL isn't
+// empty the link must also be an issue on that Jira site, <baseURL>/browse/<KEY>-<number>.
+// An empty link is valid, since the link is optional.
+func ValidateJiraURL(jiraURL string, allowedHosts []string, baseURL string) error {
+	if jiraURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(jiraURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("jiraUrl must be an absolute http or https URL, got %q", jiraURL)
+	}
+	if baseURL != "" {
+		base := strings.TrimRight(baseURL, "/")
+		issue := strings.TrimPrefix(jiraURL, base+"/browse/")
+		if !strings.HasPrefix(jiraURL, base+"/browse/") || !jiraIssueKeyPattern.MatchString(issue) {
+			return fmt.Errorf("jiraUrl must be a Jira issue like %s/browse/PROJ-123, got %q", base, jiraURL)
+		}
+	}
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(parsed.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("jiraUrl must point to %s, got %q", strings.Join(allowedHosts, " or "), parsed.Hostname())
+}
+
+// jiraIssueKeyPattern matches a Jira issue key, a project key and an issue number
+var jiraIssueKeyPattern = regexp.MustCompile(`^[A-Z]+-[0-9]+$`)
+
+// MaxEnvironmentNameLength is the longest an environment name may be
+const MaxEnvironmentNameLength = 64
+
+// environmentNamePattern allows names of letters, digits, spaces, dashes, underscores and
+// dots that start and end with a letter or digit
+var environmentNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9 ._-]*[A-Za-z0-9])?$`)
+
+// ValidateEnvironmentName checks that an environment name, already trimmed of surrounding
+// whitespace, isn't empty, is at most MaxEnvironmentNameLength characters and uses only the
+// characters environmentNamePattern allows, so it fits on one line of a dropdown
+func ValidateEnvironmentName(name string) error {
+	if name == "" {
+		return errors.New("environment name is required")
+	}
+	if n := len([]rune(name)); n > MaxEnvironmentNameLength {
+		return fmt.Errorf("environment name must be at most %d characters, got %d", MaxEnvironmentNameLength, n)
+	}
+	if !environmentNamePattern.MatchString(name) {
+		return fmt.Errorf("environment name %q may only contain letters, digits, spaces, '-', '_' and '.', and must start and end with a letter or digit", name)
+	}
+	return nil
+}
+
+// Length limits for the free-text reservation fields
+const (
+	MaxFeatureLength   = 500
+	MaxGitBranchLength = 255
+)
+
+// gitBranchPattern limits branch names to the characters commonly used in git refs
+var gitBranchPattern = regexp.MustCompile(`^[A-Za-z0-9._/+-]+$`)
+
+// ValidateFeature checks that a reservation's feature description isn't longer than
+// MaxFeatureLength characters. Whether it's required is left to the caller.
+func ValidateFeature(feature string) error {
+	if n := len([]rune(feature)); n > MaxFeatureLength {
+		return fmt.Errorf("feature must be at most %d characters, got %d", MaxFeatureLength, n)
+	}
+	return nil
+}
+
+// ValidateGitBranch checks that a reservation's git branch looks like a valid branch name:
+// at most MaxGitBranchLength characters of letters, digits and ._/+-, following git's rules
+// for where dots and slashes may appear. An empty branch is valid, since it's optional.
+func ValidateGitBranch(branch string) error {
+	if branch == "" {
+		return nil
+	}
+	if len(branch) > MaxGitBranchLength {
+		return fmt.Errorf("gitBranch must be at most %d characters, got %d", MaxGitBranchLength, len(branch))
+	}
+	invalid := !gitBranchPattern.MatchString(branch) ||
+		strings.HasPrefix(branch, "-") || strings.HasPrefix(branch, "/") ||
+		strings.HasSuffix(branch, "/") || strings.HasSuffix(branch, ".") || strings.HasSuffix(branch, ".lock") ||
+		strings.Contains(branch, "..") || strings.Contains(branch, "//") ||
+		strings.HasPrefix(branch, ".") || strings.Contains(branch, "/.")
+	if invalid {
+		return fmt.Errorf("gitBranch %q isn't a valid git branch name", branch)
+	}
+	return nil
+}
+
+// ReservationWithTimeRemaining represents a reservation along with how long it has left
+type ReservationWithTimeRemaining struct {
+	ReservationResponse
+	Remaining string `json:"remaining"` // e.g. "2h 13m left"
+}
+
+// ScheduleWindow is how far ahead an environment's schedule looks
+const ScheduleWindow = 7 * 24 * time.Hour
+
+// EnvironmentSchedule lists an environment's current reservation and the scheduled ones
+// starting before To, sorted by start time
+type EnvironmentSchedule struct {
+	EnvironmentID string                `json:"environmentId"`
+	From          time.Time             `json:"from"`
+	To            time.Time             `json:"to"`
+	Reservations  []ReservationResponse `json:"reservations"`
+	// Conflicts lists reservations whose time windows overlap, which the scheduling checks
+	// should prevent but an admin or a race can still cause
+	Conflicts []ScheduleConflict `json:"conflicts"`
+}
+
+// ScheduleConflict names two reservations of an environment whose time windows overlap
+type ScheduleConflict struct {
+	ReservationID            string `json:"reservationId"`
+	ConflictingReservationID string `json:"conflictingReservationId"`
+}
+
+// NewEnvironmentSchedule builds an environment's schedule from now until ScheduleWindow later
+// out of its current and scheduled reservations, leaving out those starting after the window
+func NewEnvironmentSchedule(environmentID string, reservations []Reservation, now time.Time) EnvironmentSchedule {
+	schedule := EnvironmentSchedule{
+		EnvironmentID: environmentID,
+		From:          now.UTC().Truncate(time.Second),
+		To:            now.Add(ScheduleWindow).UTC().Truncate(time.Second),
+		Reservations:  []ReservationResponse{},
+		Conflicts:     []ScheduleConflict{},
+	}
+
+	var inWindow []Reservation
+	for _, reservation := range reservations {
+		if reservation.StartTime.Before(schedule.To) {
+			inWindow = append(inWindow, reservation)
+		}
+	}
+	sort.SliceStable(inWindow, func(i, j int) bool {
+		return inWindow[i].StartTime.Before(inWindow[j].StartTime)
+	})
+
+	for i := range inWindow {
+		schedule.Reservations = append(schedule.Reservations, inWindow[i].ToResponse(now))
+		for j := i + 1; j < len(inWindow); j++ {
+			if inWindow[i].Overlaps(inWindow[j].StartTime, inWindow[j].EndTime) {
+				schedule.Conflicts = append(schedule.Conflicts, ScheduleConflict{
+					ReservationID:            inWindow[i].ID,
+					ConflictingReservationID: inWindow[j].ID,
+				})
+			}
+		}
+	}
+	return schedule
+}
+
+// EnvironmentBatchRequest represents a request for several environments by ID
+type EnvironmentBatchRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// EnvironmentBatchResponse holds the environments found by a batch request and the IDs that weren't
+type EnvironmentBatchResponse struct {
+	Environments []EnvironmentWithReservation `json:"environments"`
+	Missing      []string                     `json:"missing"`
 }
 
 // EnvironmentWithReservation represents an environment with its current reservation (if any)
 type EnvironmentWithReservation struct {
 	Environment
-	CurrentReservation *Reservation `json:"currentReservation,omitempty"`
+	CurrentReservation *ReservationResponse `json:"currentReservation,omitempty"`
+	// ReservationLimits holds the effective duration bounds; only set for single environment lookups
+	ReservationLimits *ReservationLimits `json:"reservationLimits,omitempty"`
+}
+
+// NewEnvironmentWithReservation pairs an environment with its reservation as seen at now. A
+// reservation that has already ended is left out, and an environment still marked RESERVED
+// because the expiry sweep hasn't reached it yet is reported as FREE, so the environment and
+// reservation columns always agree.
+func NewEnvironmentWithReservation(env Environment, reservation *Reservation, now time.Time) EnvironmentWithReservation {
+	var current *ReservationResponse
+	if reservation != nil && reservation.IsActiveAt(now) {
+		response := reservation.ToResponse(now)
+		current = &response
+	}
+	if current == nil && env.Status == StatusReserved {
+		env.Status = StatusFree
+	}
+	if env.Status == StatusHeld && !env.IsHeldAt(now) {
+		env.Status = StatusFree
+		env.HeldBy = ""
+		env.HeldUntil = nil
+	}
+	return EnvironmentWithReservation{
+		Environment:        env,
+		CurrentReservation: current,
+	}
+}
+
+// EnvironmentStatusFix describes an environment whose status disagreed with its reservations
+// and the status it was given
+type EnvironmentStatusFix struct {
+	EnvironmentID string            `json:"environmentId"`
+	OldStatus     EnvironmentStatus `json:"oldStatus"`
+	NewStatus     EnvironmentStatus `json:"newStatus"`
+	Reason        string            `json:"reason"`
+}
+
+// ReconcileReport represents the outcome of reconciling environment statuses with reservations
+type ReconcileReport struct {
+	DryRun bool                   `json:"dryRun"`
+	Fixes  []EnvironmentStatusFix `json:"fixes"`
+}
+
+// EnvironmentImportError describes a row of an environment CSV import that could not be imported
+type EnvironmentImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// EnvironmentImportResult represents the outcome of an environment CSV import
+type EnvironmentImportResult struct {
+	Created []Environment            `json:"created"`
+	Failed  []EnvironmentImportError `json:"failed"`
+}
+
+// EnvironmentSeed is an environment defined in a seed file. Only these fields are seeded;
+// an environment's status, reservations and other settings are left to the API.
+type EnvironmentSeed struct {
+	Name               string   `json:"name" yaml:"name"`
+	Description        string   `json:"description,omitempty" yaml:"description"`
+	Tags               []string `json:"tags,omitempty" yaml:"tags"`
+	MinReservationMins int      `json:"minReservationMins,omitempty" yaml:"minReservationMins"`
+	MaxReservationMins int      `json:"maxReservationMins,omitempty" yaml:"maxReservationMins"`
+}
+
+// Environment returns a new, free environment built from the seed
+func (s EnvironmentSeed) Environment() Environment {
+	return Environment{
+		Name:               s.Name,
+		Description:        s.Description,
+		Status:             StatusFree,
+		Tags:               s.Tags,
+		MinReservationMins: s.MinReservationMins,
+		MaxReservationMins: s.MaxReservationMins,
+	}
+}
+
+// Matches reports whether an environment already has the seed's description, tags and limits
+func (s EnvironmentSeed) Matches(env *Environment) bool {
+	if env.Description != s.Description || len(env.Tags) != len(s.Tags) ||
+		env.MinReservationMins != s.MinReservationMins || env.MaxReservationMins != s.MaxReservationMins {
+		return false
+	}
+	for i := range s.Tags {
+		if env.Tags[i] != s.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// EnvironmentSeedResult represents the outcome of seeding environments from a file
+type EnvironmentSeedResult struct {
+	Mode    string `json:"mode"`
+	Created int    `json:"created"`
+	Updated int    `json:"updated"`
+	Skipped int    `json:"skipped"`
+}
+
+// EnvironmentStats holds usage counters for an environment
+type EnvironmentStats struct {
+	EnvironmentID string `json:"environmentId" dynamodbav:"environmentId"`
+	// ContentionCount is the number of times someone tried to reserve the environment while it was reserved
+	ContentionCount int        `json:"contentionCount" dynamodbav:"contentionCount"`
+	LastContendedAt *time.Time `json:"lastContendedAt,omitempty" dynamodbav:"lastContendedAt,omitempty"`
+	// Releases summarizes how the environment's reservations ended. It is computed from the
+	// reservation history when responding and never stored.
+	Releases ReleaseStats `json:"releases" dynamodbav:"-"`
+}
+
+// DashboardStats represents the headline numbers for an ops dashboard
+type DashboardStats struct {
+	// TotalEnvironments counts the environments that aren't archived, and ByStatus counts
+	// them by status; Free, Reserved and Maintenance (locked) are copied out of it
+	TotalEnvironments int                       `json:"totalEnvironments"`
+	Free              int                       `json:"free"`
+	Reserved          int                       `json:"reserved"`
+	Maintenance       int                       `json:"maintenance"`
+	ByStatus          map[EnvironmentStatus]int `json:"byStatus"`
+	// ActiveReservations counts the reservations that are active now
+	ActiveReservations int `json:"activeReservations"`
+	// WeekStart is the start of this week, Monday at midnight UTC, and MostReservedThisWeek
+	// the environment with the most reservations made since then, if any were
+	WeekStart            time.Time                `json:"weekStart"`
+	MostReservedThisWeek *EnvironmentReservations `json:"mostReservedThisWeek,omitempty"`
+}
+
+// EnvironmentReservations represents how many reservations were made of an environment
+type EnvironmentReservations struct {
+	EnvironmentID   string `json:"environmentId"`
+	EnvironmentName string `json:"environmentName"`
+	Reservations    int    `json:"reservations"`
+}
+
+// ReleaseStats counts how a set of reservations ended
+type ReleaseStats struct {
+	Ended         int `json:"ended"`
+	Released      int `json:"released"`
+	ForceReleased int `json:"forceReleased"`
+	Preempted     int `json:"preempted"`
+	Expired       int `json:"expired"`
+	// EarlyReleaseRate is the share of ended reservations that ended before their end time,
+	// from 0 to 1, or 0 if none have ended
+	EarlyReleaseRate float64 `json:"earlyReleaseRate"`
+}
+
+// SummarizeReleases counts how the reservations that had ended by now ended
+func SummarizeReleases(reservations []Reservation, now time.Time) ReleaseStats {
+	var stats ReleaseStats
+	early := 0
+	for i := range reservations {
+		releaseType := reservations[i].EffectiveReleaseType(now)
+		if releaseType == "" {
+			continue
+		}
+		stats.Ended++
+		if releaseType.IsEarly() {
+			early++
+		}
+		switch releaseType {
+		case ReleaseManual:
+			stats.Released++
+		case ReleaseForced:
+			stats.ForceReleased++
+		case ReleasePreempted:
+			stats.Preempted++
+		case ReleaseExpired:
+			stats.Expired++
+		}
+	}
+	if stats.Ended > 0 {
+		stats.EarlyReleaseRate = float64(early) / float64(stats.Ended)
+	}
+	return stats
 }
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateFeature(t *testing.T) {
+	tests := []struct {
+		name    string
+		feature string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"short", "checkout flow", false},
+		{"at the limit", strings.Repeat("a", MaxFeatureLength), false},
+		{"multibyte at the limit", strings.Repeat("é", MaxFeatureLength), false},
+		{"over the limit", strings.Repeat("a", MaxFeatureLength+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateFeature(tt.feature); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFeature error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGitBranch(t *testing.T) {
+	tests := []struct {
+		branch  string
+		wantErr bool
+	}{
+		{"", false},
+		{"main", false},
+		{"feature/PAY-1234_checkout", false},
+		{"release/1.2.3", false},
+		{"fix+hotfix-2", false},
+		{strings.Repeat("a", MaxGitBranchLength), false},
+		{strings.Repeat("a", MaxGitBranchLength+1), true},
+		{"has space", true},
+		{"javascript:alert(1)", true},
+		{"-leading-dash", true},
+		{"/leading-slash", true},
+		{"trailing-slash/", true},
+		{"trailing-dot.", true},
+		{"branch.lock", true},
+		{"double..dot", true},
+		{"double//slash", true},
+		{".hidden", true},
+		{"feature/.hidden", true},
+		{"tilde~1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			if err := ValidateGitBranch(tt.branch); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGitBranch(%q) error = %v, want error %v", tt.branch, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateJiraURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		jiraURL      string
+		allowedHosts []string
+		baseURL      string
+		wantErr      bool
+	}{
+		{"empty", "", []string{"jira.example.com"}, "https://jira.example.com", false},
+		{"any http URL", "http://jira.example.com/browse/PAY-1", nil, "", false},
+		{"any https URL", "https://tracker.example.org/issues/1", nil, "", false},
+		{"javascript URL", "javascript:alert(1)", nil, "", true},
+		{"data URL", "data:text/html,<script>", nil, "", true},
+		{"relative URL", "/browse/PAY-1", nil, "", true},
+		{"no scheme", "jira.example.com/browse/PAY-1", nil, "", true},
+		{"allowed host", "https://jira.example.com/browse/PAY-1", []string{"jira.example.com"}, "", false},
+		{"allowed host in another case", "https://JIRA.example.com/browse/PAY-1", []string{"jira.example.com"}, "", false},
+		{"allowed host with a port", "https://jira.example.com:8443/browse/PAY-1", []string{"jira.example.com"}, "", false},
+		{"other host", "https://evil.example.com/browse/PAY-1", []string{"jira.example.com"}, "", true},
+		{"host as a prefix", "https://jira.example.com.evil.net/browse/PAY-1", []string{"jira.example.com"}, "", true},
+		{"issue under the base URL", "https://jira.example.com/browse/PAY-1234", nil, "https://jira.example.com/", false},
+		{"not an issue", "https://jira.example.com/secure/Dashboard.jspa", nil, "https://jira.example.com", true},
+		{"lowercase issue key", "https://jira.example.com/browse/pay-1234", nil, "https://jira.example.com", true},
+		{"issue with a path after it", "https://jira.example.com/browse/PAY-1234/x", nil, "https://jira.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateJiraURL(tt.jiraURL, tt.allowedHosts, tt.baseURL); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateJiraURL(%q) error = %v, want error %v", tt.jiraURL, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReservationResponseJSON(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	releasedAt := start.Add(30 * time.Minute)
+	reservation := Reservation{
+		ID:            "res-1",
+		EnvironmentID: "env-1",
+		Username:      "alice",
+		StartTime:     start,
+		EndTime:       end,
+		Feature:       "checkout",
+		CreatedAt:     start,
+		LastUpdated:   start,
+		Status:        ReservationStatusActive,
+	}
+	released := reservation
+	released.Status = ReservationStatusReleased
+	released.ReleaseType = ReleaseManual
+	released.ReleasedAt = &releasedAt
+	released.ReleasedBy = "alice"
+
+	const times = `"startTime":"2024-03-04T09:00:00Z","endTime":"2024-03-04T11:00:00Z","feature":"checkout",` +
+		`"createdAt":"2024-03-04T09:00:00Z","lastUpdated":"2024-03-04T09:00:00Z","durationMins":120,`
+	tests := []struct {
+		name        string
+		reservation Reservation
+		now         time.Time
+		want        string
+	}{
+		{
+			name:        "active",
+			reservation: reservation,
+			now:         start.Add(45*time.Minute + 30*time.Second),
+			want: `{"id":"res-1","environmentId":"env-1","username":"alice",` + times +
+				`"remainingMins":74,"autoRenew":false,"status":"ACTIVE","remainingSeconds":4470,"isActive":true}`,
+		},
+		{
+			// The remaining time doesn't depend on the zone now is in
+			name:        "active, now in another zone",
+			reservation: reservation,
+			now:         start.Add(45*time.Minute + 30*time.Second).In(time.FixedZone("UTC-5", -5*60*60)),
+			want: `{"id":"res-1","environmentId":"env-1","username":"alice",` + times +
+				`"remainingMins":74,"autoRenew":false,"status":"ACTIVE","remainingSeconds":4470,"isActive":true}`,
+		},
+		{
+			name:        "past its end time",
+			reservation: reservation,
+			now:         end.Add(time.Minute),
+			want: `{"id":"res-1","environmentId":"env-1","username":"alice",` + times +
+				`"remainingMins":0,"autoRenew":false,"releaseType":"EXPIRED","status":"EXPIRED","remainingSeconds":0,"isActive":false}`,
+		},
+		{
+			name:        "released",
+			reservation: released,
+			now:         start.Add(time.Hour),
+			want: `{"id":"res-1","environmentId":"env-1","username":"alice",` + times +
+				`"remainingMins":0,"autoRenew":false,"releaseType":"MANUAL","releasedAt":"2024-03-04T09:30:00Z","releasedBy":"alice",` +
+				`"status":"RELEASED","remainingSeconds":0,"isActive":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.reservation.ToResponse(tt.now))
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("JSON = %s\nwant   %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReservationToResponseLeavesReservationUnchanged(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	reservation := Reservation{ID: "res-1", StartTime: start, EndTime: start.Add(time.Hour)}
+	reservation.ToResponse(start.Add(2 * time.Hour))
+	if reservation.DurationMins != 0 || reservation.Status != "" || reservation.ReleaseType != "" {
+		t.Errorf("reservation = %+v, want its computed fields left unset", reservation)
+	}
+}
+
+func TestReservationSearchMatches(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	reservation := Reservation{
+		EnvironmentID: "env-1",
+		Username:      "alice",
+		StartTime:     start,
+		EndTime:       start.Add(2 * time.Hour),
+		Feature:       "Checkout redesign",
+		GitBranch:     "feature/pay-1234-checkout",
+		JiraURL:       "https://jira.example.com/browse/PAY-1234",
+		ReleaseType:   ReleaseManual,
+	}
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name   string
+		search ReservationSearch
+		want   bool
+	}{
+		{"feature, ignoring case", ReservationSearch{Query: "checkout REDESIGN"}, true},
+		{"branch", ReservationSearch{Query: "pay-1234-check"}, true},
+		{"Jira ticket", ReservationSearch{Query: "PAY-1234"}, true},
+		{"no field matching", ReservationSearch{Query: "PAY-9999"}, false},
+		{"user", ReservationSearch{Username: "alice"}, true},
+		{"other user", ReservationSearch{Username: "bob"}, false},
+		{"environment", ReservationSearch{EnvironmentID: "env-1"}, true},
+		{"other environment", ReservationSearch{EnvironmentID: "env-2"}, false},
+		{"range overlapping the start", ReservationSearch{From: at(-time.Hour), To: at(time.Hour)}, true},
+		{"range inside", ReservationSearch{From: at(30 * time.Minute), To: at(time.Hour)}, true},
+		{"range ending at the start", ReservationSearch{From: at(-time.Hour), To: at(0)}, false},
+		{"range starting at the end", ReservationSearch{From: at(2 * time.Hour)}, false},
+		{"release type", ReservationSearch{ReleaseType: ReleaseManual}, true},
+		{"other release type", ReservationSearch{ReleaseType: ReleaseForced}, false},
+		{"query with filters", ReservationSearch{Query: "PAY-1234", Username: "alice", From: at(time.Hour)}, true},
+		{"query with a filter not matching", ReservationSearch{Query: "PAY-1234", Username: "bob"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.search.Matches(reservation); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReservationSearchIsEmpty(t *testing.T) {
+	from := time.Now()
+	tests := []struct {
+		search ReservationSearch
+		want   bool
+	}{
+		{ReservationSearch{}, true},
+		{ReservationSearch{Query: "PAY-1234"}, false},
+		{ReservationSearch{Username: "alice"}, false},
+		{ReservationSearch{EnvironmentID: "env-1"}, false},
+		{ReservationSearch{From: &from}, false},
+		{ReservationSearch{To: &from}, false},
+		{ReservationSearch{ReleaseType: ReleaseExpired}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.search.IsEmpty(); got != tt.want {
+			t.Errorf("%+v.IsEmpty() = %v, want %v", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestReservationIsActiveAt(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name   string
+		status ReservationStatus
+		at     time.Time
+		want   bool
+	}{
+		{"at its start", ReservationStatusActive, start, true},
+		{"just before its end", ReservationStatusActive, end.Add(-time.Nanosecond), true},
+		{"at the exact end time", ReservationStatusActive, end, false},
+		{"past its end, not swept yet", ReservationStatusActive, end.Add(30 * time.Second), false},
+		{"stored without a status", "", end.Add(-time.Minute), true},
+		{"released", ReservationStatusReleased, end.Add(-time.Minute), false},
+		{"expired", ReservationStatusExpired, end.Add(-time.Minute), false},
+		{"scheduled", ReservationStatusScheduled, end.Add(-time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservation := Reservation{StartTime: start, EndTime: end, Status: tt.status}
+			if got := reservation.IsActiveAt(tt.at); got != tt.want {
+				t.Errorf("IsActiveAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEnvironmentWithReservationAtTheEndTime(t *testing.T) {
+	// The environment is still marked reserved, as it is until the expiry sweep reaches it
+	end := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	env := Environment{ID: "env-1", Status: StatusReserved}
+	reservation := Reservation{ID: "res-1", EnvironmentID: "env-1", StartTime: end.Add(-time.Hour), EndTime: end, Status: ReservationStatusActive}
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantReserved bool
+	}{
+		{"a second before", end.Add(-time.Second), true},
+		{"just before", end.Add(-time.Nanosecond), true},
+		{"at the exact end time", end, false},
+		{"within the sweep interval", end.Add(59 * time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewEnvironmentWithReservation(env, &reservation, tt.at)
+
+			// The environment agrees with whether reservation listings count the reservation
+			if listed := reservation.IsActiveAt(tt.at); listed != tt.wantReserved {
+				t.Fatalf("IsActiveAt = %v, want %v", listed, tt.wantReserved)
+			}
+			if tt.wantReserved {
+				if got.Status != StatusReserved || got.CurrentReservation == nil || !got.CurrentReservation.IsActive {
+					t.Errorf("environment %s with reservation %+v, want reserved by the active res-1", got.Status, got.CurrentReservation)
+				}
+				return
+			}
+			if got.Status != StatusFree || got.CurrentReservation != nil {
+				t.Errorf("environment %s with reservation %+v, want free with none", got.Status, got.CurrentReservation)
+			}
+		})
+	}
+}
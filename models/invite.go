@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// Invite is a single-use code that lets someone register when self-registration is disabled
+type Invite struct {
+	CodeHash  string     `json:"-" dynamodbav:"codeHash"` // SHA-256 of the code, which is only returned once
+	Role      UserRole   `json:"role" dynamodbav:"role"`
+	CreatedBy string     `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt" dynamodbav:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" dynamodbav:"expiresAt,omitempty"`
+
+	// Set once the invite has been used to register
+	Used   bool       `json:"used" dynamodbav:"used"`
+	UsedBy string     `json:"usedBy,omitempty" dynamodbav:"usedBy,omitempty"`
+	UsedAt *time.Time `json:"usedAt,omitempty" dynamodbav:"usedAt,omitempty"`
+}
+
+// InviteCreateRequest represents the data needed to create an invite
+type InviteCreateRequest struct {
+	Role      UserRole   `json:"role,omitempty"` // Defaults to USER
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// InviteCreateResponse is returned when an invite is created. It is the only
+// time the plaintext code is available.
+type InviteCreateResponse struct {
+	Invite
+	Code string `json:"code"`
+}
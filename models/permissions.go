@@ -0,0 +1,70 @@
+package models
+
+// Permission is an action a role may be allowed to perform
+type Permission string
+
+const (
+	// PermissionManageUsers allows creating and importing users, invites and API keys
+	PermissionManageUsers Permission = "users:manage"
+	// PermissionManageEnvironments allows creating, updating, archiving and exporting environments
+	PermissionManageEnvironments Permission = "environments:manage"
+	// PermissionApproveReservations allows listing and approving pending reservations
+	PermissionApproveReservations Permission = "reservations:approve"
+	// PermissionForceReleaseReservations allows releasing and transferring reservations held by other users
+	PermissionForceReleaseReservations Permission = "reservations:force-release"
+	// PermissionManageWebhooks allows registering and maintaining webhooks
+	PermissionManageWebhooks Permission = "webhooks:manage"
+	// PermissionReadAudit allows reading the audit log and user activity
+	PermissionReadAudit Permission = "audit:read"
+	// PermissionPreemptReservations allows taking a reserved environment from a lower-priority holder
+	PermissionPreemptReservations Permission = "reservations:preempt"
+	// PermissionReadSystem allows reading the server's configuration
+	PermissionReadSystem Permission = "system:read"
+	// PermissionManageSystem allows changing the server's configuration at runtime and repairing environment statuses
+	PermissionManageSystem Permission = "system:manage"
+	// PermissionViewAllTeams allows seeing and reserving every team's environments, not just
+	// the user's own team's and shared ones
+	PermissionViewAllTeams Permission = "environments:all-teams"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[UserRole][]Permission{
+	RoleAdmin: {
+		PermissionManageUsers,
+		PermissionManageEnvironments,
+		PermissionApproveReservations,
+		PermissionForceReleaseReservations,
+		PermissionManageWebhooks,
+		PermissionReadAudit,
+		PermissionPreemptReservations,
+		PermissionReadSystem,
+		PermissionManageSystem,
+		PermissionViewAllTeams,
+	},
+	RoleManager: {
+		PermissionManageEnvironments,
+		PermissionApproveReservations,
+	},
+	RoleUser: {},
+}
+
+// IsValid reports whether the role is one the server knows about
+func (r UserRole) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions returns the permissions granted by the role
+func (r UserRole) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// HasPermission reports whether the role grants the given permission
+func (r UserRole) HasPermission(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// QueueEntry is a user waiting for a reserved environment. When the environment is
+// released, the entry with the lowest position is turned into a reservation.
+type QueueEntry struct {
+	EnvironmentID         string             `json:"environmentId" dynamodbav:"environmentId"`
+	Position              int                `json:"position" dynamodbav:"position"` // Increases monotonically per environment
+	Username              string             `json:"username" dynamodbav:"username"`
+	RequestedDurationMins int                `json:"requestedDurationMins" dynamodbav:"requestedDurationMins"`
+	Feature               string             `json:"feature" dynamodbav:"feature"`
+	GitBranch             string             `json:"gitBranch,omitempty" dynamodbav:"gitBranch,omitempty"`
+	JiraURL               string             `json:"jiraUrl,omitempty" dynamodbav:"jiraUrl,omitempty"`
+	Purpose               ReservationPurpose `json:"purpose,omitempty" dynamodbav:"purpose,omitempty"`
+	QueuedAt              time.Time          `json:"queuedAt" dynamodbav:"queuedAt"`
+	// Entries that haven't reached the front of the queue by ExpiresAt are dropped
+	ExpiresAt time.Time `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+// IsExpired reports whether the entry expired before now
+func (e *QueueEntry) IsExpired(now time.Time) bool {
+	return !e.ExpiresAt.After(now)
+}
+
+// QueuePosition is a user's place in an environment's waitlist, where 1 is next in line
+type QueuePosition struct {
+	Position              int       `json:"position"`
+	Username              string    `json:"username"`
+	RequestedDurationMins int       `json:"requestedDurationMins"`
+	QueuedAt              time.Time `json:"queuedAt"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReservationRule names one of the rules every reservation that starts now must follow,
+// whether it is made by a user or promoted from the waitlist
+type ReservationRule string
+
+const (
+	// RuleNotVisible is broken when the environment belongs to a team the user isn't in
+	RuleNotVisible ReservationRule = "NOT_VISIBLE"
+	// RuleUnhealthy is broken when the environment is unhealthy and unhealthy environments can't be reserved
+	RuleUnhealthy ReservationRule = "UNHEALTHY"
+	// RuleOutsideAllowedHours is broken when the reservation doesn't fit in the environment's allowed hours
+	RuleOutsideAllowedHours ReservationRule = "OUTSIDE_ALLOWED_HOURS"
+	// RuleScheduled is broken when the reservation overlaps a scheduled reservation
+	RuleScheduled ReservationRule = "SCHEDULED"
+)
+
+// ReservationRuleError reports the rule a reservation breaks
+type ReservationRuleError struct {
+	Rule    ReservationRule
+	Message string
+	// Conflict is the scheduled reservation the reservation overlaps, for RuleScheduled
+	Conflict *Reservation
+}
+
+// Error returns the message describing the broken rule
+func (e *ReservationRuleError) Error() string {
+	return e.Message
+}
+
+// CheckReservationRules checks a reservation of the environment by user from start to end. The
+// environment must be visible to the user, healthy if blockUnhealthy is set, open for the whole
+// window and not scheduled for any of the scheduled reservations during it. It returns a
+// *ReservationRuleError for the first rule broken, or nil.
+func (e *Environment) CheckReservationRules(user User, start, end time.Time, scheduled []Reservation, blockUnhealthy bool) error {
+	if !e.VisibleTo(user) {
+		return &ReservationRuleError{Rule: RuleNotVisible, Message: "Environment not found"}
+	}
+	if blockUnhealthy && e.HealthStatus == HealthUnhealthy {
+		return &ReservationRuleError{Rule: RuleUnhealthy, Message: fmt.Sprintf("Environment is unhealthy: %s", e.LastHealthError)}
+	}
+	if e.AllowedHours != nil && !e.AllowedHours.Permits(start, end) {
+		return &ReservationRuleError{
+			Rule:    RuleOutsideAllowedHours,
+			Message: fmt.Sprintf("Environment %s can only be reserved %s; reservations must start and end within those hours", e.Name, e.AllowedHours),
+		}
+	}
+	for i := range scheduled {
+		if scheduled[i].Overlaps(start, end) {
+			return &ReservationRuleError{
+				Rule: RuleScheduled,
+				Message: fmt.Sprintf("Environment is scheduled for another reservation from %s to %s",
+					scheduled[i].StartTime.UTC().Format(time.RFC3339), scheduled[i].EndTime.UTC().Format(time.RFC3339)),
+				Conflict: &scheduled[i],
+			}
+		}
+	}
+	return nil
+}
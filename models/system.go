@@ -0,0 +1,15 @@
+package models
+
+// CheckIntervalUpdateRequest represents the data needed to change how often expired
+// reservations are checked for
+type CheckIntervalUpdateRequest struct {
+	IntervalSeconds int `json:"intervalSeconds"`
+}
+
+// ExpirySweepResult represents what one run of the expiry sweep did
+type ExpirySweepResult struct {
+	Renewed       int `json:"renewed"`
+	Expired       int `json:"expired"`
+	HoldsReleased int `json:"holdsReleased"`
+	Activated     int `json:"activated"`
+}
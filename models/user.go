@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"regexp"
 	"time"
 )
 
@@ -10,6 +12,9 @@ type UserRole string
 const (
 	// RoleAdmin represents an admin user who can manage users and environments
 	RoleAdmin UserRole = "ADMIN"
+	// RoleManager represents an environment manager who can maintain environments and approve reservations,
+	// but not manage users
+	RoleManager UserRole = "MANAGER"
 	// RoleUser represents a normal user who can reserve environments
 	RoleUser UserRole = "USER"
 )
@@ -18,15 +23,45 @@ const (
 type User struct {
 	Username    string    `json:"username" dynamodbav:"username"`
 	Password    string    `json:"-" dynamodbav:"password"` // Password is not returned in JSON responses
+	Email       string    `json:"email,omitempty" dynamodbav:"email,omitempty"`
 	Role        UserRole  `json:"role" dynamodbav:"role"`
 	CreatedAt   time.Time `json:"createdAt" dynamodbav:"createdAt"`
 	LastUpdated time.Time `json:"lastUpdated" dynamodbav:"lastUpdated"`
+
+	// Team limits which environments the user can see and reserve; see Environment.VisibleTo.
+	// Users without a team only see shared environments.
+	Team string `json:"team,omitempty" dynamodbav:"team,omitempty"`
+
+	// Password reset token (stored hashed) and its expiry, cleared once used
+	ResetTokenHash      string     `json:"-" dynamodbav:"resetTokenHash,omitempty"`
+	ResetTokenExpiresAt *time.Time `json:"-" dynamodbav:"resetTokenExpiresAt,omitempty"`
+
+	// TokenVersion is embedded in the user's JWTs; bumping it revokes every token issued before
+	TokenVersion int `json:"-" dynamodbav:"tokenVersion,omitempty"`
+}
+
+// RevokedToken is a JWT that was logged out before it expired, identified by its jti claim.
+// It only needs to be kept until the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `json:"jti" dynamodbav:"jti"`
+	Username  string    `json:"username" dynamodbav:"username"`
+	ExpiresAt time.Time `json:"expiresAt" dynamodbav:"expiresAt"`
+	// TTL is ExpiresAt in Unix seconds, for DynamoDB to delete the item once it has passed
+	TTL int64 `json:"-" dynamodbav:"ttl"`
+}
+
+// TokenRevocationResult reports a user's new token version after their tokens were revoked
+type TokenRevocationResult struct {
+	Username     string `json:"username"`
+	TokenVersion int    `json:"tokenVersion"`
 }
 
 // UserResponse is used for returning user data in API responses (without the password)
 type UserResponse struct {
 	Username    string    `json:"username"`
+	Email       string    `json:"email,omitempty"`
 	Role        UserRole  `json:"role"`
+	Team        string    `json:"team,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
 	LastUpdated time.Time `json:"lastUpdated"`
 }
@@ -35,7 +70,9 @@ type UserResponse struct {
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
 		Username:    u.Username,
+		Email:       u.Email,
 		Role:        u.Role,
+		Team:        u.Team,
 		CreatedAt:   u.CreatedAt,
 		LastUpdated: u.LastUpdated,
 	}
@@ -51,4 +88,61 @@ type LoginRequest struct {
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+
+	// InviteCode is required when self-registration is disabled
+	InviteCode string `json:"inviteCode,omitempty"`
+}
+
+// ForgotPasswordRequest represents the data needed to request a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents the data needed to reset a password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// UserImportError describes a row of a user CSV import that could not be imported
+type UserImportError struct {
+	Row      int    `json:"row"`
+	Username string `json:"username,omitempty"`
+	Error    string `json:"error"`
+}
+
+// UserImportResult represents the outcome of a user CSV import
+type UserImportResult struct {
+	Created []UserResponse    `json:"created"`
+	Failed  []UserImportError `json:"failed"`
+}
+
+// MaxTeamLength is the longest a team name may be
+const MaxTeamLength = 64
+
+// teamPattern allows team names of letters, digits, dashes, underscores and dots that start
+// with a letter or digit
+var teamPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateTeam checks that a team name, already trimmed of surrounding whitespace, is at most
+// MaxTeamLength characters of the ones teamPattern allows. An empty team is valid: it means no
+// team for users and shared for environments.
+func ValidateTeam(team string) error {
+	if team == "" {
+		return nil
+	}
+	if len(team) > MaxTeamLength {
+		return fmt.Errorf("team must be at most %d characters, got %d", MaxTeamLength, len(team))
+	}
+	if !teamPattern.MatchString(team) {
+		return fmt.Errorf("team %q may only contain letters, digits, '-', '_' and '.', and must start with a letter or digit", team)
+	}
+	return nil
+}
+
+// UserTeamRequest represents the data sent when an admin sets a user's team
+type UserTeamRequest struct {
+	// Team is the user's new team; an empty string removes them from their team
+	Team string `json:"team"`
 }
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+)
+
+// WebhookEventType identifies the kind of event sent to webhooks
+type WebhookEventType string
+
+const (
+	// EventReservationCreated is sent when an environment is reserved
+	EventReservationCreated WebhookEventType = "reservation.created"
+	// EventReservationReleased is sent when a reservation is released by its owner
+	EventReservationReleased WebhookEventType = "reservation.released"
+	// EventReservationExpired is sent when a reservation reaches its end time
+	EventReservationExpired WebhookEventType = "reservation.expired"
+	// EventEnvironmentCreated is sent when an environment is created
+	EventEnvironmentCreated WebhookEventType = "environment.created"
+	// EventEnvironmentMaintenance is sent when an environment is taken out of or returned to service
+	EventEnvironmentMaintenance WebhookEventType = "environment.maintenance"
+)
+
+// WebhookEventTypes lists every event type webhooks can subscribe to
+var WebhookEventTypes = []WebhookEventType{
+	EventReservationCreated,
+	EventReservationReleased,
+	EventReservationExpired,
+	EventEnvironmentCreated,
+	EventEnvironmentMaintenance,
+}
+
+// Webhook represents a subscription that receives events as signed HTTP POSTs
+type Webhook struct {
+	ID          string             `json:"id" dynamodbav:"id"`
+	URL         string             `json:"url" dynamodbav:"url"`
+	Secret      string             `json:"-" dynamodbav:"secret"` // Used to sign deliveries, never returned
+	Events      []WebhookEventType `json:"events" dynamodbav:"events"`
+	Active      bool               `json:"active" dynamodbav:"active"`
+	CreatedBy   string             `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt   time.Time          `json:"createdAt" dynamodbav:"createdAt"`
+	LastUpdated time.Time          `json:"lastUpdated" dynamodbav:"lastUpdated"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type
+func (w *Webhook) Subscribes(eventType WebhookEventType) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookCreateRequest represents the data needed to create a webhook
+type WebhookCreateRequest struct {
+	URL    string             `json:"url"`
+	Secret string             `json:"secret"`
+	Events []WebhookEventType `json:"events"`
+}
+
+// WebhookUpdateRequest represents the data that can be changed on a webhook.
+// Fields that are omitted are left unchanged.
+type WebhookUpdateRequest struct {
+	URL    *string             `json:"url,omitempty"`
+	Secret *string             `json:"secret,omitempty"`
+	Events *[]WebhookEventType `json:"events,omitempty"`
+	Active *bool               `json:"active,omitempty"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhooks
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Data      interface{}      `json:"data"`
+}
+
+// DeliveryStatus records the outcome of delivering an event to a webhook
+type DeliveryStatus string
+
+const (
+	// DeliverySucceeded indicates that the webhook responded with a 2xx status
+	DeliverySucceeded DeliveryStatus = "SUCCEEDED"
+	// DeliveryFailed indicates that every attempt to deliver the event failed
+	DeliveryFailed DeliveryStatus = "FAILED"
+)
+
+// WebhookDelivery records an attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	WebhookID    string           `json:"webhookId" dynamodbav:"webhookId"`
+	Timestamp    time.Time        `json:"timestamp" dynamodbav:"timestamp"`
+	EventID      string           `json:"eventId" dynamodbav:"eventId"`
+	EventType    WebhookEventType `json:"eventType" dynamodbav:"eventType"`
+	Status       DeliveryStatus   `json:"status" dynamodbav:"status"`
+	Attempts     int              `json:"attempts" dynamodbav:"attempts"`
+	ResponseCode int              `json:"responseCode,omitempty" dynamodbav:"responseCode,omitempty"`
+	Error        string           `json:"error,omitempty" dynamodbav:"error,omitempty"`
+}
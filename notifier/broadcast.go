@@ -0,0 +1,18 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devreserve/server/models"
+)
+
+// NotifyEnvironmentMessage passes an admin's message on to the holder of a reservation of
+// the environment, such as a warning about upcoming maintenance
+func NotifyEnvironmentMessage(n Notifier, reservation *models.Reservation, environmentName, sender, message string) error {
+	subject := fmt.Sprintf("Message about environment %s", environmentName)
+	body := fmt.Sprintf("%s sent a message to everyone with an active reservation of environment %s:\n\n%s\n\n"+
+		"Your reservation for %s ends at %s.",
+		sender, environmentName, message, reservation.Feature, reservation.EndTime.Format(time.RFC1123))
+	return n.Notify(reservation.Username, subject, body)
+}
@@ -0,0 +1,20 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devreserve/server/models"
+)
+
+// NotifyReservationConfirmed sends the user who created a reservation a confirmation with its
+// times, so the end time is in their inbox. releaseURL is left out of the message if empty.
+func NotifyReservationConfirmed(n Notifier, reservation *models.Reservation, environmentName, releaseURL string) error {
+	subject := fmt.Sprintf("Your reservation of %s is confirmed", environmentName)
+	message := fmt.Sprintf("You reserved environment %s for %s.\n\nStart time: %s\nEnd time: %s",
+		environmentName, reservation.Feature, reservation.StartTime.Format(time.RFC1123), reservation.EndTime.Format(time.RFC1123))
+	if releaseURL != "" {
+		message += fmt.Sprintf("\n\nDone early? Release it at %s", releaseURL)
+	}
+	return n.Notify(reservation.Username, subject, message)
+}
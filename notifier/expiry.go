@@ -0,0 +1,18 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devreserve/server/models"
+)
+
+// NotifyExpired sends the former holder of an expired reservation a summary of it.
+// environmentName is used in the subject; pass the environment ID if the name isn't known.
+func NotifyExpired(n Notifier, reservation *models.Reservation, environmentName string) error {
+	subject := fmt.Sprintf("Your reservation of %s has expired", environmentName)
+	message := fmt.Sprintf("Your reservation of environment %s has expired and the environment is free again.\n\n"+
+		"Feature: %s\nStart time: %s\nEnd time: %s",
+		environmentName, reservation.Feature, reservation.StartTime.Format(time.RFC1123), reservation.EndTime.Format(time.RFC1123))
+	return n.Notify(reservation.Username, subject, message)
+}
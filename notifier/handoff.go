@@ -0,0 +1,16 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devreserve/server/models"
+)
+
+// NotifyHandoff tells the user a queued reservation was created for that the environment
+// they were waiting for is now theirs
+func NotifyHandoff(n Notifier, reservation *models.Reservation) error {
+	message := fmt.Sprintf("Environment %s is now reserved for you for %s until %s. You were first in its queue when it became free.",
+		reservation.EnvironmentID, reservation.Feature, reservation.EndTime.Format(time.RFC1123))
+	return n.Notify(reservation.Username, "Environment handed off to you", message)
+}
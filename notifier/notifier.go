@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/mailer"
+)
+
+// Notifier sends notifications to users
+type Notifier interface {
+	Notify(username, subject, message string) error
+}
+
+// EmailNotifier notifies users by email. Users without an email address are
+// notified in the log only.
+type EmailNotifier struct {
+	userRepo *db.UserRepository
+	mailer   mailer.Mailer
+}
+
+// NewEmailNotifier creates a new EmailNotifier
+func NewEmailNotifier(userRepo *db.UserRepository, mailer mailer.Mailer) *EmailNotifier {
+	return &EmailNotifier{
+		userRepo: userRepo,
+		mailer:   mailer,
+	}
+}
+
+// Notify sends a notification to the user
+func (n *EmailNotifier) Notify(username, subject, message string) error {
+	// Get the user's email address
+	user, err := n.userRepo.GetUser(username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.Email == "" {
+		log.Printf("Notification for %s (no email address): %s - %s", username, subject, message)
+		return nil
+	}
+
+	return n.mailer.Send(user.Email, subject, message)
+}
@@ -0,0 +1,70 @@
+package realtime
+
+import (
+	"log"
+	"sync"
+
+	"github.com/devreserve/server/models"
+)
+
+// eventBufferSize is how many events can wait for a subscriber before new ones are dropped
+const eventBufferSize = 16
+
+// Event is a reservation change sent to event stream subscribers
+type Event struct {
+	Type          models.WebhookEventType `json:"type"`
+	EnvironmentID string                  `json:"environmentId"`
+	Username      string                  `json:"username"`
+}
+
+// EventBus fans reservation changes out to subscribers, each reading from its own
+// buffered channel. Publishing never blocks: a subscriber whose buffer is full misses
+// the event rather than holding up the request that caused it.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates a new EventBus with no subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscriber and returns the channel its events arrive on.
+// Callers must Unsubscribe when they stop reading.
+func (b *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends a reservation change to every subscriber
+func (b *EventBus) Publish(eventType models.WebhookEventType, reservation *models.Reservation) {
+	event := Event{
+		Type:          eventType,
+		EnvironmentID: reservation.EnvironmentID,
+		Username:      reservation.Username,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Event stream subscriber is too slow, dropping %s event for environment %s", event.Type, event.EnvironmentID)
+		}
+	}
+}
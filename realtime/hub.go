@@ -0,0 +1,171 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/service"
+	"github.com/gorilla/websocket"
+)
+
+// Message types sent to clients
+const (
+	// MessageEnvironments carries the full environment list, sent once on connect
+	MessageEnvironments = "environments"
+	// MessageEnvironmentUpdated carries one environment whose status or reservation changed
+	MessageEnvironmentUpdated = "environment_updated"
+)
+
+const (
+	// sendBufferSize is how many messages can wait for a client before it is disconnected
+	sendBufferSize = 32
+	// writeTimeout is how long a single write to a client may take
+	writeTimeout = 10 * time.Second
+	// pingInterval is how often clients are pinged to keep idle connections open
+	pingInterval = 30 * time.Second
+	// pongTimeout is how long a client may go without answering a ping
+	pongTimeout = 2 * pingInterval
+)
+
+// Message is a message sent to WebSocket clients
+type Message struct {
+	Type         string                               `json:"type"`
+	Environment  *models.EnvironmentWithReservation   `json:"environment,omitempty"`
+	Environments *[]models.EnvironmentWithReservation `json:"environments,omitempty"`
+}
+
+// client is a connected WebSocket client. Messages are queued on send and written by the
+// client's own goroutine, since a connection supports only one concurrent writer. send is
+// never closed, so a broadcast racing with a disconnect can't panic; done stops the writer.
+// user is who connected, so they are only sent environments they can see.
+type client struct {
+	conn *websocket.Conn
+	user models.User
+	send chan []byte
+	done chan struct{}
+}
+
+// Hub keeps track of the connected WebSocket clients and broadcasts environment changes
+// to those who can see the environment. Broadcasting never blocks the caller: a client that falls too far
+// behind is disconnected and has to reconnect to get a fresh environment list.
+type Hub struct {
+	envService *service.EnvironmentService
+	clients    sync.Map // *client -> struct{}
+}
+
+// NewHub creates a new Hub that looks up changed environments through envService
+func NewHub(envService *service.EnvironmentService) *Hub {
+	return &Hub{envService: envService}
+}
+
+// Serve sends the initial message to a newly upgraded connection made by user and then
+// keeps it registered for broadcasts until the client disconnects. It blocks until then.
+func (h *Hub) Serve(conn *websocket.Conn, user models.User, initial Message) {
+	c := &client{
+		conn: conn,
+		user: user,
+		send: make(chan []byte, sendBufferSize),
+		done: make(chan struct{}),
+	}
+	payload, err := json.Marshal(initial)
+	if err != nil {
+		log.Printf("Error encoding WebSocket message: %v", err)
+		conn.Close()
+		return
+	}
+	c.send <- payload
+
+	h.clients.Store(c, struct{}{})
+	go c.writeLoop()
+	c.readLoop()
+
+	h.clients.Delete(c)
+	close(c.done)
+}
+
+// Broadcast queues a message for every connected client, skipping those who can't see the
+// message's environment. It is safe to call from any goroutine.
+func (h *Hub) Broadcast(message Message) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding WebSocket message: %v", err)
+		return
+	}
+
+	h.clients.Range(func(key, _ interface{}) bool {
+		c := key.(*client)
+		if message.Environment != nil && !message.Environment.VisibleTo(c.user) {
+			return true
+		}
+		select {
+		case c.send <- payload:
+		default:
+			// Closing the connection ends the client's read loop, which unregisters it
+			log.Printf("WebSocket client %s is too slow, disconnecting it", c.conn.RemoteAddr())
+			c.conn.Close()
+		}
+		return true
+	})
+}
+
+// EnvironmentChanged broadcasts the current state of an environment to every client who can
+// see it. The environment is looked up in the background so callers don't wait on DynamoDB.
+func (h *Hub) EnvironmentChanged(environmentID string) {
+	go func() {
+		env, err := h.envService.GetEnvironment(environmentID, time.Now())
+		if err != nil {
+			log.Printf("Error getting environment %s to broadcast: %v", environmentID, err)
+			return
+		}
+
+		// Everyone who can see the environment gets the message, so leave out the secret details
+		env.SecretDetails = nil
+		h.Broadcast(Message{
+			Type:        MessageEnvironmentUpdated,
+			Environment: env,
+		})
+	}()
+}
+
+// readLoop discards anything the client sends, returning once the connection is closed
+func (c *client) readLoop() {
+	c.conn.SetReadLimit(512)
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// writeLoop writes queued messages and pings to the client until it disconnects
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case payload := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
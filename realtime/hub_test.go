@@ -0,0 +1,80 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/models"
+	"github.com/gorilla/websocket"
+)
+
+// dialHub serves a hub connection made by user on a test server and dials it, returning
+// the client side once the initial message has arrived
+func dialHub(t *testing.T, hub *Hub, user models.User) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		hub.Serve(conn, user, Message{Type: MessageEnvironments, Environments: &[]models.EnvironmentWithReservation{}})
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The client is registered for broadcasts before its initial message is written
+	var initial Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&initial); err != nil || initial.Type != MessageEnvironments {
+		t.Fatalf("initial message = %+v, %v; want the environment list", initial, err)
+	}
+	return conn
+}
+
+func TestBroadcastSkipsClientsWhoCantSeeTheEnvironment(t *testing.T) {
+	hub := NewHub(nil)
+	conn := dialHub(t, hub, models.User{Username: "alice", Role: models.RoleUser, Team: "payments"})
+
+	for _, env := range []models.Environment{
+		{ID: "env-search", Team: "search"},
+		{ID: "env-shared"},
+	} {
+		hub.Broadcast(Message{Type: MessageEnvironmentUpdated, Environment: &models.EnvironmentWithReservation{Environment: env}})
+	}
+
+	var got Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	if got.Environment == nil || got.Environment.ID != "env-shared" {
+		t.Errorf("first update = %+v, want env-shared", got.Environment)
+	}
+}
+
+func TestBroadcastReachesClientsWhoSeeEveryTeam(t *testing.T) {
+	hub := NewHub(nil)
+	conn := dialHub(t, hub, models.User{Username: "root", Role: models.RoleAdmin})
+
+	hub.Broadcast(Message{Type: MessageEnvironmentUpdated, Environment: &models.EnvironmentWithReservation{
+		Environment: models.Environment{ID: "env-search", Team: "search"},
+	}})
+
+	var got Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	if got.Environment == nil || got.Environment.ID != "env-search" {
+		t.Errorf("update = %+v, want env-search", got.Environment)
+	}
+}
@@ -0,0 +1,114 @@
+// Package revocation keeps track of revoked JWTs so they can be rejected before they expire
+package revocation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// Store holds an in-memory copy of the revoked JWT IDs and of users' token versions, so every
+// request can be checked without reading DynamoDB. Revocations made through a Store apply to it
+// immediately, and to the other replicas' stores the next time they refresh.
+type Store struct {
+	tokenRepo *db.TokenRepository
+	userRepo  db.UserRepositoryInterface
+
+	mu       sync.RWMutex
+	revoked  map[string]time.Time // expiry by jti
+	versions map[string]int       // token version by username
+}
+
+// NewStore creates a new Store; call Refresh to load the revocations
+func NewStore(tokenRepo *db.TokenRepository, userRepo db.UserRepositoryInterface) *Store {
+	return &Store{
+		tokenRepo: tokenRepo,
+		userRepo:  userRepo,
+		revoked:   make(map[string]time.Time),
+		versions:  make(map[string]int),
+	}
+}
+
+// Refresh reloads the revoked tokens and token versions from DynamoDB. If either can't be
+// read the store keeps what it had.
+func (s *Store) Refresh() error {
+	now := time.Now()
+	tokens, err := s.tokenRepo.ListRevokedTokens(now)
+	if err != nil {
+		return err
+	}
+	versions, err := s.userRepo.ListTokenVersions()
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		revoked[token.JTI] = token.ExpiresAt
+	}
+
+	// Keep revocations made through this store while the tables were being read
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.After(now) {
+			revoked[jti] = expiresAt
+		}
+	}
+	s.revoked = revoked
+	for username, version := range s.versions {
+		if version > versions[username] {
+			versions[username] = version
+		}
+	}
+	s.versions = versions
+	return nil
+}
+
+// IsRevoked reports whether a validated token has been logged out, or issued before its
+// user's tokens were revoked
+func (s *Store) IsRevoked(claims *utils.Claims) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if claims.TokenVersion < s.versions[claims.Username] {
+		return true
+	}
+	_, revoked := s.revoked[claims.ID]
+	return claims.ID != "" && revoked
+}
+
+// RevokeToken logs a token out until expiresAt, when it would have stopped working anyway
+func (s *Store) RevokeToken(claims *utils.Claims, expiresAt time.Time) error {
+	err := s.tokenRepo.RevokeToken(models.RevokedToken{
+		JTI:       claims.ID,
+		Username:  claims.Username,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[claims.ID] = expiresAt
+	return nil
+}
+
+// RevokeUserTokens revokes every token issued to a user so far and returns the user's new
+// token version. It returns db.ErrNotFound if the user doesn't exist.
+func (s *Store) RevokeUserTokens(username string) (int, error) {
+	version, err := s.userRepo.IncrementTokenVersion(username)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version > s.versions[username] {
+		s.versions[username] = version
+	}
+	return version, nil
+}
@@ -0,0 +1,268 @@
+// Package seed creates and updates environments from a versioned JSON or YAML file, at startup
+// or through the import endpoint
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode decides what happens to environments in the file that already exist
+type Mode string
+
+const (
+	// ModeCreate only creates the environments that don't exist yet
+	ModeCreate Mode = "create"
+	// ModeSync also updates the description, tags and limits of existing environments
+	ModeSync Mode = "sync"
+)
+
+// ParseMode parses a seed mode, returning an error for anything but create or sync
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case ModeCreate, ModeSync:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("seed mode must be %s or %s, got %q", ModeCreate, ModeSync, mode)
+}
+
+// lockName is the name of the lock that stops two seeds, e.g. from replicas starting at the
+// same time, from creating the same environments twice
+const lockName = "environment-seed"
+
+// lockTTL is how long a seed may hold the lock before another can take it over
+const lockTTL = 5 * time.Minute
+
+// ErrInProgress is returned when another seed holds the lock
+var ErrInProgress = errors.New("another environment seed is in progress")
+
+// FileError lists everything wrong with a seed file, each problem prefixed with its line
+type FileError struct {
+	Problems []string
+}
+
+// Error implements the error interface
+func (e *FileError) Error() string {
+	return "invalid seed file: " + strings.Join(e.Problems, "; ")
+}
+
+// Parse reads a seed file, in JSON or YAML, of the form
+//
+//	environments:
+//	  - name: staging-1
+//	    description: Main staging environment
+//	    tags: [staging, eu]
+//	    minReservationMins: 15
+//	    maxReservationMins: 240
+//
+// Names are trimmed and validated like names given to the API, may appear only once, and the
+// limits are checked against the server-wide defaults. Every problem found is returned in a
+// FileError rather than only the first.
+func Parse(data []byte, defaults models.ReservationLimits) ([]models.EnvironmentSeed, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &FileError{Problems: []string{strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+	if len(doc.Content) == 0 {
+		return nil, &FileError{Problems: []string{"the file is empty"}}
+	}
+
+	// Find the environments list
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &FileError{Problems: []string{fmt.Sprintf("line %d: expected a mapping with an environments list", root.Line)}}
+	}
+	var list *yaml.Node
+	var problems []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "environments" {
+			problems = append(problems, fmt.Sprintf("line %d: unknown field %q", key.Line, key.Value))
+			continue
+		}
+		list = value
+	}
+	if list == nil {
+		problems = append(problems, fmt.Sprintf("line %d: environments list is required", root.Line))
+		return nil, &FileError{Problems: problems}
+	}
+	if list.Kind != yaml.SequenceNode {
+		problems = append(problems, fmt.Sprintf("line %d: environments must be a list", list.Line))
+		return nil, &FileError{Problems: problems}
+	}
+
+	// Check and decode each environment
+	var seeds []models.EnvironmentSeed
+	names := make(map[string]int)
+	for i, item := range list.Content {
+		itemProblems := checkFields(item, i)
+		if len(itemProblems) > 0 {
+			problems = append(problems, itemProblems...)
+			continue
+		}
+
+		var seed models.EnvironmentSeed
+		if err := item.Decode(&seed); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: %v", item.Line, i, err))
+			continue
+		}
+		seed.Name = strings.TrimSpace(seed.Name)
+		if err := models.ValidateEnvironmentName(seed.Name); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: %v", item.Line, i, err))
+			continue
+		}
+		if line, ok := names[strings.ToLower(seed.Name)]; ok {
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: environment name %q is already used on line %d", item.Line, i, seed.Name, line))
+			continue
+		}
+		names[strings.ToLower(seed.Name)] = item.Line
+		env := seed.Environment()
+		if err := env.ValidateReservationLimits(defaults); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: %v", item.Line, i, err))
+			continue
+		}
+		seeds = append(seeds, seed)
+	}
+	if len(problems) > 0 {
+		return nil, &FileError{Problems: problems}
+	}
+
+	return seeds, nil
+}
+
+// checkFields checks that an environment in the file is a mapping of known fields with values
+// of the right types, so mistakes are reported with their line
+func checkFields(item *yaml.Node, index int) []string {
+	if item.Kind != yaml.MappingNode {
+		return []string{fmt.Sprintf("line %d: environments[%d] must be a mapping", item.Line, index)}
+	}
+
+	var problems []string
+	hasName := false
+	for i := 0; i+1 < len(item.Content); i += 2 {
+		key, value := item.Content[i], item.Content[i+1]
+		wrongType := ""
+		switch key.Value {
+		case "name":
+			hasName = true
+			if !isScalar(value, "!!str") {
+				wrongType = "a string"
+			}
+		case "description":
+			if !isScalar(value, "!!str") {
+				wrongType = "a string"
+			}
+		case "tags":
+			if value.Kind != yaml.SequenceNode {
+				wrongType = "a list of strings"
+				break
+			}
+			for _, tag := range value.Content {
+				if !isScalar(tag, "!!str") {
+					wrongType = "a list of strings"
+				}
+			}
+		case "minReservationMins", "maxReservationMins":
+			if !isScalar(value, "!!int") {
+				wrongType = "a whole number of minutes"
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: unknown field %q", key.Line, index, key.Value))
+			continue
+		}
+		if wrongType != "" {
+			problems = append(problems, fmt.Sprintf("line %d: environments[%d]: %s must be %s", value.Line, index, key.Value, wrongType))
+		}
+	}
+	if !hasName {
+		problems = append(problems, fmt.Sprintf("line %d: environments[%d]: name is required", item.Line, index))
+	}
+	return problems
+}
+
+// isScalar reports whether a node is a scalar with the given tag
+func isScalar(node *yaml.Node, tag string) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == tag
+}
+
+// Seeder applies seed files to the Environments table
+type Seeder struct {
+	envRepo  db.EnvironmentRepositoryInterface
+	lockRepo db.LockRepositoryInterface
+}
+
+// NewSeeder creates a new Seeder
+func NewSeeder(envRepo db.EnvironmentRepositoryInterface, lockRepo db.LockRepositoryInterface) *Seeder {
+	return &Seeder{
+		envRepo:  envRepo,
+		lockRepo: lockRepo,
+	}
+}
+
+// Apply creates the seeded environments that don't exist yet, matching names ignoring case,
+// and with ModeSync updates the description, tags and limits of those that do. Environments
+// that already match, or exist in ModeCreate, are skipped, so applying the same file twice
+// changes nothing the second time. Status, reservations and other settings are never changed.
+//
+// Only one seed runs at a time across every replica; Apply returns ErrInProgress if another
+// holds the lock. It returns the environments it created along with the counts, which are
+// accurate up to the failure if it returns an error.
+func (s *Seeder) Apply(seeds []models.EnvironmentSeed, mode Mode, username string) (models.EnvironmentSeedResult, []models.Environment, error) {
+	result := models.EnvironmentSeedResult{Mode: string(mode)}
+
+	// Take the lock, giving it up when done
+	owner := uuid.New().String()
+	acquired, err := s.lockRepo.AcquireLock(lockName, owner, lockTTL)
+	if err != nil {
+		return result, nil, err
+	}
+	if !acquired {
+		return result, nil, ErrInProgress
+	}
+	defer func() {
+		if err := s.lockRepo.ReleaseLock(lockName, owner); err != nil {
+			log.Printf("Error releasing environment seed lock: %v", err)
+		}
+	}()
+
+	// Get the existing environments by name, archived or not
+	environments, err := s.envRepo.ListEnvironments(true)
+	if err != nil {
+		return result, nil, err
+	}
+	existing := make(map[string]*models.Environment, len(environments))
+	for i := range environments {
+		existing[strings.ToLower(environments[i].Name)] = &environments[i]
+	}
+
+	var created []models.Environment
+	for _, seed := range seeds {
+		env, ok := existing[strings.ToLower(seed.Name)]
+		switch {
+		case !ok:
+			createdEnv, err := s.envRepo.CreateEnvironment(seed.Environment(), username)
+			if err != nil {
+				return result, created, fmt.Errorf("failed to create environment %s: %w", seed.Name, err)
+			}
+			created = append(created, *createdEnv)
+			result.Created++
+		case mode == ModeSync && !seed.Matches(env):
+			if err := s.envRepo.UpdateEnvironmentDefinition(env.ID, seed); err != nil {
+				return result, created, fmt.Errorf("failed to update environment %s: %w", seed.Name, err)
+			}
+			result.Updated++
+		default:
+			result.Skipped++
+		}
+	}
+
+	return result, created, nil
+}
@@ -0,0 +1,222 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+)
+
+// testDefaults are the server-wide reservation limits the test files are checked against
+var testDefaults = models.ReservationLimits{MinMins: 10, MaxMins: 480}
+
+// envStore is an in-memory Environments table behind a mock repository
+type envStore struct {
+	mu      sync.Mutex
+	envs    []models.Environment
+	creates int
+	updates int
+}
+
+// repo returns a mock environment repository reading and writing the store
+func (s *envStore) repo() *mock.MockEnvironmentRepository {
+	return &mock.MockEnvironmentRepository{
+		ListEnvironmentsFunc: func(bool) ([]models.Environment, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return append([]models.Environment(nil), s.envs...), nil
+		},
+		CreateEnvironmentFunc: func(env models.Environment, username string) (*models.Environment, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.creates++
+			env.ID = fmt.Sprintf("env-%d", len(s.envs)+1)
+			env.CreatedBy = username
+			s.envs = append(s.envs, env)
+			return &env, nil
+		},
+		UpdateEnvironmentDefinitionFunc: func(id string, seed models.EnvironmentSeed) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.updates++
+			for i := range s.envs {
+				if s.envs[i].ID == id {
+					s.envs[i].Description = seed.Description
+					s.envs[i].Tags = seed.Tags
+					s.envs[i].MinReservationMins = seed.MinReservationMins
+					s.envs[i].MaxReservationMins = seed.MaxReservationMins
+					return nil
+				}
+			}
+			return errors.New("no environment " + id)
+		},
+	}
+}
+
+// freeLock returns a mock lock repository whose lock is always free
+func freeLock() *mock.MockLockRepository {
+	return &mock.MockLockRepository{
+		AcquireLockFunc: func(string, string, time.Duration) (bool, error) { return true, nil },
+		ReleaseLockFunc: func(string, string) error { return nil },
+	}
+}
+
+// parse parses a seed file, failing the test if it's invalid
+func parse(t *testing.T, file string) []models.EnvironmentSeed {
+	t.Helper()
+	seeds, err := Parse([]byte(file), testDefaults)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return seeds
+}
+
+const seedFile = `
+environments:
+  - name: staging-1
+    description: Main staging environment
+    tags: [staging, eu]
+    maxReservationMins: 240
+  - name: staging-2
+    description: Spare staging environment
+`
+
+func TestApplySameFileTwice(t *testing.T) {
+	for _, mode := range []Mode{ModeCreate, ModeSync} {
+		t.Run(string(mode), func(t *testing.T) {
+			store := &envStore{}
+			seeder := NewSeeder(store.repo(), freeLock())
+			seeds := parse(t, seedFile)
+
+			result, created, err := seeder.Apply(seeds, mode, "admin")
+			if err != nil {
+				t.Fatalf("first Apply: %v", err)
+			}
+			if want := (models.EnvironmentSeedResult{Mode: string(mode), Created: 2}); result != want {
+				t.Errorf("first Apply = %+v, want %+v", result, want)
+			}
+			if len(created) != 2 || created[0].Name != "staging-1" || created[0].Status != models.StatusFree || created[0].CreatedBy != "admin" {
+				t.Errorf("first Apply created %+v, want staging-1 and staging-2, free and created by admin", created)
+			}
+
+			// The second import finds everything in place
+			result, created, err = seeder.Apply(seeds, mode, "admin")
+			if err != nil {
+				t.Fatalf("second Apply: %v", err)
+			}
+			if want := (models.EnvironmentSeedResult{Mode: string(mode), Skipped: 2}); result != want {
+				t.Errorf("second Apply = %+v, want %+v", result, want)
+			}
+			if len(created) != 0 || store.creates != 2 || store.updates != 0 {
+				t.Errorf("second Apply created %d, leaving %d creates and %d updates; want 2 creates and no updates", len(created), store.creates, store.updates)
+			}
+		})
+	}
+}
+
+func TestApplySyncLeavesStatusAlone(t *testing.T) {
+	store := &envStore{envs: []models.Environment{
+		{ID: "env-1", Name: "Staging-1", Description: "Old description", Status: models.StatusReserved, CurrentReservationID: "res-1"},
+	}}
+	seeder := NewSeeder(store.repo(), freeLock())
+	seeds := parse(t, seedFile)
+
+	// Only sync updates the existing environment, matching its name ignoring case
+	result, _, err := seeder.Apply(seeds, ModeCreate, "admin")
+	if err != nil {
+		t.Fatalf("Apply(create): %v", err)
+	}
+	if want := (models.EnvironmentSeedResult{Mode: "create", Created: 1, Skipped: 1}); result != want {
+		t.Errorf("Apply(create) = %+v, want %+v", result, want)
+	}
+	result, _, err = seeder.Apply(seeds, ModeSync, "admin")
+	if err != nil {
+		t.Fatalf("Apply(sync): %v", err)
+	}
+	if want := (models.EnvironmentSeedResult{Mode: "sync", Updated: 1, Skipped: 1}); result != want {
+		t.Errorf("Apply(sync) = %+v, want %+v", result, want)
+	}
+
+	env := store.envs[0]
+	if env.Description != "Main staging environment" || !reflect.DeepEqual(env.Tags, []string{"staging", "eu"}) || env.MaxReservationMins != 240 {
+		t.Errorf("synced environment = %+v, want the file's description, tags and limits", env)
+	}
+	if env.Status != models.StatusReserved || env.CurrentReservationID != "res-1" {
+		t.Errorf("synced environment is %s held by %q, want it still reserved by res-1", env.Status, env.CurrentReservationID)
+	}
+
+	// Syncing again finds nothing left to change
+	result, _, err = seeder.Apply(seeds, ModeSync, "admin")
+	if err != nil {
+		t.Fatalf("second Apply(sync): %v", err)
+	}
+	if want := (models.EnvironmentSeedResult{Mode: "sync", Skipped: 2}); result != want {
+		t.Errorf("second Apply(sync) = %+v, want %+v", result, want)
+	}
+}
+
+func TestApplyWhileAnotherSeedRuns(t *testing.T) {
+	lock := &mock.MockLockRepository{
+		AcquireLockFunc: func(string, string, time.Duration) (bool, error) { return false, nil },
+	}
+	seeder := NewSeeder((&envStore{}).repo(), lock)
+	if _, _, err := seeder.Apply(parse(t, seedFile), ModeCreate, "admin"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Apply error = %v, want ErrInProgress", err)
+	}
+}
+
+func TestParseReportsProblemsByLine(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []string
+	}{
+		{"empty", ``, []string{"the file is empty"}},
+		{"no list", "environment:\n  - name: a\n", []string{`line 1: unknown field "environment"`, "line 1: environments list is required"}},
+		{"not a list", "environments: staging-1\n", []string{"line 1: environments must be a list"}},
+		{
+			"bad fields",
+			"environments:\n  - name: staging-1\n    tags: staging\n  - description: no name\n  - name: staging-3\n    maxReservationMins: soon\n    owner: ops\n",
+			[]string{
+				"line 3: environments[0]: tags must be a list of strings",
+				"line 4: environments[1]: name is required",
+				"line 6: environments[2]: maxReservationMins must be a whole number of minutes",
+				`line 7: environments[2]: unknown field "owner"`,
+			},
+		},
+		{
+			"duplicate names",
+			"environments:\n  - name: staging-1\n  - name: Staging-1\n",
+			[]string{`line 3: environments[1]: environment name "Staging-1" is already used on line 2`},
+		},
+		{
+			"limits over the maximum",
+			"environments:\n  - name: staging-1\n    minReservationMins: 600\n",
+			[]string{"line 2: environments[0]: minimum reservation duration (600 minutes) can't exceed the maximum (480 minutes)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.file), testDefaults)
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("Parse error = %v, want a FileError", err)
+			}
+			if !reflect.DeepEqual(fileErr.Problems, tt.want) {
+				t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(fileErr.Problems, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	// JSON is read the same way
+	seeds := parse(t, `{"environments": [{"name": " staging-1 ", "tags": ["eu"]}]}`)
+	if len(seeds) != 1 || seeds[0].Name != "staging-1" || !reflect.DeepEqual(seeds[0].Tags, []string{"eu"}) {
+		t.Errorf("Parse(JSON) = %+v, want staging-1 tagged eu", seeds)
+	}
+}
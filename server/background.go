@@ -0,0 +1,165 @@
+package server
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/health"
+	"github.com/devreserve/server/jobs"
+	"github.com/devreserve/server/revocation"
+	"github.com/google/uuid"
+)
+
+// StartBackgroundJobs starts the goroutines that keep this process up to date. The revoked
+// tokens are always refreshed. The expiry sweep, scheduled activation and health checks only
+// run with BACKGROUND_JOBS_ENABLED; without it the internal sweep endpoint replaces them.
+func StartBackgroundJobs(d *Deps) {
+	// Start a background goroutine to reload the revoked tokens
+	go runRevocationRefresh(d.Config, d.Revocations)
+
+	if !d.Config.BackgroundJobsEnabled {
+		log.Println("Background jobs are disabled; call POST /api/internal/run-expiry-sweep to expire reservations")
+		return
+	}
+
+	// Start a background goroutine to check for expired reservations
+	go runExpirySweep(d.Config, d.Sweeper, d.LockRepo, d.CheckIntervalCh)
+
+	// Start a background goroutine to start scheduled reservations
+	go runScheduledActivation(d.Config, d.Sweeper, d.LockRepo)
+
+	// Start a background goroutine to probe environment health check URLs
+	go runHealthChecks(d.Config, health.NewProber(d.EnvRepo, d.Config.HealthCheckTimeout, d.Config.HealthCheckWorkers), d.LockRepo)
+}
+
+// expirySweepLockName is the name of the lock that elects the replica
+// responsible for running the expiry sweep
+const expirySweepLockName = "expiry-sweep"
+
+// runExpirySweep periodically frees environments whose reservations have expired.
+//
+// Every replica runs this loop, but each tick is offset by a random jitter so
+// replicas don't all wake up at the same second, and a replica only runs the
+// sweep if it holds the DynamoDB lease lock. The lease is renewed on every tick
+// and lasts two intervals plus the jitter, so the leader keeps it even when its
+// next tick comes late, and if the leader dies another replica takes over within
+// roughly two intervals. The tradeoff is that expired reservations may
+// be released up to that much later than usual during a failover, and each
+// replica still pays for one conditional write per tick.
+//
+// A new interval received on intervalCh takes effect immediately: the pending
+// tick is dropped and the timer restarted with the new interval.
+func runExpirySweep(cfg config.Config, sweeper *jobs.Sweeper, lockRepo *db.LockRepository, intervalCh <-chan time.Duration) {
+	hostname, _ := os.Hostname()
+	owner := hostname + "-" + uuid.New().String()
+	interval := cfg.ExpiryCheckInterval
+	nextWait := func() time.Duration {
+		wait := interval
+		if cfg.ExpiryCheckJitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(cfg.ExpiryCheckJitter)))
+		}
+		return wait
+	}
+	ticker := time.NewTicker(nextWait())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case interval = <-intervalCh:
+			log.Printf("Expiry check interval changed to %s", interval)
+			ticker.Reset(nextWait())
+			continue
+		case <-ticker.C:
+			// Draw a new jitter for the next tick
+			ticker.Reset(nextWait())
+		}
+		leaseTTL := 2*interval + cfg.ExpiryCheckJitter
+
+		// Only the replica holding the lock runs the sweep
+		acquired, err := lockRepo.AcquireLock(expirySweepLockName, owner, leaseTTL)
+		if err != nil {
+			log.Printf("Error acquiring expiry sweep lock: %v", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		sweeper.SweepExpired()
+	}
+}
+
+// scheduledActivationLockName is the name of the lock that elects the replica
+// responsible for starting scheduled reservations
+const scheduledActivationLockName = "scheduled-activation"
+
+// runScheduledActivation periodically activates scheduled reservations whose start time has
+// passed, reserving their environments and telling their owners. Like the expiry sweep, only
+// the replica holding the lease lock activates them.
+func runScheduledActivation(cfg config.Config, sweeper *jobs.Sweeper, lockRepo *db.LockRepository) {
+	hostname, _ := os.Hostname()
+	owner := hostname + "-" + uuid.New().String()
+	leaseTTL := 2 * cfg.ScheduleCheckInterval
+
+	for {
+		time.Sleep(cfg.ScheduleCheckInterval)
+
+		// Only the replica holding the lock activates reservations
+		acquired, err := lockRepo.AcquireLock(scheduledActivationLockName, owner, leaseTTL)
+		if err != nil {
+			log.Printf("Error acquiring scheduled activation lock: %v", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		sweeper.ActivateScheduled()
+	}
+}
+
+// runRevocationRefresh periodically reloads the revoked tokens and token versions, so tokens
+// revoked through other replicas are rejected by this one too. Every replica refreshes its own copy.
+func runRevocationRefresh(cfg config.Config, revocations *revocation.Store) {
+	for {
+		time.Sleep(cfg.TokenRevocationRefreshInterval)
+		if err := revocations.Refresh(); err != nil {
+			log.Printf("Error refreshing revoked tokens: %v", err)
+		}
+	}
+}
+
+// healthCheckLockName is the name of the lock that elects the replica
+// responsible for probing environment health
+const healthCheckLockName = "health-check"
+
+// runHealthChecks periodically probes the health check URLs of environments.
+// Like the expiry sweep, only the replica holding the lease lock probes, so each
+// environment is checked once per interval however many replicas are running.
+func runHealthChecks(cfg config.Config, prober *health.Prober, lockRepo *db.LockRepository) {
+	hostname, _ := os.Hostname()
+	owner := hostname + "-" + uuid.New().String()
+	leaseTTL := 2 * cfg.HealthCheckInterval
+
+	for {
+		time.Sleep(cfg.HealthCheckInterval)
+
+		// Only the replica holding the lock runs the checks
+		acquired, err := lockRepo.AcquireLock(healthCheckLockName, owner, leaseTTL)
+		if err != nil {
+			log.Printf("Error acquiring health check lock: %v", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		if err := prober.ProbeAll(); err != nil {
+			log.Printf("Error checking environment health: %v", err)
+		}
+	}
+}
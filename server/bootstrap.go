@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/seed"
+	"github.com/devreserve/server/utils"
+)
+
+// reconcileEnvironmentStatuses corrects environment statuses that disagree with the active
+// reservations, logging each correction. Failures are logged rather than stopping the server.
+func reconcileEnvironmentStatuses(reservationRepo *db.ReservationRepository) {
+	fixes, err := reservationRepo.ReconcileEnvironmentStatuses(false)
+	for _, fix := range fixes {
+		log.Printf("Changed environment %s from %s to %s: %s", fix.EnvironmentID, fix.OldStatus, fix.NewStatus, fix.Reason)
+	}
+	if err != nil {
+		log.Printf("Error reconciling environment statuses: %v", err)
+		return
+	}
+	log.Printf("Reconciled environment statuses, %d corrected", len(fixes))
+}
+
+// bootstrapAdmin gives a fresh deployment its first admin, since registration only
+// creates normal users. If no admin exists yet, it promotes the existing user named by
+// ADMIN_PROMOTE_USERNAME, or otherwise creates an admin from BOOTSTRAP_ADMIN_USERNAME and
+// BOOTSTRAP_ADMIN_PASSWORD. Once any admin exists it does nothing, so the variables can
+// safely stay set.
+func bootstrapAdmin(cfg config.Config, userRepo db.UserRepositoryInterface) {
+	if cfg.BootstrapAdminUsername == "" && cfg.BootstrapAdminPromoteUsername == "" {
+		return
+	}
+	if cfg.BootstrapAdminPromoteUsername == "" && cfg.BootstrapAdminPassword == "" {
+		log.Printf("BOOTSTRAP_ADMIN_USERNAME is set without BOOTSTRAP_ADMIN_PASSWORD, not creating a bootstrap admin")
+		return
+	}
+
+	// Skip if any admin already exists
+	hasAdmin, err := userRepo.HasAdmin()
+	if err != nil {
+		log.Printf("Error checking for an existing admin, not bootstrapping one: %v", err)
+		return
+	}
+	if hasAdmin {
+		return
+	}
+
+	// Promoting an existing user takes precedence over creating one
+	if cfg.BootstrapAdminPromoteUsername != "" {
+		promoteBootstrapAdmin(cfg.BootstrapAdminPromoteUsername, userRepo)
+		return
+	}
+
+	// A user registered under the username first keeps its role; promoting it has to be asked for
+	existing, err := userRepo.GetUser(cfg.BootstrapAdminUsername)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Printf("Error checking for an existing user %s, not creating a bootstrap admin: %v", cfg.BootstrapAdminUsername, err)
+		return
+	}
+	if existing != nil {
+		log.Printf("User %s already exists with role %s, not creating a bootstrap admin; set ADMIN_PROMOTE_USERNAME=%s to promote it",
+			existing.Username, existing.Role, existing.Username)
+		return
+	}
+
+	// Hash the password
+	hashedPassword, err := utils.HashPassword(cfg.BootstrapAdminPassword)
+	if err != nil {
+		log.Printf("Error hashing bootstrap admin password: %v", err)
+		return
+	}
+
+	// Create the admin (fails if another replica created it first)
+	if err := userRepo.CreateUser(models.User{
+		Username: cfg.BootstrapAdminUsername,
+		Password: hashedPassword,
+		Role:     models.RoleAdmin,
+	}); err != nil {
+		log.Printf("Error creating bootstrap admin %s: %v", cfg.BootstrapAdminUsername, err)
+		return
+	}
+	log.Printf("Created bootstrap admin %s from BOOTSTRAP_ADMIN_USERNAME", cfg.BootstrapAdminUsername)
+}
+
+// promoteBootstrapAdmin makes an existing user an admin
+func promoteBootstrapAdmin(username string, userRepo db.UserRepositoryInterface) {
+	user, err := userRepo.GetUser(username)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Printf("User %s from ADMIN_PROMOTE_USERNAME doesn't exist, not promoting it to admin", username)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user %s to promote to admin: %v", username, err)
+		return
+	}
+
+	user.Role = models.RoleAdmin
+	if err := userRepo.UpdateUser(*user); err != nil {
+		log.Printf("Error promoting %s to admin: %v", username, err)
+		return
+	}
+	log.Printf("Promoted %s to admin from ADMIN_PROMOTE_USERNAME", username)
+}
+
+// seedUsername is recorded as the creator of environments created from the seed file
+const seedUsername = "seed"
+
+// seedEnvironments applies ENVIRONMENTS_SEED_FILE. An unreadable or invalid file stops the
+// server, so mistakes are noticed; if another replica is already seeding, it is left to finish.
+func seedEnvironments(cfg config.Config, seeder *seed.Seeder) error {
+	data, err := os.ReadFile(cfg.EnvironmentsSeedFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ENVIRONMENTS_SEED_FILE: %w", err)
+	}
+	seeds, err := seed.Parse(data, models.ReservationLimits{MinMins: cfg.MinReservationMins, MaxMins: cfg.MaxReservationMins})
+	if err != nil {
+		return fmt.Errorf("%s: %w", cfg.EnvironmentsSeedFile, err)
+	}
+	mode, err := seed.ParseMode(cfg.SeedMode)
+	if err != nil {
+		return err
+	}
+
+	result, _, err := seeder.Apply(seeds, mode, seedUsername)
+	if errors.Is(err, seed.ErrInProgress) {
+		log.Printf("Another replica is seeding environments, skipping %s", cfg.EnvironmentsSeedFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to seed environments (%d created, %d updated so far): %w", result.Created, result.Updated, err)
+	}
+	log.Printf("Seeded environments from %s in %s mode: %d created, %d updated, %d skipped",
+		cfg.EnvironmentsSeedFile, mode, result.Created, result.Updated, result.Skipped)
+	return nil
+}
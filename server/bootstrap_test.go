@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/db/mock"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/utils"
+)
+
+// newUserStore returns a mock user repository that keeps users in memory, starting with users
+func newUserStore(users ...models.User) (*mock.MockUserRepository, map[string]models.User) {
+	byName := make(map[string]models.User, len(users))
+	for _, user := range users {
+		byName[user.Username] = user
+	}
+	repo := &mock.MockUserRepository{
+		HasAdminFunc: func() (bool, error) {
+			for _, user := range byName {
+				if user.Role == models.RoleAdmin {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		GetUserFunc: func(username string) (*models.User, error) {
+			user, ok := byName[username]
+			if !ok {
+				return nil, db.ErrNotFound
+			}
+			return &user, nil
+		},
+		CreateUserFunc: func(user models.User) error {
+			if _, ok := byName[user.Username]; ok {
+				return fmt.Errorf("user %s already exists", user.Username)
+			}
+			byName[user.Username] = user
+			return nil
+		},
+		UpdateUserFunc: func(user models.User) error {
+			byName[user.Username] = user
+			return nil
+		},
+	}
+	return repo, byName
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	existingAdmin := models.User{Username: "root", Role: models.RoleAdmin}
+	alice := models.User{Username: "alice", Role: models.RoleUser}
+
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		users     []models.User
+		wantRoles map[string]models.UserRole // Users expected afterwards and their roles
+	}{
+		{
+			name:      "creates the first admin",
+			cfg:       config.Config{BootstrapAdminUsername: "admin", BootstrapAdminPassword: "s3cret-pass"},
+			wantRoles: map[string]models.UserRole{"admin": models.RoleAdmin},
+		},
+		{
+			name:      "already bootstrapped",
+			cfg:       config.Config{BootstrapAdminUsername: "admin", BootstrapAdminPassword: "s3cret-pass"},
+			users:     []models.User{existingAdmin},
+			wantRoles: map[string]models.UserRole{"root": models.RoleAdmin},
+		},
+		{
+			name:      "vars missing",
+			users:     []models.User{alice},
+			wantRoles: map[string]models.UserRole{"alice": models.RoleUser},
+		},
+		{
+			name:      "username without a password",
+			cfg:       config.Config{BootstrapAdminUsername: "admin"},
+			wantRoles: map[string]models.UserRole{},
+		},
+		{
+			name:      "user exists with USER role",
+			cfg:       config.Config{BootstrapAdminUsername: "alice", BootstrapAdminPassword: "s3cret-pass"},
+			users:     []models.User{alice},
+			wantRoles: map[string]models.UserRole{"alice": models.RoleUser},
+		},
+		{
+			name:      "promotes an existing user",
+			cfg:       config.Config{BootstrapAdminPromoteUsername: "alice"},
+			users:     []models.User{alice},
+			wantRoles: map[string]models.UserRole{"alice": models.RoleAdmin},
+		},
+		{
+			name:      "promotion takes precedence over creation",
+			cfg:       config.Config{BootstrapAdminUsername: "admin", BootstrapAdminPassword: "s3cret-pass", BootstrapAdminPromoteUsername: "alice"},
+			users:     []models.User{alice},
+			wantRoles: map[string]models.UserRole{"alice": models.RoleAdmin},
+		},
+		{
+			name:      "promoting a missing user",
+			cfg:       config.Config{BootstrapAdminPromoteUsername: "nobody"},
+			wantRoles: map[string]models.UserRole{},
+		},
+		{
+			name:      "promoting once an admin exists",
+			cfg:       config.Config{BootstrapAdminPromoteUsername: "alice"},
+			users:     []models.User{existingAdmin, alice},
+			wantRoles: map[string]models.UserRole{"root": models.RoleAdmin, "alice": models.RoleUser},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, users := newUserStore(tt.users...)
+			bootstrapAdmin(tt.cfg, repo)
+
+			if len(users) != len(tt.wantRoles) {
+				t.Errorf("users = %v, want %v", users, tt.wantRoles)
+			}
+			for username, wantRole := range tt.wantRoles {
+				if user, ok := users[username]; !ok || user.Role != wantRole {
+					t.Errorf("user %s = %+v, want role %s", username, user, wantRole)
+				}
+			}
+		})
+	}
+}
+
+func TestBootstrapAdminHashesThePassword(t *testing.T) {
+	repo, users := newUserStore()
+	bootstrapAdmin(config.Config{BootstrapAdminUsername: "admin", BootstrapAdminPassword: "s3cret-pass"}, repo)
+
+	admin, ok := users["admin"]
+	if !ok {
+		t.Fatal("bootstrap admin wasn't created")
+	}
+	if admin.Password == "s3cret-pass" || !utils.CheckPassword("s3cret-pass", admin.Password) {
+		t.Errorf("stored password %q isn't a hash of the configured one", admin.Password)
+	}
+}
@@ -0,0 +1,134 @@
+// Package server wires the repositories, handlers and background jobs together, so the
+// long-running HTTP server and the Lambda function serve the same API
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/jobs"
+	"github.com/devreserve/server/mailer"
+	"github.com/devreserve/server/notifier"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/revocation"
+	"github.com/devreserve/server/seed"
+	"github.com/devreserve/server/service"
+	"github.com/devreserve/server/webhook"
+)
+
+// Deps holds everything the router and the background jobs are built from
+type Deps struct {
+	Config config.Config
+
+	// Repositories
+	UserRepo        *db.UserRepository
+	EnvRepo         *db.EnvironmentRepository
+	ReservationRepo *db.ReservationRepository
+	LockRepo        *db.LockRepository
+	AuditRepo       *db.AuditRepository
+	APIKeyRepo      *db.APIKeyRepository
+	WebhookRepo     *db.WebhookRepository
+	InviteRepo      *db.InviteRepository
+	StatsRepo       *db.StatsRepository
+	QueueRepo       *db.QueueRepository
+	TokenRepo       *db.TokenRepository
+
+	// Services
+	Revocations *revocation.Store
+	Mailer      mailer.Mailer
+	Notifier    notifier.Notifier
+	Webhooks    *webhook.Dispatcher
+	EnvService  *service.EnvironmentService
+	Hub         *realtime.Hub
+	Events      *realtime.EventBus
+	Sweeper     *jobs.Sweeper
+	Seeder      *seed.Seeder
+
+	// New expiry sweep intervals set through the system config endpoint
+	CheckIntervalCh chan time.Duration
+}
+
+// NewDeps connects to DynamoDB, makes sure the tables exist and creates the repositories and
+// services. It also runs the startup tasks: bootstrapping the first admin and, if configured,
+// reconciling environment statuses.
+func NewDeps(cfg config.Config) (*Deps, error) {
+	// Create the DynamoDB client
+	dbClient, err := db.NewDynamoDBClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DynamoDB client: %w", err)
+	}
+
+	// Ensure the required tables exist. With a local endpoint, such as a docker-compose
+	// DynamoDB container, wait for it to start instead of failing straight away.
+	if cfg.DynamoDBEndpoint != "" || cfg.DBWaitForReady {
+		err = dbClient.CreateTablesWhenReady(context.Background(), cfg.DBStartupTimeout)
+	} else {
+		err = dbClient.CreateTablesIfNotExist(context.Background())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DynamoDB tables: %w", err)
+	}
+
+	// Create the repositories
+	d := &Deps{Config: cfg}
+	d.UserRepo = db.NewUserRepository(dbClient)
+	d.EnvRepo = db.NewEnvironmentRepository(dbClient)
+	d.ReservationRepo = db.NewReservationRepository(dbClient, d.EnvRepo)
+	d.LockRepo = db.NewLockRepository(dbClient)
+	d.AuditRepo = db.NewAuditRepository(dbClient)
+	d.APIKeyRepo = db.NewAPIKeyRepository(dbClient, d.UserRepo)
+	d.WebhookRepo = db.NewWebhookRepository(dbClient)
+	d.InviteRepo = db.NewInviteRepository(dbClient)
+	d.StatsRepo = db.NewStatsRepository(dbClient)
+	d.QueueRepo = db.NewQueueRepository(dbClient, d.EnvRepo, d.ReservationRepo, d.UserRepo)
+	d.TokenRepo = db.NewTokenRepository(dbClient)
+
+	// Load the revoked tokens; they are refreshed in the background so tokens revoked
+	// through other replicas are rejected here too
+	d.Revocations = revocation.NewStore(d.TokenRepo, d.UserRepo)
+	if err := d.Revocations.Refresh(); err != nil {
+		log.Printf("Error loading revoked tokens: %v", err)
+	}
+
+	// Create the environments defined in the seed file
+	d.Seeder = seed.NewSeeder(d.EnvRepo, d.LockRepo)
+	if cfg.EnvironmentsSeedFile != "" {
+		if err := seedEnvironments(cfg, d.Seeder); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the first admin on a fresh deployment
+	bootstrapAdmin(cfg, d.UserRepo)
+
+	// Fix environments left with the wrong status, e.g. while the expiry sweep was down
+	if cfg.ReconcileOnStartup {
+		reconcileEnvironmentStatuses(d.ReservationRepo)
+	}
+
+	// Create the mailer (logs emails instead of sending them if SMTP isn't configured)
+	d.Mailer = mailer.NewMailer(cfg)
+
+	// Create the notifier used to tell users about changes to their reservations
+	d.Notifier = notifier.NewEmailNotifier(d.UserRepo, d.Mailer)
+
+	// Create the dispatcher that delivers events to webhooks in the background
+	d.Webhooks = webhook.NewDispatcher(d.WebhookRepo, cfg.WebhookWorkers)
+
+	// Create the hub that streams environment changes to WebSocket clients
+	d.EnvService = service.NewEnvironmentService(d.EnvRepo, d.ReservationRepo, cfg.ReservationLookupWorkers)
+	d.Hub = realtime.NewHub(d.EnvService)
+
+	// Create the bus that sends reservation changes to server-sent event streams
+	d.Events = realtime.NewEventBus()
+
+	// Create the sweeper that expires and activates reservations
+	d.Sweeper = jobs.NewSweeper(d.ReservationRepo, d.EnvRepo, d.QueueRepo, d.Notifier, d.Webhooks, d.Hub, d.Events)
+	d.CheckIntervalCh = make(chan time.Duration, 1)
+
+	return d, nil
+}
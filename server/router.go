@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/devreserve/server/handlers"
+	"github.com/devreserve/server/middleware"
+	"github.com/devreserve/server/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+)
+
+// NewRouter creates the handlers and returns the router serving the whole API, wrapped in
+// the CORS middleware
+func NewRouter(d *Deps) http.Handler {
+	// Create the handlers
+	authHandler := handlers.NewAuthHandler(d.UserRepo, d.InviteRepo, d.AuditRepo, d.Revocations, d.Mailer, d.Config)
+	userHandler := handlers.NewUserHandler(d.UserRepo, d.ReservationRepo, d.AuditRepo, d.Revocations)
+	envHandler := handlers.NewEnvironmentHandler(d.EnvRepo, d.ReservationRepo, d.AuditRepo, d.StatsRepo, d.EnvService, d.Webhooks, d.Seeder, d.Config)
+	reservationHandler := handlers.NewReservationHandler(d.ReservationRepo, d.EnvRepo, d.UserRepo, d.AuditRepo, d.StatsRepo, d.QueueRepo, d.Notifier, d.Webhooks, d.Hub, d.Events, d.Config)
+	apiKeyHandler := handlers.NewAPIKeyHandler(d.APIKeyRepo, d.UserRepo, d.AuditRepo)
+	webhookHandler := handlers.NewWebhookHandler(d.WebhookRepo)
+	inviteHandler := handlers.NewInviteHandler(d.InviteRepo, d.AuditRepo)
+	queueHandler := handlers.NewQueueHandler(d.QueueRepo, d.EnvRepo)
+	eventsHandler := handlers.NewEventsHandler(d.Events, d.EnvRepo)
+	systemConfigHandler := handlers.NewSystemConfigHandler(d.Config, d.AuditRepo, d.CheckIntervalCh)
+	maintenanceHandler := handlers.NewMaintenanceHandler(d.ReservationRepo, d.AuditRepo, d.Hub)
+	internalHandler := handlers.NewInternalHandler(d.Sweeper, d.Config.InternalAPISecret)
+	realtimeHandler := handlers.NewRealtimeHandler(d.Hub, d.EnvRepo, d.EnvService, d.Config.CORSAllowedOrigins)
+
+	// Create the router
+	router := mux.NewRouter()
+
+	// Public routes
+	router.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")
+	router.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")
+	router.HandleFunc("/api/auth/forgot-password", authHandler.ForgotPassword).Methods("POST")
+	router.HandleFunc("/api/auth/reset-password", authHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/api/openapi.json", handlers.NewOpenAPIHandler(router).GetSpec).Methods("GET")
+
+	// Internal routes, authenticated by the shared secret instead of a user
+	router.HandleFunc("/api/internal/run-expiry-sweep", internalHandler.RunExpirySweep).Methods("POST")
+
+	// Protected routes
+	authRouter := router.PathPrefix("/api").Subrouter()
+	authRouter.Use(middleware.AuthMiddleware(d.Config, d.APIKeyRepo, d.Revocations))
+	if d.Config.RateLimitPerMinute > 0 || len(d.Config.RateLimitRoutes) > 0 {
+		limits := middleware.RateLimits{
+			PerMinute:   d.Config.RateLimitPerMinute,
+			Routes:      d.Config.RateLimitRoutes,
+			ExemptUsers: d.Config.RateLimitExemptUsers,
+		}
+		authRouter.Use(middleware.RateLimitMiddleware(limits, middleware.NewMemoryRateLimitStore(limits.Capacity())))
+	}
+
+	// User routes
+	authRouter.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST")
+	authRouter.HandleFunc("/users", userHandler.ListUsers).Methods("GET")
+	authRouter.HandleFunc("/users/{username}", userHandler.GetUser).Methods("GET")
+
+	// Admin routes, each guarded by the permission it needs
+	adminRouter := authRouter.PathPrefix("/admin").Subrouter()
+	manageUsers := middleware.RequirePermission(models.PermissionManageUsers)
+	manageEnvironments := middleware.RequirePermission(models.PermissionManageEnvironments)
+	approveReservations := middleware.RequirePermission(models.PermissionApproveReservations)
+	manageWebhooks := middleware.RequirePermission(models.PermissionManageWebhooks)
+	readAudit := middleware.RequirePermission(models.PermissionReadAudit)
+	readSystem := middleware.RequirePermission(models.PermissionReadSystem)
+	manageSystem := middleware.RequirePermission(models.PermissionManageSystem)
+	adminRouter.Handle("/users", manageUsers(http.HandlerFunc(userHandler.CreateUser))).Methods("POST")
+	adminRouter.Handle("/users/import", manageUsers(http.HandlerFunc(userHandler.ImportUsers))).Methods("POST")
+	adminRouter.Handle("/users/{username}/activity", readAudit(http.HandlerFunc(userHandler.GetUserActivity))).Methods("GET")
+	adminRouter.Handle("/users/{username}/team", manageUsers(http.HandlerFunc(userHandler.SetUserTeam))).Methods("PUT")
+	adminRouter.Handle("/users/{username}/revoke-tokens", manageUsers(http.HandlerFunc(authHandler.RevokeUserTokens))).Methods("POST")
+	adminRouter.Handle("/invites", manageUsers(http.HandlerFunc(inviteHandler.CreateInvite))).Methods("POST")
+
+	// System routes
+	adminRouter.Handle("/system/config", readSystem(http.HandlerFunc(systemConfigHandler.GetConfig))).Methods("GET")
+	adminRouter.Handle("/system/config/check-interval", manageSystem(http.HandlerFunc(systemConfigHandler.SetCheckInterval))).Methods("PUT")
+	adminRouter.Handle("/maintenance/reconcile", manageSystem(http.HandlerFunc(maintenanceHandler.Reconcile))).Methods("POST")
+
+	// Environment routes
+	authRouter.HandleFunc("/stats", envHandler.GetDashboardStats).Methods("GET")
+	authRouter.HandleFunc("/environments", envHandler.ListEnvironments).Methods("GET")
+	authRouter.HandleFunc("/environments/available", envHandler.ListAvailableEnvironments).Methods("GET")
+	authRouter.HandleFunc("/environments/batch", envHandler.BatchGetEnvironments).Methods("POST")
+	authRouter.HandleFunc("/environments/{id}", envHandler.GetEnvironment).Methods("GET")
+	authRouter.HandleFunc("/environments/{id}/stats", envHandler.GetEnvironmentStats).Methods("GET")
+	authRouter.HandleFunc("/environments/{id}/schedule", envHandler.GetEnvironmentSchedule).Methods("GET")
+	authRouter.HandleFunc("/environments/{id}/queue", queueHandler.GetEnvironmentQueue).Methods("GET")
+	authRouter.HandleFunc("/environments/{id}/queue/me", queueHandler.WithdrawFromQueue).Methods("DELETE")
+	authRouter.HandleFunc("/environments/{id}/hold", reservationHandler.HoldEnvironment).Methods("POST")
+	authRouter.HandleFunc("/environments/{id}/hold", reservationHandler.ReleaseHold).Methods("DELETE")
+	adminRouter.Handle("/environments", manageEnvironments(http.HandlerFunc(envHandler.CreateEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/import", manageEnvironments(http.HandlerFunc(envHandler.ImportEnvironments))).Methods("POST")
+	adminRouter.Handle("/environments/export", manageEnvironments(http.HandlerFunc(envHandler.ExportEnvironments))).Methods("GET")
+	adminRouter.Handle("/environments/{id}", manageEnvironments(http.HandlerFunc(envHandler.UpdateEnvironment))).Methods("PUT")
+	adminRouter.Handle("/environments/{id}/clone", manageEnvironments(http.HandlerFunc(envHandler.CloneEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/lock", manageEnvironments(http.HandlerFunc(envHandler.LockEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/unlock", manageEnvironments(http.HandlerFunc(envHandler.UnlockEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/archive", manageEnvironments(http.HandlerFunc(envHandler.ArchiveEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/unarchive", manageEnvironments(http.HandlerFunc(envHandler.UnarchiveEnvironment))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/reservations/history", manageEnvironments(http.HandlerFunc(envHandler.GetReservationHistory))).Methods("GET")
+	adminRouter.Handle("/environments/{id}/reassign-reservations", manageEnvironments(http.HandlerFunc(reservationHandler.ReassignReservations))).Methods("POST")
+	adminRouter.Handle("/environments/{id}/notify-users", manageEnvironments(http.HandlerFunc(reservationHandler.NotifyEnvironmentUsers))).Methods("POST")
+
+	// API key routes
+	adminRouter.Handle("/apikeys", manageUsers(http.HandlerFunc(apiKeyHandler.CreateAPIKey))).Methods("POST")
+	adminRouter.Handle("/apikeys", manageUsers(http.HandlerFunc(apiKeyHandler.ListAPIKeys))).Methods("GET")
+	adminRouter.Handle("/apikeys/{id}/revoke", manageUsers(http.HandlerFunc(apiKeyHandler.RevokeAPIKey))).Methods("POST")
+
+	// Webhook routes
+	adminRouter.Handle("/webhooks", manageWebhooks(http.HandlerFunc(webhookHandler.CreateWebhook))).Methods("POST")
+	adminRouter.Handle("/webhooks", manageWebhooks(http.HandlerFunc(webhookHandler.ListWebhooks))).Methods("GET")
+	adminRouter.Handle("/webhooks/{id}", manageWebhooks(http.HandlerFunc(webhookHandler.GetWebhook))).Methods("GET")
+	adminRouter.Handle("/webhooks/{id}", manageWebhooks(http.HandlerFunc(webhookHandler.UpdateWebhook))).Methods("PUT")
+	adminRouter.Handle("/webhooks/{id}", manageWebhooks(http.HandlerFunc(webhookHandler.DeleteWebhook))).Methods("DELETE")
+	adminRouter.Handle("/webhooks/{id}/deliveries", manageWebhooks(http.HandlerFunc(webhookHandler.ListDeliveries))).Methods("GET")
+
+	// Event stream routes
+	authRouter.HandleFunc("/events", eventsHandler.StreamEvents).Methods("GET")
+
+	// Reservation routes
+	authRouter.HandleFunc("/reservations", reservationHandler.CreateReservation).Methods("POST")
+	authRouter.HandleFunc("/reservations", reservationHandler.GetActiveReservations).Methods("GET")
+	authRouter.HandleFunc("/reservations/mine", reservationHandler.GetMyReservations).Methods("GET")
+	authRouter.HandleFunc("/reservations/search", reservationHandler.SearchReservations).Methods("GET")
+	authRouter.HandleFunc("/reservations/bulk-release", reservationHandler.BulkReleaseReservations).Methods("POST")
+	authRouter.HandleFunc("/reservations/preempt", reservationHandler.PreemptReservation).Methods("POST")
+	authRouter.HandleFunc("/reservations/series/{seriesID}", reservationHandler.CancelReservationSeries).Methods("DELETE")
+	authRouter.HandleFunc("/reservations/{id}", reservationHandler.UpdateReservation).Methods("PATCH")
+	authRouter.HandleFunc("/reservations/{id}/release", reservationHandler.ReleaseReservation).Methods("POST")
+	authRouter.HandleFunc("/reservations/{id}/transfer", reservationHandler.TransferReservation).Methods("POST")
+	adminRouter.Handle("/reservations", readAudit(http.HandlerFunc(reservationHandler.ListAllReservations))).Methods("GET")
+	adminRouter.Handle("/reservations/pending", approveReservations(http.HandlerFunc(reservationHandler.ListPendingReservations))).Methods("GET")
+	adminRouter.Handle("/reservations/{id}/approve", approveReservations(http.HandlerFunc(reservationHandler.ApproveReservation))).Methods("POST")
+
+	// WebSocket routes, outside /api so the rate limiter doesn't count long-lived connections
+	router.Handle("/ws/environments", middleware.AuthMiddleware(d.Config, d.APIKeyRepo, d.Revocations)(http.HandlerFunc(realtimeHandler.StreamEnvironments))).Methods("GET")
+
+	// Set up CORS
+	corsMiddleware := cors.New(cors.Options{
+		AllowedOrigins:   d.Config.CORSAllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowCredentials: true,
+	})
+	return corsMiddleware.Handler(router)
+}
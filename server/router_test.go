@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/handlers"
+	"github.com/devreserve/server/models"
+	"github.com/devreserve/server/realtime"
+	"github.com/devreserve/server/revocation"
+	"github.com/devreserve/server/utils"
+)
+
+// newTestRouter returns the router over dependencies without a database. Only requests that
+// are answered before a repository is used can be made to it.
+func newTestRouter(t *testing.T) (http.Handler, config.Config) {
+	t.Helper()
+	cfg := config.Config{
+		JWTSecret:          "test-secret",
+		JWTExpirationHours: 1,
+		JWTLeeway:          time.Minute,
+		InternalAPISecret:  "sweep-secret",
+		CORSAllowedOrigins: []string{"https://devreserve.example.com"},
+		MinReservationMins: 1,
+		MaxReservationMins: 480,
+	}
+	d := &Deps{
+		Config:      cfg,
+		Revocations: revocation.NewStore(nil, nil),
+		Hub:         realtime.NewHub(nil),
+		Events:      realtime.NewEventBus(),
+	}
+	return NewRouter(d), cfg
+}
+
+// bearer returns an Authorization header value for a token of user
+func bearer(t *testing.T, user models.User, cfg config.Config) string {
+	t.Helper()
+	token, err := utils.GenerateToken(user, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestRouter(t *testing.T) {
+	router, cfg := newTestRouter(t)
+	user := bearer(t, models.User{Username: "alice", Role: models.RoleUser, Team: "payments"}, cfg)
+	admin := bearer(t, models.User{Username: "root", Role: models.RoleAdmin}, cfg)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		headers  map[string]string
+		body     string
+		status   int
+		wantCode string
+	}{
+		{"public route", http.MethodPost, "/api/auth/login", nil, `{"username": "alice"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"protected route without a token", http.MethodGet, "/api/environments", nil, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"protected route with a bad token", http.MethodGet, "/api/environments", map[string]string{"Authorization": "Bearer nonsense"}, "", http.StatusUnauthorized, "INVALID_TOKEN"},
+		{"user route", http.MethodPost, "/api/reservations", map[string]string{"Authorization": user}, `{"durationMins": 30, "feature": "checkout"}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"admin route as a user", http.MethodPost, "/api/admin/environments", map[string]string{"Authorization": user}, `{"name": "qa-1"}`, http.StatusForbidden, "PERMISSION_REQUIRED"},
+		{"admin route as an admin", http.MethodPost, "/api/admin/environments", map[string]string{"Authorization": admin}, `{"name": "qa/1"}`, http.StatusBadRequest, "INVALID_ENV_NAME"},
+		{"internal route without the secret", http.MethodPost, "/api/internal/run-expiry-sweep", nil, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"internal route with the wrong secret", http.MethodPost, "/api/internal/run-expiry-sweep", map[string]string{handlers.InternalSecretHeader: "guess"}, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"unknown route", http.MethodGet, "/api/nothing-here", nil, "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var resp utils.Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+			}
+			if string(resp.ErrorCode) != tt.wantCode {
+				t.Errorf("error code = %s, want %s", resp.ErrorCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRouterServesTheOpenAPISpec(t *testing.T) {
+	router, _ := newTestRouter(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", rec.Code, rec.Body.String())
+	}
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("decoding spec: %v", err)
+	}
+	for path, method := range map[string]string{
+		"/api/reservations":                 "post",
+		"/api/internal/run-expiry-sweep":    "post",
+		"/api/admin/environments/{id}/lock": "post",
+		"/api/environments/{id}/queue/me":   "delete",
+	} {
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("spec has no %s %s", method, path)
+		}
+	}
+}
+
+func TestRouterCORS(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for origin, allowed := range map[string]bool{
+		"https://devreserve.example.com": true,
+		"https://evil.example.com":       false,
+	} {
+		r := httptest.NewRequest(http.MethodOptions, "/api/environments", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		r.Header.Set("Access-Control-Request-Headers", "Authorization")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("preflight from %s allowed = %v, want %v", origin, got, allowed)
+		}
+	}
+}
+
+func TestRouterBehindAPIGateway(t *testing.T) {
+	// The Lambda function serves the same router through the API Gateway proxy adapter
+	router, cfg := newTestRouter(t)
+	handler := algnhsa.New(router, nil)
+
+	invoke := func(method, path string, headers map[string]string, body string) events.APIGatewayProxyResponse {
+		t.Helper()
+		payload, err := json.Marshal(events.APIGatewayProxyRequest{
+			HTTPMethod: method,
+			Path:       path,
+			Headers:    headers,
+			Body:       body,
+			RequestContext: events.APIGatewayProxyRequestContext{
+				AccountID:  "123456789012",
+				HTTPMethod: method,
+				Path:       path,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := handler.Invoke(context.Background(), payload)
+		if err != nil {
+			t.Fatalf("Invoke: %v", err)
+		}
+		var resp events.APIGatewayProxyResponse
+		if err := json.Unmarshal(out, &resp); err != nil {
+			t.Fatalf("decoding response %s: %v", out, err)
+		}
+		return resp
+	}
+
+	resp := invoke(http.MethodGet, "/api/environments", nil, "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want 401; body %s", resp.StatusCode, resp.Body)
+	}
+
+	resp = invoke(http.MethodPost, "/api/reservations", map[string]string{
+		"Authorization": bearer(t, models.User{Username: "alice", Role: models.RoleUser}, cfg),
+		"Content-Type":  "application/json",
+	}, `{"environmentId": "env-1", "feature": "checkout"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(resp.Body, "DURATION_OUT_OF_RANGE") {
+		t.Errorf("response = %d %s, want 400 DURATION_OUT_OF_RANGE", resp.StatusCode, resp.Body)
+	}
+}
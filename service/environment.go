@@ -0,0 +1,90 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+)
+
+// EnvironmentService assembles environments with their current reservations. Each
+// environment's reservation is looked up through the reservations' environment index, so
+// the cost grows with the number of environments asked for rather than with every active
+// reservation in the system.
+type EnvironmentService struct {
+	envRepo         db.EnvironmentRepositoryInterface
+	reservationRepo db.ReservationRepositoryInterface
+	workers         int
+}
+
+// NewEnvironmentService creates a new EnvironmentService that looks up at most workers
+// reservations at once
+func NewEnvironmentService(envRepo db.EnvironmentRepositoryInterface, reservationRepo db.ReservationRepositoryInterface, workers int) *EnvironmentService {
+	return &EnvironmentService{
+		envRepo:         envRepo,
+		reservationRepo: reservationRepo,
+		workers:         workers,
+	}
+}
+
+// GetEnvironment gets an environment together with its reservation as seen at now, reading
+// both at the same time. Only a failure to get the environment is returned; see WithReservations.
+func (s *EnvironmentService) GetEnvironment(id string, now time.Time) (*models.EnvironmentWithReservation, error) {
+	var reservation *models.Reservation
+	var reservationErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reservation, reservationErr = s.reservationRepo.GetActiveReservationByEnvironmentID(id, now)
+	}()
+
+	env, err := s.envRepo.GetEnvironment(id)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.assemble(*env, reservation, reservationErr, now)
+	return &result, nil
+}
+
+// WithReservations pairs each environment with its reservation as seen at now, keeping the
+// order of environments. If an environment's reservation can't be read, the environment is
+// returned as stored with no reservation and a warning is logged, so one failed lookup
+// doesn't fail the whole listing.
+func (s *EnvironmentService) WithReservations(environments []models.Environment, now time.Time) []models.EnvironmentWithReservation {
+	result := make([]models.EnvironmentWithReservation, len(environments))
+
+	// Feed the environment indexes to a fixed pool of workers
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				env := environments[j]
+				reservation, err := s.reservationRepo.GetActiveReservationByEnvironmentID(env.ID, now)
+				result[j] = s.assemble(env, reservation, err, now)
+			}
+		}()
+	}
+	for i := range environments {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return result
+}
+
+// assemble builds the response for an environment from the outcome of its reservation lookup
+func (s *EnvironmentService) assemble(env models.Environment, reservation *models.Reservation, err error, now time.Time) models.EnvironmentWithReservation {
+	if err != nil {
+		log.Printf("Error getting current reservation of environment %s, returning it without one: %v", env.ID, err)
+		return models.EnvironmentWithReservation{Environment: env}
+	}
+	return models.NewEnvironmentWithReservation(env, reservation, now)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address a request came from. X-Forwarded-For is only believed when
+// the direct peer is one of the trusted proxies, and then only as far back as the chain of
+// trusted proxies goes: the header is read from right to left, skipping trusted addresses, and
+// the first untrusted one is the client. Anything to its left was written by the client and
+// may be forged. If every address is trusted the leftmost is returned.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !isTrustedProxy(peerIP, trusted) {
+		return peer
+	}
+
+	// Gather every X-Forwarded-For header in order, since proxies may add their own header
+	// rather than appending to an existing one
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := peerIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// The trusted proxy to the right of a malformed entry is as far as we can go
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// isTrustedProxy reports whether an IP address is in one of the trusted networks
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "192.168.1.1/32", "fd00::/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"direct client forging the header", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"direct client forging a trusted address", "203.0.113.7:5000", []string{"10.0.0.5"}, "203.0.113.7"},
+		{"behind a trusted proxy", "10.0.0.2:443", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"behind a trusted proxy without the header", "10.0.0.2:443", nil, "10.0.0.2"},
+		{"spoofed entries left of the client", "10.0.0.2:443", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:443", []string{"203.0.113.7, 192.168.1.1, 10.0.0.9"}, "203.0.113.7"},
+		{"untrusted hop in the chain", "10.0.0.2:443", []string{"203.0.113.7, 198.51.100.9, 10.0.0.9"}, "198.51.100.9"},
+		{"headers added by separate proxies", "10.0.0.2:443", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"malformed entry", "10.0.0.2:443", []string{"203.0.113.7, not-an-ip, 10.0.0.9"}, "10.0.0.9"},
+		{"every hop trusted", "10.0.0.2:443", []string{"10.0.0.8, 10.0.0.9"}, "10.0.0.8"},
+		{"IPv6 behind a trusted proxy", "[fd00::1]:443", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"peer without a port", "203.0.113.7", []string{"198.51.100.1"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/reservations/res-1/release", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Without trusted proxies the header is never believed
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.2:443"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := ClientIP(r, nil); got != "10.0.0.2" {
+		t.Errorf("ClientIP with no trusted proxies = %s, want 10.0.0.2", got)
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import "net/http"
+
+// ErrorCode is a machine-readable identifier for an API error. Clients should match on
+// the code rather than the human-readable message, which may be reworded.
+type ErrorCode string
+
+// Generic error codes, used when no more specific code applies
+const (
+	ErrCodeInternal           ErrorCode = "INTERNAL"
+	ErrCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+	ErrCodeInvalidRequest     ErrorCode = "INVALID_REQUEST"
+	ErrCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden          ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound           ErrorCode = "NOT_FOUND"
+	ErrCodeMethodNotAllowed   ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeConflict           ErrorCode = "CONFLICT"
+	ErrCodeRateLimited        ErrorCode = "RATE_LIMITED"
+)
+
+// Request error codes
+const (
+	ErrCodeInvalidBody        ErrorCode = "INVALID_BODY"
+	ErrCodeMissingField       ErrorCode = "MISSING_FIELD"
+	ErrCodeBatchTooLarge      ErrorCode = "BATCH_TOO_LARGE"
+	ErrCodeInvalidPurpose     ErrorCode = "INVALID_PURPOSE"
+	ErrCodeInvalidPageToken   ErrorCode = "INVALID_PAGE_TOKEN"
+	ErrCodeInvalidJiraURL     ErrorCode = "INVALID_JIRA_URL"
+	ErrCodeInvalidGitBranch   ErrorCode = "INVALID_GIT_BRANCH"
+	ErrCodeFieldTooLong       ErrorCode = "FIELD_TOO_LONG"
+	ErrCodeIntervalOutOfRange ErrorCode = "INTERVAL_OUT_OF_RANGE"
+	ErrCodeConflictingFields  ErrorCode = "CONFLICTING_FIELDS"
+	ErrCodeInvalidFilter      ErrorCode = "INVALID_FILTER"
+)
+
+// Authentication and authorization error codes
+const (
+	ErrCodeInvalidCredentials ErrorCode = "INVALID_CREDENTIALS"
+	ErrCodeInvalidToken       ErrorCode = "INVALID_TOKEN"
+	ErrCodeTokenRevoked       ErrorCode = "TOKEN_REVOKED"
+	ErrCodeInvalidAPIKey      ErrorCode = "INVALID_API_KEY"
+	ErrCodeMissingScope       ErrorCode = "MISSING_SCOPE"
+	ErrCodePermissionRequired ErrorCode = "PERMISSION_REQUIRED"
+	ErrCodeInvalidResetToken  ErrorCode = "INVALID_RESET_TOKEN"
+	ErrCodeInviteRequired     ErrorCode = "INVITE_REQUIRED"
+	ErrCodeInviteInvalid      ErrorCode = "INVITE_INVALID"
+	ErrCodeInviteUsed         ErrorCode = "INVITE_USED"
+	ErrCodeInviteExpired      ErrorCode = "INVITE_EXPIRED"
+)
+
+// User, API key and webhook error codes
+const (
+	ErrCodeUserNotFound     ErrorCode = "USER_NOT_FOUND"
+	ErrCodeUsernameTaken    ErrorCode = "USERNAME_TAKEN"
+	ErrCodeEmailTaken       ErrorCode = "EMAIL_TAKEN"
+	ErrCodePasswordTooShort ErrorCode = "PASSWORD_TOO_SHORT"
+	ErrCodeInvalidRole      ErrorCode = "INVALID_ROLE"
+	ErrCodeInvalidTeam      ErrorCode = "INVALID_TEAM"
+	ErrCodeInvalidScope     ErrorCode = "INVALID_SCOPE"
+	ErrCodeAPIKeyNotFound   ErrorCode = "API_KEY_NOT_FOUND"
+	ErrCodeWebhookNotFound  ErrorCode = "WEBHOOK_NOT_FOUND"
+)
+
+// Environment error codes
+const (
+	ErrCodeEnvNotFound             ErrorCode = "ENV_NOT_FOUND"
+	ErrCodeEnvAlreadyReserved      ErrorCode = "ENV_ALREADY_RESERVED"
+	ErrCodeEnvUnavailable          ErrorCode = "ENV_UNAVAILABLE"
+	ErrCodeEnvArchived             ErrorCode = "ENV_ARCHIVED"
+	ErrCodeEnvUnhealthy            ErrorCode = "ENV_UNHEALTHY"
+	ErrCodeEnvHasActiveReservation ErrorCode = "ENV_HAS_ACTIVE_RESERVATION"
+	ErrCodeOutsideAllowedHours     ErrorCode = "OUTSIDE_ALLOWED_HOURS"
+	ErrCodeEnvLocked               ErrorCode = "ENV_LOCKED"
+	ErrCodeEnvNotLocked            ErrorCode = "ENV_NOT_LOCKED"
+	ErrCodeEnvGroupNotFound        ErrorCode = "ENV_GROUP_NOT_FOUND"
+	ErrCodeEnvGroupUnavailable     ErrorCode = "ENV_GROUP_UNAVAILABLE"
+	ErrCodeEnvHeld                 ErrorCode = "ENV_HELD"
+	ErrCodeEnvNotHeld              ErrorCode = "ENV_NOT_HELD"
+	ErrCodeEnvScheduled            ErrorCode = "ENV_SCHEDULED"
+	ErrCodeInvalidEnvName          ErrorCode = "INVALID_ENV_NAME"
+	ErrCodeEnvNameTaken            ErrorCode = "ENV_NAME_TAKEN"
+	ErrCodeInvalidSeedFile         ErrorCode = "INVALID_SEED_FILE"
+	ErrCodeEnvGroupTooLarge        ErrorCode = "ENV_GROUP_TOO_LARGE"
+	ErrCodeEnvGroupUnsupported     ErrorCode = "ENV_GROUP_UNSUPPORTED"
+)
+
+// Reservation and queue error codes
+const (
+	ErrCodeReservationNotFound   ErrorCode = "RESERVATION_NOT_FOUND"
+	ErrCodeReservationNotActive  ErrorCode = "RESERVATION_NOT_ACTIVE"
+	ErrCodeReservationNotPending ErrorCode = "RESERVATION_NOT_PENDING"
+	ErrCodeReservationChanged    ErrorCode = "RESERVATION_CHANGED"
+	ErrCodeDurationOutOfRange    ErrorCode = "DURATION_OUT_OF_RANGE"
+	ErrCodeNotOwner              ErrorCode = "NOT_OWNER"
+	ErrCodeAlreadyOwner          ErrorCode = "ALREADY_OWNER"
+	ErrCodeAlreadyQueued         ErrorCode = "ALREADY_QUEUED"
+	ErrCodeNotQueued             ErrorCode = "NOT_QUEUED"
+	ErrCodeNotPreemptable        ErrorCode = "NOT_PREEMPTABLE"
+	ErrCodeRecurrenceOutOfRange  ErrorCode = "RECURRENCE_OUT_OF_RANGE"
+	ErrCodeInvalidAutoRenew      ErrorCode = "INVALID_AUTO_RENEW"
+)
+
+// defaultErrorCode returns the generic error code for an HTTP status
+func defaultErrorCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrCodeServiceUnavailable
+	default:
+		return ErrCodeInternal
+	}
+}
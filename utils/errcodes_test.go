@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondWithErrorUsesTheStatusCode(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorCode
+	}{
+		{http.StatusBadRequest, "INVALID_REQUEST"},
+		{http.StatusUnauthorized, "UNAUTHORIZED"},
+		{http.StatusForbidden, "FORBIDDEN"},
+		{http.StatusNotFound, "NOT_FOUND"},
+		{http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+		{http.StatusConflict, "CONFLICT"},
+		{http.StatusTooManyRequests, "RATE_LIMITED"},
+		{http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE"},
+		{http.StatusInternalServerError, "INTERNAL"},
+		{http.StatusBadGateway, "INTERNAL"},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RespondWithError(rec, tt.status, "Something went wrong")
+
+			var resp Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+			}
+			if rec.Code != tt.status || resp.Success || resp.ErrorCode != tt.want || resp.Error != "Something went wrong" {
+				t.Errorf("response = %d %+v, want %d with code %s and the message", rec.Code, resp, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondWithErrorCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RespondWithErrorCode(rec, http.StatusConflict, ErrCodeEnvAlreadyReserved, "Environment is already reserved")
+
+	// The code is part of the API contract, so check the JSON itself
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusConflict || resp["errorCode"] != "ENV_ALREADY_RESERVED" || resp["error"] != "Environment is already reserved" {
+		t.Errorf("response = %d %s, want 409 with errorCode ENV_ALREADY_RESERVED", rec.Code, rec.Body.String())
+	}
+}
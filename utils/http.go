@@ -2,19 +2,33 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 )
 
+// APIVersion is the version of the API that responded, sent in every Response and in
+// the X-API-Version header
+var APIVersion = "v1"
+
 // Response represents a generic API response
 type Response struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success    bool        `json:"success"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	ErrorCode  ErrorCode   `json:"errorCode,omitempty"`
+	APIVersion string      `json:"apiVersion"`
 }
 
-// RespondWithJSON sends a JSON response with the given status code
+// RespondWithJSON sends a JSON response with the given status code. Response payloads
+// are stamped with the API version.
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	if resp, ok := payload.(Response); ok {
+		resp.APIVersion = APIVersion
+		payload = resp
+	}
+
 	response, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Error marshalling JSON response: %v", err)
@@ -23,15 +37,24 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-API-Version", APIVersion)
 	w.WriteHeader(code)
 	w.Write(response)
 }
 
-// RespondWithError sends an error response with the given status code
+// RespondWithError sends an error response with the given status code and the generic
+// error code for that status
 func RespondWithError(w http.ResponseWriter, code int, message string) {
+	RespondWithErrorCode(w, code, defaultErrorCode(code), message)
+}
+
+// RespondWithErrorCode sends an error response with the given status code, machine-readable
+// error code and message
+func RespondWithErrorCode(w http.ResponseWriter, code int, errorCode ErrorCode, message string) {
 	RespondWithJSON(w, code, Response{
-		Success: false,
-		Error:   message,
+		Success:   false,
+		Error:     message,
+		ErrorCode: errorCode,
 	})
 }
 
@@ -43,8 +66,37 @@ func RespondWithSuccess(w http.ResponseWriter, data interface{}) {
 	})
 }
 
+// RespondWithCreated sends a 201 Created success response with the created resource,
+// setting the Location header to its URL if one is given
+func RespondWithCreated(w http.ResponseWriter, location string, data interface{}) {
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	RespondWithJSON(w, http.StatusCreated, Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// RespondWithNoContent sends a 204 No Content response, for requests that succeeded
+// with nothing to return such as deletes and releases
+func RespondWithNoContent(w http.ResponseWriter) {
+	w.Header().Set("X-API-Version", APIVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ParseJSONBody parses the JSON body of a request into the given struct
 func ParseJSONBody(r *http.Request, v interface{}) error {
 	defer r.Body.Close()
 	return json.NewDecoder(r.Body).Decode(v)
 }
+
+// InvalidBodyMessage returns the error message for a request body that ParseJSONBody
+// rejected, explaining the expected format when a timestamp couldn't be parsed
+func InvalidBodyMessage(err error) string {
+	var parseErr *time.ParseError
+	if errors.As(err, &parseErr) {
+		return "Invalid timestamp " + parseErr.Value + ": timestamps must be RFC3339 with an explicit offset, e.g. 2024-01-02T15:04:05Z"
+	}
+	return "Invalid request body"
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondWithCreated(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+	}{
+		{"with a location", "/api/environments/env-1"},
+		{"without a location", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RespondWithCreated(rec, tt.location, map[string]string{"id": "env-1"})
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+			var resp struct {
+				Success bool              `json:"success"`
+				Data    map[string]string `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+			}
+			if !resp.Success || resp.Data["id"] != "env-1" {
+				t.Errorf("response = %s, want a success with the created resource", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRespondWithNoContent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RespondWithNoContent(rec)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want none", rec.Body.String())
+	}
+	if rec.Header().Get("X-API-Version") != APIVersion {
+		t.Errorf("X-API-Version = %q, want %q", rec.Header().Get("X-API-Version"), APIVersion)
+	}
+}
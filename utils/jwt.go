@@ -7,36 +7,54 @@ import (
 	"github.com/devreserve/server/config"
 	"github.com/devreserve/server/models"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 // Claims represents the JWT claims
 type Claims struct {
 	Username string        `json:"username"`
 	Role     models.UserRole `json:"role"`
+	// Team is the user's team when the token was issued, which decides the environments they
+	// can see; changing a user's team revokes their tokens so the next login picks it up
+	Team string `json:"team,omitempty"`
+	// TokenVersion is the user's token version when the token was issued; the token is
+	// revoked once the user's version is bumped past it
+	TokenVersion int `json:"tokenVersion,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // GenerateToken generates a JWT token for a user
 func GenerateToken(user models.User, cfg config.Config) (string, error) {
 	// Set the expiration time
-	expirationTime := time.Now().Add(time.Duration(cfg.JWTExpirationHours) * time.Hour)
+	now := time.Now()
+	expirationTime := now.Add(time.Duration(cfg.JWTExpirationHours) * time.Hour)
 
 	// Create the JWT claims
 	claims := &Claims{
-		Username: user.Username,
-		Role:     user.Role,
+		Username:     user.Username,
+		Role:         user.Role,
+		Team:         user.Team,
+		TokenVersion: user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
+			// The ID lets the token be revoked on its own by logging out
+			ID:        uuid.New().String(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "dev-reserve",
 		},
 	}
 
-	// Create the JWT token
+	// Create the JWT token, naming the signing key in the kid header if there's a keyset
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	secret := cfg.JWTSecret
+	if len(cfg.JWTKeys.Keys) > 0 {
+		token.Header["kid"] = cfg.JWTKeys.Current
+		secret = cfg.JWTKeys.Keys[cfg.JWTKeys.Current]
+	}
 
 	// Sign the token with the secret key
-	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	tokenString, err := token.SignedString([]byte(secret))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -55,8 +73,10 @@ func ValidateToken(tokenString string, cfg config.Config) (*Claims, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
-			return []byte(cfg.JWTSecret), nil
+			return verificationKey(token, cfg)
 		},
+		// The time-based claims are checked below, allowing for clock skew
+		jwt.WithoutClaimsValidation(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -68,5 +88,40 @@ func ValidateToken(tokenString string, cfg config.Config) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// Check the time-based claims, tolerating up to JWTLeeway of clock skew between
+	// whoever issued the token and this server. Tokens issued before nbf was added don't have it.
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-cfg.JWTLeeway), true) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(cfg.JWTLeeway), false) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(cfg.JWTLeeway), false) {
+		return nil, fmt.Errorf("token used before it was issued")
+	}
+
 	return claims, nil
 }
+
+// verificationKey returns the key to verify a token with: the keyset key named by its kid
+// header, or JWTSecret for tokens issued without a kid. Once there is a keyset, tokens
+// without a kid are only accepted until JWTSecretValidUntil, and never if it isn't set.
+func verificationKey(token *jwt.Token, cfg config.Config) (interface{}, error) {
+	kidValue, ok := token.Header["kid"]
+	if !ok {
+		if len(cfg.JWTKeys.Keys) > 0 && !time.Now().Before(cfg.JWTSecretValidUntil) {
+			return nil, fmt.Errorf("token has no kid")
+		}
+		return []byte(cfg.JWTSecret), nil
+	}
+	kid, ok := kidValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid kid header")
+	}
+	secret, ok := cfg.JWTKeys.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return []byte(secret), nil
+}
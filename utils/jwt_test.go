@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devreserve/server/config"
+	"github.com/devreserve/server/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testJWTConfig returns a configuration signing tokens with secret and no keyset
+func testJWTConfig() config.Config {
+	return config.Config{
+		JWTSecret:          "legacy-secret",
+		JWTExpirationHours: 1,
+		JWTLeeway:          30 * time.Second,
+	}
+}
+
+// withKeys returns cfg with a keyset whose current key is current
+func withKeys(cfg config.Config, current string, keys map[string]string) config.Config {
+	cfg.JWTKeys = config.JWTKeySet{Current: current, Keys: keys}
+	return cfg
+}
+
+func TestValidateTokenKeyRotation(t *testing.T) {
+	user := models.User{Username: "alice", Role: models.RoleUser}
+	legacy := testJWTConfig()
+	rotatedFrom := withKeys(legacy, "k1", map[string]string{"k1": "secret-1"})
+	rotatedTo := withKeys(legacy, "k2", map[string]string{"k1": "secret-1", "k2": "secret-2"})
+	retired := withKeys(legacy, "k2", map[string]string{"k2": "secret-2"})
+	inGrace := rotatedTo
+	inGrace.JWTSecretValidUntil = time.Now().Add(time.Hour)
+	graceOver := rotatedTo
+	graceOver.JWTSecretValidUntil = time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		issuedBy config.Config
+		verifier config.Config
+		wantErr  bool
+	}{
+		{name: "kid-less token without a keyset", issuedBy: legacy, verifier: legacy},
+		{name: "current kid", issuedBy: rotatedTo, verifier: rotatedTo},
+		{name: "previous kid still in the keyset", issuedBy: rotatedFrom, verifier: rotatedTo},
+		{name: "retired kid", issuedBy: rotatedFrom, verifier: retired, wantErr: true},
+		{name: "kid-less token once there is a keyset", issuedBy: legacy, verifier: rotatedTo, wantErr: true},
+		{name: "kid-less token in the grace window", issuedBy: legacy, verifier: inGrace},
+		{name: "kid-less token after the grace window", issuedBy: legacy, verifier: graceOver, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := GenerateToken(user, tt.issuedBy)
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+			claims, err := ValidateToken(token, tt.verifier)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ValidateToken succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.Username != "alice" {
+				t.Errorf("claims username = %q, want alice", claims.Username)
+			}
+		})
+	}
+}
+
+func TestValidateTokenUnknownKid(t *testing.T) {
+	cfg := withKeys(testJWTConfig(), "k1", map[string]string{"k1": "secret-1"})
+
+	// Sign with a key the keyset doesn't have, under a kid it doesn't have either
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Username: "mallory",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	token.Header["kid"] = "k9"
+	signed, err := token.SignedString([]byte("secret-9"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	if _, err := ValidateToken(signed, cfg); err == nil {
+		t.Error("ValidateToken accepted a token with an unknown kid")
+	}
+}
+
+func TestValidateTokenKidlessSignedWithKeysetKey(t *testing.T) {
+	// A kid-less token must not be verifiable with a keyset key either
+	cfg := withKeys(testJWTConfig(), "k1", map[string]string{"k1": "secret-1"})
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Username: "mallory",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	signed, err := token.SignedString([]byte("secret-1"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	if _, err := ValidateToken(signed, cfg); err == nil {
+		t.Error("ValidateToken accepted a kid-less token")
+	}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxCellWidth is the widest a plain-text table cell can be before it is truncated
+const maxCellWidth = 40
+
+// WantsTable reports whether the client asked for a plain-text table instead of JSON,
+// either with ?format=table or an Accept header preferring text/plain
+func WantsTable(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "table" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.HasPrefix(accept, "text/plain")
+}
+
+// RespondWithTable sends rows as an aligned plain-text table with a header line.
+// Long cells are truncated so one long value doesn't push the other columns off screen.
+func RespondWithTable(w http.ResponseWriter, headers []string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-API-Version", APIVersion)
+	w.WriteHeader(http.StatusOK)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = truncate(cell, maxCellWidth)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+// HumanizeRemaining describes the time left until end, e.g. "2h 13m left" or "expired"
+func HumanizeRemaining(end, now time.Time) string {
+	remaining := end.Sub(now)
+	if remaining <= 0 {
+		return "expired"
+	}
+
+	days := int(remaining / (24 * time.Hour))
+	hours := int(remaining % (24 * time.Hour) / time.Hour)
+	minutes := int(remaining % time.Hour / time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh left", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm left", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm left", minutes)
+	default:
+		return "<1m left"
+	}
+}
+
+// truncate shortens s to at most max characters, marking the cut with "..."
+func truncate(s string, max int) string {
+	// Tabs and newlines would break the table's alignment
+	s = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
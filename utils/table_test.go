@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name.golden, rewriting the file instead with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v (run go test -update to create it)", path, err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("table differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRespondWithTable(t *testing.T) {
+	headers := []string{"ID", "ENVIRONMENT", "USER", "FEATURE", "REMAINING"}
+	tests := []struct {
+		name string
+		rows [][]string
+	}{
+		{"table_empty", nil},
+		{"table_reservations", [][]string{
+			{"res-1", "payments-1", "alice", "Checkout redesign", "2h 13m left"},
+			{"res-2", "search-staging-eu-west-1", "bob", "", "<1m left"},
+		}},
+		{"table_long_cells", [][]string{
+			{"res-1", "payments-1", "alice", strings.Repeat("very long feature description ", 4), "45m left"},
+			{"res-2", "payments-2", "carol", "multi-line\nfeature\twith tabs", "1d 2h left"},
+			{"res-3", "payments-3", "dave", strings.Repeat("é", 50), "expired"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RespondWithTable(rec, headers, tt.rows)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/plain", got)
+			}
+			checkGolden(t, tt.name, rec.Body.Bytes())
+		})
+	}
+}
+
+func TestHumanizeRemaining(t *testing.T) {
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		remaining time.Duration
+		want      string
+	}{
+		{-time.Minute, "expired"},
+		{0, "expired"},
+		{30 * time.Second, "<1m left"},
+		{45 * time.Minute, "45m left"},
+		{2*time.Hour + 13*time.Minute + 59*time.Second, "2h 13m left"},
+		{time.Hour, "1h 0m left"},
+		{26 * time.Hour, "1d 2h left"},
+	}
+	for _, tt := range tests {
+		if got := HumanizeRemaining(now.Add(tt.remaining), now); got != tt.want {
+			t.Errorf("HumanizeRemaining(%s) = %q, want %q", tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestWantsTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   bool
+	}{
+		{"default", "/api/environments", "", false},
+		{"format parameter", "/api/environments?format=table", "", true},
+		{"other format", "/api/environments?format=json", "", false},
+		{"plain text accepted", "/api/environments", "text/plain", true},
+		{"JSON accepted", "/api/environments", "application/json", false},
+		{"anything accepted", "/api/environments", "*/*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if got := WantsTable(r); got != tt.want {
+				t.Errorf("WantsTable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
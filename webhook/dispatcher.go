@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devreserve/server/db"
+	"github.com/devreserve/server/models"
+	"github.com/google/uuid"
+)
+
+// SignatureHeader holds the hex HMAC-SHA256 of the request body, keyed with the webhook's secret
+const SignatureHeader = "X-DevReserve-Signature"
+
+// EventHeader holds the event type
+const EventHeader = "X-DevReserve-Event"
+
+const (
+	// queueSize is how many events can wait for a worker before new ones are dropped
+	queueSize = 100
+	// maxAttempts is how many times a delivery is tried before it is recorded as failed
+	maxAttempts = 3
+	// retryBackoff is the wait before the first retry, doubled for each later one
+	retryBackoff = 1 * time.Second
+)
+
+// Dispatcher delivers events to subscribed webhooks in the background. Events are
+// queued and sent by a fixed pool of workers, so dispatching never blocks the caller.
+type Dispatcher struct {
+	webhookRepo *db.WebhookRepository
+	client      *http.Client
+	events      chan models.WebhookEvent
+}
+
+// NewDispatcher creates a new Dispatcher and starts its workers
+func NewDispatcher(webhookRepo *db.WebhookRepository, workers int) *Dispatcher {
+	d := &Dispatcher{
+		webhookRepo: webhookRepo,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		events:      make(chan models.WebhookEvent, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Dispatch queues an event for delivery. If the queue is full the event is dropped
+// and logged rather than making the caller wait.
+func (d *Dispatcher) Dispatch(eventType models.WebhookEventType, data interface{}) {
+	event := models.WebhookEvent{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("Webhook queue full, dropping %s event %s", event.Type, event.ID)
+	}
+}
+
+// work delivers queued events to every active webhook subscribed to them
+func (d *Dispatcher) work() {
+	for event := range d.events {
+		webhooks, err := d.webhookRepo.ListWebhooks()
+		if err != nil {
+			log.Printf("Error listing webhooks for %s event %s: %v", event.Type, event.ID, err)
+			continue
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error marshalling %s event %s: %v", event.Type, event.ID, err)
+			continue
+		}
+
+		for _, webhook := range webhooks {
+			if !webhook.Active || !webhook.Subscribes(event.Type) {
+				continue
+			}
+
+			delivery := d.deliver(webhook, event, body)
+			if err := d.webhookRepo.RecordDelivery(delivery); err != nil {
+				log.Printf("Error recording webhook delivery: %v", err)
+			}
+		}
+	}
+}
+
+// deliver POSTs the event to the webhook, retrying with exponential backoff
+func (d *Dispatcher) deliver(webhook models.Webhook, event models.WebhookEvent, body []byte) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Status:    models.DeliveryFailed,
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts = attempt
+
+		statusCode, err := d.post(webhook, event, body)
+		delivery.ResponseCode = statusCode
+		if err == nil {
+			delivery.Status = models.DeliverySucceeded
+			delivery.Error = ""
+			return delivery
+		}
+		delivery.Error = err.Error()
+	}
+
+	log.Printf("Failed to deliver %s event %s to webhook %s: %s", event.Type, event.ID, webhook.ID, delivery.Error)
+	return delivery
+}
+
+// post makes a single delivery attempt, returning the response status code
+func (d *Dispatcher) post(webhook models.Webhook, event models.WebhookEvent, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the value of the signature header for a body: "sha256=" followed by
+// the hex HMAC-SHA256 of the body keyed with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}